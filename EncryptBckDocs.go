@@ -1,544 +1,928 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"net/url"
+	"log/slog"
 	"os"
-	"os/user"
-	"path/filepath"
+	"os/signal"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"golang.org/x/net/context"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/drive/v3"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive/drivetest"
+	"github.com/amcereijo/EncryptBckDocs/internal/buildinfo"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/control"
+	"github.com/amcereijo/EncryptBckDocs/internal/dashboard"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+	"github.com/amcereijo/EncryptBckDocs/internal/metrics"
+	"github.com/amcereijo/EncryptBckDocs/internal/notify"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+	"github.com/amcereijo/EncryptBckDocs/internal/priority"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+	"github.com/amcereijo/EncryptBckDocs/internal/tracing"
+	"github.com/amcereijo/EncryptBckDocs/internal/tui"
+	"github.com/amcereijo/EncryptBckDocs/internal/watcher"
 )
 
-const configFileName = "config.json"
 const clientSecretFileName = "client_secret.json"
 
-var appFiles = []string{configFileName, clientSecretFileName, "EncryptBckDocs.go", "EncryptBckDocs"}
+// app holds the components of the running app, wired together in newApp.
+type app struct {
+	// configStore holds the config and saves its changes
+	configStore *config.Store
+	accounts    *drive.Accounts
+	pipeline    *pipeline.Pipeline
+	events      *events.Bus
+	notifier    *notify.Notifier
+	report      *notify.Report
+	metrics     *metrics.Metrics
+	history     *history.Log
+	state       *state.Store
+	// syncing is set while a backup asked by syncNow runs
+	syncing atomic.Bool
+	// remoteConfigHash is the hash of the config last saved in Drive
+	remoteConfigHash  [sha256.Size]byte
+	remoteConfigMutex sync.Mutex
+}
 
-var driveSrv *drive.Service // drive service
+// newApp builds the components of the app for the config of configStore,
+// authorizing Drive accounts with authorizer. Its uploads stop when ctx is
+// cancelled.
+func newApp(ctx context.Context, configStore *config.Store, authorizer *auth.Authorizer) *app {
+	cfg := configStore.Config()
+	a := &app{
+		configStore: configStore,
+		accounts:    drive.NewAccounts(cfg, authorizer),
+		events:      events.New(),
+		notifier:    notify.New(cfg),
+		report:      notify.NewReport(cfg),
+		metrics:     metrics.New(cfg),
+		history:     history.New(history.FileName),
+	}
+	// every component reads the current config, a reload applying to all
+	a.accounts.ConfigStore = configStore
+	a.notifier.ConfigStore = configStore
+	a.report.ConfigStore = configStore
+	a.metrics.ConfigStore = configStore
+	a.accounts.AuthExpired = func(account string, expired bool) {
+		a.metrics.AuthExpired(expired)
+		if expired {
+			a.notifier.AuthExpired(account)
+		}
+	}
+	a.pipeline = &pipeline.Pipeline{
+		ConfigStore: configStore,
+		Accounts:    a.accounts,
+		Events:      a.events,
+		Queue:       queue.New(),
+		AppFiles:    appFiles(cfg, authorizer),
+	}
+	a.events.Subscribe(events.Log)
+	a.events.Subscribe(a.metrics.HandleEvent)
+	a.events.Subscribe(a.history.HandleEvent)
+	a.events.Subscribe(a.notifier.HandleEvent)
+	a.events.Subscribe(a.report.HandleEvent)
+	a.pipeline.Start(ctx, 1)
+	return a
+}
 
-var configApp appConfig // app configuration object
+// config returns the current config, which is never changed: the changes
+// go through updateConfig.
+func (a *app) config() *config.Config {
+	return a.configStore.Config()
+}
 
-type appConfig struct {
-	FolderName    string   `json:"folderName"`
-	LastUpdate    string   `json:"lastUpdate"`
-	FolderToWatch []string `json:"folderToWatch"`
+// appFiles returns the paths of the files and folders the app uses with cfg and
+// authorizer, its binary included, never uploaded from a watched folder.
+func appFiles(cfg *config.Config, authorizer *auth.Authorizer) []string {
+	files := []string{
+		config.FileName,
+		config.BackupDir(config.FileName),
+		history.FileName,
+		state.FileName,
+		controlSocket(cfg),
+		config.Resolve(logFileFlag, "ENCRYPTBCKDOCS_LOG_FILE", cfg.LogFile, ""),
+		cfg.ServiceAccountFile,
+	}
+	if authorizer.ClientSecretPath != "-" {
+		files = append(files, authorizer.ClientSecretPath)
+	}
+	accountNames := []string{""}
+	for _, name := range cfg.FolderAccount {
+		accountNames = append(accountNames, name)
+	}
+	for _, name := range accountNames {
+		if tokenFile, err := authorizer.TokenCacheFile(name); err == nil {
+			files = append(files, tokenFile)
+		}
+	}
+	if executable, err := os.Executable(); err == nil {
+		files = append(files, executable)
+	}
+	return files
 }
 
-// getClient uses a Context and Config to retrieve a Token
-// then generate a Client. It returns the generated Client.
-func getClient(ctx context.Context, config *oauth2.Config) *http.Client {
-	cacheFile, err := tokenCacheFile()
+// openState opens the state database used to skip unchanged files. Without
+// it every file is uploaded again.
+func (a *app) openState() {
+	store, err := state.Open(state.FileName)
 	if err != nil {
-		log.Fatalf("Unable to get path to cached credential file. %v", err)
+		slog.Warn("Unable to open the state database, every file will be uploaded", "file", state.FileName, "error", err)
+		return
 	}
-	tok, err := tokenFromFile(cacheFile)
+	// the files whose upload was cut by a crash are checked again
+	recovered, err := store.Recover()
 	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(cacheFile, tok)
+		slog.Warn("Unable to recover the journaled uploads", "file", state.FileName, "error", err)
 	}
-	return config.Client(ctx, tok)
+	for _, path := range recovered {
+		slog.Warn("Upload interrupted by a crash, uploading it again", "file", path)
+	}
+	a.state = store
+	a.pipeline.State = store
+	a.accounts.State = store
 }
 
-// getTokenFromWeb uses Config to request a Token.
-// It returns the retrieved Token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var code string
-	if _, err := fmt.Scan(&code); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
+// updateConfig applies change to the config and saves it.
+func (a *app) updateConfig(change func(cfg *config.Config)) {
+	if err := a.configStore.Update(change); err != nil {
+		slog.Error("Cannot create config file", "error", err)
 	}
+}
 
-	tok, err := config.Exchange(oauth2.NoContext, code)
+func (a *app) createConfig() {
+	// create config file
+	requireTerminal("set ENCRYPTBCKDOCS_FOLDER_NAME and ENCRYPTBCKDOCS_WATCH instead of configuring the app")
+	folderName := ask("Name for the folder to save files (default: EncryptBckDoc): ", "EncryptBckDoc")
+	backupConfig()
+	// machines sharing the destination folder do not overwrite each other
+	hostname, err := os.Hostname()
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
-	}
-	return tok
+		slog.Warn("Unable to get the hostname, files are uploaded to the destination folder itself", "error", err)
+		hostname = ""
+	}
+	// keep the authorization settings, only the backup setup is replaced
+	a.updateConfig(func(cfg *config.Config) {
+		cfg.FolderName = folderName
+		cfg.FolderID = ""
+		cfg.HostFolder = hostname
+		cfg.LastUpdate = ""
+		cfg.FolderToWatch = nil
+		cfg.FolderAccount = nil
+	})
 }
 
-// tokenCacheFile generates credential file path/filename.
-// It returns the generated credential path/filename.
-func tokenCacheFile() (string, error) {
-	usr, err := user.Current()
+// startWatcher uploads every file written in the watched folders from now
+// on, reloading the config when it changes.
+func (a *app) startWatcher(ctx context.Context) {
+	w, err := watcher.New()
 	if err != nil {
-		return "", err
+		logging.Fatal("Unable to start the watcher", "error", err)
+	}
+
+	w.Changed = func(path string) {
+		a.pipeline.FileChanged(ctx, path)
 	}
-	tokenCacheDir := filepath.Join(usr.HomeDir, ".credentials")
-	os.MkdirAll(tokenCacheDir, 0700)
-	return filepath.Join(tokenCacheDir,
-		url.QueryEscape("EncryptBckDocs.json")), err
+	w.Removed = func(path string) {
+		a.pipeline.FileRemoved(ctx, path)
+	}
+	w.Alive = a.metrics.WatcherAlive
+	go w.Run()
+
+	for _, actualFileToWatch := range a.config().FolderToWatch {
+		slog.Info("Watching folder", "folder", actualFileToWatch)
+		err = w.Add(actualFileToWatch)
+		if err != nil {
+			// the other folders are still backed up
+			slog.Error("Unable to watch folder", "folder", actualFileToWatch, "error", err)
+		}
+	}
+
+	go watcher.WatchConfig(config.FileName, func() {
+		a.reloadConfig(ctx, w)
+	})
+	go watcher.WatchResume(ctx, func(slept time.Duration) {
+		a.resumed(ctx, w, slept)
+	})
+	go a.report.Run()
 }
 
-// tokenFromFile retrieves a Token from a given file path.
-// It returns the retrieved Token and any read error encountered.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
+// requireTerminal exits telling the user what to do instead, when there
+// is no terminal to ask questions on, like in a container.
+func requireTerminal(instead string) {
+	if !logging.CanPrompt() {
+		logging.Exit(exitConfig, "No terminal to ask questions on, "+instead)
 	}
-	t := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(t)
-	defer f.Close()
-	return t, err
 }
 
-// saveToken uses a file path to create a file and store the
-// token in it.
-func saveToken(file string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", file)
-	f, err := os.Create(file)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+func (a *app) configFolderToWatch() {
+	if len(a.config().FolderToWatch) == 0 || a.config().FolderToWatch[0] == "" {
+		requireTerminal("no folder to watch, set ENCRYPTBCKDOCS_WATCH or folderToWatch in " + config.FileName)
+		folderToWatch := a.askFolderToWatch()
+		a.updateConfig(func(cfg *config.Config) {
+			cfg.FolderToWatch = []string{folderToWatch}
+		})
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
 }
 
-func findHolderFolder(folderName string) (file *drive.File, err error) {
-	r, err := driveSrv.Files.List().Q("mimeType='application/vnd.google-apps.folder' and explicitlyTrashed=false").Fields("nextPageToken, files(id, name, mimeType)").Do()
-	if err != nil {
-		return nil, err
-	}
-	var folder *drive.File
-	if len(r.Files) > 0 {
-		for _, actualFile := range r.Files {
-			// fmt.Printf("-- NAME: %s - ID: (%s) - TYPE:%s\n", actualFile.Name, actualFile.Id, actualFile.MimeType)
-			if actualFile.Name == folderName {
-				folder = actualFile
-				//	break
+func (a *app) addFolderToWatch() {
+	requireTerminal("add the folder to folderToWatch in " + config.FileName)
+	if len(a.config().FolderToWatch) == 0 {
+		slog.Warn("Lauch config option first!!")
+	} else {
+		//save in config file
+		folderToWatch := a.askFolderToWatch()
+
+		isFolderInConfig := false
+		for _, actualFoldertoWatch := range a.config().FolderToWatch {
+			if actualFoldertoWatch == folderToWatch {
+				isFolderInConfig = true
 			}
 		}
-		if folder == nil {
-			errorString := fmt.Sprintf("No folder with name \"%s\"", folderName)
-			err = errors.New(errorString)
+		if isFolderInConfig {
+			slog.Error("The folder is already in config", "folder", folderToWatch)
+		} else {
+			accountName := ask("Drive account for this path (default: main account): ", "")
+			backupConfig()
+			a.updateConfig(func(cfg *config.Config) {
+				if accountName != "" {
+					if cfg.FolderAccount == nil {
+						cfg.FolderAccount = make(map[string]string)
+					}
+					cfg.FolderAccount[folderToWatch] = accountName
+				}
+				cfg.FolderToWatch = append(cfg.FolderToWatch, folderToWatch)
+			})
 		}
-	} else {
-		err = errors.New("No folders")
 	}
-	return folder, err
 }
 
-func findUploadFileInDrive(fileName string, parentID string) (fileToUpload *drive.File, err error) {
-	log.Println("findUploadFileInDrive: ", fileName)
-	r, err := driveSrv.Files.List().Q("'" + parentID + "' in parents and explicitlyTrashed=false and name='" + fileName + "'").Fields("files(id, name)").Do()
-	if err != nil {
-		return nil, err
-	}
-	if len(r.Files) > 0 {
-		fileToUpload = r.Files[0]
-	}
-	return fileToUpload, err
-}
+func (a *app) removeFolderToWatch() {
+	requireTerminal("remove the folder from folderToWatch in " + config.FileName)
+	// the folders of the file, not the ones of the environment
+	saved := a.configStore.Copy()
+	pathToWatchLen := len(saved.FolderToWatch)
+	if pathToWatchLen <= 0 {
+		slog.Error("There is no paths configured yet")
+	} else {
+		fmt.Println("Available options:")
+		for i, path := range saved.FolderToWatch {
+			fmt.Printf("  %s  %s\n", logging.Colorize(logging.Bold, strconv.Itoa(i+1)), path)
+		}
 
-func updateLastUpdateAppConfig() {
-	configApp.LastUpdate = time.Now().String()
-	saveConfigJSONFile()
-}
+		userOption := ask("Your choice: ", "")
 
-func updateFileInDrive(driveFileToUpload *drive.File, goFile *os.File) (err error) {
-	fmt.Printf("Upate existing file %s\n!!", driveFileToUpload.Name)
-	driveFileToUpdate := &drive.File{
-		Name: filepath.Base(driveFileToUpload.Name),
+		intUserOption, err := strconv.Atoi(userOption)
+		if err != nil || intUserOption < 1 || intUserOption > pathToWatchLen {
+			fmt.Printf("\n%s\n\n", logging.Colorize(logging.Red, fmt.Sprintf("Wrong option, valid options are from 1 to %d", pathToWatchLen)))
+		} else {
+			folder := saved.FolderToWatch[intUserOption-1]
+			backupConfig()
+			a.updateConfig(func(cfg *config.Config) {
+				delete(cfg.FolderAccount, folder)
+				cfg.FolderToWatch = slices.DeleteFunc(cfg.FolderToWatch, func(path string) bool { return path == folder })
+			})
+		}
 	}
+}
 
-	_, err = driveSrv.Files.Update(driveFileToUpload.Id, driveFileToUpdate).Media(goFile).Do()
-	if err != nil {
-		panic(err)
+func (a *app) showAppConfig() {
+	if logging.JSONOutput() {
+		json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+			"event":         "status",
+			"folderName":    a.config().FolderName,
+			"folderId":      a.config().FolderID,
+			"hostFolder":    a.config().HostFolder,
+			"lastUpdate":    a.pipeline.LastUpdate(),
+			"folderToWatch": a.config().FolderToWatch,
+			"folderAccount": a.config().FolderAccount,
+		})
+		return
+	}
+	fmt.Printf("\n%s\n", logging.Colorize(logging.Bold, "Actual configuration"))
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if a.config().FolderID != "" {
+		fmt.Fprintf(table, "  Destination folder ID in Drive\t%s\n", a.config().FolderID)
 	} else {
-		fmt.Printf("Updated file \"%s\"!!\n", driveFileToUpload.Name)
-		updateLastUpdateAppConfig()
+		fmt.Fprintf(table, "  Destination folder in Drive\t%s\n", a.config().FolderName)
 	}
-
-	return err
-}
-
-func uploadNewFileToDrive(folderFile *drive.File, fileToUploadName string, fileToUploadURL string, goFile *os.File) (err error) {
-	parents := []string{folderFile.Id}
-	driveFileToUpload := &drive.File{
-		Parents: parents,
-		Name:    filepath.Base(fileToUploadName),
+	if a.config().HostFolder != "" {
+		fmt.Fprintf(table, "  Folder of this machine in it\t%s\n", a.config().HostFolder)
 	}
-	_, err = driveSrv.Files.Create(driveFileToUpload).Media(goFile).Do()
-	if err != nil {
-		panic(err)
-	} else {
-		fmt.Printf("Uploaded file \"%s\" to \"%s\" !!\n", fileToUploadName, folderFile.Name)
-		updateLastUpdateAppConfig()
+	fmt.Fprintf(table, "  Last syncronization time\t%s\n", a.pipeline.LastUpdate())
+	for i, folder := range a.config().FolderToWatch {
+		label := ""
+		if i == 0 {
+			label = "Local watching folders"
+		}
+		fmt.Fprintf(table, "  %s\t%s\n", label, folder)
 	}
-	return err
+	for folder, accountName := range a.config().FolderAccount {
+		fmt.Fprintf(table, "  Account for %s\t%s\n", folder, accountName)
+	}
+	table.Flush()
+	fmt.Println()
 }
 
-func loadConfig() (config appConfig, err error) {
-	configFileContent, err := os.Open(configFileName)
-	if err != nil {
-		return config, err
-	}
-	defer configFileContent.Close()
+func (a *app) runOption(ctx context.Context, userOption string, backToMenu bool) {
+	if userOption == "e" {
+		a.executeApp(ctx)
+	} else if userOption == "tui" {
+		a.runTUI(ctx)
+	} else if userOption == "b" {
+		a.openState()
+		if err := a.prepareBackup(ctx); err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to start the backup", "error", err)
+		}
+		code := a.backupWatchedFolders(ctx, queue.PriorityManual).ExitCode()
+		if a.state != nil {
+			a.state.Close()
+		}
+		tracing.Shutdown()
+		os.Exit(code)
+	} else if userOption == "p" {
+		// pauses or resumes the app running in another terminal
+		if err := control.Run(ctx, controlSocket(a.config()), "toggle", os.Stdout); err != nil {
+			slog.Error("Unable to pause or resume the running app", "error", err)
+		}
+		if backToMenu {
+			a.showAppMenu(ctx)
+		}
+	} else if userOption == "get" {
+		if len(optionArgs) < 1 {
+			logging.Exit(exitConfig, "Missing file to get, use get <remote-name> [-o local-path]")
+		}
+		a.openState()
+		err := a.getFile(ctx, optionArgs[0], "", getOutputFlag)
+		if a.state != nil {
+			a.state.Close()
+		}
+		if err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to get file", "file", optionArgs[0], "error", err)
+		}
+	} else if userOption == "revisions" {
+		if len(optionArgs) < 1 {
+			logging.Exit(exitConfig, "Missing file, use revisions <remote-name> [<revision> [-o local-path]]")
+		}
+		var err error
+		if len(optionArgs) < 2 {
+			err = a.listRevisions(ctx, optionArgs[0])
+		} else {
+			a.openState()
+			err = a.getFile(ctx, optionArgs[0], optionArgs[1], getOutputFlag)
+			if a.state != nil {
+				a.state.Close()
+			}
+		}
+		if err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to get the revisions", "file", optionArgs[0], "error", err)
+		}
+	} else if userOption == "snapshot" {
+		var err error
+		if len(optionArgs) < 1 {
+			err = a.listSnapshots(ctx)
+		} else {
+			err = a.restoreSnapshot(ctx, optionArgs[0], getOutputFlag)
+		}
+		if err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to get the snapshots", "error", err)
+		}
+	} else if userOption == "pruneremote" {
+		// prune-remote, the dashes of the option are removed
+		a.openState()
+		err := a.pruneRemote(ctx, yesFlag, forceFlag)
+		if a.state != nil {
+			a.state.Close()
+		}
+		if err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to prune the backed up files", "error", err)
+		}
+	} else if userOption == "deduperemote" {
+		// dedupe-remote, the dashes of the option are removed
+		a.openState()
+		err := a.dedupeRemote(ctx, yesFlag)
+		if a.state != nil {
+			a.state.Close()
+		}
+		if err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to remove the duplicate backed up files", "error", err)
+		}
+	} else if userOption == "gc" {
+		a.openState()
+		err := a.collectGarbage(ctx, yesFlag)
+		if a.state != nil {
+			a.state.Close()
+		}
+		if err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to apply the retention policy", "error", err)
+		}
+	} else if userOption == "diff" {
+		a.openState()
+		differs, err := a.diffRemote(ctx)
+		if a.state != nil {
+			a.state.Close()
+		}
+		if err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to compare the watched folders with the backup", "error", err)
+		}
+		if differs {
+			os.Exit(exitFailed)
+		}
+	} else if userOption == "adopt" {
+		a.openState()
+		err := a.adoptRemote(ctx)
+		if a.state != nil {
+			a.state.Close()
+		}
+		if err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to adopt the backed up files", "error", err)
+		}
+	} else if userOption == "q" {
+		os.Exit(exitOK)
+	} else if userOption == "c" {
+		a.createConfig()
+		a.configFolderToWatch()
 
-	jsonParser := json.NewDecoder(configFileContent)
-	err = jsonParser.Decode(&config)
-	if err != nil {
-		return config, err
+		if backToMenu {
+			a.showAppMenu(ctx)
+		}
+	} else if userOption == "a" {
+		a.addFolderToWatch()
+		if backToMenu {
+			a.showAppMenu(ctx)
+		}
+	} else if userOption == "r" {
+		a.removeFolderToWatch()
+		if backToMenu {
+			a.showAppMenu(ctx)
+		}
+	} else if userOption == "s" {
+		a.showAppConfig()
+		if backToMenu {
+			a.showAppMenu(ctx)
+		}
+	} else if userOption == "h" || userOption == "history" {
+		filter := ""
+		if len(optionArgs) > 0 {
+			filter = optionArgs[0]
+		}
+		a.history.Show(filter)
+		if backToMenu {
+			a.showAppMenu(ctx)
+		}
+	} else {
+		logging.Exit(exitConfig, "Wrong option", "option", userOption)
 	}
-
-	return config, nil
 }
 
-func saveConfigJSONFile() {
-	//save json file
-	jsonContent, err := json.Marshal(configApp)
-	if err != nil {
-		log.Printf("ERROR! Cannot create config file: %v ", err)
-	} else {
-		ioutil.WriteFile(configFileName, jsonContent, 0644)
+func (a *app) showAppMenu(ctx context.Context) {
+	requireTerminal("run with an option like e")
+	optionsWithAppConfig := [][2]string{
+		{"c", "Configure (remove previous configuration)"},
+		{"s", "Show configuration"},
+		{"a", "Add path to listen"},
+		{"r", "Remove path to listen"},
+		{"h", "Show upload history"},
+		{"b", "Backup once and exit"},
+		{"e", "Execute"},
+		{"p", "Pause or resume the running app"},
+		{"q", "Exit"},
+	}
+	optionsWithoutAppConfig := [][2]string{
+		{"c", "Configure"},
+		{"x", "Exit"},
 	}
-}
 
-func createConfig() (config appConfig) {
-	// create config file
-	folderName := "EncryptBckDoc"
-	var inputFolderName string
-	fmt.Print("Name for the folder to save files (default: EncryptBckDoc): ")
-	fmt.Scanln(&inputFolderName)
-	if inputFolderName != "" {
-		folderName = inputFolderName
+	options := optionsWithoutAppConfig
+	if a.config().FolderName != "" || a.config().FolderID != "" {
+		options = optionsWithAppConfig
 	}
-	configApp = appConfig{
-		FolderName: folderName,
+	fmt.Println(logging.Colorize(logging.Bold, "Options (case insensitive):"))
+	for _, option := range options {
+		fmt.Printf("  %s  %s\n", logging.Colorize(logging.Bold, option[0]), option[1])
 	}
-	//save json file
-	saveConfigJSONFile()
 
-	return configApp
+	userOption := strings.ToLower(ask("Option: ", ""))
+
+	a.runOption(ctx, userOption, true)
 }
 
-func createFolderInDrive(folderName string) (folderFile *drive.File, err error) {
-	log.Printf("Error finding %s : %v\n", folderName, err)
-	// create folder
-	fileMeta := &drive.File{
-		Name:     folderName,
-		MimeType: "application/vnd.google-apps.folder",
-	}
-	folderFile, err = driveSrv.Files.Create(fileMeta).Do()
+// executeApp backs up the watched folders and keeps uploading their changes
+// until ctx is cancelled.
+func (a *app) executeApp(ctx context.Context) {
+	a.startDaemon(ctx)
+	a.backupWatchedFolders(ctx, queue.PriorityBulk)
 
-	return folderFile, err
+	<-ctx.Done()
+	a.shutdown()
 }
 
-func isNotAppFile(fileName string) (isIt bool) {
-	for _, name := range appFiles {
-		indexOfName := strings.LastIndex(fileName, name)
-		nameLength := len(name)
-		fileNameLength := len(fileName)
-		if fileNameLength-nameLength == indexOfName {
-			return false
-		}
+// runTUI runs the app like executeApp, showing the terminal UI until the
+// user quits.
+func (a *app) runTUI(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a.startDaemon(ctx)
+	go a.backupWatchedFolders(ctx, queue.PriorityBulk)
+
+	err := tui.Run(ctx, tui.Options{
+		Status: a.status,
+		Pause:  a.pause,
+		Resume: a.resume,
+		Scan: func() {
+			go a.backupWatchedFolders(ctx, queue.PriorityManual)
+		},
+		Events: a.events,
+	})
+	if err != nil {
+		slog.Error("Terminal UI failed", "error", err)
 	}
-	return true
+	cancel()
+	a.shutdown()
 }
 
-func isNotHiddenFile(fileName string) (isHidden bool) {
-	return strings.Index(fileName, "/.") != -1
+// printVersion prints the build of the app, as a JSON event with the json
+// output.
+func printVersion() {
+	info := buildinfo.Get()
+	if logging.JSONOutput() {
+		json.NewEncoder(os.Stdout).Encode(struct {
+			Event string `json:"event"`
+			buildinfo.Info
+		}{"version", info})
+		return
+	}
+	fmt.Printf("EncryptBckDocs %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Printf("commit:  %s\n", info.Commit)
+	}
+	if info.Date != "" {
+		fmt.Printf("built:   %s\n", info.Date)
+	}
+	fmt.Printf("go:      %s %s/%s\n", info.GoVersion, runtime.GOOS, runtime.GOARCH)
 }
 
-func runWatcher(parentFolder *drive.File) {
+// startDaemon prepares the backup and starts watching the folders and
+// serving the control API and the dashboard.
+func (a *app) startDaemon(ctx context.Context) {
+	slog.Info("Starting", "version", buildinfo.Get().String())
+	a.openState()
+	if err := a.prepareBackup(ctx); err != nil {
+		logging.Exit(exitCode(err, exitFailed), "Unable to start the backup", "error", err)
+	}
+	// watch first, so files edited during the initial scan jump ahead of it
+	a.startWatcher(ctx)
+	go a.watchSignals(ctx)
+	go a.pipeline.WatchPower(ctx)
+	go a.pipeline.WatchNetwork(ctx)
+	a.serveControl(ctx)
+	a.serveDashboard()
+}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
+// shutdown releases the state database and flushes the traces.
+func (a *app) shutdown() {
+	slog.Info("Shutting down")
+	if a.state != nil {
+		a.state.Close()
 	}
-	defer watcher.Close()
-
-	done := make(chan bool)
-	go func() {
-		for {
-			select {
-			case event := <-watcher.Events:
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					if isNotAppFile(event.Name) && !isNotHiddenFile(event.Name) {
-						//onlyFileName := strings.Replace(event.Name, actualFileToWatch+"/", "", -1)
-						lastPos := strings.LastIndex(event.Name, string(os.PathSeparator))
-						actualFileToWatch := event.Name[0:lastPos]
-						onlyFileName := event.Name[(lastPos + 1):len(event.Name)]
-						log.Println("ToReplace: ", actualFileToWatch+string(os.PathSeparator), " - name: ", event.Name, "  onlyFileName: ", onlyFileName)
-						processUpload(event.Name, onlyFileName, parentFolder)
-					}
-				}
-			case err := <-watcher.Errors:
-				log.Println("error:", err)
-			}
-		}
-	}()
+	tracing.Shutdown()
+}
 
-	for _, actualFileToWatch := range configApp.FolderToWatch {
-		log.Println("add to watch: ", actualFileToWatch)
-		err = watcher.Add(actualFileToWatch)
-		if err != nil {
-			log.Fatal(err)
-		}
+// serveControl serves the control API, so other invocations of the app can
+// pause, resume or trigger a backup. The app runs without it on failure.
+func (a *app) serveControl(ctx context.Context) {
+	server := &control.Server{
+		Pause:  a.pause,
+		Resume: a.resume,
+		Backup: func() any {
+			return a.backupWatchedFolders(ctx, queue.PriorityManual)
+		},
+		Sync: func() bool {
+			return a.syncNow(ctx)
+		},
+		Status: a.status,
+		Events: a.events,
+	}
+	if err := server.Serve(controlSocket(a.config())); err != nil {
+		slog.Error("Unable to serve the control API", "error", err)
 	}
-
-	<-done
-
 }
 
-func uploadActualFilesInWatchDir(parentFolder *drive.File) {
-	for _, actualFolderToWatch := range configApp.FolderToWatch {
-		log.Println("-uploadActualFilesInWatchDir: ", actualFolderToWatch)
-		files, err := ioutil.ReadDir(actualFolderToWatch)
-		if err != nil {
-			log.Println("Error uploadActualFilesInWatchDir: ", err)
-		} else {
-			for _, actualFile := range files {
-				if !actualFile.IsDir() {
-					totalName := actualFolderToWatch + "/" + actualFile.Name()
-					if isNotAppFile(totalName) && !isNotHiddenFile(totalName) {
-						processUpload(totalName, actualFile.Name(), parentFolder)
-					}
-				}
-			}
-		}
+// serveDashboard serves the web dashboard when an address is set.
+func (a *app) serveDashboard() {
+	address := config.Resolve(dashboardFlag, "ENCRYPTBCKDOCS_DASHBOARD_ADDRESS", a.config().DashboardAddress, "")
+	if address == "" {
+		return
+	}
+	server := &dashboard.Server{Status: a.status, Accounts: a.accounts, State: a.state}
+	a.events.Subscribe(server.HandleEvent)
+	if err := server.Serve(address); err != nil {
+		slog.Error("Unable to serve the dashboard", "error", err)
 	}
 }
 
-func processUpload(uploadFilePath string, uploadFileName string, parentFolder *drive.File) {
-	goFile, err := os.Open(uploadFilePath)
-	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+// pause stops the workers taking queued files, changes keep being queued.
+func (a *app) pause() {
+	if !a.pipeline.Queue.Paused() {
+		slog.Info("Uploads paused", "queued", a.pipeline.Queue.Len())
 	}
+	a.pipeline.Queue.Pause()
+}
 
-	var driveFileToUpload *drive.File
-	driveFileToUpload, err = findUploadFileInDrive(uploadFileName, parentFolder.Id)
-	if err != nil {
-		log.Fatalf("Error checking if file \"%s\" already exists", uploadFileName)
+// resume lets the workers upload the queued files again.
+func (a *app) resume() {
+	if a.pipeline.Queue.Paused() {
+		slog.Info("Uploads resumed", "queued", a.pipeline.Queue.Len())
 	}
+	a.pipeline.Queue.Resume()
+}
 
-	if driveFileToUpload != nil {
-		log.Println("Update existing file to Drive")
-		updateFileInDrive(driveFileToUpload, goFile)
+// togglePause resumes the uploads when paused and pauses them otherwise.
+func (a *app) togglePause() {
+	if a.pipeline.Queue.Paused() {
+		a.resume()
 	} else {
-		log.Println("Update new file to Drive")
-		uploadNewFileToDrive(parentFolder, uploadFileName, uploadFilePath, goFile)
+		a.pause()
 	}
 }
 
-func configFolderToWatch() {
-	if len(configApp.FolderToWatch) == 0 || configApp.FolderToWatch[0] == "" {
-		var folderToWatch string
-		fmt.Print("Path to watch (default-actual folder: \".\" ): ")
-		fmt.Scanln(&folderToWatch)
-		if folderToWatch == "" {
-			folderToWatch = "."
-		}
-		//save in config file
-		folderToWatch, _ = filepath.Abs(filepath.Dir(folderToWatch))
-		configApp.FolderToWatch = []string{folderToWatch}
-		saveConfigJSONFile()
+// status returns the state of the running app.
+func (a *app) status() control.Status {
+	return control.Status{
+		Paused:        a.pipeline.Queue.Paused(),
+		Offline:       a.pipeline.Offline(),
+		QuotaExceeded: a.pipeline.QuotaExceeded(),
+		BatteryLow:    a.pipeline.BatteryLow(),
+		Metered:       a.pipeline.Metered(),
+		CircuitOpen:   a.pipeline.CircuitOpen(),
+		Queued:        a.pipeline.Queue.Len(),
+		Folders:       a.config().FolderToWatch,
+		LastUpdate:    a.pipeline.LastUpdate(),
+		Version:       buildinfo.Get().String(),
 	}
 }
 
-func addFolderToWatch() {
-	if len(configApp.FolderToWatch) == 0 {
-		log.Println("Lauch config option first!!")
-	} else {
-		var folderToWatch string
-		fmt.Print("Path to watch (default-actual folder: \".\" ): ")
-		fmt.Scanln(&folderToWatch)
-		if folderToWatch == "" {
-			folderToWatch = "."
-		}
-
-		//save in config file
-		folderToWatch, _ = filepath.Abs(filepath.Dir(folderToWatch + "/"))
+// controlSocket returns the path of the control socket set in cfg.
+func controlSocket(cfg *config.Config) string {
+	return config.Resolve("", "ENCRYPTBCKDOCS_CONTROL_SOCKET", cfg.ControlSocket, control.SocketName)
+}
 
-		isFolderInConfig := false
-		for _, actualFoldertoWatch := range configApp.FolderToWatch {
-			if actualFoldertoWatch == folderToWatch {
-				isFolderInConfig = true
-			}
-		}
-		if isFolderInConfig {
-			log.Println("ERROR!! - The folder ir already in config!")
-		} else {
-			configApp.FolderToWatch = append(configApp.FolderToWatch, folderToWatch)
-			saveConfigJSONFile()
+// prepareBackup lowers the priority of the app when configured, looks up
+// the destination folder, asks for the folders to watch when there are none
+// and authorizes every configured account.
+func (a *app) prepareBackup(ctx context.Context) error {
+	if a.config().LowPriority {
+		// the scans and the hashing yield to what the user is doing
+		if err := priority.Lower(); err != nil {
+			slog.Warn("Unable to lower the priority of the app", "error", err)
 		}
 	}
-}
+	slog.Info("Looking for folder", "folder", a.config().FolderName, "id", a.config().FolderID)
 
-func removeFolderToWatch() {
-	pathToWatchLen := len(configApp.FolderToWatch)
-	if pathToWatchLen <= 0 {
-		log.Println("ERROR - There is no paths configured yet!!")
-	} else {
-		log.Println("Available options:")
-		for i, path := range configApp.FolderToWatch {
-			fmt.Printf("\t%d - %s\n", (i + 1), path)
-		}
+	account, err := a.accounts.Get(ctx, "")
+	if err != nil {
+		return fmt.Errorf("Unable to find the destination folder: %v", err)
+	}
+	folderFile := account.Folder()
 
-		userOption := ""
-		log.Print("Your choice: ")
-		fmt.Scanln(&userOption)
+	slog.Info("Found folder", "folder", folderFile.Name, "id", folderFile.Id)
 
-		intUserOption, err := strconv.Atoi(userOption)
-		if err != nil || intUserOption < pathToWatchLen {
-			fmt.Printf("\nERROR - Wrong option!!. Valid options should be from 1 to %d\n\n", pathToWatchLen)
-		} else {
-			intUserOption = intUserOption - 1
-			configApp.FolderToWatch = append(configApp.FolderToWatch[:intUserOption], configApp.FolderToWatch[intUserOption+1:]...)
+	a.configFolderToWatch()
+	if a.config().RemoteConfig {
+		if err := a.saveRemoteConfig(ctx); err != nil {
+			slog.Warn("Unable to save the config in Drive", "error", err)
 		}
 	}
-}
-
-func showAppConfig() {
-	fmt.Printf("\n### Actual configuration ####\n")
-	fmt.Printf("###  - Destination folder in Drive: %s\n", configApp.FolderName)
-	fmt.Printf("###  - Last syncronization time: %s\n", configApp.LastUpdate)
-	fmt.Printf("###  - Local watching folder: %s\n", configApp.FolderToWatch)
-	fmt.Printf("### #################### ####\n\n")
-}
-
-func runOption(userOption string, backToMenu bool) {
-	if userOption == "e" {
-		executeApp()
-	} else if userOption == "q" {
-		os.Exit(0)
-	} else if userOption == "c" {
-		configApp = createConfig()
-		configFolderToWatch()
 
-		if backToMenu {
-			showAppMenu()
-		}
-	} else if userOption == "a" {
-		addFolderToWatch()
-		if backToMenu {
-			showAppMenu()
+	// authorize every configured account before starting
+	destinations := []*drive.Account{account}
+	for _, actualFolderToWatch := range a.config().FolderToWatch {
+		folderAccount, err := a.accounts.ForFolder(ctx, actualFolderToWatch)
+		if err != nil {
+			slog.Error("Error getting Drive account", "error", err)
+		} else if !slices.Contains(destinations, folderAccount) {
+			destinations = append(destinations, folderAccount)
 		}
-	} else if userOption == "r" {
-		removeFolderToWatch()
-		if backToMenu {
-			showAppMenu()
+	}
+	for _, destination := range destinations {
+		others, err := a.pipeline.RegisterDevice(ctx, destination)
+		if err != nil {
+			slog.Warn("Unable to check the other machines backing up to the destination folder", "folder", destination.Folder().Name, "error", err)
 		}
-	} else if userOption == "s" {
-		showAppConfig()
-		if backToMenu {
-			showAppMenu()
+		if len(others) > 0 {
+			slog.Warn("Other machines back up to the same destination folder, give each one its own hostFolder to keep their files apart",
+				"folder", destination.Folder().Name, "machines", strings.Join(others, ", "))
 		}
-	} else {
-		log.Fatal("Wrong option: ", userOption)
 	}
+	return nil
 }
 
-func showAppMenu() {
-	optionsWithAppConfig := fmt.Sprintf("Options(case insensitive):\n" +
-		"  c - Configure (remove previous configuration)\n" +
-		"  s - Show Configuration\n" +
-		"  a - Add path to listen\n" +
-		"  r - Remove path to listen\n" +
-		"  e - Execute\n" +
-		"  q - Exit\n")
-	optionsWithoutAppConfig := fmt.Sprintf("Options:\n" +
-		"  c - Configure\n" +
-		"  x - Exit\n")
-
-	if configApp.FolderName != "" {
-		fmt.Printf(optionsWithAppConfig)
-	} else {
-		fmt.Printf(optionsWithoutAppConfig)
+// backupWatchedFolders uploads the current contents of every watched
+// folder with priority and prints the summary of the pass.
+func (a *app) backupWatchedFolders(ctx context.Context, priority queue.Priority) *pipeline.Summary {
+	result := a.pipeline.ScanAll(ctx, priority)
+	if logging.ConsoleOutput() {
+		result.Print()
 	}
-
-	var userOption string
-	fmt.Print("Option: ")
-	fmt.Scanln(&userOption)
-	userOption = strings.ToLower(userOption)
-
-	runOption(userOption, true)
+	return result
 }
 
-func executeApp() {
-	fmt.Printf("Looking for folder \"%s\"...\n", configApp.FolderName)
+// syncNow starts backing up every watched folder in the background, for
+// the signal and the control request asking for it, unless a backup it
+// started is still running. It reports whether it started one.
+func (a *app) syncNow(ctx context.Context) bool {
+	if !a.syncing.CompareAndSwap(false, true) {
+		slog.Info("Backup already running, not starting another one")
+		return false
+	}
+	slog.Info("Backing up every watched folder now")
+	go func() {
+		defer a.syncing.Store(false)
+		a.backupWatchedFolders(ctx, queue.PriorityManual)
+	}()
+	return true
+}
 
-	folderFile, err := findHolderFolder(configApp.FolderName)
+func main() {
+	arguments, err := parseFlags(os.Args[1:])
 	if err != nil {
-		folderFile, err = createFolderInDrive(configApp.FolderName)
+		logging.Exit(exitConfig, "Invalid arguments", "error", err)
+	}
+	cfg, err := config.Load(config.FileName)
+	logging.Setup(logging.Options{
+		Verbose:    verboseFlag,
+		Quiet:      quietFlag,
+		JSON:       outputFlag == "json",
+		File:       config.Resolve(logFileFlag, "ENCRYPTBCKDOCS_LOG_FILE", cfg.LogFile, ""),
+		MaxSizeMB:  cfg.LogMaxSizeMB,
+		MaxAgeDays: cfg.LogMaxAgeDays,
+		MaxBackups: cfg.LogMaxBackups,
+		// the terminal UI owns the terminal, the log only goes to the file
+		NoConsole: len(arguments) >= 1 && arguments[0] == "tui",
+	})
+	if err != nil && !os.IsNotExist(err) && len(arguments) >= 1 &&
+		!slices.Contains([]string{"c", "doctor", "config", "init"}, strings.TrimLeft(arguments[0], "-")) {
+		// the menu and c let the user configure the app again
+		logging.Exit(exitConfig, "Unable to read the config", "file", config.FileName, "error", err)
+	} else if err != nil {
+		slog.Info("No app config yet")
+	}
+	// the environment overrides the config for this run, never saved
+	configStore := config.NewStore(cfg, config.FileName)
+	cfg = configStore.Config()
+	// cancelled on Ctrl-C or SIGTERM, stopping the uploads in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(arguments) >= 1 && strings.TrimLeft(arguments[0], "-") == "version" {
+		printVersion()
+		return
+	}
+
+	// control commands talk to the running app, they need no Drive access
+	if len(arguments) >= 1 && arguments[0] == "ctl" {
+		if len(arguments) < 2 {
+			logging.Exit(exitConfig, "Missing control command, use pause, resume, toggle, backup, sync, status or events")
+		}
+		if err = control.Run(ctx, controlSocket(cfg), arguments[1], os.Stdout); err != nil {
+			logging.Fatal("Control command failed", "error", err)
+		}
+		return
+	}
 
-		if err != nil {
-			panic(err)
+	// the system mode runs the app as every user, it needs no Drive access
+	if len(arguments) >= 1 && arguments[0] == "system" {
+		if len(arguments) == 1 {
+			err = runSystem(ctx)
 		} else {
-			fmt.Printf("Created folder \"%s\" for files!!\n", configApp.FolderName)
+			err = runSystemOption(ctx, arguments[1], arguments[2:])
 		}
+		if err != nil {
+			logging.Fatal("System mode failed", "error", err)
+		}
+		return
 	}
 
-	fmt.Printf("Found folder %s - ID: (%s) - TYPE:%s\n", folderFile.Name, folderFile.Id, folderFile.MimeType)
-
-	configFolderToWatch()
+	// config.json secrets are sealed with the master key and its backups
+	// are local files, no Drive access
+	if len(arguments) >= 2 && arguments[0] == "config" && arguments[1] == "backups" {
+		if err = listConfigBackups(); err != nil {
+			logging.Fatal("Unable to list the config backups", "error", err)
+		}
+		return
+	}
+	if len(arguments) >= 2 && arguments[0] == "config" && arguments[1] == "rollback" {
+		n := ""
+		if len(arguments) >= 3 {
+			n = arguments[2]
+		}
+		if err = rollbackConfig(n); err != nil {
+			logging.Exit(exitConfig, "Unable to roll back the config", "error", err)
+		}
+		return
+	}
+	if len(arguments) >= 1 && arguments[0] == "config" {
+		if len(arguments) < 3 || arguments[1] != "set-secret" {
+			logging.Exit(exitConfig, "Missing config command, use config set-secret "+secretKeys+", config backups or config rollback [n]")
+		}
+		if err = setSecret(configStore, err, arguments[2]); err != nil {
+			logging.Fatal("Unable to set the secret", "error", err)
+		}
+		return
+	}
 
-	uploadActualFilesInWatchDir(folderFile)
+	if len(arguments) >= 1 && arguments[0] == "init" {
+		if !fromRemoteFlag {
+			logging.Exit(exitConfig, "Missing source of the config, use init --from-remote [host]")
+		}
+		// the copy of the config is in the app data folder
+		saved := configStore.Copy()
+		configStore.Replace(&saved, func(cfg *config.Config) {
+			cfg.ApplyEnvironment()
+			cfg.RemoteConfig = true
+		})
+	}
+
+	authorizer := &auth.Authorizer{
+		Config:           cfg,
+		ClientSecretPath: config.Resolve(clientSecretFlag, "ENCRYPTBCKDOCS_CLIENT_SECRET", cfg.ClientSecretFile, clientSecretFileName),
+		TokenCachePath:   config.Resolve(tokenCacheFlag, "ENCRYPTBCKDOCS_TOKEN_CACHE", cfg.TokenCacheFile, ""),
+	}
+	a := newApp(ctx, configStore, authorizer)
+	if progressJSONFlag != "" {
+		progress, err := openProgressJSON(progressJSONFlag)
+		if err != nil {
+			logging.Exit(exitConfig, "Unable to write the progress events", "error", err)
+		}
+		a.events.Subscribe(events.NewWriter(progress).HandleEvent)
+	}
+	if os.Getenv("ENCRYPTBCKDOCS_FAKE_DRIVE") != "" {
+		// a Drive in memory, to try the app without credentials
+		fake := drivetest.NewServer()
+		defer fake.Close()
+		a.accounts.Connect = fake.Connect(drive.ChunkSize(cfg))
+		slog.Warn("Uploading to a fake Drive in memory, nothing is backed up")
+	}
 
-	runWatcher(folderFile)
-}
+	auditPermissions(cfg, authorizer, strictFlag)
 
-func main() {
-	arguments := os.Args[1:]
+	// checked before authorizing, which could ask the user
+	if len(arguments) >= 1 && arguments[0] == "doctor" {
+		if !doctor(ctx, cfg, err, authorizer, a.accounts) {
+			os.Exit(exitFailed)
+		}
+		return
+	}
 
 	// start config for Drive
-	context := context.Background()
-
-	b, err := ioutil.ReadFile("client_secret.json")
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+	if _, err = a.accounts.Authorize(ctx, ""); err != nil {
+		logging.Exit(exitCode(err, exitAuth), "Unable to retrieve drive Client", "error", err)
 	}
 
-	// If modifying these scopes, delete your previously saved credentials
-	// at ~/.credentials/drive-go-quickstart.json
-	config, err := google.ConfigFromJSON(b, drive.DriveScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
-	}
-	client := getClient(context, config)
+	// end config for Drive
 
-	driveSrv, err = drive.New(client)
-	if err != nil {
-		log.Fatalf("Unable to retrieve drive Client %v", err)
+	if len(arguments) >= 1 && arguments[0] == "init" {
+		host := ""
+		if len(arguments) >= 2 {
+			host = arguments[1]
+		}
+		if err = a.initFromRemote(ctx, host); err != nil {
+			logging.Exit(exitCode(err, exitFailed), "Unable to get the config from Drive", "error", err)
+		}
+		return
 	}
 
-	// end config for Drive
+	if err = tracing.Setup(ctx, cfg.TracingEndpoint); err != nil {
+		slog.Error("Unable to set up tracing", "error", err)
+	}
 
-	configApp, err = loadConfig()
-	if err != nil {
-		//configApp = createConfig()
-		fmt.Println("No app config yet")
+	if metricsAddress := config.Resolve(metricsAddrFlag, "ENCRYPTBCKDOCS_METRICS_ADDRESS", cfg.MetricsAddress, ""); metricsAddress != "" {
+		a.metrics.Serve(metricsAddress)
 	}
 
-	fmt.Println(arguments)
 	if len(arguments) >= 1 {
-		fmt.Println("Execute listen")
 		userOption := strings.Replace(arguments[0], "-", "", -1)
-		fmt.Println("userOption: ", userOption)
-		runOption(userOption, false)
+		optionArgs = arguments[1:]
+		slog.Debug("Running option", "option", userOption)
+		a.runOption(ctx, userOption, false)
 	} else {
-		showAppMenu()
+		a.showAppMenu(ctx)
 	}
 
 }
-
-/*
-TODO
-	add more folder to watch
-*/
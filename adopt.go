@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// adoptRemote fills state.db with the files of the watched folders already
+// in their destination folder, like a backup made from another machine, so
+// they are not uploaded again. A local file is adopted when a Drive file
+// with its name, gzipped or not, was uploaded with its SHA-256; the files
+// state.db already knows are left alone. The folders in archive mode,
+// backed up as chunks, organized by date or with a remote template are
+// not adopted.
+func (a *app) adoptRemote(ctx context.Context) error {
+	if a.state == nil {
+		return fmt.Errorf("No state database to adopt the files in")
+	}
+	listed := make(map[string][]*drivev3.File)
+	var adopted, known, differ, missing int
+	for _, folder := range a.config().FolderToWatch {
+		if a.config().ArchiveFolder(folder) || a.config().RepositoryFolder(folder) {
+			slog.Info("Folder in archive mode or backed up as chunks, not adopted", "folder", folder)
+			continue
+		}
+		if a.config().DateFolder(folder) || a.config().TemplateForFolder(folder) != "" {
+			slog.Info("Folder organized by date or with a remote template, not adopted", "folder", folder)
+			continue
+		}
+		account, err := a.accounts.ForFolder(ctx, folder)
+		if err != nil {
+			return err
+		}
+		files, ok := listed[account.Name()]
+		if !ok {
+			if files, err = account.Client().ListFiles(ctx, account.Folder().Id); err != nil {
+				return fmt.Errorf("Unable to list the backed up files: %v", err)
+			}
+			listed[account.Name()] = files
+		}
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			return fmt.Errorf("Unable to read folder %s: %v", folder, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(folder, entry.Name())
+			if !entry.Type().IsRegular() || !a.pipeline.Included(path) {
+				continue
+			}
+			if file, err := a.state.Get(path); err == nil && file != nil {
+				known++
+				continue
+			}
+			candidates := adoptCandidates(entry.Name(), files)
+			if len(candidates) == 0 {
+				missing++
+				continue
+			}
+			remoteFile, file, err := adoptFile(path, candidates)
+			if err != nil {
+				return err
+			}
+			if remoteFile == nil {
+				slog.Info("Backed up file differs from the local one, not adopted", "file", path)
+				differ++
+				continue
+			}
+			if err = a.state.Put(path, file); err != nil {
+				return fmt.Errorf("Unable to save the state of %s: %v", path, err)
+			}
+			slog.Info("Adopted backed up file", "file", path, "remote", remoteFile.Name, "account", account.Name())
+			adopted++
+		}
+	}
+	fmt.Printf("%d files adopted, %d already known, %d differ from their backup, %d not in Drive\n", adopted, known, differ, missing)
+	return nil
+}
+
+// adoptCandidates returns the files of files a local file called name may
+// have been uploaded to: the ones with its name, gzipped, encrypted or not.
+func adoptCandidates(name string, files []*drivev3.File) []*drivev3.File {
+	var candidates []*drivev3.File
+	for _, file := range files {
+		if _, _, part := pipeline.PartOf(file.Name); part || drive.IsFolder(file) {
+			continue
+		}
+		if file.Name == name || pipeline.UnprocessedName(file.Name) == name {
+			candidates = append(candidates, file)
+		}
+	}
+	return candidates
+}
+
+// adoptFile returns the file of candidates uploaded with the content of
+// the local file at path, and the state of the local file backed up to
+// it, nil when none matches.
+func adoptFile(path string, candidates []*drivev3.File) (*drivev3.File, state.File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, state.File{}, err
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return nil, state.File{}, err
+	}
+	for _, remoteFile := range candidates {
+		if drive.Property(remoteFile.AppProperties, drive.PropertyHash) != hash {
+			continue
+		}
+		uploaded, _ := time.Parse(time.RFC3339, remoteFile.ModifiedTime)
+		return remoteFile, state.File{
+			Hash:       hash,
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+			RemoteID:   remoteFile.Id,
+			LastUpload: uploaded,
+		}, nil
+	}
+	return nil, state.File{}, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// dedupeRemote keeps a single file of every name in the destination
+// folders, trashing the others after asking for confirmation unless yes is
+// set. The file a local file was uploaded to is kept, or the most recently
+// modified one when state.db knows none of them.
+func (a *app) dedupeRemote(ctx context.Context, yes bool) error {
+	knownIDs := a.remoteIDs()
+
+	var extras []accountFile
+	for _, name := range a.accountNames() {
+		account, err := a.accounts.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		files, err := account.Client().ListFiles(ctx, account.Folder().Id)
+		if err != nil {
+			return fmt.Errorf("Unable to list the backed up files: %v", err)
+		}
+		byName := make(map[string][]*drivev3.File)
+		var names []string
+		for _, file := range files {
+			if drive.IsFolder(file) {
+				continue
+			}
+			if len(byName[file.Name]) == 0 {
+				names = append(names, file.Name)
+			}
+			byName[file.Name] = append(byName[file.Name], file)
+		}
+		sort.Strings(names)
+		for _, fileName := range names {
+			same := byName[fileName]
+			if len(same) < 2 {
+				continue
+			}
+			kept := keptFile(same, knownIDs)
+			fmt.Printf("%s: keeping %s, modified %s (%s)\n", fileName, kept.Id, kept.ModifiedTime, humanize.Bytes(kept.Size))
+			for _, file := range same {
+				if file != kept {
+					fmt.Printf("\ttrash %s, modified %s (%s)\n", file.Id, file.ModifiedTime, humanize.Bytes(file.Size))
+					extras = append(extras, accountFile{account, file})
+				}
+			}
+		}
+	}
+	if len(extras) == 0 {
+		fmt.Println("No duplicate files in Drive")
+		return nil
+	}
+
+	if !confirm(fmt.Sprintf("Move the %d duplicates to the Drive trash?", len(extras)), yes) {
+		fmt.Println("Nothing trashed")
+		return nil
+	}
+	trashed := a.trashFiles(ctx, extras)
+	fmt.Printf("%d of %d duplicate files moved to the Drive trash\n", len(trashed), len(extras))
+	return nil
+}
+
+// keptFile returns the file of same, files with the same name, to keep:
+// the one state.db knows, else the most recently modified one.
+func keptFile(same []*drivev3.File, knownIDs map[string]bool) *drivev3.File {
+	kept := same[0]
+	for _, file := range same[1:] {
+		if knownIDs[file.Id] != knownIDs[kept.Id] {
+			if knownIDs[file.Id] {
+				kept = file
+			}
+		} else if file.ModifiedTime > kept.ModifiedTime {
+			// RFC 3339 times in UTC sort as strings
+			kept = file
+		}
+	}
+	return kept
+}
+
+// remoteIDs returns the Drive IDs of the files the local files were
+// uploaded to.
+func (a *app) remoteIDs() map[string]bool {
+	ids := make(map[string]bool)
+	if a.state == nil {
+		return ids
+	}
+	err := a.state.ForEach(func(path string, file state.File) error {
+		if file.RemoteID != "" {
+			ids[file.RemoteID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Unable to read file state", "error", err)
+	}
+	return ids
+}
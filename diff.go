@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+)
+
+// kinds of differences between the watched folders and the backup
+const (
+	diffOnlyLocal  = "only-local"
+	diffOnlyRemote = "only-remote"
+	diffChanged    = "changed"
+)
+
+// difference is a file differing between a watched folder and its backup.
+type difference struct {
+	Status string `json:"status"`
+	// Path is the local file, empty for a file only in Drive.
+	Path string `json:"path,omitempty"`
+	// Remote is the name of the Drive file, empty for a file only in a
+	// watched folder.
+	Remote  string `json:"remote,omitempty"`
+	Account string `json:"account,omitempty"`
+}
+
+// diffRemote prints the files only in a watched folder, the files of the
+// destination folders only in Drive and the files whose content differs
+// from their backup, comparing the SHA-256 of the local file with the one
+// saved at upload. It reports whether there is any difference. The folders
+// in archive mode, backed up as chunks, organized by date or with a remote
+// template are not compared.
+func (a *app) diffRemote(ctx context.Context) (bool, error) {
+	listed := make(map[string][]*drivev3.File)
+	matched := make(map[string]bool)
+	var differences []difference
+	for _, folder := range a.config().FolderToWatch {
+		if a.config().ArchiveFolder(folder) {
+			slog.Info("Folder in archive mode, not compared", "folder", folder)
+			continue
+		}
+		if a.config().RepositoryFolder(folder) {
+			slog.Info("Folder backed up as chunks, not compared", "folder", folder)
+			continue
+		}
+		if a.config().DateFolder(folder) || a.config().TemplateForFolder(folder) != "" {
+			slog.Info("Folder organized by date or with a remote template, not compared", "folder", folder)
+			continue
+		}
+		account, err := a.accounts.ForFolder(ctx, folder)
+		if err != nil {
+			return false, err
+		}
+		files, ok := listed[account.Name()]
+		if !ok {
+			if files, err = account.Client().ListFiles(ctx, account.Folder().Id); err != nil {
+				return false, fmt.Errorf("Unable to list the backed up files: %v", err)
+			}
+			listed[account.Name()] = files
+		}
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			return false, fmt.Errorf("Unable to read folder %s: %v", folder, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(folder, entry.Name())
+			if entry.IsDir() || !a.pipeline.Included(path) {
+				continue
+			}
+			remoteFile := a.backupOf(path, files)
+			if remoteFile == nil {
+				differences = append(differences, difference{Status: diffOnlyLocal, Path: path, Account: account.Name()})
+				continue
+			}
+			matched[remoteFile.Id] = true
+			changed, err := a.changedSinceBackup(path, remoteFile)
+			if err != nil {
+				return false, err
+			}
+			if changed {
+				differences = append(differences, difference{Status: diffChanged, Path: path, Remote: remoteFile.Name, Account: account.Name()})
+			}
+		}
+	}
+	for name, files := range listed {
+		for _, file := range files {
+			// parts go with their file, archives with their folder, and
+			// the date subfolders are not compared
+			if _, _, part := pipeline.PartOf(file.Name); matched[file.Id] || part || a.isArchive(file.Name) || drive.IsFolder(file) {
+				continue
+			}
+			differences = append(differences, difference{Status: diffOnlyRemote, Remote: file.Name, Account: name})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool {
+		if differences[i].Status != differences[j].Status {
+			return differences[i].Status < differences[j].Status
+		}
+		return differences[i].Path+differences[i].Remote < differences[j].Path+differences[j].Remote
+	})
+	printDifferences(differences)
+	return len(differences) > 0, nil
+}
+
+// backupOf returns the file of files the local file at path is backed up
+// to: the one state.db knows, else the one with its name, gzipped or not.
+func (a *app) backupOf(path string, files []*drivev3.File) *drivev3.File {
+	remoteID := ""
+	if a.state != nil {
+		if known, err := a.state.Get(path); err == nil && known != nil {
+			remoteID = known.RemoteID
+		}
+	}
+	name := filepath.Base(path)
+	var candidates []*drivev3.File
+	for _, file := range files {
+		if file.Id == remoteID {
+			return file
+		}
+		if file.Name == name || pipeline.UnprocessedName(file.Name) == name {
+			candidates = append(candidates, file)
+		}
+	}
+	return drive.PreferFile(candidates, a.isKnownFile)
+}
+
+// changedSinceBackup reports whether the content of the local file at
+// path differs from the one uploaded to remoteFile. The file is only
+// hashed when state.db does not know it unchanged since the upload.
+func (a *app) changedSinceBackup(path string, remoteFile *drivev3.File) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	uploaded := drive.Property(remoteFile.AppProperties, drive.PropertyHash)
+	var localHash string
+	if a.state != nil {
+		if known, err := a.state.Get(path); err == nil && known != nil && known.RemoteID == remoteFile.Id {
+			if uploaded == "" {
+				uploaded = known.Hash
+			}
+			if known.Unchanged(info.Size(), info.ModTime(), 0) {
+				localHash = known.Hash
+			}
+		}
+	}
+	if uploaded == "" {
+		slog.Warn("No hash of the backed up file, its content is not compared", "file", path, "remote", remoteFile.Name)
+		return false, nil
+	}
+	if localHash == "" {
+		if localHash, err = hashFile(path); err != nil {
+			return false, err
+		}
+	}
+	return localHash != uploaded, nil
+}
+
+// hashFile returns the SHA-256 of the local file at path, like the one
+// saved at upload.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("Unable to read %s: %v", path, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// printDifferences prints differences, one per line, as JSON events with
+// the json output.
+func printDifferences(differences []difference) {
+	if logging.JSONOutput() {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, d := range differences {
+			encoder.Encode(struct {
+				Event string `json:"event"`
+				difference
+			}{"diff", d})
+		}
+		return
+	}
+	if len(differences) == 0 {
+		fmt.Println("The backup matches the watched folders")
+		return
+	}
+	counts := make(map[string]int)
+	for _, d := range differences {
+		counts[d.Status]++
+		switch d.Status {
+		case diffOnlyLocal:
+			fmt.Printf("only local     %s\n", d.Path)
+		case diffOnlyRemote:
+			fmt.Printf("only in Drive  %s\n", d.Remote)
+		case diffChanged:
+			fmt.Printf("changed        %s (%s)\n", d.Path, d.Remote)
+		}
+	}
+	fmt.Printf("%d only local, %d only in Drive, %d changed\n", counts[diffOnlyLocal], counts[diffOnlyRemote], counts[diffChanged])
+}
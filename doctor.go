@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+	"github.com/amcereijo/EncryptBckDocs/internal/proxy"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// thresholds of the doctor checks
+const (
+	minStateFreeSpace = 100 << 20
+	maxClockSkew      = 5 * time.Minute
+)
+
+// clockURL is asked for the time of Google.
+const clockURL = "https://www.googleapis.com/"
+
+// checkResult is the result of a doctor check, failed when err is set.
+type checkResult struct {
+	name    string
+	err     error
+	fix     string
+	skipped bool
+}
+
+// doctor checks what the app needs to back up, printing every check as
+// passed, failed with how to fix it, or skipped when an earlier failure
+// prevents it. loadErr is the error loading cfg. It reports whether every
+// check passed.
+func doctor(ctx context.Context, cfg *config.Config, loadErr error, authorizer *auth.Authorizer, accounts *drive.Accounts) bool {
+	var results []checkResult
+	check := func(name string, err error, fix string) bool {
+		results = append(results, checkResult{name: name, err: err, fix: fix})
+		return err == nil
+	}
+	skip := func(name string) {
+		results = append(results, checkResult{name: name, skipped: true})
+	}
+
+	if os.IsNotExist(loadErr) {
+		loadErr = fmt.Errorf("%s not found", config.FileName)
+	}
+	configOK := check("config "+config.FileName, loadErr, "run the c option, or fix the JSON syntax")
+	if configOK && cfg.FolderName == "" && cfg.FolderID == "" {
+		check("destination folder", fmt.Errorf("no destination folder"), "run the c option to choose one")
+	}
+
+	for _, folder := range cfg.FolderToWatch {
+		info, err := os.Stat(folder)
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("not a folder")
+		}
+		check("watched folder "+folder, err, "create it or remove it with the r option")
+	}
+
+	if os.Getenv("ENCRYPTBCKDOCS_FAKE_DRIVE") != "" {
+		skip("credentials, Drive and clock, using a fake Drive")
+	} else {
+		credentialsOK := checkCredentials(authorizer, check, skip)
+		for _, name := range append([]string{""}, accountNamesOf(cfg)...) {
+			label := "account " + name
+			if name == "" {
+				label = "default account"
+			}
+			if !credentialsOK {
+				skip(label + " token")
+				continue
+			}
+			fix := "run any option of the app to authorize it again"
+			if authorizer.UsesServiceAccount(name) {
+				fix = "check the service account key is enabled"
+			}
+			if !check(label+" token", authorizer.CheckToken(ctx, name), fix) {
+				skip(label + " Drive access")
+				continue
+			}
+			var err error
+			if account, authErr := accounts.Authorize(ctx, name); authErr != nil {
+				err = authErr
+			} else {
+				_, err = account.Client().Quota(ctx)
+			}
+			check(label+" Drive access", err, "check the network, the proxy settings and that the Drive API is enabled")
+		}
+		if skew, err := clockSkew(cfg); err != nil {
+			check("clock", err, "check the network and the proxy settings")
+		} else if skew > maxClockSkew || skew < -maxClockSkew {
+			check("clock", fmt.Errorf("the clock is %s off", skew.Round(time.Second)), "synchronize the clock of the machine with NTP")
+		} else {
+			check("clock", nil, "")
+		}
+	}
+
+	if hasWatchLimits {
+		check("inotify limits", checkWatchLimits(len(cfg.FolderToWatch)), "raise fs.inotify.max_user_watches and fs.inotify.max_user_instances with sysctl")
+	} else {
+		skip("inotify limits, only on Linux")
+	}
+	loose := loosePermissions(privateFiles(cfg, authorizer))
+	for _, f := range loose {
+		check("permissions of "+f.path, fmt.Errorf("mode %s, accessible by other users", f.mode.Perm()), f.fix())
+	}
+	if len(loose) == 0 {
+		check("permissions of the private files", nil, "")
+	}
+	stateDir, _ := filepath.Abs(filepath.Dir(state.FileName))
+	free, err := freeSpace(stateDir)
+	if err == nil && free < minStateFreeSpace {
+		err = fmt.Errorf("%d MiB free in %s", free>>20, stateDir)
+	}
+	check("disk space for "+state.FileName, err, "free some disk space")
+
+	passed := true
+	for _, r := range results {
+		switch {
+		case r.skipped:
+			fmt.Printf("%s  %s\n", logging.Colorize(logging.Dim, "SKIP"), logging.Colorize(logging.Dim, r.name))
+		case r.err == nil:
+			fmt.Printf("%s  %s\n", logging.Colorize(logging.Green, "PASS"), r.name)
+		default:
+			passed = false
+			fmt.Printf("%s  %s: %v\n", logging.Colorize(logging.Red, "FAIL"), r.name, r.err)
+			fmt.Printf("      fix: %s\n", r.fix)
+		}
+	}
+	return passed
+}
+
+// checkCredentials checks the client secret, or the service account key,
+// can be read. It reports whether they can.
+func checkCredentials(authorizer *auth.Authorizer, check func(name string, err error, fix string) bool, skip func(name string)) bool {
+	if authorizer.Config.ServiceAccountFile != "" {
+		_, err := os.Stat(authorizer.Config.ServiceAccountFile)
+		if !check("service account key "+authorizer.Config.ServiceAccountFile, err, "download the key of the service account") {
+			return false
+		}
+		if len(authorizer.Config.FolderAccount) == 0 {
+			return true
+		}
+	}
+	if os.Getenv("ENCRYPTBCKDOCS_CLIENT_SECRET_JSON") != "" {
+		return check("client secret in ENCRYPTBCKDOCS_CLIENT_SECRET_JSON", nil, "")
+	}
+	if authorizer.ClientSecretPath == "-" {
+		// stdin is only read when authorizing
+		skip("client secret read from stdin")
+		return false
+	}
+	_, err := os.Stat(authorizer.ClientSecretPath)
+	return check("client secret "+authorizer.ClientSecretPath, err, "download the OAuth client of your Google Cloud project as "+authorizer.ClientSecretPath)
+}
+
+// accountNamesOf returns the names of the accounts of the watched folders
+// of cfg, the default one excluded.
+func accountNamesOf(cfg *config.Config) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range cfg.FolderAccount {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// clockSkew returns how far ahead of the clock of Google the clock of the
+// machine is, OAuth tokens being rejected when they are too far apart.
+func clockSkew(cfg *config.Config) (time.Duration, error) {
+	client, err := proxy.NewClient(cfg)
+	if err != nil {
+		return 0, err
+	}
+	client.Timeout = 30 * time.Second
+	start := time.Now()
+	resp, err := client.Head(clockURL)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to reach Google: %v", err)
+	}
+	resp.Body.Close()
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("No time in the answer of Google: %v", err)
+	}
+	// the Date header has a precision of a second, taken halfway
+	local := start.Add(time.Since(start) / 2)
+	return local.Sub(remote), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// hasWatchLimits is true, Linux limits the inotify watches and instances.
+const hasWatchLimits = true
+
+// checkWatchLimits checks the inotify limits leave room to watch folders
+// folders, one watch each, plus the config file.
+func checkWatchLimits(folders int) error {
+	watches, err := readLimit("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return err
+	}
+	if watches < folders+1 {
+		return fmt.Errorf("max_user_watches is %d, %d folders are watched", watches, folders)
+	}
+	instances, err := readLimit("/proc/sys/fs/inotify/max_user_instances")
+	if err != nil {
+		return err
+	}
+	if instances < 2 {
+		return fmt.Errorf("max_user_instances is %d", instances)
+	}
+	return nil
+}
+
+func readLimit(file string) (int, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
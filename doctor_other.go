@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+// hasWatchLimits is false, only Linux has inotify limits.
+const hasWatchLimits = false
+
+func checkWatchLimits(folders int) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+)
+
+// exit codes of the commands, for the scripts and cron jobs running them
+const (
+	exitOK = 0
+	// some files could not be backed up, or the command failed
+	exitFailed = 1
+	// the config, the arguments or the files of the app are wrong
+	exitConfig = 2
+	// Google refused the credentials or the authorization could not be done
+	exitAuth = 3
+	// Drive could not be reached
+	exitUnreachable = 4
+)
+
+// exitCode returns the exit code of a command failing with err, fallback
+// when err does not tell what went wrong.
+func exitCode(err error, fallback int) int {
+	switch {
+	case drive.IsOffline(err):
+		return exitUnreachable
+	case auth.IsInvalidGrant(err) || drive.IsUnauthorized(err):
+		return exitAuth
+	case drive.IsNotFound(err):
+		// the destination folder ID of the config does not exist
+		return exitConfig
+	}
+	return fallback
+}
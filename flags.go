@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+)
+
+var clientSecretFlag string // --client-secret value
+var tokenCacheFlag string   // --token-cache value
+var verboseFlag bool        // --verbose value
+var quietFlag bool          // --quiet value
+var logFileFlag string      // --log-file value
+var metricsAddrFlag string  // --metrics-address value
+var dashboardFlag string    // --dashboard-address value
+var outputFlag string       // --output value
+var getOutputFlag string    // -o value of the get command
+var yesFlag bool            // --yes value
+var forceFlag bool          // --force value
+var strictFlag bool         // --strict value
+var progressJSONFlag string // --progress-json value
+var fromRemoteFlag bool     // --from-remote value of the init command
+
+var optionArgs []string // command line arguments after the menu option
+
+// parseFlags parses the command line flags in args.
+// It returns the remaining arguments, the menu option being the first one.
+func parseFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("EncryptBckDocs", flag.ContinueOnError)
+	flags.StringVar(&clientSecretFlag, "client-secret", "", "path of the OAuth client secret file (env ENCRYPTBCKDOCS_CLIENT_SECRET)")
+	flags.StringVar(&tokenCacheFlag, "token-cache", "", "path of the cached OAuth token (env ENCRYPTBCKDOCS_TOKEN_CACHE)")
+	flags.BoolVar(&verboseFlag, "verbose", false, "log debug messages")
+	flags.BoolVar(&quietFlag, "quiet", false, "only log warnings and errors")
+	flags.StringVar(&logFileFlag, "log-file", "", "also write the log to this file, with rotation")
+	flags.StringVar(&outputFlag, "output", "text", "output format: text or json (newline-delimited events)")
+	flags.StringVar(&metricsAddrFlag, "metrics-address", "", "serve Prometheus metrics on this address, e.g. 127.0.0.1:9184")
+	flags.StringVar(&dashboardFlag, "dashboard-address", "", "serve the web dashboard on this loopback address, e.g. 127.0.0.1:8484")
+	flags.StringVar(&getOutputFlag, "o", "", "file the get and revisions commands, or folder the snapshot command, write to")
+	flags.BoolVar(&yesFlag, "yes", false, "do not ask for confirmation before prune-remote, dedupe-remote or gc remove files")
+	flags.BoolVar(&strictFlag, "strict", false, "refuse to run when the config, state or credential files can be read by other users")
+	flags.StringVar(&progressJSONFlag, "progress-json", "", "write every event as a line of JSON to this file, - for stdout or a number for an inherited file descriptor")
+	flags.BoolVar(&fromRemoteFlag, "from-remote", false, "init writes config.json from the copy saved in Drive with remoteConfig")
+	flags.BoolVar(&forceFlag, "force", false, "let prune-remote trash more files than the delete protection allows")
+
+	// menu options can be given as "-e" too, keep them and the arguments
+	// of the option out of the flag parser, flags may follow them
+	var options, flagArgs []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if len(name) == 1 && flags.Lookup(name) == nil || !strings.HasPrefix(args[i], "-") {
+			options = append(options, args[i])
+			continue
+		}
+		flagArgs = append(flagArgs, args[i])
+		if !strings.Contains(args[i], "=") && takesValue(flags.Lookup(name)) && i+1 < len(args) {
+			i++
+			flagArgs = append(flagArgs, args[i])
+		}
+	}
+
+	if err := flags.Parse(flagArgs); err != nil {
+		return nil, err
+	}
+	if outputFlag != "text" && outputFlag != "json" {
+		return nil, errors.New("Invalid output format " + outputFlag + ", use text or json")
+	}
+	return append(options, flags.Args()...), nil
+}
+
+// takesValue reports whether f is a known flag followed by its value.
+func takesValue(f *flag.Flag) bool {
+	if f == nil {
+		return false
+	}
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !boolFlag.IsBoolFlag()
+}
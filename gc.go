@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+	"github.com/amcereijo/EncryptBckDocs/internal/retention"
+)
+
+// accountGarbage is what the retention policy collects in the repository
+// of account.
+type accountGarbage struct {
+	account *drive.Account
+	garbage *pipeline.Garbage
+}
+
+// accountRevision is a revision of a backed up file in the destination
+// folder of account.
+type accountRevision struct {
+	account  *drive.Account
+	file     *drivev3.File
+	revision *drivev3.Revision
+}
+
+// collectGarbage applies the retention policy to the destination folders
+// after asking for confirmation, unless yes is set. The revisions of every
+// backed up file it keeps are kept forever, as Drive deletes the others
+// after 30 days, and the ones it does not keep are deleted now, apart from
+// the ones pinned after a mass change. The
+// archive cycles and the snapshots it does not keep, the parts no split
+// file lists anymore and the chunks no kept snapshot lists are moved to
+// the Drive trash.
+func (a *app) collectGarbage(ctx context.Context, yes bool) error {
+	now := time.Now()
+	var pinned, expired []accountRevision
+	var unreferenced []accountFile
+	var repositories []accountGarbage
+	chunks := 0
+	for _, name := range a.accountNames() {
+		account, err := a.accounts.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		files, err := account.Client().ListFiles(ctx, account.Folder().Id)
+		if err != nil {
+			return fmt.Errorf("Unable to list the backed up files: %v", err)
+		}
+		byName := make(map[string]*drivev3.File, len(files))
+		for _, file := range files {
+			byName[file.Name] = file
+		}
+		for _, file := range files {
+			if base, number, ok := pipeline.PartOf(file.Name); ok {
+				if manifest := byName[base]; manifest == nil || number > partCount(manifest) {
+					unreferenced = append(unreferenced, accountFile{account, file})
+				}
+				continue
+			}
+			if a.isArchive(file.Name) || drive.IsFolder(file) {
+				continue
+			}
+			revisions, err := account.Client().ListRevisions(ctx, file.Id)
+			if err != nil {
+				return fmt.Errorf("Unable to list the revisions of %s: %v", file.Name, err)
+			}
+			times := make([]time.Time, len(revisions))
+			for i, revision := range revisions {
+				if times[i], err = time.Parse(time.RFC3339, revision.ModifiedTime); err != nil {
+					// unknown age, kept as the latest
+					times[i] = now
+				}
+			}
+			keep := retention.Keep(times, a.config().Retention, now)
+			pinnedRevisions := pipeline.PinnedRevisions(file)
+			for i, revision := range revisions {
+				// the current content, and the one from before a mass
+				// change, always stay
+				if keep[i] || i == len(revisions)-1 || pinnedRevisions[revision.Id] {
+					if !revision.KeepForever {
+						pinned = append(pinned, accountRevision{account, file, revision})
+					}
+				} else {
+					expired = append(expired, accountRevision{account, file, revision})
+				}
+			}
+		}
+		unreferenced = append(unreferenced, a.expiredArchives(account, files, now)...)
+		garbage, err := pipeline.CollectRepository(ctx, account.Client(), account.Folder(), a.config().Retention, now)
+		if err != nil {
+			return fmt.Errorf("Unable to collect the chunk repository: %v", err)
+		}
+		if garbage != nil && len(garbage.Snapshots)+len(garbage.Chunks) > 0 {
+			repositories = append(repositories, accountGarbage{account, garbage})
+			chunks += len(garbage.Chunks)
+			for _, file := range garbage.Snapshots {
+				unreferenced = append(unreferenced, accountFile{account, file})
+			}
+		}
+	}
+	if len(pinned)+len(expired)+len(unreferenced)+chunks == 0 {
+		fmt.Println("Nothing to collect, the backup follows the retention policy")
+		return nil
+	}
+
+	for _, r := range expired {
+		fmt.Printf("\tdelete %s revision %s, modified %s (%s)\n", r.file.Name, r.revision.Id, r.revision.ModifiedTime, humanize.Bytes(r.revision.Size))
+	}
+	for _, f := range unreferenced {
+		fmt.Printf("\ttrash %s (%s)\n", f.file.Name, humanize.Bytes(f.file.Size))
+	}
+	var chunksSize int64
+	for _, r := range repositories {
+		for _, file := range r.garbage.Chunks {
+			chunksSize += file.Size
+		}
+	}
+	if chunks > 0 {
+		fmt.Printf("\ttrash %d chunks no kept snapshot lists (%s)\n", chunks, humanize.Bytes(chunksSize))
+	}
+	fmt.Printf("%d revisions to keep, %d expired revisions to delete, %d expired archives and snapshots and unreferenced parts to trash, %d unreferenced chunks to trash\n",
+		len(pinned), len(expired), len(unreferenced), chunks)
+	if !confirm("Apply the retention policy?", yes) {
+		fmt.Println("Nothing changed")
+		return nil
+	}
+
+	for _, r := range pinned {
+		if err := r.account.Client().KeepRevision(ctx, r.file.Id, r.revision.Id); err != nil {
+			slog.Error("Unable to keep the revision", "file", r.file.Name, "revision", r.revision.Id, "error", err)
+		}
+	}
+	deleted := 0
+	for _, r := range expired {
+		if err := r.account.Client().DeleteRevision(ctx, r.file.Id, r.revision.Id); err != nil {
+			slog.Error("Unable to delete the revision", "file", r.file.Name, "revision", r.revision.Id, "error", err)
+			continue
+		}
+		deleted++
+	}
+	trashed := a.trashFiles(ctx, unreferenced)
+	trashedChunks := 0
+	for _, r := range repositories {
+		if len(r.garbage.Chunks) == 0 {
+			continue
+		}
+		chunkFiles := make([]accountFile, len(r.garbage.Chunks))
+		for i, file := range r.garbage.Chunks {
+			chunkFiles[i] = accountFile{r.account, file}
+		}
+		trashedChunks += len(a.trashFiles(ctx, chunkFiles))
+		if err := pipeline.MarkCollected(ctx, r.account.Client(), r.garbage.ChunksFolder, now); err != nil {
+			slog.Error("Unable to tell the other machines the chunks were collected", "account", r.account.Name(), "error", err)
+		}
+	}
+	fmt.Printf("%d of %d expired revisions deleted, %d of %d files and %d of %d chunks moved to the Drive trash\n",
+		deleted, len(expired), len(trashed), len(unreferenced), trashedChunks, chunks)
+	return nil
+}
+
+// partCount returns how many parts the split file manifest lists, 0 when
+// it was not split.
+func partCount(manifest *drivev3.File) int {
+	count, _ := strconv.Atoi(drive.Property(manifest.AppProperties, drive.PropertyParts))
+	return count
+}
+
+// expiredArchives returns the archives of files, the destination folder of
+// account, belonging to cycles the retention policy does not keep at now.
+// A cycle is a full archive and the incremental ones after it, the latest
+// cycle always being kept.
+func (a *app) expiredArchives(account *drive.Account, files []*drivev3.File, now time.Time) []accountFile {
+	type archive struct {
+		file  *drivev3.File
+		made  time.Time
+		level int
+	}
+	var expired []accountFile
+	for _, folder := range a.config().ArchiveFolders {
+		var archives []archive
+		for _, file := range files {
+			if made, level, ok := pipeline.ParseArchive(folder, file.Name); ok {
+				archives = append(archives, archive{file, made, level})
+			}
+		}
+		sort.Slice(archives, func(i, j int) bool {
+			return archives[i].made.Before(archives[j].made)
+		})
+		var cycles [][]archive
+		for _, archive := range archives {
+			if archive.level == 0 || len(cycles) == 0 {
+				cycles = append(cycles, nil)
+			}
+			cycles[len(cycles)-1] = append(cycles[len(cycles)-1], archive)
+		}
+		times := make([]time.Time, len(cycles))
+		for i, cycle := range cycles {
+			times[i] = cycle[0].made
+		}
+		keep := retention.Keep(times, a.config().Retention, now)
+		for i, cycle := range cycles {
+			if keep[i] || i == len(cycles)-1 {
+				continue
+			}
+			for _, archive := range cycle {
+				expired = append(expired, accountFile{account, archive.file})
+			}
+		}
+	}
+	return expired
+}
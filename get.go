@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// errFound stops the search of the state database.
+var errFound = errors.New("found")
+
+// getFile downloads the backed up file called remoteName from the
+// destination folder, or from a date subfolder with a name like
+// 2024/05/IMG_0001.jpg, or its Drive revision revisionID when not empty,
+// decrypts and decompresses it when it was encrypted or gzipped and writes
+// it to target, the local name in the working directory when empty. The
+// latest version is checked against the hash of the uploaded file kept in
+// state.db or in its Drive properties, a revision against the MD5 Drive
+// keeps of it, and an existing file is never overwritten. A file uploaded
+// in parts is put back together from them. The permissions, owner and
+// extended attributes kept in the properties are restored.
+func (a *app) getFile(ctx context.Context, remoteName string, revisionID string, target string) error {
+	account, err := a.accounts.Get(ctx, "")
+	if err != nil {
+		return err
+	}
+	files, err := a.findBackups(ctx, account, remoteName)
+	if err != nil {
+		return err
+	}
+	remoteFile := drive.PreferFile(files, a.isKnownFile)
+	if remoteFile == nil {
+		return fmt.Errorf("No backed up file called %s", remoteName)
+	}
+	localPath, known := a.knownFile(remoteFile.Id)
+	knownHash := ""
+	if known != nil {
+		knownHash = known.Hash
+	} else {
+		// state.db is lost or from another machine, the properties written
+		// at upload tell the same
+		localPath = drive.Property(remoteFile.AppProperties, drive.PropertyPath)
+	}
+	if target == "" {
+		target = pipeline.LocalName(remoteFile, localPath)
+	}
+	if _, err = os.Lstat(target); err == nil {
+		return fmt.Errorf("%s already exists, choose another file with -o", target)
+	}
+
+	var revision *drivev3.Revision
+	if revisionID != "" {
+		if revision, err = account.Client().GetRevision(ctx, remoteFile.Id, revisionID); err != nil {
+			return fmt.Errorf("Unable to get revision %s of %s: %v", revisionID, remoteName, err)
+		}
+	}
+	hash, err := pipeline.RestoreFile(ctx, account.Client(), remoteFile, revision, localPath, knownHash, target)
+	if err != nil {
+		return err
+	}
+	slog.Info("Downloaded file", "file", remoteName, "revision", revisionID, "target", target, "sha256", hash)
+	return nil
+}
+
+// findBackups returns the backed up files called remoteName in the
+// destination folder of account, or in its subfolders for a name like
+// 2024/05/IMG_0001.jpg, nil when a subfolder does not exist.
+func (a *app) findBackups(ctx context.Context, account *drive.Account, remoteName string) ([]*drivev3.File, error) {
+	dir, name := path.Split(remoteName)
+	folder, err := remoteFolder(ctx, account, dir)
+	if err != nil || folder == nil {
+		return nil, err
+	}
+	return account.Client().FindFiles(ctx, name, folder.Id)
+}
+
+// remoteFolder returns the folder at dir, like 2024/05, in the destination
+// folder of account, nil when it does not exist.
+func remoteFolder(ctx context.Context, account *drive.Account, dir string) (*drivev3.File, error) {
+	folder := account.Folder()
+	for _, folderName := range strings.Split(dir, "/") {
+		if folderName == "" {
+			continue
+		}
+		var err error
+		if folder, err = account.Client().FindFolder(ctx, folderName, folder.Id); err != nil || folder == nil {
+			return nil, err
+		}
+	}
+	return folder, nil
+}
+
+// isKnownFile reports whether a local file was uploaded to the Drive file
+// remoteID.
+func (a *app) isKnownFile(remoteID string) bool {
+	_, known := a.knownFile(remoteID)
+	return known != nil
+}
+
+// knownFile returns the local path and the state of the file uploaded as
+// the Drive file remoteID, nil when state.db does not know it.
+func (a *app) knownFile(remoteID string) (string, *state.File) {
+	if a.state == nil {
+		return "", nil
+	}
+	var path string
+	var known *state.File
+	err := a.state.ForEach(func(p string, file state.File) error {
+		if file.RemoteID == remoteID {
+			path, known = p, &file
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		slog.Error("Unable to read file state", "error", err)
+	}
+	return path, known
+}
@@ -0,0 +1,18 @@
+module github.com/amcereijo/EncryptBckDocs
+
+go 1.26.0
+
+require (
+	github.com/charmbracelet/bubbletea v1.3.6
+	github.com/fsnotify/fsnotify v1.9.0
+	go.etcd.io/bbolt v1.4.3
+	go.opentelemetry.io/otel v1.44.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.44.0
+	go.opentelemetry.io/otel/sdk v1.44.0
+	go.opentelemetry.io/otel/trace v1.44.0
+	golang.org/x/net v0.59.0
+	golang.org/x/oauth2 v0.37.0
+	golang.org/x/sys v0.48.0
+	google.golang.org/api v0.299.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+)
@@ -0,0 +1,371 @@
+// Package auth authorizes the app against Google Drive with the OAuth
+// browser or device flows, or with a service account, and keeps the
+// tokens encrypted on disk.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+	"github.com/amcereijo/EncryptBckDocs/internal/proxy"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// Authorizer builds authorized HTTP clients for the Drive accounts.
+type Authorizer struct {
+	Config *config.Config
+	// ClientSecretPath is the OAuth client secret file, "-" to read it
+	// from stdin.
+	ClientSecretPath string
+	// TokenCachePath is the cached token of the default account, "" for
+	// the one in ~/.credentials.
+	TokenCachePath string
+
+	clientSecret []byte // client secret already read
+}
+
+// Client authorizes the account called accountName, "" being the default
+// account, and returns an HTTP client for it. Only the default account may
+// use the configured service account.
+func (a *Authorizer) Client(ctx context.Context, accountName string) (*http.Client, error) {
+	proxyClient, err := proxy.NewClient(a.Config)
+	if err != nil {
+		return nil, err
+	}
+	// both the token requests and the Drive calls go through the proxy
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, proxyClient)
+
+	if a.UsesServiceAccount(accountName) {
+		client, err := getServiceAccountClient(ctx, a.Config.ServiceAccountFile, a.Config.ImpersonateUser, a.Scopes())
+		if err != nil {
+			return nil, fmt.Errorf("Unable to use service account file: %v", err)
+		}
+		return client, nil
+	}
+
+	b, err := a.readClientSecret()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read client secret file: %v", err)
+	}
+
+	// If modifying these scopes, delete your previously saved credentials
+	// at ~/.credentials/EncryptBckDocs.json
+	oauthConfig, err := google.ConfigFromJSON(b, a.Scopes()...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse client secret file to config: %v", err)
+	}
+	return a.getClient(ctx, oauthConfig, accountName)
+}
+
+// UsesServiceAccount reports whether accountName is authorized with the
+// service account key instead of an OAuth token.
+func (a *Authorizer) UsesServiceAccount(accountName string) bool {
+	return accountName == "" && a.Config.ServiceAccountFile != ""
+}
+
+// Scopes returns the OAuth scopes to request. By default the app can only
+// access the files it created itself, full access must be enabled in config,
+// and its hidden app data folder when the config is saved in Drive.
+func (a *Authorizer) Scopes() []string {
+	scopes := []string{drive.DriveFileScope}
+	if a.Config.FullDriveAccess {
+		scopes = []string{drive.DriveScope}
+	}
+	if a.Config.RemoteConfig {
+		scopes = append(scopes, drive.DriveAppdataScope)
+	}
+	return scopes
+}
+
+// getClient uses a Context and Config to retrieve a Token
+// then generate a Client. It returns the generated Client.
+func (a *Authorizer) getClient(ctx context.Context, oauthConfig *oauth2.Config, accountName string) (*http.Client, error) {
+	cacheFile, err := a.TokenCacheFile(accountName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get path to cached credential file: %v", err)
+	}
+	tok, err := tokenFromFile(cacheFile)
+	if err != nil {
+		if envToken := os.Getenv("ENCRYPTBCKDOCS_TOKEN_JSON"); envToken != "" && accountName == "" {
+			// authorized on another machine, e.g. mounted as a secret
+			tok = &oauth2.Token{}
+			if err = json.Unmarshal([]byte(envToken), tok); err != nil {
+				return nil, fmt.Errorf("Unable to parse ENCRYPTBCKDOCS_TOKEN_JSON: %v", err)
+			}
+		} else if a.Config.DeviceAuth {
+			tok, err = getTokenFromDevice(ctx, oauthConfig)
+		} else if !canOpenBrowser() {
+			err = fmt.Errorf("No cached token for %s and no terminal or desktop to authorize it, set ENCRYPTBCKDOCS_TOKEN_JSON, a serviceAccountFile or deviceAuth", accountLabel(accountName))
+		} else {
+			tok, err = getTokenFromWeb(ctx, oauthConfig)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = saveToken(cacheFile, tok); err != nil {
+			return nil, fmt.Errorf("Unable to cache oauth token: %v", err)
+		}
+	}
+	source := &savingTokenSource{source: oauthConfig.TokenSource(ctx, tok), file: cacheFile, accessToken: tok.AccessToken}
+	return oauth2.NewClient(ctx, source), nil
+}
+
+// readClientSecret returns the OAuth client configuration, taken from the
+// ENCRYPTBCKDOCS_CLIENT_SECRET_JSON environment variable, from stdin when the
+// client secret path is "-", or from the client secret file.
+func (a *Authorizer) readClientSecret() ([]byte, error) {
+	if a.clientSecret != nil {
+		return a.clientSecret, nil
+	}
+
+	var content []byte
+	var err error
+	if envContent := os.Getenv("ENCRYPTBCKDOCS_CLIENT_SECRET_JSON"); envContent != "" {
+		content = []byte(envContent)
+	} else if a.ClientSecretPath == "-" {
+		// stdin can only be read once, keep it for later accounts
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(a.ClientSecretPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.clientSecret = content
+	return content, nil
+}
+
+// CheckToken checks the account called accountName is authorized without
+// asking the user: the token of its service account key, or its cached
+// OAuth token, is refreshed. Nothing is saved.
+func (a *Authorizer) CheckToken(ctx context.Context, accountName string) error {
+	proxyClient, err := proxy.NewClient(a.Config)
+	if err != nil {
+		return err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, proxyClient)
+
+	var source oauth2.TokenSource
+	if a.UsesServiceAccount(accountName) {
+		b, err := ioutil.ReadFile(a.Config.ServiceAccountFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read service account file: %v", err)
+		}
+		jwtConfig, err := google.JWTConfigFromJSON(b, a.Scopes()...)
+		if err != nil {
+			return fmt.Errorf("Unable to use service account file: %v", err)
+		}
+		jwtConfig.Subject = a.Config.ImpersonateUser
+		source = jwtConfig.TokenSource(ctx)
+	} else {
+		b, err := a.readClientSecret()
+		if err != nil {
+			return fmt.Errorf("Unable to read client secret file: %v", err)
+		}
+		oauthConfig, err := google.ConfigFromJSON(b, a.Scopes()...)
+		if err != nil {
+			return fmt.Errorf("Unable to parse client secret file to config: %v", err)
+		}
+		cacheFile, err := a.TokenCacheFile(accountName)
+		if err != nil {
+			return fmt.Errorf("Unable to get path to cached credential file: %v", err)
+		}
+		tok, err := tokenFromFile(cacheFile)
+		if err != nil {
+			return fmt.Errorf("No cached token in %s: %v", cacheFile, err)
+		}
+		// an expired access token forces a refresh
+		tok.Expiry = time.Now().Add(-time.Minute)
+		source = oauthConfig.TokenSource(ctx, tok)
+	}
+	_, err = source.Token()
+	return err
+}
+
+// getTokenFromWeb uses Config to request a Token, catching the
+// authorization code on a local redirect listener.
+// It returns the retrieved Token.
+func getTokenFromWeb(ctx context.Context, oauthConfig *oauth2.Config) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to start the local redirect listener: %v", err)
+	}
+	oauthConfig.RedirectURL = "http://" + listener.Addr().String()
+
+	state, err := randomState()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate the authorization state: %v", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	authURL := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+	fmt.Printf("Opening the following link in your browser, authorize "+
+		"the app there: \n%v\n", authURL)
+	if err := openBrowser(authURL); err != nil {
+		slog.Warn("Unable to open the browser, open the link manually", "error", err)
+	}
+
+	code, err := receiveAuthCode(ctx, listener, state)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read authorization code: %v", err)
+	}
+
+	tok, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve token from web: %v", err)
+	}
+	return tok, nil
+}
+
+// openBrowser opens url with the default browser of the platform.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+// accountLabel names the account called accountName in messages.
+func accountLabel(accountName string) string {
+	if accountName == "" {
+		return "the default account"
+	}
+	return "account " + accountName
+}
+
+// canOpenBrowser reports whether the user can authorize in a browser: a
+// terminal shows the link, a desktop opens it. A Linux machine with
+// neither, like a container, cannot.
+func canOpenBrowser() bool {
+	if logging.CanPrompt() || runtime.GOOS != "linux" {
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// randomState returns an unguessable value for the OAuth state parameter.
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// receiveAuthCode serves the OAuth redirect on listener until the browser
+// comes back with an authorization code or an error, or ctx is cancelled.
+// Redirects not carrying the expected state are rejected.
+// It returns the received code.
+func receiveAuthCode(ctx context.Context, listener net.Listener, state string) (string, error) {
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	sendResult := func(res result) {
+		// only the first answer counts, later requests must not block
+		select {
+		case results <- res:
+		default:
+		}
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if query.Get("state") != state {
+				http.Error(w, "Invalid authorization state", http.StatusBadRequest)
+				return
+			}
+			if authError := query.Get("error"); authError != "" {
+				fmt.Fprintf(w, "Authorization failed: %s. You can close this window.", authError)
+				sendResult(result{err: errors.New(authError)})
+				return
+			}
+			code := query.Get("code")
+			if code == "" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, "Authorization received. You can close this window.")
+			sendResult(result{code: code})
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	select {
+	case res := <-results:
+		return res.code, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// getServiceAccountClient builds a Client authorized with the service
+// account key in keyFile. When subject is set the account impersonates that
+// Workspace user through domain-wide delegation.
+func getServiceAccountClient(ctx context.Context, keyFile string, subject string, scopes []string) (*http.Client, error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	jwtConfig, err := google.JWTConfigFromJSON(b, scopes...)
+	if err != nil {
+		return nil, err
+	}
+	jwtConfig.Subject = subject
+	return jwtConfig.Client(ctx), nil
+}
+
+// getTokenFromDevice uses Config to request a Token with the device
+// authorization grant: the user enters a short code on any other device,
+// so no browser is needed on this machine.
+// It returns the retrieved Token.
+func getTokenFromDevice(ctx context.Context, oauthConfig *oauth2.Config) (*oauth2.Token, error) {
+	if oauthConfig.Endpoint.DeviceAuthURL == "" {
+		oauthConfig.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+
+	deviceAuth, err := oauthConfig.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to start device authorization: %v", err)
+	}
+	fmt.Printf("On any device go to %s and enter the code: %s\n",
+		deviceAuth.VerificationURI, deviceAuth.UserCode)
+
+	tok, err := oauthConfig.DeviceAccessToken(ctx, deviceAuth)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve token from device authorization: %v", err)
+	}
+	return tok, nil
+}
+
+// IsInvalidGrant reports whether err comes from Google rejecting the
+// refresh token, which happens when it is revoked or expired.
+func IsInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant"
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const masterKeyFileName = "EncryptBckDocs.key"
+const masterKeySize = 32 // AES-256
+
+// MasterKeyFile returns the file of the master key, in the credentials
+// directory. Another machine opens what this one sealed with a copy of it.
+// Next to the cached tokens it protects, it keeps them from a copy of the
+// token files alone, not from a user who can read the directory.
+func MasterKeyFile() (string, error) {
+	dir, err := credentialsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, masterKeyFileName), nil
+}
+
+// loadMasterKey reads the master key from the credentials directory,
+// generating and saving a random one the first time.
+func loadMasterKey() ([]byte, error) {
+	keyFile, err := MasterKeyFile()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ioutil.ReadFile(keyFile)
+	if err == nil {
+		warnLoosePermissions(keyFile)
+		if len(key) != masterKeySize {
+			return nil, errors.New("Invalid master key file " + keyFile)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, masterKeySize)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	slog.Info("Creating master key file", "file", keyFile)
+	return key, writePrivateFile(keyFile, key)
+}
+
+// encryptBytes seals plain with AES-GCM. The random nonce is prepended to
+// the returned ciphertext.
+func encryptBytes(key []byte, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+// decryptBytes opens a ciphertext produced by encryptBytes.
+func decryptBytes(key []byte, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Encrypted content too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writePrivateFile writes content to file making sure only the owner can
+// read it, even when the file already existed with looser permissions.
+func writePrivateFile(file string, content []byte) error {
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(file, 0600)
+}
+
+// warnLoosePermissions loudly warns when file can be read by other users.
+func warnLoosePermissions(file string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		slog.Warn("File is accessible by other users, run: chmod 600 "+file, "file", file, "mode", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// sealedPrefix starts the secrets of config.json sealed with the master
+// key.
+const sealedPrefix = "sealed:"
+
+// SealSecret encrypts plain with the master key, for a setting of
+// config.json that OpenSecret gives back.
+func SealSecret(plain string) (string, error) {
+	sealed, err := Seal([]byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenSecret decrypts a setting sealed by SealSecret, returning the other
+// ones as they are.
+func OpenSecret(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.New("Invalid sealed secret in config")
+	}
+	plain, err := Open(sealed)
+	if err == errOtherKey {
+		return "", errors.New("Unable to decrypt a secret of the config, sealed with another master key")
+	} else if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// errOtherKey is returned by Open for content sealed with another master
+// key.
+var errOtherKey = errors.New("Sealed with another master key")
+
+// Seal encrypts plain with the master key, for content leaving the
+// machine, like the copy of the config saved in Drive, that Open gives
+// back.
+func Seal(plain []byte) ([]byte, error) {
+	key, err := loadMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	return encryptBytes(key, plain)
+}
+
+// Open decrypts content sealed by Seal, on this machine or on another one
+// with the same master key.
+func Open(sealed []byte) ([]byte, error) {
+	key, err := loadMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := decryptBytes(key, sealed)
+	if err != nil {
+		return nil, errOtherKey
+	}
+	return plain, nil
+}
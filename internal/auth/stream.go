@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+)
+
+// streamMagic starts the content encrypted by EncryptWriter, followed by
+// the salt the key of the stream is derived with.
+const streamMagic = "EBDENC1\n"
+
+const streamSaltSize = 32
+
+// streamSegmentSize is how much plain content is sealed at a time, the
+// memory an encrypted stream holds whatever its size.
+const streamSegmentSize = 64 << 10
+
+// errTruncated is returned for encrypted content missing its end.
+var errTruncated = errors.New("Encrypted content truncated")
+
+// errModified is returned for encrypted content that does not decrypt.
+var errModified = errors.New("Encrypted content modified or sealed with another master key")
+
+var masterKeyMutex sync.Mutex
+var masterKey []byte
+
+// cachedMasterKey returns the master key, read once.
+func cachedMasterKey() ([]byte, error) {
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+	if masterKey == nil {
+		key, err := loadMasterKey()
+		if err != nil {
+			return nil, err
+		}
+		masterKey = key
+	}
+	return masterKey, nil
+}
+
+// deriveKey returns the key of use derived from the master key and salt
+// with HKDF-SHA256, so the master key itself is never used twice.
+func deriveKey(salt []byte, use string) ([]byte, error) {
+	key, err := cachedMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(key)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(use + "\x01"))
+	return expand.Sum(nil), nil
+}
+
+// streamCipher returns the AES-GCM of a stream, its key derived from the
+// master key and salt, so no two streams share a key and their segment
+// counters can start at zero.
+func streamCipher(salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(salt, "EncryptBckDocs stream")
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+// ContentID returns the ID of data in a content-addressed store, its
+// HMAC-SHA256 keyed from the master key: machines sharing the master key
+// give the same content the same ID, while the ID does not tell someone
+// without it whether a known file is stored, like a plain hash would.
+func ContentID(data []byte) (string, error) {
+	key, err := deriveKey(nil, "EncryptBckDocs content id")
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// IsEncrypted reports whether content starts like the content encrypted
+// by EncryptWriter.
+func IsEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(streamMagic))
+}
+
+// segmentNonce is the nonce of segment number i, its last byte set for the
+// last segment of the stream so dropping whole segments from the end is
+// detected.
+func segmentNonce(nonce []byte, i uint64, last bool) []byte {
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], i)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// EncryptWriter returns a writer encrypting what is written to it with the
+// master key into w, in segments of 64 KiB, for files leaving the machine
+// too large to hold in memory. Closing it writes the last segment, it does
+// not close w. DecryptReader gives the content back.
+func EncryptWriter(w io.Writer) (io.WriteCloser, error) {
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	gcm, err := streamCipher(salt)
+	if err != nil {
+		return nil, err
+	}
+	// written with the first segment, w may be a pipe not read yet
+	header := append([]byte(streamMagic), salt...)
+	return &encryptWriter{w: w, gcm: gcm, header: header, nonce: make([]byte, gcm.NonceSize()), plain: make([]byte, 0, streamSegmentSize)}, nil
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	header  []byte
+	nonce   []byte
+	plain   []byte
+	sealed  []byte
+	segment uint64
+}
+
+func (e *encryptWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		// a full segment is only sealed once more content follows, the
+		// last one being sealed by Close
+		if len(e.plain) == streamSegmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.plain[len(e.plain):streamSegmentSize], b)
+		e.plain = e.plain[:len(e.plain)+n]
+		b = b[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	e.sealed = append(e.sealed[:0], e.header...)
+	e.header = nil
+	e.sealed = e.gcm.Seal(e.sealed, segmentNonce(e.nonce, e.segment, last), e.plain, nil)
+	e.segment++
+	e.plain = e.plain[:0]
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+// DecryptReader returns a reader of the content encrypted by EncryptWriter
+// read from r, on this machine or on another one with the same master key.
+// Reading fails when the content was modified or truncated.
+func DecryptReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, len(streamMagic)+streamSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(streamMagic)]) != streamMagic {
+		return nil, errors.New("Not encrypted by this app")
+	}
+	gcm, err := streamCipher(header[len(streamMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: bufio.NewReader(r), gcm: gcm, nonce: make([]byte, gcm.NonceSize()),
+		sealed: make([]byte, streamSegmentSize+gcm.Overhead()), buffer: make([]byte, 0, streamSegmentSize)}, nil
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	gcm     cipher.AEAD
+	nonce   []byte
+	sealed  []byte
+	buffer  []byte
+	plain   []byte
+	segment uint64
+	done    bool
+	err     error
+}
+
+func (d *decryptReader) Read(b []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.open()
+	}
+	n := copy(b, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	if err == io.EOF {
+		return errTruncated
+	} else if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	// a short segment is the last one, so is a full one ending the content
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, err = d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	plain, err := d.gcm.Open(d.buffer[:0], segmentNonce(d.nonce, d.segment, last), d.sealed[:n], nil)
+	if err != nil {
+		if _, err = d.gcm.Open(d.buffer[:0], segmentNonce(d.nonce, d.segment, !last), d.sealed[:n], nil); err == nil && last {
+			// a segment followed by others in the original content
+			return errTruncated
+		}
+		return errModified
+	}
+	d.segment++
+	d.plain, d.done = plain, last
+	return nil
+}
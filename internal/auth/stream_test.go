@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// a master key of the tests, never the one of the user
+	dir, err := os.MkdirTemp("", "credentials")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("ENCRYPTBCKDOCS_CREDENTIALS_DIR", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// encryptStream returns plain encrypted by EncryptWriter.
+func encryptStream(t *testing.T, plain []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	w, err := EncryptWriter(&sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written in uneven pieces, like io.Copy from a pipe
+	for len(plain) > 0 {
+		n := min(len(plain), 10000)
+		if _, err = w.Write(plain[:n]); err != nil {
+			t.Fatal(err)
+		}
+		plain = plain[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func decryptStream(sealed []byte) ([]byte, error) {
+	r, err := DecryptReader(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, streamSegmentSize - 1, streamSegmentSize, streamSegmentSize + 1, 3*streamSegmentSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		sealed := encryptStream(t, plain)
+		// a few random bytes may be found in the encrypted content by chance
+		if size > 16 && bytes.Contains(sealed, plain) {
+			t.Errorf("size %d: the plain content is in the encrypted one", size)
+		}
+		got, err := decryptStream(sealed)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted content differs", size)
+		}
+	}
+}
+
+func TestStreamSalted(t *testing.T) {
+	plain := []byte("same content")
+	if bytes.Equal(encryptStream(t, plain), encryptStream(t, plain)) {
+		t.Error("the same content encrypted twice is equal, want a salt of its own")
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	plain := make([]byte, 2*streamSegmentSize+100)
+	sealed := encryptStream(t, plain)
+	segment := streamSegmentSize + 16
+	header := len(streamMagic) + streamSaltSize
+
+	// cut after whole segments, or within the last one
+	for _, length := range []int{header + segment, header + 2*segment, len(sealed) - 1} {
+		if _, err := decryptStream(sealed[:length]); err == nil {
+			t.Errorf("truncated to %d bytes of %d: decrypted, want an error", length, len(sealed))
+		}
+	}
+}
+
+func TestStreamModified(t *testing.T) {
+	sealed := encryptStream(t, []byte("the content of a backed up file"))
+	sealed[len(sealed)-20] ^= 1
+	if _, err := decryptStream(sealed); err != errModified {
+		t.Errorf("error = %v, want %v", err, errModified)
+	}
+	if _, err := decryptStream([]byte("plain content, not encrypted by the app")); err == nil {
+		t.Error("content not encrypted decrypted, want an error")
+	}
+}
+
+func TestContentID(t *testing.T) {
+	data := []byte("a chunk of a backed up file")
+	id, err := ContentID(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := ContentID(data); again != id {
+		t.Errorf("ContentID = %s then %s, want the same ID for the same content", id, again)
+	}
+	if other, _ := ContentID([]byte("another chunk")); other == id {
+		t.Error("two contents have the same ID")
+	}
+	sum := sha256.Sum256(data)
+	if id == hex.EncodeToString(sum[:]) {
+		t.Error("the ID is the plain SHA-256 of the content, want it keyed")
+	}
+	if !IsEncrypted(encryptStream(t, data)) || IsEncrypted(data) {
+		t.Error("IsEncrypted does not tell encrypted content from plain one")
+	}
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenCacheFile generates credential file path/filename for an account,
+// "" being the default one.
+// It returns the generated credential path/filename.
+func (a *Authorizer) TokenCacheFile(accountName string) (string, error) {
+	if a.TokenCachePath != "" {
+		if accountName == "" {
+			return a.TokenCachePath, nil
+		}
+		ext := filepath.Ext(a.TokenCachePath)
+		return strings.TrimSuffix(a.TokenCachePath, ext) + "-" + accountName + ext, nil
+	}
+	tokenCacheDir, err := credentialsDir()
+	if err != nil {
+		return "", err
+	}
+	cacheFileName := "EncryptBckDocs.json"
+	if accountName != "" {
+		cacheFileName = "EncryptBckDocs-" + accountName + ".json"
+	}
+	return filepath.Join(tokenCacheDir,
+		url.QueryEscape(cacheFileName)), err
+}
+
+// ForgetToken removes the cached token of accountName, so the next client
+// built for it runs the authorization flow again.
+func (a *Authorizer) ForgetToken(accountName string) error {
+	cacheFile, err := a.TokenCacheFile(accountName)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(cacheFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// credentialsDir returns the private directory holding the cached
+// credentials, ~/.credentials unless the ENCRYPTBCKDOCS_CREDENTIALS_DIR
+// environment variable names another one, creating it when missing.
+func credentialsDir() (string, error) {
+	dir := os.Getenv("ENCRYPTBCKDOCS_CREDENTIALS_DIR")
+	if dir == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(usr.HomeDir, ".credentials")
+	}
+	err := os.MkdirAll(dir, 0700)
+	return dir, err
+}
+
+// tokenFromFile retrieves a Token from a given file path, decrypting it
+// with the master key. Tokens saved in plain JSON by older versions are
+// encrypted again in place.
+// It returns the retrieved Token and any read error encountered.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	warnLoosePermissions(file)
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	t := &oauth2.Token{}
+	if len(content) > 0 && content[0] == '{' {
+		if err = json.Unmarshal(content, t); err == nil {
+			slog.Info("Encrypting plain text credential file", "file", file)
+			err = saveToken(file, t)
+		}
+		return t, err
+	}
+
+	key, err := loadMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := decryptBytes(key, content)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(plain, t)
+	return t, err
+}
+
+// saveToken uses a file path to create a file only readable by the
+// user and store the token in it, encrypted with the master key.
+func saveToken(file string, token *oauth2.Token) error {
+	slog.Info("Saving credential file", "file", file)
+	key, err := loadMasterKey()
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	sealed, err := encryptBytes(key, plain)
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(file, sealed)
+}
+
+// savingTokenSource saves the tokens source refreshes to file, so the
+// next run starts from the latest one Google issued instead of the token
+// of the authorization.
+type savingTokenSource struct {
+	source oauth2.TokenSource
+	file   string
+
+	mutex       sync.Mutex
+	accessToken string // of the token last saved
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if token.AccessToken != s.accessToken {
+		// the refreshed token still works for this run
+		if err := saveToken(s.file, token); err != nil {
+			slog.Warn("Unable to cache refreshed oauth token", "file", s.file, "error", err)
+		}
+		s.accessToken = token.AccessToken
+	}
+	return token, nil
+}
+
+// CredentialFiles returns the files holding the credentials of the
+// accounts called accountNames, "" being the default one: the client
+// secret or the service account key, the cached tokens, the master key
+// and the folder holding them.
+func (a *Authorizer) CredentialFiles(accountNames []string) []string {
+	var files []string
+	if a.Config.ServiceAccountFile != "" {
+		files = append(files, a.Config.ServiceAccountFile)
+	}
+	if a.ClientSecretPath != "-" {
+		files = append(files, a.ClientSecretPath)
+	}
+	for _, name := range accountNames {
+		if file, err := a.TokenCacheFile(name); err == nil {
+			files = append(files, file)
+		}
+	}
+	if dir, err := credentialsDir(); err == nil {
+		files = append(files, dir, filepath.Join(dir, masterKeyFileName))
+	}
+	return files
+}
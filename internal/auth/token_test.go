@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshedTokenIsCached(t *testing.T) {
+	var refreshes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"refreshed","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+	oauthConfig := &oauth2.Config{ClientID: "id", Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+	file := filepath.Join(t.TempDir(), "EncryptBckDocs.json")
+	expired := &oauth2.Token{AccessToken: "first", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+	if err := saveToken(file, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	source := &savingTokenSource{source: oauthConfig.TokenSource(context.Background(), expired), file: file, accessToken: expired.AccessToken}
+	for i := 0; i < 3; i++ {
+		if _, err := source.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := refreshes.Load(); n != 1 {
+		t.Errorf("token refreshed %d times, want once while it is valid", n)
+	}
+	saved, err := tokenFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the refresh token is kept when Google does not send a new one
+	if saved.AccessToken != "refreshed" || saved.RefreshToken != "refresh" {
+		t.Errorf("cached token = %q with refresh token %q, want the refreshed one", saved.AccessToken, saved.RefreshToken)
+	}
+}
@@ -0,0 +1,310 @@
+package drive
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+	"golang.org/x/net/context"
+	drive "google.golang.org/api/drive/v3"
+)
+
+// Account is an authorized Drive account together with the destination
+// folder files are uploaded to.
+type Account struct {
+	name   string
+	client Client
+	folder *drive.File
+}
+
+// Name returns the account name, "" for the default account.
+func (account *Account) Name() string {
+	return account.name
+}
+
+// Client returns the Drive client of the account.
+func (account *Account) Client() Client {
+	return account.client
+}
+
+// Folder returns the folder the files of the account are uploaded to.
+func (account *Account) Folder() *drive.File {
+	return account.folder
+}
+
+// Accounts authorizes the Drive accounts and keeps the ones in use by name.
+type Accounts struct {
+	// Config is the config of the accounts when ConfigStore is nil.
+	Config *config.Config
+	// ConfigStore holds the current config, read again for every lookup
+	// so a reloaded config applies.
+	ConfigStore *config.Store
+	Auth        *auth.Authorizer
+	// AuthExpired is called with true when the authorization of an account
+	// is rejected and with false once the account is authorized again.
+	AuthExpired func(account string, expired bool)
+	// State caches the ID of the destination folders, they are searched
+	// every time when nil.
+	State *state.Store
+	// Connect returns the Drive client of the account called name, the one
+	// of the authorized HTTP client when nil. Tests replace it with a fake.
+	Connect func(ctx context.Context, name string) (Client, error)
+
+	mutex       sync.Mutex
+	accounts    map[string]*Account
+	reauthMutex sync.Mutex
+	// folders caches the nested folders of EnsureFolderPath by account,
+	// parent and path
+	foldersMutex sync.Mutex
+	folders      map[string]*drive.File
+}
+
+// NewAccounts returns the accounts of cfg, authorized with authorizer.
+func NewAccounts(cfg *config.Config, authorizer *auth.Authorizer) *Accounts {
+	return &Accounts{Config: cfg, Auth: authorizer, accounts: make(map[string]*Account)}
+}
+
+// config returns the current config, which is never changed.
+func (accounts *Accounts) config() *config.Config {
+	if accounts.ConfigStore != nil {
+		return accounts.ConfigStore.Config()
+	}
+	return accounts.Config
+}
+
+// NewService authorizes the account called name, "" being the default
+// account, and returns a Drive service for it.
+func (accounts *Accounts) NewService(ctx context.Context, name string) (*drive.Service, error) {
+	client, err := accounts.Auth.Client(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return drive.New(client)
+}
+
+// connect returns a Drive client for the account called name.
+func (accounts *Accounts) connect(ctx context.Context, name string) (Client, error) {
+	if accounts.Connect != nil {
+		return accounts.Connect(ctx, name)
+	}
+	httpClient, err := accounts.Auth.Client(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	srv, err := drive.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(srv, httpClient, ChunkSize(accounts.config())), nil
+}
+
+// Authorize authorizes the account called name, unless it is already
+// authorized, without looking up its destination folder.
+func (accounts *Accounts) Authorize(ctx context.Context, name string) (*Account, error) {
+	accounts.mutex.Lock()
+	defer accounts.mutex.Unlock()
+	return accounts.authorize(ctx, name)
+}
+
+func (accounts *Accounts) authorize(ctx context.Context, name string) (*Account, error) {
+	if account, ok := accounts.accounts[name]; ok {
+		return account, nil
+	}
+	if name != "" {
+		slog.Info("Authorizing Drive account", "account", name)
+	}
+	client, err := accounts.connect(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	account := &Account{name: name, client: client}
+	accounts.accounts[name] = account
+	return account, nil
+}
+
+// Get returns the account called name. The first time an account is used
+// it is authorized and its destination folder is looked up, or created
+// when missing.
+func (accounts *Accounts) Get(ctx context.Context, name string) (*Account, error) {
+	accounts.mutex.Lock()
+	defer accounts.mutex.Unlock()
+
+	account, err := accounts.authorize(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if account.folder != nil {
+		return account, nil
+	}
+	if account.folder, err = accounts.destination(ctx, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// RefreshFolder looks up the destination folder of account again, after
+// Drive answered that failedFolder no longer exists. Calls for an already
+// replaced folder do nothing.
+func (accounts *Accounts) RefreshFolder(ctx context.Context, account *Account, failedFolder *drive.File) error {
+	accounts.mutex.Lock()
+	defer accounts.mutex.Unlock()
+
+	if account.folder != failedFolder {
+		return nil
+	}
+	slog.Warn("Destination folder not found, looking it up again", "folder", account.folder.Name, "account", account.name)
+	accounts.cacheFolderID(account, "")
+	accounts.forgetFolders(account)
+	folder, err := accounts.destination(ctx, account)
+	if err != nil {
+		return err
+	}
+	account.folder = folder
+	return nil
+}
+
+// destination returns the folder the files of account are uploaded to,
+// the HostFolder subfolder of the destination folder when set.
+func (accounts *Accounts) destination(ctx context.Context, account *Account) (*drive.File, error) {
+	folder, err := accounts.lookupFolder(ctx, account)
+	if err != nil || accounts.config().HostFolder == "" {
+		return folder, err
+	}
+	return accounts.EnsureFolderPath(ctx, account, folder, accounts.config().HostFolder)
+}
+
+// lookupFolder returns the destination folder of account, the folder
+// FolderID when set. Otherwise FolderName is a folder name or a path like
+// "Backups/Laptop/Docs" from the root of the Drive, looked up with the
+// cached ID when it is still valid and created when missing.
+func (accounts *Accounts) lookupFolder(ctx context.Context, account *Account) (*drive.File, error) {
+	if id := accounts.config().FolderID; id != "" {
+		folderFile, err := account.client.GetFile(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get the destination folder %s: %w", id, err)
+		}
+		if folderFile.Trashed || folderFile.MimeType != folderMimeType {
+			return nil, fmt.Errorf("Destination %s is not a folder or is in the trash", id)
+		}
+		return folderFile, nil
+	}
+
+	path := folderPath(accounts.config().FolderName)
+	folderName := path[len(path)-1]
+	if accounts.State != nil {
+		id, err := accounts.State.FolderID(accounts.folderKey(account))
+		if err != nil {
+			slog.Error("Unable to read cached folder ID", "error", err)
+		} else if id != "" {
+			folderFile, err := account.client.GetFile(ctx, id)
+			if err == nil && !folderFile.Trashed && folderFile.Name == folderName {
+				return folderFile, nil
+			} else if err != nil && !IsNotFound(err) {
+				return nil, err
+			}
+			slog.Debug("Cached folder ID is no longer valid", "folder", accounts.config().FolderName, "id", id)
+		}
+	}
+
+	// a single name is searched anywhere in the Drive, a path from its root
+	parentID := ""
+	if len(path) > 1 {
+		parentID = "root"
+	}
+	folderFile, err := accounts.ensureFolderPath(ctx, account, parentID, path)
+	if err != nil {
+		return nil, err
+	}
+	accounts.cacheFolderID(account, folderFile.Id)
+	return folderFile, nil
+}
+
+// folderKey identifies the destination folder of account in the cache.
+func (accounts *Accounts) folderKey(account *Account) string {
+	return account.name + "/" + accounts.config().FolderName
+}
+
+func (accounts *Accounts) cacheFolderID(account *Account, id string) {
+	if accounts.State == nil {
+		return
+	}
+	if err := accounts.State.PutFolderID(accounts.folderKey(account), id); err != nil {
+		slog.Error("Unable to cache folder ID", "error", err)
+	}
+}
+
+// Reconnect replaces the clients of the authorized accounts with new ones,
+// dropping connections gone stale, e.g. while the system slept. The tokens
+// are refreshed on the next call when they expired.
+func (accounts *Accounts) Reconnect(ctx context.Context) error {
+	accounts.mutex.Lock()
+	defer accounts.mutex.Unlock()
+	accounts.reauthMutex.Lock()
+	defer accounts.reauthMutex.Unlock()
+
+	for name, account := range accounts.accounts {
+		client, err := accounts.connect(ctx, name)
+		if err != nil {
+			return err
+		}
+		account.client = client
+	}
+	return nil
+}
+
+// ForFolder returns the account a watched folder is uploaded to.
+func (accounts *Accounts) ForFolder(ctx context.Context, folder string) (*Account, error) {
+	return accounts.Get(ctx, accounts.config().AccountForFolder(folder))
+}
+
+// Names returns the names of the accounts files are uploaded to, sorted,
+// "" being the default account.
+func (accounts *Accounts) Names() []string {
+	names := []string{""}
+	for _, name := range accounts.config().FolderAccount {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Reauthorize discards the rejected token of account and runs the
+// authorization flow again, replacing failedClient. Uploads of every account
+// wait until it finishes. Calls for an already replaced client do nothing.
+func (accounts *Accounts) Reauthorize(ctx context.Context, account *Account, failedClient Client) error {
+	accounts.reauthMutex.Lock()
+	defer accounts.reauthMutex.Unlock()
+
+	if account.client != failedClient {
+		return nil
+	}
+	if accounts.Auth.UsesServiceAccount(account.name) {
+		return errors.New("service account key was rejected, check " + accounts.config().ServiceAccountFile)
+	}
+
+	accountName := account.name
+	if accountName == "" {
+		accountName = "default"
+	}
+	slog.Warn("Drive authorization expired or was revoked, uploads are paused until you authorize the app again", "account", accountName)
+	if accounts.AuthExpired != nil {
+		accounts.AuthExpired(accountName, true)
+		defer accounts.AuthExpired(accountName, false)
+	}
+
+	if err := accounts.Auth.ForgetToken(account.name); err != nil {
+		return err
+	}
+	client, err := accounts.connect(ctx, account.name)
+	if err != nil {
+		return err
+	}
+	account.client = client
+	slog.Info("Drive authorization renewed, resuming uploads", "account", accountName)
+	return nil
+}
@@ -0,0 +1,123 @@
+package drive
+
+import (
+	"io"
+	"net/http"
+
+	"golang.org/x/net/context"
+	drive "google.golang.org/api/drive/v3"
+)
+
+// Client is the part of the Drive API the app uses, so it can be replaced
+// by a fake.
+type Client interface {
+	FindFolder(ctx context.Context, folderName string, parentID string) (*drive.File, error)
+	CreateFolder(ctx context.Context, folderName string, parentID string) (*drive.File, error)
+	FindFiles(ctx context.Context, fileName string, parentID string) ([]*drive.File, error)
+	GetFile(ctx context.Context, fileID string) (*drive.File, error)
+	CreateFile(ctx context.Context, folderFile *drive.File, fileName string, r io.Reader) (*drive.File, error)
+	UpdateFile(ctx context.Context, file *drive.File, r io.Reader) (*drive.File, error)
+	ListFiles(ctx context.Context, parentID string) ([]*drive.File, error)
+	Download(ctx context.Context, fileID string, w io.Writer) error
+	MoveFile(ctx context.Context, file *drive.File, name string, parentID string) (*drive.File, error)
+	TrashFile(ctx context.Context, fileID string) error
+	SetAppProperties(ctx context.Context, fileID string, props map[string]string) error
+	ListRevisions(ctx context.Context, fileID string) ([]*drive.Revision, error)
+	GetRevision(ctx context.Context, fileID string, revisionID string) (*drive.Revision, error)
+	DownloadRevision(ctx context.Context, fileID string, revisionID string, w io.Writer) error
+	KeepRevision(ctx context.Context, fileID string, revisionID string) error
+	DeleteRevision(ctx context.Context, fileID string, revisionID string) error
+	Quota(ctx context.Context) (*drive.AboutStorageQuota, error)
+	StartUpload(ctx context.Context, folderFile *drive.File, fileName string, file *drive.File) (string, error)
+	ResumeUpload(ctx context.Context, uri string, r io.Reader, checkpoint func(offset int64)) (*drive.File, error)
+}
+
+// NewClient returns a Client calling Drive through srv, resumable uploads
+// being sent with the authorized httpClient srv was created with, in
+// chunks of chunkSize bytes.
+func NewClient(srv *drive.Service, httpClient *http.Client, chunkSize int) Client {
+	return &serviceClient{srv: srv, httpClient: httpClient, chunkSize: chunkSize}
+}
+
+// serviceClient implements Client with the functions of this package.
+type serviceClient struct {
+	srv        *drive.Service
+	httpClient *http.Client
+	chunkSize  int
+}
+
+func (c *serviceClient) FindFolder(ctx context.Context, folderName string, parentID string) (*drive.File, error) {
+	return FindFolder(ctx, c.srv, folderName, parentID)
+}
+
+func (c *serviceClient) CreateFolder(ctx context.Context, folderName string, parentID string) (*drive.File, error) {
+	return CreateFolder(ctx, c.srv, folderName, parentID)
+}
+
+func (c *serviceClient) FindFiles(ctx context.Context, fileName string, parentID string) ([]*drive.File, error) {
+	return FindFiles(ctx, c.srv, fileName, parentID)
+}
+
+func (c *serviceClient) GetFile(ctx context.Context, fileID string) (*drive.File, error) {
+	return GetFile(ctx, c.srv, fileID)
+}
+
+func (c *serviceClient) CreateFile(ctx context.Context, folderFile *drive.File, fileName string, r io.Reader) (*drive.File, error) {
+	return CreateFile(ctx, c.srv, folderFile, fileName, r, c.chunkSize)
+}
+
+func (c *serviceClient) UpdateFile(ctx context.Context, file *drive.File, r io.Reader) (*drive.File, error) {
+	return UpdateFile(ctx, c.srv, file, r, c.chunkSize)
+}
+
+func (c *serviceClient) ListFiles(ctx context.Context, parentID string) ([]*drive.File, error) {
+	return ListFiles(ctx, c.srv, parentID)
+}
+
+func (c *serviceClient) Download(ctx context.Context, fileID string, w io.Writer) error {
+	return Download(ctx, c.srv, fileID, w)
+}
+
+func (c *serviceClient) MoveFile(ctx context.Context, file *drive.File, name string, parentID string) (*drive.File, error) {
+	return MoveFile(ctx, c.srv, file, name, parentID)
+}
+
+func (c *serviceClient) TrashFile(ctx context.Context, fileID string) error {
+	return TrashFile(ctx, c.srv, fileID)
+}
+
+func (c *serviceClient) SetAppProperties(ctx context.Context, fileID string, props map[string]string) error {
+	return SetAppProperties(ctx, c.srv, fileID, props)
+}
+
+func (c *serviceClient) ListRevisions(ctx context.Context, fileID string) ([]*drive.Revision, error) {
+	return ListRevisions(ctx, c.srv, fileID)
+}
+
+func (c *serviceClient) GetRevision(ctx context.Context, fileID string, revisionID string) (*drive.Revision, error) {
+	return GetRevision(ctx, c.srv, fileID, revisionID)
+}
+
+func (c *serviceClient) DownloadRevision(ctx context.Context, fileID string, revisionID string, w io.Writer) error {
+	return DownloadRevision(ctx, c.srv, fileID, revisionID, w)
+}
+
+func (c *serviceClient) KeepRevision(ctx context.Context, fileID string, revisionID string) error {
+	return KeepRevision(ctx, c.srv, fileID, revisionID)
+}
+
+func (c *serviceClient) DeleteRevision(ctx context.Context, fileID string, revisionID string) error {
+	return DeleteRevision(ctx, c.srv, fileID, revisionID)
+}
+
+func (c *serviceClient) Quota(ctx context.Context) (*drive.AboutStorageQuota, error) {
+	return Quota(ctx, c.srv)
+}
+
+func (c *serviceClient) StartUpload(ctx context.Context, folderFile *drive.File, fileName string, file *drive.File) (string, error) {
+	return StartUpload(ctx, c.httpClient, folderFile, fileName, file)
+}
+
+func (c *serviceClient) ResumeUpload(ctx context.Context, uri string, r io.Reader, checkpoint func(offset int64)) (*drive.File, error) {
+	return ResumeUpload(ctx, c.httpClient, uri, r, c.chunkSize, checkpoint)
+}
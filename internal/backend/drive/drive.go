@@ -0,0 +1,360 @@
+// Package drive is the Google Drive backend files are uploaded to.
+package drive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// AppDataFolder is the ID of the hidden folder of the app in the Drive,
+// its files only seen by the app.
+const AppDataFolder = "appDataFolder"
+
+// listCall starts listing the files of the folder parentID, searching the
+// space of the app data folder for it.
+func listCall(srv *drive.Service, parentID string) *drive.FilesListCall {
+	call := srv.Files.List()
+	if parentID == AppDataFolder {
+		call = call.Spaces(AppDataFolder)
+	}
+	return call
+}
+
+// foldersQuery searches the folders called folderName not in the trash.
+func foldersQuery(folderName string) string {
+	return "mimeType='" + folderMimeType + "' and trashed=false and name='" + escapeQuery(folderName) + "'"
+}
+
+// childrenQuery searches the files in the folder parentID not in the
+// trash.
+func childrenQuery(parentID string) string {
+	return "'" + parentID + "' in parents and explicitlyTrashed=false"
+}
+
+// fileQuery searches the file called fileName in the folder parentID.
+func fileQuery(fileName string, parentID string) string {
+	return childrenQuery(parentID) + " and name='" + escapeQuery(fileName) + "'"
+}
+
+// escapeQuery escapes value for a quoted string of a Drive query.
+func escapeQuery(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+// FindFolder returns the folder called folderName in the folder parentID,
+// or anywhere in the Drive when parentID is empty, nil when there is none.
+// Several folders matching is an error, Drive allowing duplicate names.
+func FindFolder(ctx context.Context, srv *drive.Service, folderName string, parentID string) (*drive.File, error) {
+	query := foldersQuery(folderName)
+	if parentID != "" {
+		query += " and '" + parentID + "' in parents"
+	}
+	var folders []*drive.File
+	pageToken := ""
+	for {
+		call := srv.Files.List().Q(query).Fields("nextPageToken, files(id, name, mimeType)").Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		r, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, r.Files...)
+		if r.NextPageToken == "" {
+			break
+		}
+		pageToken = r.NextPageToken
+	}
+
+	switch len(folders) {
+	case 0:
+		return nil, nil
+	case 1:
+		return folders[0], nil
+	}
+	ids := make([]string, len(folders))
+	for i, folder := range folders {
+		ids[i] = folder.Id
+	}
+	return nil, fmt.Errorf("%d folders called \"%s\" in Drive (%s), set folderId in config.json to the ID of the one to use",
+		len(folders), folderName, strings.Join(ids, ", "))
+}
+
+// FindFiles returns the files called fileName in the folder parentID, with
+// their appProperties, the most recently modified first. Drive allows
+// several files with the same name, PreferFile picks one.
+func FindFiles(ctx context.Context, srv *drive.Service, fileName string, parentID string) ([]*drive.File, error) {
+	slog.Debug("Looking for file in Drive", "file", fileName)
+	var files []*drive.File
+	pageToken := ""
+	for {
+		call := listCall(srv, parentID).Q(fileQuery(fileName, parentID)).OrderBy("modifiedTime desc").
+			Fields("nextPageToken, files(id, name, size, modifiedTime, appProperties)").Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		r, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, r.Files...)
+		if r.NextPageToken == "" {
+			return files, nil
+		}
+		pageToken = r.NextPageToken
+	}
+}
+
+// PreferFile returns the file of files, found with FindFiles, known
+// reports being in the local state, or the most recently modified one when
+// none is. It returns nil when files is empty.
+func PreferFile(files []*drive.File, known func(fileID string) bool) *drive.File {
+	if len(files) == 0 {
+		return nil
+	}
+	if len(files) > 1 {
+		slog.Warn("Several files with the same name in Drive, run dedupe-remote to keep one", "file", files[0].Name, "count", len(files))
+		for _, file := range files {
+			if known(file.Id) {
+				return file
+			}
+		}
+	}
+	return files[0]
+}
+
+// UpdateFile replaces the content of driveFileToUpload with goFile. It is
+// streamed, holding at most chunkSize bytes in memory.
+func UpdateFile(ctx context.Context, srv *drive.Service, driveFileToUpload *drive.File, goFile io.Reader, chunkSize int) (*drive.File, error) {
+	slog.Debug("Updating existing file", "file", driveFileToUpload.Name)
+	driveFileToUpdate := &drive.File{
+		Name: filepath.Base(driveFileToUpload.Name),
+	}
+
+	return srv.Files.Update(driveFileToUpload.Id, driveFileToUpdate).Media(goFile, googleapi.ChunkSize(chunkSize)).Context(ctx).Do()
+}
+
+// CreateFile uploads goFile as a new file called fileToUploadName in
+// folderFile, streamed like in UpdateFile.
+func CreateFile(ctx context.Context, srv *drive.Service, folderFile *drive.File, fileToUploadName string, goFile io.Reader, chunkSize int) (*drive.File, error) {
+	parents := []string{folderFile.Id}
+	driveFileToUpload := &drive.File{
+		Parents: parents,
+		Name:    filepath.Base(fileToUploadName),
+	}
+	return srv.Files.Create(driveFileToUpload).Media(goFile, googleapi.ChunkSize(chunkSize)).Context(ctx).Do()
+}
+
+// GetFile returns the file fileID, with its type, parents, trashed state
+// and appProperties.
+func GetFile(ctx context.Context, srv *drive.Service, fileID string) (*drive.File, error) {
+	return srv.Files.Get(fileID).Fields("id, name, mimeType, parents, trashed, appProperties").Context(ctx).Do()
+}
+
+// IsRetryable reports whether err is a temporary failure worth trying
+// again later: Drive rate limiting or server errors, and network errors.
+func IsRetryable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IsBackendError reports whether err came from Drive, answered by it or
+// failing to reach it, not from the local file or the processors.
+func IsBackendError(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) || IsRetryable(err) || IsOffline(err)
+}
+
+// IsOffline reports whether err means Drive could not be reached at all:
+// no network, DNS failures, refused or dropped connections.
+func IsOffline(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr)
+}
+
+// IsQuotaExceeded reports whether err means the Drive storage is full.
+func IsQuotaExceeded(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "storageQuotaExceeded" || item.Reason == "quotaExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound reports whether err comes from Drive not finding a file, when
+// it was deleted or its ID is wrong.
+func IsNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// IsUnauthorized reports whether err comes from Drive refusing the
+// credentials of the request.
+func IsUnauthorized(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized
+}
+
+// CreateFolder creates a folder called folderName in the folder parentID,
+// at the root of the Drive when empty.
+func CreateFolder(ctx context.Context, srv *drive.Service, folderName string, parentID string) (folderFile *drive.File, err error) {
+	slog.Info("Creating folder in Drive", "folder", folderName)
+	// create folder
+	fileMeta := &drive.File{
+		Name:     folderName,
+		MimeType: folderMimeType,
+	}
+	if parentID != "" {
+		fileMeta.Parents = []string{parentID}
+	}
+	folderFile, err = srv.Files.Create(fileMeta).Context(ctx).Do()
+
+	return folderFile, err
+}
+
+// ListFiles returns every file and folder in the folder parentID.
+func ListFiles(ctx context.Context, srv *drive.Service, parentID string) ([]*drive.File, error) {
+	var files []*drive.File
+	pageToken := ""
+	for {
+		call := listCall(srv, parentID).Q(childrenQuery(parentID)).Fields("nextPageToken, files(id, name, mimeType, size, modifiedTime, appProperties)").Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		r, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, r.Files...)
+		if r.NextPageToken == "" {
+			return files, nil
+		}
+		pageToken = r.NextPageToken
+	}
+}
+
+// IsFolder reports whether file, got with ListFiles or GetFile, is a
+// folder.
+func IsFolder(file *drive.File) bool {
+	return file.MimeType == folderMimeType
+}
+
+// Quota returns the storage quota of the account, a Limit of 0 meaning
+// unlimited.
+func Quota(ctx context.Context, srv *drive.Service) (*drive.AboutStorageQuota, error) {
+	about, err := srv.About.Get().Fields("storageQuota").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if about.StorageQuota == nil {
+		return &drive.AboutStorageQuota{}, nil
+	}
+	return about.StorageQuota, nil
+}
+
+// MoveFile renames file, got with GetFile, to name and moves it to the
+// folder parentID when it is not there already.
+func MoveFile(ctx context.Context, srv *drive.Service, file *drive.File, name string, parentID string) (*drive.File, error) {
+	call := srv.Files.Update(file.Id, &drive.File{Name: name})
+	inParent := false
+	for _, parent := range file.Parents {
+		inParent = inParent || parent == parentID
+	}
+	if !inParent {
+		call = call.AddParents(parentID).RemoveParents(strings.Join(file.Parents, ","))
+	}
+	return call.Fields("id, name, parents").Context(ctx).Do()
+}
+
+// TrashFile moves the file fileID to the trash, where Drive deletes it
+// after 30 days.
+func TrashFile(ctx context.Context, srv *drive.Service, fileID string) error {
+	_, err := srv.Files.Update(fileID, &drive.File{Trashed: true}).Context(ctx).Do()
+	return err
+}
+
+// Download writes the content of the file fileID to w.
+func Download(ctx context.Context, srv *drive.Service, fileID string, w io.Writer) error {
+	response, err := srv.Files.Get(fileID).Context(ctx).Download()
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+// ListRevisions returns the stored revisions of the file fileID, oldest
+// first.
+func ListRevisions(ctx context.Context, srv *drive.Service, fileID string) ([]*drive.Revision, error) {
+	var revisions []*drive.Revision
+	pageToken := ""
+	for {
+		call := srv.Revisions.List(fileID).Fields("nextPageToken, revisions(id, modifiedTime, size, md5Checksum, keepForever)").Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		r, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r.Revisions...)
+		if r.NextPageToken == "" {
+			return revisions, nil
+		}
+		pageToken = r.NextPageToken
+	}
+}
+
+// GetRevision returns the revision revisionID of the file fileID.
+func GetRevision(ctx context.Context, srv *drive.Service, fileID string, revisionID string) (*drive.Revision, error) {
+	return srv.Revisions.Get(fileID, revisionID).Fields("id, modifiedTime, size, md5Checksum").Context(ctx).Do()
+}
+
+// KeepRevision keeps the revision revisionID of the file fileID forever,
+// Drive otherwise deleting it after 30 days or 100 newer ones.
+func KeepRevision(ctx context.Context, srv *drive.Service, fileID string, revisionID string) error {
+	_, err := srv.Revisions.Update(fileID, revisionID, &drive.Revision{KeepForever: true}).Context(ctx).Do()
+	return err
+}
+
+// DeleteRevision deletes the revision revisionID of the file fileID, which
+// must not be its current content.
+func DeleteRevision(ctx context.Context, srv *drive.Service, fileID string, revisionID string) error {
+	return srv.Revisions.Delete(fileID, revisionID).Context(ctx).Do()
+}
+
+// DownloadRevision writes the content of the revision revisionID of the
+// file fileID to w.
+func DownloadRevision(ctx context.Context, srv *drive.Service, fileID string, revisionID string, w io.Writer) error {
+	response, err := srv.Revisions.Get(fileID, revisionID).Context(ctx).Download()
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(w, response.Body)
+	return err
+}
@@ -0,0 +1,290 @@
+package drive_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive/drivetest"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+// minChunkSize is the smallest chunk Drive accepts, so small files are
+// uploaded in several chunks.
+const minChunkSize = 256 << 10
+
+// newServer starts a fake Drive and returns it with a client of it.
+func newServer(t *testing.T) (*drivetest.Server, drive.Client) {
+	t.Helper()
+	server := drivetest.NewServer()
+	t.Cleanup(server.Close)
+	client, err := server.Connect(minChunkSize)(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestCreateFolder(t *testing.T) {
+	ctx := context.Background()
+	_, client := newServer(t)
+
+	folder, err := client.CreateFolder(ctx, "backup", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !drive.IsFolder(folder) {
+		t.Errorf("created %+v, want a folder", folder)
+	}
+	found, err := client.FindFolder(ctx, "backup", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.Id != folder.Id {
+		t.Fatalf("FindFolder = %+v, want the created folder %s", found, folder.Id)
+	}
+	if missing, err := client.FindFolder(ctx, "other", ""); missing != nil || err != nil {
+		t.Errorf("FindFolder of a missing folder = %+v, %v, want nil", missing, err)
+	}
+
+	// Drive allows two folders with the same name, which one to use is
+	// for the user to tell
+	if _, err = client.CreateFolder(ctx, "backup", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.FindFolder(ctx, "backup", ""); err == nil || !strings.Contains(err.Error(), "folderId") {
+		t.Errorf("FindFolder of a duplicate folder = %v, want an error asking for folderId", err)
+	}
+}
+
+func TestAccountsCreateDestination(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newServer(t)
+	cfg := &config.Config{FolderName: "Backups/Laptop"}
+
+	accounts := drive.NewAccounts(cfg, nil)
+	accounts.Connect = server.Connect(minChunkSize)
+	account, err := accounts.Get(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Folder().Name != "Laptop" {
+		t.Errorf("destination = %s, want Laptop", account.Folder().Name)
+	}
+
+	// another run finds the folders instead of creating them again
+	accounts = drive.NewAccounts(cfg, nil)
+	accounts.Connect = server.Connect(minChunkSize)
+	again, err := accounts.Get(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Folder().Id != account.Folder().Id {
+		t.Errorf("destination of the second run = %s, want %s", again.Folder().Id, account.Folder().Id)
+	}
+	if n := len(server.Files()); n != 2 {
+		t.Errorf("%d files in Drive, want the 2 folders of the path", n)
+	}
+}
+
+func TestAccountsNames(t *testing.T) {
+	cfg := &config.Config{
+		FolderAccount: map[string]string{"/photos": "family", "/work": "family"},
+	}
+	if names := drive.NewAccounts(cfg, nil).Names(); !reflect.DeepEqual(names, []string{"", "family"}) {
+		t.Errorf("Names = %q, want the default account and family", names)
+	}
+	if names := drive.NewAccounts(&config.Config{}, nil).Names(); !reflect.DeepEqual(names, []string{""}) {
+		t.Errorf("Names without folder accounts = %q, want the default account", names)
+	}
+}
+
+func TestUploadAndUpdate(t *testing.T) {
+	ctx := context.Background()
+	_, client := newServer(t)
+	folder, err := client.CreateFolder(ctx, "backup", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := client.CreateFile(ctx, folder, "report.txt", strings.NewReader("first version"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := client.UpdateFile(ctx, created, strings.NewReader("second version"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Id != created.Id {
+		t.Errorf("update created the file %s, want %s updated", updated.Id, created.Id)
+	}
+
+	var content bytes.Buffer
+	if err = client.Download(ctx, created.Id, &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.String() != "second version" {
+		t.Errorf("downloaded %q, want %q", content.String(), "second version")
+	}
+	revisions, err := client.ListRevisions(ctx, created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("%d revisions, want one per upload", len(revisions))
+	}
+	content.Reset()
+	if err = client.DownloadRevision(ctx, created.Id, revisions[0].Id, &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.String() != "first version" {
+		t.Errorf("first revision holds %q, want %q", content.String(), "first version")
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	ctx := context.Background()
+	server, client := newServer(t)
+	folder, err := client.CreateFolder(ctx, "backup", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*minChunkSize/16+100)
+
+	uri, err := client.StartUpload(ctx, folder, "large.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	file, err := client.ResumeUpload(ctx, uri, bytes.NewReader(content), func(offset int64) {
+		offsets = append(offsets, offset)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 3 || offsets[2] != 3*minChunkSize {
+		t.Errorf("checkpoints at %v, want one after each of the 3 full chunks", offsets)
+	}
+	if stored, _ := server.Content(file.Id); !bytes.Equal(stored, content) {
+		t.Errorf("Drive holds %d bytes, want the %d uploaded", len(stored), len(content))
+	}
+
+	// resuming a finished session answers the file without sending more
+	requests := server.Requests()
+	reader := bytes.NewReader(content)
+	again, err := client.ResumeUpload(ctx, uri, reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != file.Id || server.Requests() != requests+1 {
+		t.Errorf("resumed a finished upload with %d requests, want the file answered at once", server.Requests()-requests)
+	}
+	// the readers hashing the content still see all of it
+	if reader.Len() != 0 {
+		t.Errorf("%d bytes left unread resuming a finished upload, want them all read", reader.Len())
+	}
+}
+
+func TestPagination(t *testing.T) {
+	ctx := context.Background()
+	server, client := newServer(t)
+	server.PageSize = 2
+	folder, err := client.CreateFolder(ctx, "backup", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err = client.CreateFile(ctx, folder, fmt.Sprintf("file%d.txt", i), strings.NewReader("content")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// duplicates of a name spread over several pages too
+	for i := 0; i < 3; i++ {
+		if _, err = client.CreateFile(ctx, folder, "same.txt", strings.NewReader("content")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests := server.Requests()
+	files, err := client.ListFiles(ctx, folder.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 8 {
+		t.Errorf("listed %d files, want every page of the 8", len(files))
+	}
+	if n := server.Requests() - requests; n != 4 {
+		t.Errorf("listed in %d requests, want 4 pages of 2", n)
+	}
+	same, err := client.FindFiles(ctx, "same.txt", folder.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(same) != 3 {
+		t.Errorf("found %d files called same.txt, want 3", len(same))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	server, client := newServer(t)
+	folder, err := client.CreateFolder(ctx, "backup", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusBadRequest, false},
+	}
+	for _, test := range tests {
+		server.Fail(test.status, 1)
+		_, err := client.CreateFile(ctx, folder, "report.txt", strings.NewReader("content"))
+		if err == nil {
+			t.Fatalf("upload answered with %d succeeded, want an error", test.status)
+		}
+		if retryable := drive.IsRetryable(err); retryable != test.retryable {
+			t.Errorf("IsRetryable(%v) = %v, want %v", err, retryable, test.retryable)
+		}
+	}
+	// the failures are over
+	if _, err = client.CreateFile(ctx, folder, "report.txt", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := client.FindFiles(ctx, "report.txt", folder.Id); len(files) != 1 {
+		t.Errorf("%d files called report.txt, want only the upload that succeeded", len(files))
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	server, client := newServer(t)
+	server.Limit = 10
+	folder, err := client.CreateFolder(ctx, "backup", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.CreateFile(ctx, folder, "large.txt", strings.NewReader("more than ten bytes"))
+	if !drive.IsQuotaExceeded(err) {
+		t.Errorf("upload beyond the quota failed with %v, want the quota exceeded", err)
+	}
+	quota, err := client.Quota(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.Limit != 10 || quota.Usage != 0 {
+		t.Errorf("quota = %+v, want a limit of 10 and nothing used", quota)
+	}
+}
@@ -0,0 +1,439 @@
+// Package drivetest is a fake Drive keeping its files in memory. It serves
+// the part of the Drive API the app uses on an httptest server, so the
+// backup can be tried and tested without credentials or network.
+package drivetest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+)
+
+// DefaultPageSize is how many files a list answers per page unless set.
+const DefaultPageSize = 100
+
+// rootID is the parent of the files created without one.
+const rootID = "root"
+
+type file struct {
+	// order is the creation order
+	order     int
+	meta      drivev3.File
+	content   []byte
+	revisions []*revision
+}
+
+type revision struct {
+	meta    drivev3.Revision
+	content []byte
+}
+
+// Server is a fake Drive. Its zero value is not usable, call NewServer.
+type Server struct {
+	// PageSize is how many files a list answers per page, DefaultPageSize
+	// when not set. A small one exercises the pagination.
+	PageSize int
+	// Limit is the storage quota in bytes, uploads beyond it failing like
+	// on a full Drive. 0 is unlimited.
+	Limit int64
+
+	server   *httptest.Server
+	mutex    sync.Mutex
+	files    map[string]*file
+	sessions map[string]*session
+	lastID   int
+	// failures are the statuses answered to the next requests
+	failures []int
+	requests int
+}
+
+// NewServer starts a fake Drive without files. Close it when done.
+func NewServer() *Server {
+	s := &Server{files: make(map[string]*file), sessions: make(map[string]*session)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns an HTTP client sending the requests for any host, like
+// the Drive API ones, to the fake.
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.server.URL)
+	return &http.Client{Transport: &transport{target: target, base: s.server.Client().Transport}}
+}
+
+// Connect returns a function for drive.Accounts.Connect giving every
+// account a client of the fake, uploading in chunks of chunkSize bytes.
+func (s *Server) Connect(chunkSize int) func(ctx context.Context, name string) (drive.Client, error) {
+	return func(ctx context.Context, name string) (drive.Client, error) {
+		httpClient := s.Client()
+		srv, err := drivev3.New(httpClient)
+		if err != nil {
+			return nil, err
+		}
+		return drive.NewClient(srv, httpClient, chunkSize), nil
+	}
+}
+
+// Fail answers the next n requests with status: 429 and 403 are Drive
+// rate limiting, 5xx its server errors.
+func (s *Server) Fail(status int, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// Requests returns how many requests the server answered.
+func (s *Server) Requests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests
+}
+
+// Files returns every file and folder, trashed ones included, in creation
+// order.
+func (s *Server) Files() []*drivev3.File {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var files []*drivev3.File
+	for _, f := range s.sorted() {
+		meta := f.meta
+		files = append(files, &meta)
+	}
+	return files
+}
+
+// Content returns the content of the file fileID, false when there is no
+// such file.
+func (s *Server) Content(fileID string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, ok := s.files[fileID]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), f.content...), true
+}
+
+// transport sends every request to target, keeping its path.
+type transport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	req.Host = ""
+	return t.base.RoundTrip(req)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests++
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, status, failureReason(status), "Injected failure")
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(path) >= 3 && path[0] == "upload" && path[1] == "drive" && path[2] == "v3":
+		s.handleUpload(w, r, path[3:])
+	case len(path) >= 2 && path[0] == "drive" && path[1] == "v3":
+		s.handleAPI(w, r, path[2:])
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "Unknown path "+r.URL.Path)
+	}
+}
+
+// handleAPI answers the metadata requests, path following /drive/v3.
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request, path []string) {
+	switch {
+	case len(path) == 1 && path[0] == "about" && r.Method == http.MethodGet:
+		s.about(w)
+	case len(path) == 1 && path[0] == "files" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case len(path) == 1 && path[0] == "files" && r.Method == http.MethodPost:
+		var meta drivev3.File
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			writeError(w, http.StatusBadRequest, "parseError", err.Error())
+			return
+		}
+		writeJSON(w, s.create(meta, nil))
+	case len(path) == 2 && path[0] == "files":
+		f, ok := s.files[path[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "File not found: "+path[1])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("alt") == "media" {
+				w.Write(f.content)
+				return
+			}
+			writeJSON(w, f.meta)
+		case http.MethodPatch:
+			if err := s.patch(f, r); err != nil {
+				writeError(w, http.StatusBadRequest, "parseError", err.Error())
+				return
+			}
+			writeJSON(w, f.meta)
+		case http.MethodDelete:
+			delete(s.files, f.meta.Id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "badRequest", "Method not allowed")
+		}
+	case len(path) >= 3 && path[0] == "files" && path[2] == "revisions":
+		f, ok := s.files[path[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "File not found: "+path[1])
+			return
+		}
+		s.handleRevisions(w, r, f, path[3:])
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "Unknown path "+r.URL.Path)
+	}
+}
+
+func (s *Server) about(w http.ResponseWriter) {
+	writeJSON(w, drivev3.About{StorageQuota: &drivev3.AboutStorageQuota{Limit: s.Limit, Usage: s.usage()}})
+}
+
+// list answers a search, a page at a time, the page token being the index
+// of the first file of the page.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	match, err := parseQuery(query.Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	var files []*drivev3.File
+	for _, f := range s.sorted() {
+		if match(f) {
+			meta := f.meta
+			files = append(files, &meta)
+		}
+	}
+	if strings.HasPrefix(query.Get("orderBy"), "modifiedTime desc") {
+		sort.SliceStable(files, func(i, j int) bool { return files[i].ModifiedTime > files[j].ModifiedTime })
+	}
+
+	start := 0
+	if token := query.Get("pageToken"); token != "" {
+		if start, err = strconv.Atoi(token); err != nil || start < 0 || start > len(files) {
+			writeError(w, http.StatusBadRequest, "invalid", "Invalid page token")
+			return
+		}
+	}
+	pageSize := s.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	list := drivev3.FileList{Files: files[start:]}
+	if len(list.Files) > pageSize {
+		list.Files = list.Files[:pageSize]
+		list.NextPageToken = strconv.Itoa(start + pageSize)
+	}
+	writeJSON(w, list)
+}
+
+// create adds a file with the metadata meta, and a first revision with
+// content when it is not nil.
+func (s *Server) create(meta drivev3.File, content []byte) drivev3.File {
+	s.lastID++
+	f := &file{order: s.lastID, meta: drivev3.File{
+		Id:            fmt.Sprintf("file%d", s.lastID),
+		Name:          meta.Name,
+		MimeType:      meta.MimeType,
+		Parents:       meta.Parents,
+		AppProperties: meta.AppProperties,
+		CreatedTime:   now(),
+		ModifiedTime:  now(),
+	}}
+	if len(f.meta.Parents) == 0 {
+		f.meta.Parents = []string{rootID}
+	}
+	if f.meta.MimeType == "" {
+		f.meta.MimeType = "application/octet-stream"
+	}
+	s.files[f.meta.Id] = f
+	if content != nil {
+		s.write(f, content)
+	}
+	return f.meta
+}
+
+// write stores content as a new revision of f.
+func (s *Server) write(f *file, content []byte) {
+	sum := md5.Sum(content)
+	s.lastID++
+	rev := &revision{meta: drivev3.Revision{
+		Id:           fmt.Sprintf("rev%d", s.lastID),
+		ModifiedTime: now(),
+		Size:         int64(len(content)),
+		Md5Checksum:  hex.EncodeToString(sum[:]),
+		MimeType:     f.meta.MimeType,
+	}, content: content}
+	f.revisions = append(f.revisions, rev)
+	f.content = content
+	f.meta.Size = rev.meta.Size
+	f.meta.Md5Checksum = rev.meta.Md5Checksum
+	f.meta.ModifiedTime = rev.meta.ModifiedTime
+	f.meta.HeadRevisionId = rev.meta.Id
+}
+
+// patch updates the metadata of f with the body of r, its name, trashed
+// state and appProperties, and its parents with the addParents and
+// removeParents parameters.
+func (s *Server) patch(f *file, r *http.Request) error {
+	var meta map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		return err
+	}
+	return s.update(f, meta, r.URL.Query())
+}
+
+func (s *Server) update(f *file, meta map[string]json.RawMessage, query url.Values) error {
+	if value, ok := meta["name"]; ok {
+		if err := json.Unmarshal(value, &f.meta.Name); err != nil {
+			return err
+		}
+	}
+	if value, ok := meta["trashed"]; ok {
+		if err := json.Unmarshal(value, &f.meta.Trashed); err != nil {
+			return err
+		}
+	}
+	if value, ok := meta["appProperties"]; ok {
+		// properties set to null are removed, the others replaced
+		var props map[string]*string
+		if err := json.Unmarshal(value, &props); err != nil {
+			return err
+		}
+		if f.meta.AppProperties == nil {
+			f.meta.AppProperties = map[string]string{}
+		}
+		for key, value := range props {
+			if value == nil {
+				delete(f.meta.AppProperties, key)
+			} else {
+				f.meta.AppProperties[key] = *value
+			}
+		}
+	}
+	if remove := query.Get("removeParents"); remove != "" {
+		var parents []string
+		for _, parent := range f.meta.Parents {
+			if !slices.Contains(strings.Split(remove, ","), parent) {
+				parents = append(parents, parent)
+			}
+		}
+		f.meta.Parents = parents
+	}
+	if add := query.Get("addParents"); add != "" {
+		f.meta.Parents = append(f.meta.Parents, strings.Split(add, ",")...)
+	}
+	f.meta.ModifiedTime = now()
+	return nil
+}
+
+// sorted returns the files in creation order.
+func (s *Server) sorted() []*file {
+	files := make([]*file, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].order < files[j].order })
+	return files
+}
+
+// usage returns the bytes taken by every revision of every file.
+func (s *Server) usage() int64 {
+	var usage int64
+	for _, f := range s.files {
+		for _, rev := range f.revisions {
+			usage += rev.meta.Size
+		}
+	}
+	return usage
+}
+
+// full reports whether storing size more bytes exceeds the quota.
+func (s *Server) full(size int64) bool {
+	return s.Limit > 0 && s.usage()+size > s.Limit
+}
+
+// now formats the current time like Drive, with a fixed number of
+// digits so the times sort as strings.
+func now() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// failureReason is the reason Drive gives with status.
+func failureReason(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rateLimitExceeded"
+	case status == http.StatusForbidden:
+		return "userRateLimitExceeded"
+	case status >= http.StatusInternalServerError:
+		return "backendError"
+	}
+	return "badRequest"
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError answers with an error formatted like the Drive ones, which
+// googleapi.CheckResponse parses.
+func writeError(w http.ResponseWriter, status int, reason string, message string) {
+	type item struct {
+		Domain  string `json:"domain"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	var body struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Errors  []item `json:"errors"`
+		} `json:"error"`
+	}
+	body.Error.Code = status
+	body.Error.Message = message
+	body.Error.Errors = []item{{Domain: "global", Reason: reason, Message: message}}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,117 @@
+package drivetest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// token is a word, an operator or a quoted string of a Drive query.
+type token struct {
+	text   string
+	quoted bool
+}
+
+// parseQuery returns a function matching the files of the Drive query q.
+// Only the clauses joined with and that the app searches with are known:
+// 'id' in parents, and name, mimeType, trashed and explicitlyTrashed
+// compared with = or !=.
+func parseQuery(q string) (func(f *file) bool, error) {
+	tokens, err := tokenize(q)
+	if err != nil {
+		return nil, err
+	}
+	var clauses []func(f *file) bool
+	for len(tokens) > 0 {
+		if len(tokens) < 3 {
+			return nil, fmt.Errorf("Invalid query: %s", q)
+		}
+		clause, err := parseClause(tokens[0], tokens[1], tokens[2])
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+		tokens = tokens[3:]
+		if len(tokens) > 0 {
+			if tokens[0].quoted || tokens[0].text != "and" {
+				return nil, fmt.Errorf("Invalid query, only and is known: %s", q)
+			}
+			tokens = tokens[1:]
+			if len(tokens) == 0 {
+				return nil, fmt.Errorf("Invalid query: %s", q)
+			}
+		}
+	}
+	return func(f *file) bool {
+		for _, clause := range clauses {
+			if !clause(f) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func parseClause(left token, operator token, right token) (func(f *file) bool, error) {
+	if left.quoted && operator.text == "in" && !right.quoted && right.text == "parents" {
+		return func(f *file) bool { return slices.Contains(f.meta.Parents, left.text) }, nil
+	}
+	if left.quoted || operator.quoted || operator.text != "=" && operator.text != "!=" {
+		return nil, fmt.Errorf("Invalid query clause: %s %s %s", left.text, operator.text, right.text)
+	}
+	var field func(f *file) string
+	switch left.text {
+	case "name":
+		field = func(f *file) string { return f.meta.Name }
+	case "mimeType":
+		field = func(f *file) string { return f.meta.MimeType }
+	case "trashed", "explicitlyTrashed":
+		field = func(f *file) string { return fmt.Sprint(f.meta.Trashed) }
+	default:
+		return nil, fmt.Errorf("Unknown query field: %s", left.text)
+	}
+	equal := operator.text == "="
+	return func(f *file) bool { return (field(f) == right.text) == equal }, nil
+}
+
+// tokenize splits q in words, = and != operators and quoted strings, whose
+// \' and \\ are unescaped.
+func tokenize(q string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(q); {
+		switch c := q[i]; {
+		case c == ' ':
+			i++
+		case c == '\'':
+			var text strings.Builder
+			i++
+			for ; i < len(q) && q[i] != '\''; i++ {
+				if q[i] == '\\' && i+1 < len(q) {
+					i++
+				}
+				text.WriteByte(q[i])
+			}
+			if i == len(q) {
+				return nil, fmt.Errorf("Unterminated string in query: %s", q)
+			}
+			i++
+			tokens = append(tokens, token{text: text.String(), quoted: true})
+		case c == '=':
+			tokens = append(tokens, token{text: "="})
+			i++
+		case c == '!' && i+1 < len(q) && q[i+1] == '=':
+			tokens = append(tokens, token{text: "!="})
+			i += 2
+		default:
+			start := i
+			for i < len(q) && !strings.ContainsRune(" '=!", rune(q[i])) {
+				i++
+			}
+			if i == start {
+				return nil, fmt.Errorf("Invalid query: %s", q)
+			}
+			tokens = append(tokens, token{text: q[start:i]})
+		}
+	}
+	return tokens, nil
+}
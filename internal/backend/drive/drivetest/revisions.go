@@ -0,0 +1,66 @@
+package drivetest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	drivev3 "google.golang.org/api/drive/v3"
+)
+
+// handleRevisions answers the requests on the revisions of f, path
+// following /drive/v3/files/<id>/revisions.
+func (s *Server) handleRevisions(w http.ResponseWriter, r *http.Request, f *file, path []string) {
+	if len(path) == 0 && r.Method == http.MethodGet {
+		var list drivev3.RevisionList
+		for _, rev := range f.revisions {
+			meta := rev.meta
+			list.Revisions = append(list.Revisions, &meta)
+		}
+		writeJSON(w, list)
+		return
+	}
+	if len(path) != 1 {
+		writeError(w, http.StatusNotFound, "notFound", "Unknown path "+r.URL.Path)
+		return
+	}
+	index := -1
+	for i, rev := range f.revisions {
+		if rev.meta.Id == path[0] {
+			index = i
+		}
+	}
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "notFound", "Revision not found: "+path[0])
+		return
+	}
+	rev := f.revisions[index]
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("alt") == "media" {
+			w.Write(rev.content)
+			return
+		}
+		writeJSON(w, rev.meta)
+	case http.MethodPatch:
+		var update struct {
+			KeepForever *bool `json:"keepForever"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeError(w, http.StatusBadRequest, "parseError", err.Error())
+			return
+		}
+		if update.KeepForever != nil {
+			rev.meta.KeepForever = *update.KeepForever
+		}
+		writeJSON(w, rev.meta)
+	case http.MethodDelete:
+		if index == len(f.revisions)-1 {
+			writeError(w, http.StatusBadRequest, "badRequest", "The head revision cannot be deleted")
+			return
+		}
+		f.revisions = append(f.revisions[:index], f.revisions[index+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "badRequest", "Method not allowed")
+	}
+}
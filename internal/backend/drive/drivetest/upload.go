@@ -0,0 +1,219 @@
+package drivetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	drivev3 "google.golang.org/api/drive/v3"
+)
+
+// uploadURL is where the resumable upload sessions are, answered in the
+// Location header like Drive does.
+const uploadURL = "https://www.googleapis.com/upload/drive/v3/files?uploadType=resumable&upload_id="
+
+// statusResumeIncomplete is the answer to a chunk that is not the last one.
+const statusResumeIncomplete = 308
+
+// session is a resumable upload.
+type session struct {
+	// fileID is the file updated, "" creating a new one
+	fileID   string
+	metadata []byte
+	query    url.Values
+	data     []byte
+	// done is the uploaded file, once finished
+	done *drivev3.File
+}
+
+// handleUpload answers the media uploads, path following /upload/drive/v3:
+// simple, multipart and resumable ones creating a file, or updating the
+// file of the path.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, path []string) {
+	query := r.URL.Query()
+	if id := query.Get("upload_id"); id != "" {
+		s.uploadChunk(w, r, id)
+		return
+	}
+	fileID := ""
+	switch {
+	case len(path) == 1 && path[0] == "files" && r.Method == http.MethodPost:
+	case len(path) == 2 && path[0] == "files" && r.Method == http.MethodPatch:
+		fileID = path[1]
+		if _, ok := s.files[fileID]; !ok {
+			writeError(w, http.StatusNotFound, "notFound", "File not found: "+fileID)
+			return
+		}
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "Unknown path "+r.URL.Path)
+		return
+	}
+
+	switch query.Get("uploadType") {
+	case "media":
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "badRequest", err.Error())
+			return
+		}
+		s.store(w, fileID, nil, query, content)
+	case "multipart":
+		metadata, content, err := readMultipart(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "badRequest", err.Error())
+			return
+		}
+		s.store(w, fileID, metadata, query, content)
+	case "resumable":
+		metadata, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "badRequest", err.Error())
+			return
+		}
+		s.lastID++
+		id := fmt.Sprintf("upload%d", s.lastID)
+		s.sessions[id] = &session{fileID: fileID, metadata: metadata, query: query}
+		w.Header().Set("Location", uploadURL+id)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusBadRequest, "badRequest", "Unknown uploadType "+query.Get("uploadType"))
+	}
+}
+
+// uploadChunk stores a chunk of the session id at its Content-Range, a
+// range without bytes asking how many Drive has.
+func (s *Server) uploadChunk(w http.ResponseWriter, r *http.Request, id string) {
+	upload, ok := s.sessions[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Upload session not found: "+id)
+		return
+	}
+	if upload.done != nil {
+		writeJSON(w, upload.done)
+		return
+	}
+	first, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badRequest", err.Error())
+		return
+	}
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badRequest", err.Error())
+		return
+	}
+	if first >= 0 {
+		if first > int64(len(upload.data)) {
+			writeError(w, http.StatusBadRequest, "badRequest", "Chunk after the stored bytes")
+			return
+		}
+		upload.data = append(upload.data[:first], chunk...)
+	}
+	if total >= 0 && int64(len(upload.data)) > total {
+		writeError(w, http.StatusBadRequest, "badRequest", "More bytes than the total")
+		return
+	}
+	if total >= 0 && int64(len(upload.data)) == total {
+		if meta, ok := s.store(w, upload.fileID, upload.metadata, upload.query, upload.data); ok {
+			upload.done = meta
+		}
+		return
+	}
+	if len(upload.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(upload.data)-1))
+	}
+	w.WriteHeader(statusResumeIncomplete)
+}
+
+// store creates a file with metadata and content, or updates the file
+// fileID when it is not "", and answers with it. It fails like Drive when
+// the quota is exceeded.
+func (s *Server) store(w http.ResponseWriter, fileID string, metadata []byte, query url.Values, content []byte) (*drivev3.File, bool) {
+	if s.full(int64(len(content))) {
+		writeError(w, http.StatusForbidden, "storageQuotaExceeded", "The user's Drive storage quota has been exceeded.")
+		return nil, false
+	}
+	if len(metadata) == 0 {
+		metadata = []byte("{}")
+	}
+	if fileID == "" {
+		var meta drivev3.File
+		if err := json.Unmarshal(metadata, &meta); err != nil {
+			writeError(w, http.StatusBadRequest, "parseError", err.Error())
+			return nil, false
+		}
+		created := s.create(meta, content)
+		writeJSON(w, created)
+		return &created, true
+	}
+	f := s.files[fileID]
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(metadata, &meta); err != nil {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error())
+		return nil, false
+	}
+	if err := s.update(f, meta, query); err != nil {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error())
+		return nil, false
+	}
+	s.write(f, content)
+	updated := f.meta
+	writeJSON(w, updated)
+	return &updated, true
+}
+
+// readMultipart returns the JSON metadata and the content of a multipart
+// upload.
+func readMultipart(r *http.Request) ([]byte, []byte, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, nil, fmt.Errorf("Not a multipart upload: %s", mediaType)
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	var parts [][]byte
+	for len(parts) < 2 {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Missing part of the multipart upload: %v", err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, content)
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseContentRange parses "bytes first-last/total", first being -1 for
+// "bytes */total" and total -1 for an unknown "*" one.
+func parseContentRange(header string) (int64, int64, error) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	byteRange, size, ok := strings.Cut(spec, "/")
+	if !found || !ok {
+		return 0, 0, fmt.Errorf("Invalid Content-Range: %q", header)
+	}
+	first, total := int64(-1), int64(-1)
+	var err error
+	if byteRange != "*" {
+		start, _, _ := strings.Cut(byteRange, "-")
+		if first, err = strconv.ParseInt(start, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Invalid Content-Range: %q", header)
+		}
+	}
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Invalid Content-Range: %q", header)
+		}
+	}
+	return first, total, nil
+}
@@ -0,0 +1,85 @@
+package drive
+
+import (
+	"log/slog"
+	"strings"
+
+	"golang.org/x/net/context"
+	drive "google.golang.org/api/drive/v3"
+)
+
+// EnsureFolderPath returns the folder at path, like
+// "Backups/hostname/Documents/2024", inside parent, creating the missing
+// folders of the path. Their IDs are cached until the destination folder
+// of account has to be looked up again.
+func (accounts *Accounts) EnsureFolderPath(ctx context.Context, account *Account, parent *drive.File, path string) (*drive.File, error) {
+	if strings.Trim(path, "/") == "" {
+		return parent, nil
+	}
+	return accounts.ensureFolderPath(ctx, account, parent.Id, folderPath(path))
+}
+
+// ensureFolderPath returns the folder at names in the folder parentID,
+// the first one being searched anywhere in the Drive when parentID is
+// empty, creating the missing ones.
+func (accounts *Accounts) ensureFolderPath(ctx context.Context, account *Account, parentID string, names []string) (*drive.File, error) {
+	// held while creating, so two uploads do not create the same folder
+	accounts.foldersMutex.Lock()
+	defer accounts.foldersMutex.Unlock()
+	if accounts.folders == nil {
+		accounts.folders = make(map[string]*drive.File)
+	}
+
+	var folderFile *drive.File
+	for i, name := range names {
+		key := account.name + "/" + parentID + "/" + strings.Join(names[:i+1], "/")
+		if cached, ok := accounts.folders[key]; ok {
+			folderFile = cached
+		} else {
+			var err error
+			folderFile, err = account.client.FindFolder(ctx, name, parentID)
+			if err != nil {
+				return nil, err
+			}
+			if folderFile == nil {
+				// an empty parentID only searches anywhere, folders are
+				// created at the root of the Drive
+				folderFile, err = account.client.CreateFolder(ctx, name, parentID)
+				if err != nil {
+					return nil, err
+				}
+				slog.Info("Created folder for files", "folder", name, "account", account.name)
+			}
+			accounts.folders[key] = folderFile
+		}
+		parentID = folderFile.Id
+	}
+	return folderFile, nil
+}
+
+// forgetFolders drops the cached folders of account, one of them no longer
+// existing.
+func (accounts *Accounts) forgetFolders(account *Account) {
+	accounts.foldersMutex.Lock()
+	defer accounts.foldersMutex.Unlock()
+	for key := range accounts.folders {
+		if strings.HasPrefix(key, account.name+"/") {
+			delete(accounts.folders, key)
+		}
+	}
+}
+
+// folderPath splits a folder path into the names of the nested folders it
+// is made of.
+func folderPath(path string) []string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{path}
+	}
+	return names
+}
@@ -0,0 +1,90 @@
+package drive
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	drive "google.golang.org/api/drive/v3"
+)
+
+// Keys of the appProperties written on every uploaded file, so it can be
+// restored and audited without state.db.
+const (
+	// PropertyPath is the absolute path of the local file.
+	PropertyPath = "path"
+	// PropertyHash is the SHA-256 of the local file, before the processors.
+	PropertyHash = "sha256"
+	// PropertyProcessors is the comma separated processors the file went
+	// through, gzip meaning it has to be decompressed.
+	PropertyProcessors = "processors"
+	// PropertyHost is the hostname of the machine that uploaded the file
+	// last.
+	PropertyHost = "host"
+	// PropertyMode is the octal permissions of the local file.
+	PropertyMode = "mode"
+	// PropertyOwner is the uid:gid owning the local file, outside Windows.
+	PropertyOwner = "owner"
+	// PropertyXattrs is the extended attributes of the local file, one
+	// name=base64 value per line.
+	PropertyXattrs = "xattrs"
+	// PropertySymlink is the target of the local file when it was a
+	// symbolic link, its content being the one of the target.
+	PropertySymlink = "symlink"
+	// PropertyParts is how many parts the content was split in, the Drive
+	// file holding the manifest listing them, empty when it was not.
+	PropertyParts = "parts"
+	// PropertyPinned is the comma separated revisions kept forever from
+	// before a mass change, which gc keeps whatever its retention policy.
+	PropertyPinned = "pinned"
+	// PropertyCollected is when gc last trashed chunks, set on the chunks
+	// folder of the repository so every machine sharing it forgets the
+	// chunks it knew.
+	PropertyCollected = "collected"
+)
+
+// maxPropertySize is the most bytes Drive accepts for the key and the
+// value of a property together.
+const maxPropertySize = 124
+
+// SetProperty sets key to value in props, spreading a value too long for a
+// single property over key, key.1, key.2... An empty part ends the value,
+// so a shorter value replaces a longer one.
+func SetProperty(props map[string]string, key string, value string) {
+	partKey := key
+	for i := 1; ; i++ {
+		size := maxPropertySize - len(partKey)
+		if len(value) <= size {
+			props[partKey] = value
+			props[key+"."+strconv.Itoa(i)] = ""
+			return
+		}
+		// never split a character
+		for !utf8.RuneStart(value[size]) {
+			size--
+		}
+		props[partKey] = value[:size]
+		value = value[size:]
+		partKey = key + "." + strconv.Itoa(i)
+	}
+}
+
+// Property returns the value of key set with SetProperty, empty when props
+// does not have it.
+func Property(props map[string]string, key string) string {
+	value := props[key]
+	for i := 1; ; i++ {
+		part := props[key+"."+strconv.Itoa(i)]
+		if part == "" {
+			return value
+		}
+		value += part
+	}
+}
+
+// SetAppProperties adds props to the appProperties of the file fileID,
+// only visible to this app.
+func SetAppProperties(ctx context.Context, srv *drive.Service, fileID string, props map[string]string) error {
+	_, err := srv.Files.Update(fileID, &drive.File{AppProperties: props}).Context(ctx).Do()
+	return err
+}
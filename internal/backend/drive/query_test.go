@@ -0,0 +1,60 @@
+package drive
+
+import (
+	"testing"
+
+	drive "google.golang.org/api/drive/v3"
+)
+
+func TestEscapeQuery(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"John's notes", `John\'s notes`},
+		{`C:\docs`, `C:\\docs`},
+		{`it\'s`, `it\\\'s`},
+	}
+	for _, test := range tests {
+		if got := escapeQuery(test.value); got != test.want {
+			t.Errorf("escapeQuery(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestQueries(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"folders", foldersQuery("Backup's"),
+			`mimeType='application/vnd.google-apps.folder' and trashed=false and name='Backup\'s'`},
+		{"children", childrenQuery("folder1"),
+			`'folder1' in parents and explicitlyTrashed=false`},
+		{"file", fileQuery("John's report.odt.gz", "folder1"),
+			`'folder1' in parents and explicitlyTrashed=false and name='John\'s report.odt.gz'`},
+	}
+	for _, test := range tests {
+		if test.query != test.want {
+			t.Errorf("%s query = %q, want %q", test.name, test.query, test.want)
+		}
+	}
+}
+
+func TestPreferFile(t *testing.T) {
+	newest := &drive.File{Id: "newest", Name: "report.odt"}
+	known := &drive.File{Id: "known", Name: "report.odt"}
+	files := []*drive.File{newest, known}
+
+	if file := PreferFile(nil, func(string) bool { return true }); file != nil {
+		t.Errorf("PreferFile(nil) = %v, want nil", file)
+	}
+	if file := PreferFile(files, func(id string) bool { return id == "known" }); file != known {
+		t.Errorf("PreferFile = %s, want the file known in the state", file.Id)
+	}
+	if file := PreferFile(files, func(string) bool { return false }); file != newest {
+		t.Errorf("PreferFile = %s, want the most recently modified file", file.Id)
+	}
+}
@@ -0,0 +1,198 @@
+package drive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+const uploadURL = "https://www.googleapis.com/upload/drive/v3/files"
+
+// DefaultChunkSize is how much of an upload is sent per request unless
+// configured otherwise. The chunk size bounds the memory an upload takes,
+// whatever the size of the file.
+const DefaultChunkSize = 8 << 20
+
+// minChunkSize is the granularity of the chunks Drive accepts.
+const minChunkSize = 256 << 10
+
+// ChunkSize returns the chunk size set in cfg, rounded down to a multiple
+// of the 256 KiB Drive requires, DefaultChunkSize when not set.
+func ChunkSize(cfg *config.Config) int {
+	if cfg.UploadChunkKB <= 0 {
+		return DefaultChunkSize
+	}
+	size := cfg.UploadChunkKB << 10 / minChunkSize * minChunkSize
+	if size < minChunkSize {
+		return minChunkSize
+	}
+	return size
+}
+
+// statusResumeIncomplete is the answer Drive gives to a chunk that is not
+// the last one.
+const statusResumeIncomplete = 308
+
+// StartUpload opens a resumable upload session for the file called
+// fileName in the folder folderFile, or for a new version of file when it
+// is not nil, and returns the session URI.
+func StartUpload(ctx context.Context, client *http.Client, folderFile *drive.File, fileName string, file *drive.File) (string, error) {
+	method, url := http.MethodPost, uploadURL+"?uploadType=resumable"
+	metadata := map[string]any{}
+	if file != nil {
+		method, url = http.MethodPatch, uploadURL+"/"+file.Id+"?uploadType=resumable"
+	} else {
+		metadata["name"] = fileName
+		metadata["parents"] = []string{folderFile.Id}
+	}
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return "", err
+	}
+	location := res.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("No session URI in the Drive answer")
+	}
+	return location, nil
+}
+
+// ResumeUpload sends r to the upload session uri in chunks of chunkSize
+// bytes, skipping the bytes Drive already has, and returns the uploaded
+// file. checkpoint, when not nil, is called with the bytes stored by Drive
+// after every chunk. r is read to its end even when Drive already has the
+// whole upload, for the readers hashing what goes through it.
+func ResumeUpload(ctx context.Context, client *http.Client, uri string, r io.Reader, chunkSize int, checkpoint func(offset int64)) (*drive.File, error) {
+	offset, file, err := uploadStatus(ctx, client, uri)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, fmt.Errorf("Unable to read the uploaded bytes: %v", err)
+		}
+		return file, nil
+	}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		return nil, fmt.Errorf("Unable to skip the uploaded bytes: %v", err)
+	}
+
+	// chunk is the only buffer of the file, reading into it skips the small
+	// buffer of reader, only used to peek for the end of the file
+	reader := bufio.NewReader(r)
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		last := err != nil
+		if !last {
+			_, err = reader.Peek(1)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			last = err == io.EOF
+		}
+
+		contentRange := fmt.Sprintf("bytes %d-%d/", offset, offset+int64(n)-1)
+		if n == 0 {
+			contentRange = "bytes */"
+		}
+		if last {
+			contentRange += strconv.FormatInt(offset+int64(n), 10)
+		} else {
+			contentRange += "*"
+		}
+		stored, file, err := sendChunk(ctx, client, uri, chunk[:n], contentRange)
+		if err != nil || file != nil {
+			return file, err
+		}
+		if stored != offset+int64(n) {
+			return nil, fmt.Errorf("Drive stored %d bytes of %d sent", stored, offset+int64(n))
+		}
+		offset = stored
+		if checkpoint != nil {
+			checkpoint(offset)
+		}
+		if last {
+			return nil, fmt.Errorf("Drive did not finish the upload")
+		}
+	}
+}
+
+// uploadStatus returns how many bytes of the session uri Drive has, or the
+// uploaded file when the upload is already finished.
+func uploadStatus(ctx context.Context, client *http.Client, uri string) (int64, *drive.File, error) {
+	return sendChunk(ctx, client, uri, nil, "bytes */*")
+}
+
+// sendChunk puts chunk at contentRange of the session uri, returning the
+// bytes Drive has or the uploaded file once finished.
+func sendChunk(ctx context.Context, client *http.Client, uri string, chunk []byte, contentRange string) (int64, *drive.File, error) {
+	req, err := http.NewRequest(http.MethodPut, uri, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == statusResumeIncomplete {
+		return storedBytes(res.Header.Get("Range")), nil, nil
+	}
+	if err := googleapi.CheckResponse(res); err != nil {
+		return 0, nil, err
+	}
+	file := &drive.File{}
+	if err := json.NewDecoder(res.Body).Decode(file); err != nil {
+		return 0, nil, fmt.Errorf("Unable to read the uploaded file: %v", err)
+	}
+	return 0, file, nil
+}
+
+// storedBytes parses the Range header of a 308 answer, "bytes=0-N", no
+// header meaning nothing was stored yet.
+func storedBytes(header string) int64 {
+	i := strings.LastIndex(header, "-")
+	if i < 0 {
+		return 0
+	}
+	last, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return last + 1
+}
+
+// IsSessionExpired reports whether err means a resumable upload session
+// no longer exists, the upload must start again.
+func IsSessionExpired(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone)
+}
@@ -0,0 +1,75 @@
+// Package buildinfo identifies the build of the app, so bug reports, logs
+// and the upload history tell which one produced them. Release builds set
+// the variables with the linker:
+//
+//	go build -ldflags "-X github.com/amcereijo/EncryptBckDocs/internal/buildinfo.Version=1.4.0 \
+//		-X github.com/amcereijo/EncryptBckDocs/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X github.com/amcereijo/EncryptBckDocs/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them the commit and date come from the version control
+// information Go embeds when building from a checkout.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// set with -ldflags -X
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the identity of a build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	// Modified is set when the build comes from a checkout with
+	// uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Get returns the identity of the running build.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// String returns the version and the short commit, like 1.4.0 (3f2a9c1),
+// to tag a log or a history entry with.
+func (info Info) String() string {
+	commit := info.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if info.Modified {
+		commit += "+dirty"
+	}
+	if commit == "" {
+		return info.Version
+	}
+	return fmt.Sprintf("%s (%s)", info.Version, commit)
+}
@@ -0,0 +1,87 @@
+// Package chunker splits files in content-defined chunks: the boundaries
+// depend on the content around them, found with a rolling hash, so an
+// insertion in a file only changes the chunks around it and the same
+// content gives the same chunks in any file.
+package chunker
+
+import (
+	"io"
+)
+
+// sizes of the chunks
+const (
+	MinSize = 512 << 10
+	MaxSize = 8 << 20
+	// a boundary is found on average every 1 MiB after MinSize, testing
+	// the high bits of the hash, which depend on the last 64 bytes
+	boundaryMask = (1<<20 - 1) << 44
+)
+
+// gear maps every byte to a random value of the rolling hash. The values
+// are fixed, chunks must keep their boundaries from one run to another.
+var gear [256]uint64
+
+func init() {
+	// splitmix64, seeded with a fixed value
+	seed := uint64(0x9E3779B97F4A7C15)
+	for i := range gear {
+		seed += 0x9E3779B97F4A7C15
+		z := seed
+		z = (z ^ z>>30) * 0xBF58476D1CE4E5B9
+		z = (z ^ z>>27) * 0x94D049BB133111EB
+		gear[i] = z ^ z>>31
+	}
+}
+
+// Chunker reads the chunks of a stream, holding at most MaxSize bytes.
+type Chunker struct {
+	r   io.Reader
+	buf []byte
+	// pending are the bytes of buf read and not returned yet
+	pending int
+	eof     bool
+}
+
+// New returns a Chunker reading r.
+func New(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, MaxSize)}
+}
+
+// Next returns the next chunk, io.EOF after the last one.
+func (c *Chunker) Next() ([]byte, error) {
+	for !c.eof && c.pending < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.pending:])
+		c.pending += n
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.pending == 0 {
+		return nil, io.EOF
+	}
+	size := boundary(c.buf[:c.pending])
+	chunk := make([]byte, size)
+	copy(chunk, c.buf[:size])
+	c.pending = copy(c.buf, c.buf[size:c.pending])
+	return chunk, nil
+}
+
+// boundary returns where the chunk starting data ends, the first position
+// after MinSize where the rolling hash of the previous bytes has the bits
+// of boundaryMask at zero, or MaxSize.
+func boundary(data []byte) int {
+	if len(data) <= MinSize {
+		return len(data)
+	}
+	var hash uint64
+	// the hash covers the last 64 bytes, older ones shifted out
+	for i := MinSize - 64; i < len(data); i++ {
+		hash = hash<<1 + gear[data[i]]
+		if i >= MinSize && hash&boundaryMask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// keptBackups is how many backups of the config are kept, the older ones
+// being removed.
+const keptBackups = 10
+
+// backupTimeLayout dates the backups, sorting by name sorts them by date.
+const backupTimeLayout = "20060102-150405.000"
+
+// BackupDir returns the folder the backups of the config file are saved
+// in, config-backups next to it.
+func BackupDir(file string) string {
+	return filepath.Join(filepath.Dir(file), "config-backups")
+}
+
+// Backup copies the config file to a new backup, named after the time,
+// before it is overwritten, removing the oldest backups beyond
+// keptBackups. It returns the backup, "" when there is no config yet.
+func Backup(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	dir := BackupDir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	backup := filepath.Join(dir, name+"-"+time.Now().Format(backupTimeLayout)+filepath.Ext(file))
+	if err := os.WriteFile(backup, content, 0600); err != nil {
+		return "", err
+	}
+
+	backups, err := Backups(file)
+	if err != nil {
+		return backup, err
+	}
+	for _, old := range backups[min(len(backups), keptBackups):] {
+		if err := os.Remove(old); err != nil {
+			return backup, err
+		}
+	}
+	return backup, nil
+}
+
+// Backups returns the backups of the config file, the latest first.
+func Backups(file string) ([]string, error) {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	backups, err := filepath.Glob(filepath.Join(BackupDir(file), name+"-*"+filepath.Ext(file)))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(backups)
+	slices.Reverse(backups)
+	return backups, nil
+}
+
+// Rollback puts the backup back as the config file, backing up the
+// current config first so the rollback can be undone. The backup must be a
+// valid config.
+func Rollback(file string, backup string) error {
+	restored, err := Load(backup)
+	if err != nil {
+		return fmt.Errorf("Unable to read the backup %s: %v", backup, err)
+	}
+	if _, err := Backup(file); err != nil {
+		return fmt.Errorf("Unable to back up the current config: %v", err)
+	}
+	return restored.Save(file)
+}
+
+// BackupTime returns when backup was taken, from its name.
+func BackupTime(backup string) (time.Time, error) {
+	name := strings.TrimSuffix(filepath.Base(backup), filepath.Ext(backup))
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		// the layout has a dash too
+		if j := strings.LastIndex(name[:i], "-"); j >= 0 {
+			return time.ParseInLocation(backupTimeLayout, name[j+1:], time.Local)
+		}
+	}
+	return time.Time{}, errors.New("Not a config backup: " + backup)
+}
@@ -0,0 +1,540 @@
+// Package config holds the app configuration saved in config.json.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FileName is the config file, in the working directory unless the
+// ENCRYPTBCKDOCS_CONFIG environment variable names another one.
+var FileName = "config.json"
+
+func init() {
+	if file := os.Getenv("ENCRYPTBCKDOCS_CONFIG"); file != "" {
+		FileName = file
+	}
+}
+
+// Config is the app configuration.
+type Config struct {
+	FolderName         string   `json:"folderName"`
+	LastUpdate         string   `json:"lastUpdate"`
+	FolderToWatch      []string `json:"folderToWatch"`
+	ServiceAccountFile string   `json:"serviceAccountFile,omitempty"`
+	ImpersonateUser    string   `json:"impersonateUser,omitempty"`
+	// FolderID is the Drive ID of the destination folder, taking priority
+	// over FolderName, a name or a path like "Backups/Laptop/Docs" that
+	// can match several folders.
+	FolderID string `json:"folderId,omitempty"`
+	// HostFolder nests the backups in a subfolder of the destination
+	// folder, the hostname in new configurations, so machines sharing an
+	// account and a destination folder keep their files apart.
+	HostFolder string `json:"hostFolder,omitempty"`
+	// FolderAccount maps a watched folder to the Drive account it is
+	// uploaded to, folders without an entry use the default account.
+	FolderAccount map[string]string `json:"folderAccount,omitempty"`
+	// FolderProcessors maps a watched folder to the processors its files go
+	// through before the upload, in order. Folders without an entry only
+	// use the "filter" processor, which checks the file is still included.
+	FolderProcessors map[string][]string `json:"folderProcessors,omitempty"`
+	// ArchiveFolders are watched folders backed up as a single encrypted
+	// tar.gz per scan instead of file by file.
+	ArchiveFolders []string `json:"archiveFolders,omitempty"`
+	// ArchiveFullEvery is how many archives of a folder make a cycle: a
+	// full one, level 0, then incremental ones of levels 1, 2... holding
+	// the files modified since the previous one, 7 by default.
+	ArchiveFullEvery int `json:"archiveFullEvery,omitempty"`
+	// RepositoryFolders are watched folders backed up as snapshots of
+	// content-defined chunks, each chunk stored once whatever the files
+	// and snapshots holding it.
+	RepositoryFolders []string `json:"repositoryFolders,omitempty"`
+	// DateFolders are watched folders whose photos and videos are uploaded
+	// to YYYY/MM subfolders of the destination folder, by the date they
+	// were taken.
+	DateFolders []string `json:"dateFolders,omitempty"`
+	// FileAge leaves out files by when they were last modified, no file
+	// when nil.
+	FileAge *FileAge `json:"fileAge,omitempty"`
+	// FolderFileAge maps a watched folder to the FileAge of its files,
+	// folders without an entry using FileAge.
+	FolderFileAge map[string]FileAge `json:"folderFileAge,omitempty"`
+	// DocumentFolders are watched folders whose programs and scripts, like
+	// .exe and .dll files or executable shell scripts, are not uploaded.
+	DocumentFolders []string `json:"documentFolders,omitempty"`
+	// RemoteTemplate lays out the uploaded files in the destination
+	// folder, like "{hostname}/{folder}/{filename}" or
+	// "{year}/{month}/{filename}", the last element naming the file and
+	// the others nested folders. Empty uploads every file with its local
+	// name to the destination folder.
+	RemoteTemplate string `json:"remoteTemplate,omitempty"`
+	// FolderTemplate maps a watched folder to the RemoteTemplate of its
+	// files, folders without an entry using RemoteTemplate.
+	FolderTemplate map[string]string `json:"folderTemplate,omitempty"`
+	// Retention decides which revisions and archives gc keeps, the
+	// defaults of Retention when nil.
+	Retention *Retention `json:"retention,omitempty"`
+	// DeleteProtection stops prune-remote trashing many files at once, the
+	// defaults of DeleteProtection when nil.
+	DeleteProtection *DeleteProtection `json:"deleteProtection,omitempty"`
+	// Exclude are patterns of files never uploaded, like "*.tmp" matching
+	// the file name or "/home/me/Docs/private/*" matching the whole path.
+	Exclude []string `json:"exclude,omitempty"`
+	// IncludeHidden uploads hidden files too: dot-files, files in dot
+	// folders and files with the hidden attribute on Windows.
+	IncludeHidden bool `json:"includeHidden,omitempty"`
+	// SkipEmptyFiles does not upload zero-byte files.
+	SkipEmptyFiles bool `json:"skipEmptyFiles,omitempty"`
+	// UploadPlaceholders uploads the online-only files of cloud sync
+	// clients like OneDrive or Dropbox, downloading them first, instead of
+	// skipping them.
+	UploadPlaceholders bool `json:"uploadPlaceholders,omitempty"`
+	// ScanWorkers is how many files of a watched folder are filtered and
+	// queued at the same time while scanning it, 8 by default.
+	ScanWorkers int `json:"scanWorkers,omitempty"`
+	// LowPriority runs the backups at a lower CPU and disk priority, so
+	// the scans and the hashing leave the machine responsive.
+	LowPriority bool `json:"lowPriority,omitempty"`
+	// DeviceAuth authorizes by entering a code on another device instead
+	// of opening a browser on this machine.
+	DeviceAuth bool `json:"deviceAuth,omitempty"`
+	// ClientSecretFile and TokenCacheFile override the default location
+	// of the OAuth client configuration and of the cached token.
+	ClientSecretFile string `json:"clientSecretFile,omitempty"`
+	TokenCacheFile   string `json:"tokenCacheFile,omitempty"`
+	// FullDriveAccess requests access to the whole Drive instead of only
+	// to the files created by the app.
+	FullDriveAccess bool `json:"fullDriveAccess,omitempty"`
+	// RemoteConfig saves a copy of the config, encrypted with the master
+	// key, in the hidden app data folder of the Drive, for init
+	// --from-remote to set up a replacement machine.
+	RemoteConfig bool `json:"remoteConfig,omitempty"`
+	// Proxy is the http, https or socks5 URL of the proxy used to reach
+	// Google, overriding HTTP_PROXY and HTTPS_PROXY.
+	Proxy string `json:"proxy,omitempty"`
+	// HTTP tunes the connections to Google and the notification endpoints.
+	HTTP *HTTP `json:"http,omitempty"`
+	// UploadChunkKB is how much of a large file is sent per request, a
+	// multiple of 256, 8192 by default. Small chunks lose less on an
+	// unstable link, large ones are faster on a fast one.
+	UploadChunkKB int `json:"uploadChunkKB,omitempty"`
+	// SplitSizeMB uploads the files larger than this in parts of this
+	// size, for accounts limiting the size of a file. Files are never
+	// split when 0.
+	SplitSizeMB int `json:"splitSizeMB,omitempty"`
+	// ParallelParts is how many parts of a split file are uploaded at the
+	// same time, each written aside first when above 1, 1 by default.
+	ParallelParts int `json:"parallelParts,omitempty"`
+	// CircuitFailures is how many uploads in a row failing in Drive, with
+	// server errors, rate limiting or timeouts, make the uploads wait until
+	// Drive answers again, 5 by default.
+	CircuitFailures int `json:"circuitFailures,omitempty"`
+	// UploadTimeoutMinutes cancels an upload taking longer, which is then
+	// retried. Uploads have no deadline when 0.
+	UploadTimeoutMinutes int `json:"uploadTimeoutMinutes,omitempty"`
+	// MaxBackupSizeMB stops uploading files growing the backup over this
+	// size, the backup being uncapped when 0.
+	MaxBackupSizeMB int `json:"maxBackupSizeMB,omitempty"`
+	// FolderMaxSizeMB maps a watched folder to the size its backup cannot
+	// grow over, folders without an entry being uncapped.
+	FolderMaxSizeMB map[string]int `json:"folderMaxSizeMB,omitempty"`
+	// Battery holds the uploads while a laptop runs on a low battery,
+	// never when nil.
+	Battery *Battery `json:"battery,omitempty"`
+	// Metered holds the large uploads on a metered connection, like a
+	// phone hotspot, never when nil.
+	Metered *Metered `json:"metered,omitempty"`
+	// MassChange pauses the uploads when many watched files change at
+	// once, like encrypted by a ransomware, never when nil.
+	MassChange *MassChange `json:"massChange,omitempty"`
+	// LogFile also writes the log to this file, rotated when it reaches
+	// LogMaxSizeMB or is older than LogMaxAgeDays, keeping LogMaxBackups
+	// old files.
+	LogFile       string `json:"logFile,omitempty"`
+	LogMaxSizeMB  int    `json:"logMaxSizeMB,omitempty"`
+	LogMaxAgeDays int    `json:"logMaxAgeDays,omitempty"`
+	LogMaxBackups int    `json:"logMaxBackups,omitempty"`
+	// MetricsAddress enables the Prometheus /metrics listener, e.g.
+	// "127.0.0.1:9184".
+	MetricsAddress string `json:"metricsAddress,omitempty"`
+	// HealthFailureMinutes is how long a failed Drive call keeps /healthz
+	// unhealthy if nothing succeeded after it.
+	HealthFailureMinutes int `json:"healthFailureMinutes,omitempty"`
+	// DashboardAddress serves the web dashboard, e.g. "127.0.0.1:8484".
+	// Only loopback addresses are accepted.
+	DashboardAddress string `json:"dashboardAddress,omitempty"`
+	// ControlSocket is the Unix socket of the control API, served while
+	// the app runs, "encryptbckdocs.sock" by default.
+	ControlSocket string `json:"controlSocket,omitempty"`
+	// TracingEndpoint exports OpenTelemetry traces of the upload pipeline
+	// to this OTLP/HTTP URL, e.g. "http://localhost:4318".
+	TracingEndpoint string `json:"tracingEndpoint,omitempty"`
+	// DesktopNotifications shows native notifications when the initial
+	// backup finishes and when uploads or the authorization fail.
+	DesktopNotifications bool `json:"desktopNotifications,omitempty"`
+	// Webhooks receive a POST for every notification event.
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+	// Email sends a daily or weekly summary report.
+	Email *Email `json:"email,omitempty"`
+	// Slack and Telegram post notifications to a chat.
+	Slack    *Slack    `json:"slack,omitempty"`
+	Telegram *Telegram `json:"telegram,omitempty"`
+}
+
+// Battery is when the uploads wait for the laptop to be plugged in.
+type Battery struct {
+	// MinPercent holds the uploads on battery below this charge, 30 by
+	// default, 100 whenever on battery.
+	MinPercent int `json:"minPercent,omitempty"`
+}
+
+// Metered is when the connection is metered and which uploads wait for
+// another one.
+type Metered struct {
+	// MaxSizeMB is the largest file uploaded on a metered connection, 10
+	// by default.
+	MaxSizeMB int `json:"maxSizeMB,omitempty"`
+	// SSIDs are Wi-Fi networks always metered, like phone hotspots, on top
+	// of the connections the system flags as metered.
+	SSIDs []string `json:"ssids,omitempty"`
+}
+
+// MassChange is how many changes in how long pause the uploads.
+type MassChange struct {
+	// Percent of the watched files changed or deleted, 30 by default, and
+	// at least MinFiles of them, 50 by default, within WindowMinutes, 10
+	// by default.
+	Percent       int `json:"percent,omitempty"`
+	MinFiles      int `json:"minFiles,omitempty"`
+	WindowMinutes int `json:"windowMinutes,omitempty"`
+}
+
+// Retention is a retention policy, the zero values keeping the defaults:
+// the 3 latest versions, one a day for 7 days, one a week for 4 weeks and
+// one a month for 12 months.
+type Retention struct {
+	KeepLast      int `json:"keepLast,omitempty"`
+	DailyDays     int `json:"dailyDays,omitempty"`
+	WeeklyWeeks   int `json:"weeklyWeeks,omitempty"`
+	MonthlyMonths int `json:"monthlyMonths,omitempty"`
+}
+
+// FileAge is how recently the files uploaded were modified.
+type FileAge struct {
+	// MinAgeMinutes waits for a file to be left unmodified this long
+	// before uploading it, like a file still being downloaded, 0 uploading
+	// it at once.
+	MinAgeMinutes int `json:"minAgeMinutes,omitempty"`
+	// MaxAgeDays skips the files not modified in this many days, 0
+	// uploading files of any age.
+	MaxAgeDays int `json:"maxAgeDays,omitempty"`
+}
+
+// DeleteProtection is how many files of a destination folder prune-remote
+// trashes without --force, like when a watched folder was emptied by
+// mistake and would empty its backup.
+type DeleteProtection struct {
+	// MaxFiles trashed in one run, 100 by default, and MaxPercent of the
+	// files of a destination folder, 50 by default.
+	MaxFiles   int `json:"maxFiles,omitempty"`
+	MaxPercent int `json:"maxPercent,omitempty"`
+}
+
+// HTTP sets up the HTTP connections, the zero values keeping the defaults.
+type HTTP struct {
+	// ConnectTimeoutSeconds limits opening a connection, 30 by default.
+	ConnectTimeoutSeconds int `json:"connectTimeoutSeconds,omitempty"`
+	// ResponseTimeoutSeconds limits waiting for the answer once a request
+	// is sent, 120 by default.
+	ResponseTimeoutSeconds int `json:"responseTimeoutSeconds,omitempty"`
+	// KeepAliveSeconds is the TCP keep-alive period, 30 by default.
+	KeepAliveSeconds int `json:"keepAliveSeconds,omitempty"`
+	// MaxIdleConns and MaxIdleConnsPerHost limit the connections kept
+	// open between requests, 100 and 2 by default.
+	MaxIdleConns        int `json:"maxIdleConns,omitempty"`
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost,omitempty"`
+}
+
+// Webhook is an endpoint receiving notification events.
+type Webhook struct {
+	URL string `json:"url"`
+	// Events limits the events sent, all of them when empty.
+	Events []string `json:"events,omitempty"`
+	// Template is a text/template rendered with the notification to build
+	// the body, the notification as JSON when empty.
+	Template    string            `json:"template,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// Email sets up the periodic summary report sent by email.
+type Email struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Every is "daily" or "weekly".
+	Every string `json:"every,omitempty"`
+}
+
+// Severities are the severities of the notifications, from the least to
+// the most important.
+var Severities = []string{"info", "warning", "error"}
+
+// Slack posts notifications to a Slack incoming webhook.
+type Slack struct {
+	WebhookURL string `json:"webhookUrl"`
+	// MinSeverity is "info" (default), "warning" or "error".
+	MinSeverity string `json:"minSeverity,omitempty"`
+}
+
+// Telegram posts notifications to a chat through a Telegram bot.
+type Telegram struct {
+	BotToken string `json:"botToken"`
+	ChatID   string `json:"chatId"`
+	// MinSeverity is "info" (default), "warning" or "error".
+	MinSeverity string `json:"minSeverity,omitempty"`
+}
+
+// Load reads the config in file.
+func Load(file string) (*Config, error) {
+	config := &Config{}
+	configFileContent, err := os.Open(file)
+	if err != nil {
+		return config, err
+	}
+	defer configFileContent.Close()
+
+	jsonParser := json.NewDecoder(configFileContent)
+	err = jsonParser.Decode(config)
+	config.cleanFolders()
+	if err == nil {
+		err = config.checkSeverities()
+	}
+	return config, err
+}
+
+// checkSeverities returns an error for a minSeverity that is not one of
+// Severities, a typo otherwise sending every notification.
+func (config *Config) checkSeverities() error {
+	minSeverities := map[string]string{}
+	if config.Slack != nil {
+		minSeverities["slack"] = config.Slack.MinSeverity
+	}
+	if config.Telegram != nil {
+		minSeverities["telegram"] = config.Telegram.MinSeverity
+	}
+	for notifier, minSeverity := range minSeverities {
+		if minSeverity != "" && !slices.Contains(Severities, minSeverity) {
+			return fmt.Errorf("Unknown minSeverity %q of %s, use one of %s", minSeverity, notifier, strings.Join(Severities, ", "))
+		}
+	}
+	return nil
+}
+
+// cleanFolders writes the watched folders with the separators of the OS
+// and without trailing ones, so they match the folders of the uploaded
+// files, e.g. "C:/Docs/" is "C:\Docs" on Windows.
+func (config *Config) cleanFolders() {
+	for i, folder := range config.FolderToWatch {
+		if folder != "" {
+			config.FolderToWatch[i] = filepath.Clean(folder)
+		}
+	}
+	if config.FolderAccount != nil {
+		folderAccount := make(map[string]string, len(config.FolderAccount))
+		for folder, account := range config.FolderAccount {
+			folderAccount[filepath.Clean(folder)] = account
+		}
+		config.FolderAccount = folderAccount
+	}
+	if config.FolderProcessors != nil {
+		folderProcessors := make(map[string][]string, len(config.FolderProcessors))
+		for folder, processors := range config.FolderProcessors {
+			folderProcessors[filepath.Clean(folder)] = processors
+		}
+		config.FolderProcessors = folderProcessors
+	}
+	if config.FolderMaxSizeMB != nil {
+		folderMaxSize := make(map[string]int, len(config.FolderMaxSizeMB))
+		for folder, size := range config.FolderMaxSizeMB {
+			folderMaxSize[filepath.Clean(folder)] = size
+		}
+		config.FolderMaxSizeMB = folderMaxSize
+	}
+	if config.FolderFileAge != nil {
+		folderFileAge := make(map[string]FileAge, len(config.FolderFileAge))
+		for folder, age := range config.FolderFileAge {
+			folderFileAge[filepath.Clean(folder)] = age
+		}
+		config.FolderFileAge = folderFileAge
+	}
+	for i, folder := range config.ArchiveFolders {
+		config.ArchiveFolders[i] = filepath.Clean(folder)
+	}
+	for i, folder := range config.RepositoryFolders {
+		config.RepositoryFolders[i] = filepath.Clean(folder)
+	}
+	for i, folder := range config.DateFolders {
+		config.DateFolders[i] = filepath.Clean(folder)
+	}
+	for i, folder := range config.DocumentFolders {
+		config.DocumentFolders[i] = filepath.Clean(folder)
+	}
+	if config.FolderTemplate != nil {
+		folderTemplate := make(map[string]string, len(config.FolderTemplate))
+		for folder, template := range config.FolderTemplate {
+			folderTemplate[filepath.Clean(folder)] = template
+		}
+		config.FolderTemplate = folderTemplate
+	}
+}
+
+// Clone returns a copy of config sharing none of its lists, maps and
+// settings, so changing one does not change the other.
+func (config *Config) Clone() *Config {
+	clone := &Config{}
+	// every setting is JSON, it cannot fail
+	content, _ := json.Marshal(config)
+	json.Unmarshal(content, clone)
+	return clone
+}
+
+// Save writes the config to file, only readable by the user as it can
+// hold secrets. The config is written to a temporary file renamed over
+// file, a crash leaving the old config or the new one, never a truncated
+// one.
+func (config *Config) Save(file string) error {
+	jsonContent, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	// a symbolic link is kept, the file it points to is replaced
+	if target, err := filepath.EvalSymlinks(file); err == nil {
+		file = target
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err = temp.Write(jsonContent); err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+// AccountForFolder returns the name of the account a watched folder is
+// uploaded to, "" being the default account.
+func (config *Config) AccountForFolder(folder string) string {
+	return config.FolderAccount[folder]
+}
+
+// ProcessorsForFolder returns the names of the processors the files of a
+// watched folder go through before the upload.
+func (config *Config) ProcessorsForFolder(folder string) []string {
+	if processors, ok := config.FolderProcessors[folder]; ok {
+		return processors
+	}
+	return []string{"filter"}
+}
+
+// ArchiveFolder reports whether a watched folder is backed up as archives.
+func (config *Config) ArchiveFolder(folder string) bool {
+	return slices.Contains(config.ArchiveFolders, folder)
+}
+
+// RepositoryFolder reports whether a watched folder is backed up as
+// snapshots of chunks.
+func (config *Config) RepositoryFolder(folder string) bool {
+	return slices.Contains(config.RepositoryFolders, folder)
+}
+
+// TemplateForFolder returns the template naming the remote files of a
+// watched folder, "" when they keep their local name.
+func (config *Config) TemplateForFolder(folder string) string {
+	if template, ok := config.FolderTemplate[folder]; ok {
+		return template
+	}
+	return config.RemoteTemplate
+}
+
+// DateFolder reports whether the photos and videos of a watched folder are
+// organized by date.
+func (config *Config) DateFolder(folder string) bool {
+	return slices.Contains(config.DateFolders, folder)
+}
+
+// FileAgeForFolder returns the FileAge of the files of a watched folder,
+// nil when their age does not matter.
+func (config *Config) FileAgeForFolder(folder string) *FileAge {
+	if age, ok := config.FolderFileAge[folder]; ok {
+		return &age
+	}
+	return config.FileAge
+}
+
+// DocumentFolder reports whether the programs and scripts of a watched
+// folder are left out.
+func (config *Config) DocumentFolder(folder string) bool {
+	return slices.Contains(config.DocumentFolders, folder)
+}
+
+// Resolve picks a setting from, by priority, the command line flag, the
+// environment variable envName, the config file or defaultValue.
+func Resolve(flagValue string, envName string, configValue string, defaultValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if envValue := os.Getenv(envName); envValue != "" {
+		return envValue
+	}
+	if configValue != "" {
+		return configValue
+	}
+	return defaultValue
+}
+
+// ApplyEnvironment overrides the destination folder with the
+// ENCRYPTBCKDOCS_FOLDER_NAME and ENCRYPTBCKDOCS_FOLDER_ID environment
+// variables and the watched folders with ENCRYPTBCKDOCS_WATCH, a list
+// separated like PATH, so the app runs without config.json or questions,
+// like in a container.
+func (config *Config) ApplyEnvironment() {
+	config.FolderName = Resolve("", "ENCRYPTBCKDOCS_FOLDER_NAME", config.FolderName, "")
+	config.FolderID = Resolve("", "ENCRYPTBCKDOCS_FOLDER_ID", config.FolderID, "")
+	if watch := os.Getenv("ENCRYPTBCKDOCS_WATCH"); watch != "" {
+		config.FolderToWatch = filepath.SplitList(watch)
+		config.cleanFolders()
+	}
+}
+
+// GetEnvAny returns the value of the first set environment variable in names.
+func GetEnvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// IntOrDefault returns value, or defaultValue when value is not set.
+func IntOrDefault(value int, defaultValue int) int {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// baselineConfig is a config.json written by the first versions of the
+// app, before the other settings existed.
+const baselineConfig = `{"folderName":"MyBackup","lastUpdate":"2019-03-02 10:00:00 +0100 CET","folderToWatch":["/home/me/Documents/","/home/me/Photos"]}`
+
+func TestLoadBaselineConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(baselineConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.FolderName != "MyBackup" {
+		t.Errorf("FolderName = %q, want %q", config.FolderName, "MyBackup")
+	}
+	want := []string{filepath.Clean("/home/me/Documents/"), filepath.Clean("/home/me/Photos")}
+	if !reflect.DeepEqual(config.FolderToWatch, want) {
+		t.Errorf("FolderToWatch = %q, want %q", config.FolderToWatch, want)
+	}
+	// the settings added later keep their defaults
+	if processors := config.ProcessorsForFolder(want[0]); !reflect.DeepEqual(processors, []string{"filter"}) {
+		t.Errorf("ProcessorsForFolder = %q, want only the filter", processors)
+	}
+	if account := config.AccountForFolder(want[0]); account != "" {
+		t.Errorf("AccountForFolder = %q, want the default account", account)
+	}
+	if config.ArchiveFolder(want[0]) || config.RepositoryFolder(want[0]) {
+		t.Error("a baseline folder is backed up as archives or chunks, want file by file")
+	}
+}
+
+func TestLoadCleansFolders(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	content := `{"folderToWatch":["/docs/"],"folderAccount":{"/docs/":"work"},"folderProcessors":{"/docs//":["filter","gzip"]},"folderMaxSizeMB":{"/docs/":100}}`
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder := filepath.Clean("/docs")
+	if !reflect.DeepEqual(config.FolderToWatch, []string{folder}) {
+		t.Errorf("FolderToWatch = %q, want %q", config.FolderToWatch, []string{folder})
+	}
+	if account := config.AccountForFolder(folder); account != "work" {
+		t.Errorf("AccountForFolder(%q) = %q, want %q", folder, account, "work")
+	}
+	if processors := config.ProcessorsForFolder(folder); !reflect.DeepEqual(processors, []string{"filter", "gzip"}) {
+		t.Errorf("ProcessorsForFolder(%q) = %q, want filter and gzip", folder, processors)
+	}
+	if size := config.FolderMaxSizeMB[folder]; size != 100 {
+		t.Errorf("FolderMaxSizeMB[%q] = %d, want 100", folder, size)
+	}
+}
+
+func TestLoadChecksSeverities(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	for content, valid := range map[string]bool{
+		`{"slack":{"webhookUrl":"https://hooks.slack.com/x","minSeverity":"warning"}}`: true,
+		`{"telegram":{"botToken":"token","chatId":"42"}}`:                              true,
+		`{"slack":{"webhookUrl":"https://hooks.slack.com/x","minSeverity":"warn"}}`:    false,
+		`{"telegram":{"botToken":"token","chatId":"42","minSeverity":"Error"}}`:        false,
+	} {
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(file); (err == nil) != valid {
+			t.Errorf("Load(%s) = %v, want valid %v", content, err, valid)
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	config, err := Load(filepath.Join(t.TempDir(), "config.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("error = %v, want the file not found", err)
+	}
+	if config == nil {
+		t.Fatal("config = nil, want an empty config to fill")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	saved := &Config{FolderName: "Backup", FolderToWatch: []string{filepath.Clean("/docs")}, Exclude: []string{"*.tmp"}}
+	if err := saved.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 && os.PathSeparator == '/' {
+		t.Errorf("config.json mode = %v, want only readable by the user", perm)
+	}
+
+	loaded, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, saved) {
+		t.Errorf("loaded %+v, want %+v", loaded, saved)
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the config shared by the components of the app, changed by
+// the menu and the config reload. It serializes the changes and the saves,
+// so one does not save the config halfway through another. A config it
+// returns is never changed: a change replaces it with a new one, so a
+// component reads a whole config while another one is being applied.
+//
+// The config the components read is the one of the file with a runtime
+// layer over it, the environment variables by default, which is never
+// saved: the changes apply to the config of the file, and the file keeps
+// its own settings whatever the environment of the run.
+type Store struct {
+	mutex  sync.Mutex
+	config atomic.Pointer[Config]
+	// saved is the config as in the file, without the runtime layer
+	saved *Config
+	// runtime applies the runtime layer to a copy of saved
+	runtime func(config *Config)
+	// file is where the changes are saved, not saved when empty
+	file string
+}
+
+// NewStore returns a Store of saved, the config read from file, saving its
+// changes to file. The environment variables of ApplyEnvironment are its
+// runtime layer.
+func NewStore(saved *Config, file string) *Store {
+	store := &Store{saved: saved, runtime: (*Config).ApplyEnvironment, file: file}
+	store.apply()
+	return store
+}
+
+// Config returns the current config of the store, with the runtime layer
+// applied. It must not be changed, Update changes the config.
+func (store *Store) Config() *Config {
+	return store.config.Load()
+}
+
+// Update applies change to a copy of the config of the file, which
+// becomes the config, and saves it.
+func (store *Store) Update(change func(config *Config)) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	saved := store.saved.Clone()
+	change(saved)
+	store.saved = saved
+	store.apply()
+	if store.file == "" {
+		return nil
+	}
+	return store.saved.Save(store.file)
+}
+
+// Copy returns a copy of the config as saved in the file, without the
+// runtime layer.
+func (store *Store) Copy() Config {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return *store.saved.Clone()
+}
+
+// Replace puts newConfig, read again from the file, in place of the
+// config without saving it, with runtime as the runtime layer from then
+// on, nil keeping the current one. The components reading the config from
+// the store see the new one.
+func (store *Store) Replace(newConfig *Config, runtime func(config *Config)) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.saved = newConfig
+	if runtime != nil {
+		store.runtime = runtime
+	}
+	store.apply()
+}
+
+// apply sets the config to a copy of saved with the runtime layer.
+func (store *Store) apply() {
+	config := store.saved.Clone()
+	store.runtime(config)
+	store.config.Store(config)
+}
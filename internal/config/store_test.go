@@ -0,0 +1,64 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoreNeverSavesEnvironment(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	docs := filepath.Clean("/docs")
+	t.Setenv("ENCRYPTBCKDOCS_FOLDER_NAME", "Container")
+	t.Setenv("ENCRYPTBCKDOCS_WATCH", filepath.Clean("/data"))
+
+	store := NewStore(&Config{FolderName: "Backup", FolderToWatch: []string{docs}}, file)
+	if cfg := store.Config(); cfg.FolderName != "Container" || !reflect.DeepEqual(cfg.FolderToWatch, []string{filepath.Clean("/data")}) {
+		t.Errorf("config = %+v, want the environment over the file", cfg)
+	}
+	err := store.Update(func(cfg *Config) {
+		cfg.FolderToWatch = append(cfg.FolderToWatch, filepath.Clean("/photos"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.FolderName != "Backup" || !reflect.DeepEqual(saved.FolderToWatch, []string{docs, filepath.Clean("/photos")}) {
+		t.Errorf("saved %+v, want the settings of the file with the change", saved)
+	}
+	if cfg := store.Config(); cfg.FolderName != "Container" {
+		t.Errorf("config after the change = %+v, want the environment still over the file", cfg)
+	}
+	if copied := store.Copy(); copied.FolderName != "Backup" {
+		t.Errorf("copy = %+v, want the config of the file", copied)
+	}
+
+	// a reload keeps the environment too
+	store.Replace(&Config{FolderName: "Edited"}, nil)
+	if cfg := store.Config(); cfg.FolderName != "Container" {
+		t.Errorf("config after a reload = %+v, want the environment over the file", cfg)
+	}
+}
+
+func TestStoreNeverChangesReturnedConfig(t *testing.T) {
+	store := NewStore(&Config{FolderName: "Backup", FolderAccount: map[string]string{"/docs": "work"}}, "")
+	cfg := store.Config()
+	if err := store.Update(func(cfg *Config) {
+		cfg.FolderName = "Changed"
+		cfg.FolderAccount["/docs"] = "personal"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store.Replace(&Config{FolderName: "Reloaded"}, nil)
+
+	if cfg.FolderName != "Backup" || cfg.FolderAccount["/docs"] != "work" {
+		t.Errorf("config read before the changes = %+v, want it unchanged", cfg)
+	}
+	if current := store.Config(); current.FolderName != "Reloaded" {
+		t.Errorf("config = %+v, want the reloaded one", current)
+	}
+}
@@ -0,0 +1,58 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// commands of the control client and the request each one sends
+var commands = map[string]string{
+	"pause":  http.MethodPost + " /pause",
+	"resume": http.MethodPost + " /resume",
+	"toggle": http.MethodPost + " /toggle",
+	"backup": http.MethodPost + " /backup",
+	"sync":   http.MethodPost + " /sync",
+	"status": http.MethodGet + " /status",
+	"events": http.MethodGet + " /events",
+}
+
+// Run sends command to the daemon listening on the Unix socket at path
+// and copies its answer to out, until ctx is cancelled for "events".
+func Run(ctx context.Context, path string, command string, out io.Writer) error {
+	request, ok := commands[command]
+	if !ok {
+		return errors.New("Unknown command " + command + ", use pause, resume, toggle, backup, sync, status or events")
+	}
+	method, urlPath, _ := strings.Cut(request, " ")
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, method, "http://daemon"+urlPath, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to reach the daemon, is it running? %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+	_, err = io.Copy(out, response.Body)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+// Package control serves the local API a running daemon is driven through,
+// on a Unix socket: pause, resume, toggle, backup now, sync in the
+// background, status and live events.
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+)
+
+// SocketName is the default control socket, in the working directory.
+const SocketName = "encryptbckdocs.sock"
+
+const eventBuffer = 100 // events kept for a slow reader before dropping them
+
+// Status is the state of the daemon returned by /status.
+type Status struct {
+	Paused bool `json:"paused"`
+	// Offline is set while Drive is unreachable, the uploads waiting until
+	// it is back.
+	Offline bool `json:"offline"`
+	// QuotaExceeded is set while the Drive storage is full, the uploads
+	// waiting until there is space.
+	QuotaExceeded bool `json:"quotaExceeded"`
+	// BatteryLow is set while the laptop runs on a low battery, the
+	// uploads waiting until it is plugged in.
+	BatteryLow bool `json:"batteryLow"`
+	// Metered is set while the connection is metered, the large uploads
+	// waiting for another one.
+	Metered bool `json:"metered"`
+	// CircuitOpen is set while the uploads wait because Drive kept
+	// failing, until it answers again.
+	CircuitOpen bool     `json:"circuitOpen"`
+	Queued      int      `json:"queued"`
+	Folders     []string `json:"folders"`
+	LastUpdate  string   `json:"lastUpdate"`
+	// Version is the build of the running app.
+	Version string `json:"version,omitempty"`
+}
+
+// Server answers the control requests with the functions of the daemon.
+type Server struct {
+	Pause  func()
+	Resume func()
+	// Backup uploads every watched folder and returns its summary.
+	Backup func() any
+	// Sync starts backing up every watched folder in the background and
+	// reports whether it did, false while a pass it started is running.
+	Sync   func() bool
+	Status func() Status
+	Events *events.Bus
+}
+
+// Serve listens on the Unix socket at path in the background. A socket
+// left by a previous run, refusing connections, is replaced. It fails when
+// another instance of the app answers on path, or when path is not a
+// socket, never removing it.
+func (server *Server) Serve(path string) error {
+	if err := removeStaleSocket(path); err != nil {
+		return err
+	}
+	// only the user running the daemon may drive it
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", server.post(server.Pause))
+	mux.HandleFunc("/resume", server.post(server.Resume))
+	mux.HandleFunc("/toggle", server.post(server.toggle))
+	mux.HandleFunc("/backup", server.backupHandler)
+	mux.HandleFunc("/sync", server.syncHandler)
+	mux.HandleFunc("/status", server.statusHandler)
+	mux.HandleFunc("/events", server.eventsHandler)
+	go func() {
+		slog.Info("Serving control API", "socket", path)
+		if err := http.Serve(listener, mux); err != nil {
+			slog.Error("Control API stopped", "socket", path, "error", err)
+		}
+	}()
+	return nil
+}
+
+// removeStaleSocket removes the socket at path left by a run that did not
+// remove it, nothing being at path otherwise.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !isSocket(info) {
+		return fmt.Errorf("%s is not a socket, remove it or set another controlSocket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("Another instance of the app serves the control API on %s", path)
+	}
+	return os.Remove(path)
+}
+
+// post returns a handler calling action for POST requests.
+func (server *Server) post(action func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		action()
+		writeJSON(w, server.Status())
+	}
+}
+
+// toggle resumes the daemon when paused and pauses it otherwise.
+func (server *Server) toggle() {
+	if server.Status().Paused {
+		server.Resume()
+	} else {
+		server.Pause()
+	}
+}
+
+func (server *Server) backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, server.Backup())
+}
+
+// syncHandler starts a backup without waiting for it, answering whether it
+// started or one was already running.
+func (server *Server) syncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, struct {
+		Started bool `json:"started"`
+	}{server.Sync()})
+}
+
+func (server *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, server.Status())
+}
+
+// eventsHandler streams every event as a line of JSON until the client
+// goes away.
+func (server *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	received := make(chan events.Event, eventBuffer)
+	unsubscribe := server.Events.Subscribe(func(event events.Event) {
+		select {
+		case received <- event:
+		default:
+			// the bus must not wait for a slow reader
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-received:
+			if err := encoder.Encode(events.NewLine(event)); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
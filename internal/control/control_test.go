@@ -0,0 +1,70 @@
+package control
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// socketPath returns a path for a socket, short enough for the limit of
+// the socket addresses.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, SocketName)
+}
+
+func TestServeKeepsRunningInstance(t *testing.T) {
+	path := socketPath(t)
+	server := &Server{Status: func() Status { return Status{} }}
+	if err := server.Serve(path); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("socket mode = %v, %v, want only the user allowed", info.Mode().Perm(), err)
+		}
+	}
+	if err := server.Serve(path); err == nil {
+		t.Error("served over the socket of a running instance, want an error")
+	}
+	if conn, err := net.Dial("unix", path); err != nil {
+		t.Errorf("socket of the running instance removed: %v", err)
+	} else {
+		conn.Close()
+	}
+}
+
+func TestServeReplacesStaleSocket(t *testing.T) {
+	path := socketPath(t)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// left behind like by a crash
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	if err = (&Server{}).Serve(path); err != nil {
+		t.Errorf("stale socket not replaced: %v", err)
+	}
+}
+
+func TestServeKeepsOtherFile(t *testing.T) {
+	path := socketPath(t)
+	if err := os.WriteFile(path, []byte("notes"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Server{}).Serve(path); err == nil {
+		t.Error("served over a regular file, want an error")
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "notes" {
+		t.Errorf("file at the socket path = %q, %v, want it untouched", content, err)
+	}
+}
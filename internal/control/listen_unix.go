@@ -0,0 +1,23 @@
+//go:build !windows
+
+package control
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenPrivate listens on the Unix socket at path, created only readable
+// and writable by the user: no one else can connect between its creation
+// and a chmod.
+func listenPrivate(path string) (net.Listener, error) {
+	mask := syscall.Umask(0177)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
+
+// isSocket reports whether info is of a Unix socket.
+func isSocket(info os.FileInfo) bool {
+	return info.Mode().Type() == os.ModeSocket
+}
@@ -0,0 +1,18 @@
+package control
+
+import (
+	"net"
+	"os"
+)
+
+// listenPrivate listens on the Unix socket at path, which has the
+// permissions of its folder on Windows.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
+
+// isSocket reports whether info is of a Unix socket, which Windows may
+// report as an irregular file.
+func isSocket(info os.FileInfo) bool {
+	return info.Mode().Type()&(os.ModeSocket|os.ModeIrregular) != 0
+}
@@ -0,0 +1,338 @@
+// Package dashboard serves a small local web page showing what the app is
+// doing, with buttons to download or restore backed up files.
+package dashboard
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/control"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+	"golang.org/x/net/context"
+)
+
+const recentActivity = 50 // uploads and failures shown
+
+//go:embed dashboard.html
+var pageSource string
+
+var page = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"bytes": humanize.Bytes,
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(pageSource))
+
+// Activity is an upload or a failure shown on the page.
+type Activity struct {
+	Time   time.Time
+	Path   string
+	Action string
+	Size   int64
+	Error  string
+}
+
+// Server is the dashboard of the running app.
+type Server struct {
+	Status   func() control.Status
+	Accounts *drive.Accounts
+	// State finds the Drive file of a local path to restore it, restoring
+	// is disabled when nil.
+	State *state.Store
+
+	// addr is the address served, the only Host accepted.
+	addr string
+	// token is random for every run of the app, the downloads and restores
+	// must carry it so only the page can start them.
+	token string
+
+	mutex    sync.Mutex
+	activity []Activity // latest last
+}
+
+// HandleEvent keeps the latest uploads and failures published on the
+// event bus.
+func (server *Server) HandleEvent(event events.Event) {
+	var activity Activity
+	switch e := event.(type) {
+	case events.UploadSucceeded:
+		activity = Activity{Path: e.Path, Action: e.Action, Size: e.Size}
+	case events.FileRenamed:
+		activity = Activity{Path: e.Path, Action: "rename"}
+	case events.UploadFailed:
+		activity = Activity{Path: e.Path, Action: "fail", Error: e.Err.Error()}
+	default:
+		return
+	}
+	activity.Time = time.Now()
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.activity = append(server.activity, activity)
+	if len(server.activity) > recentActivity {
+		server.activity = server.activity[1:]
+	}
+}
+
+// Serve serves the dashboard on addr in the background. Only loopback
+// addresses are accepted, the page has no authentication.
+func (server *Server) Serve(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errors.New("dashboard address " + addr + " is not a loopback address")
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	server.addr, server.token = addr, hex.EncodeToString(token)
+
+	// an address in use fails here, not in the background
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Serving dashboard", "address", "http://"+addr)
+	go func() {
+		if err := http.Serve(listener, server.Handler()); err != nil {
+			slog.Error("Dashboard stopped", "address", addr, "error", err)
+		}
+	}()
+	return nil
+}
+
+// Handler returns the handler of the dashboard. Requests for another host
+// than the address served are refused: a site whose name resolves to the
+// loopback address, rebinding its DNS, would otherwise read the page.
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", server.pageHandler)
+	mux.HandleFunc("/download", server.downloadHandler)
+	mux.HandleFunc("/restore", server.restoreHandler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Host, server.addr) {
+			http.Error(w, "Unknown host "+r.Host, http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// validToken reports whether r carries the token of this run, given to the
+// page only.
+func (server *Server) validToken(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.FormValue("token")), []byte(server.token)) == 1
+}
+
+// RemoteFile is a backed up file listed on the page.
+type RemoteFile struct {
+	// Account is the name of the account holding the file, "" being the
+	// default account.
+	Account  string
+	ID       string
+	Name     string
+	Size     int64
+	Modified string
+}
+
+func (server *Server) pageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	data := struct {
+		Status     control.Status
+		Activity   []Activity
+		Errors     []Activity
+		Files      []RemoteFile
+		FilesError string
+		Missing    []string
+		Message    string
+		Token      string
+	}{Status: server.Status(), Message: r.URL.Query().Get("message"), Token: server.token}
+
+	server.mutex.Lock()
+	for i := len(server.activity) - 1; i >= 0; i-- {
+		activity := server.activity[i]
+		if activity.Error != "" {
+			data.Errors = append(data.Errors, activity)
+		} else {
+			data.Activity = append(data.Activity, activity)
+		}
+	}
+	server.mutex.Unlock()
+
+	files, err := server.remoteFiles(r.Context())
+	if err != nil {
+		data.FilesError = err.Error()
+	}
+	data.Files = files
+	data.Missing = server.missingFiles()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, data); err != nil {
+		slog.Error("Unable to render dashboard", "error", err)
+	}
+}
+
+// remoteFiles lists the files in the destination folder of every account.
+func (server *Server) remoteFiles(ctx context.Context) ([]RemoteFile, error) {
+	var remoteFiles []RemoteFile
+	for _, name := range server.Accounts.Names() {
+		account, err := server.Accounts.Get(ctx, name)
+		if err != nil {
+			return remoteFiles, err
+		}
+		files, err := account.Client().ListFiles(ctx, account.Folder().Id)
+		if err != nil {
+			return remoteFiles, err
+		}
+		for _, file := range files {
+			remoteFiles = append(remoteFiles, RemoteFile{Account: name, ID: file.Id, Name: file.Name, Size: file.Size, Modified: file.ModifiedTime})
+		}
+	}
+	return remoteFiles, nil
+}
+
+// missingFiles returns the backed up local files that no longer exist, the
+// ones that can be restored.
+func (server *Server) missingFiles() []string {
+	if server.State == nil {
+		return nil
+	}
+	var missing []string
+	err := server.State.ForEach(func(path string, file state.File) error {
+		if _, err := os.Stat(path); os.IsNotExist(err) && file.RemoteID != "" {
+			missing = append(missing, path)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Unable to read file state", "error", err)
+	}
+	return missing
+}
+
+// downloadHandler sends a backed up file to the browser, decrypted,
+// decompressed and put back together from its parts. The download is cut
+// short when its content does not match the uploaded file.
+func (server *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !server.validToken(r) {
+		http.Error(w, "Invalid token, reload the dashboard", http.StatusForbidden)
+		return
+	}
+	// never authorize an account the page did not list
+	name := r.FormValue("account")
+	if !slices.Contains(server.Accounts.Names(), name) {
+		http.Error(w, "Unknown account "+name, http.StatusNotFound)
+		return
+	}
+	ctx := r.Context()
+	account, err := server.Accounts.Get(ctx, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	remoteFile, err := account.Client().GetFile(ctx, r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	backup, err := pipeline.OpenBackup(ctx, account.Client(), remoteFile, nil, drive.Property(remoteFile.AppProperties, drive.PropertyPath))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer backup.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(filepath.Base(backup.Name)))
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(w, hasher), backup.Body)
+	knownHash := drive.Property(remoteFile.AppProperties, drive.PropertyHash)
+	if err == nil && knownHash != "" && hex.EncodeToString(hasher.Sum(nil)) != knownHash {
+		err = errors.New("the content does not match the uploaded file")
+	}
+	if err != nil {
+		slog.Error("Unable to download file", "file", remoteFile.Name, "error", err)
+		// the browser sees the download fail instead of a complete file
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// restoreHandler downloads the backed up version of a local file back to
+// its path, which must not exist any more.
+func (server *Server) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	// the page has no login, refuse forms posted by other sites
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+		http.Error(w, "Cross-site request refused", http.StatusForbidden)
+		return
+	}
+	if !server.validToken(r) {
+		http.Error(w, "Invalid token, reload the dashboard", http.StatusForbidden)
+		return
+	}
+	path := r.FormValue("path")
+	message := "Restored " + path
+	if err := server.restore(r.Context(), path); err != nil {
+		message = "Unable to restore " + path + ": " + err.Error()
+	}
+	http.Redirect(w, r, "/?message="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// restore writes the backed up version of the local file at path back to
+// it, from the destination folder of its watched folder, checked against
+// the hash of the uploaded file.
+func (server *Server) restore(ctx context.Context, path string) error {
+	if server.State == nil {
+		return errors.New("no state database")
+	}
+	known, err := server.State.Get(path)
+	if err != nil {
+		return err
+	}
+	if known == nil || known.RemoteID == "" {
+		return errors.New("not backed up")
+	}
+	// never overwrite a local file
+	if _, err = os.Lstat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	account, err := server.Accounts.ForFolder(ctx, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	remoteFile, err := account.Client().GetFile(ctx, known.RemoteID)
+	if err != nil {
+		return err
+	}
+	_, err = pipeline.RestoreFile(ctx, account.Client(), remoteFile, nil, path, known.Hash, path)
+	return err
+}
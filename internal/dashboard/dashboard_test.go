@@ -0,0 +1,77 @@
+package dashboard
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/control"
+)
+
+func newTestServer() *Server {
+	return &Server{
+		Status: func() control.Status { return control.Status{} },
+		addr:   "127.0.0.1:8484",
+		token:  "secret-token",
+	}
+}
+
+func TestHandlerRefusesOtherHosts(t *testing.T) {
+	server := newTestServer()
+	for _, host := range []string{"evil.example:8484", "127.0.0.1:9999", ""} {
+		r := httptest.NewRequest(http.MethodGet, "/download?id=1&token=secret-token", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("host %q: status %d, want %d", host, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestHandlerRequiresToken(t *testing.T) {
+	server := newTestServer()
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/download?id=1", nil),
+		httptest.NewRequest(http.MethodGet, "/download?id=1&token=wrong", nil),
+		httptest.NewRequest(http.MethodPost, "/restore", strings.NewReader(url.Values{"path": {"/home/me/report.txt"}}.Encode())),
+		httptest.NewRequest(http.MethodPost, "/restore", strings.NewReader(url.Values{"path": {"/home/me/report.txt"}, "token": {"wrong"}}.Encode())),
+	}
+	for _, r := range requests {
+		r.Host = server.addr
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status %d, want %d", r.Method, r.URL, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestRestoreRefusesOtherOrigins(t *testing.T) {
+	server := newTestServer()
+	r := httptest.NewRequest(http.MethodPost, "/restore", strings.NewReader(url.Values{"path": {"/home/me/report.txt"}, "token": {"secret-token"}}.Encode()))
+	r.Host = server.addr
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", "http://evil.example")
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestServeAddressInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if err = (&Server{}).Serve(listener.Addr().String()); err == nil {
+		t.Error("served on an address in use, want an error")
+	}
+}
@@ -0,0 +1,285 @@
+// Package events carries what the sync engine does to whoever observes it:
+// logs, metrics, history, notifications and user interfaces.
+package events
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Event is one of the event types of this package.
+type Event interface {
+	event()
+}
+
+// FileQueued is published when a file starts waiting to be uploaded,
+// again when it is retried.
+type FileQueued struct {
+	Path     string `json:"path"`
+	Priority int    `json:"priority"`
+}
+
+// UploadStarted is published when a worker takes a queued file. One of
+// UploadSucceeded, FileRenamed, UploadSkipped or UploadFailed follows, or
+// FileQueued when the upload is retried.
+type UploadStarted struct {
+	Path string `json:"path"`
+}
+
+// UploadProgress is published now and then while a file is read for its
+// upload.
+type UploadProgress struct {
+	Path string `json:"path"`
+	Sent int64  `json:"sent"`
+	Size int64  `json:"size"`
+}
+
+// UploadSucceeded is published when a file is uploaded.
+type UploadSucceeded struct {
+	Path string `json:"path"`
+	// Folder is the watched folder of the file.
+	Folder string `json:"folder"`
+	// Action is history.ActionUpload or history.ActionUpdate.
+	Action   string `json:"action"`
+	RemoteID string `json:"remoteId"`
+	// RemoteFolder is the name of the Drive folder it was uploaded to.
+	RemoteFolder string        `json:"remoteFolder"`
+	Hash         string        `json:"sha256"`
+	Size         int64         `json:"size"`
+	Duration     time.Duration `json:"duration"`
+}
+
+// UploadSkipped is published when a file is not uploaded, because it did
+// not change or was filtered out.
+type UploadSkipped struct {
+	Path string `json:"path"`
+	// Reason is why the file was skipped, one of the Skip constants.
+	Reason string `json:"reason,omitempty"`
+}
+
+// reasons of UploadSkipped
+const (
+	SkipUnchanged    = "unchanged"
+	SkipDeleted      = "deleted"
+	SkipEmpty        = "empty"
+	SkipTooOld       = "too old"
+	SkipExecutable   = "executable"
+	SkipPlaceholder  = "online-only"
+	SkipProcessor    = "filtered"
+	SkipOtherMachine = "backed up by another machine"
+)
+
+// FileRenamed is published when a file, renamed or moved locally, is
+// renamed or moved in Drive instead of being uploaded again.
+type FileRenamed struct {
+	Path string `json:"path"`
+	// From is the path the file was uploaded from.
+	From     string `json:"from"`
+	RemoteID string `json:"remoteId"`
+}
+
+// UploadFailed is published when the upload of a file fails.
+type UploadFailed struct {
+	Path string `json:"path"`
+	Err  error  `json:"-"`
+	// Backend is set when Drive returned the error.
+	Backend bool `json:"backend"`
+}
+
+// MarshalJSON writes Err as its message.
+func (e UploadFailed) MarshalJSON() ([]byte, error) {
+	type plain UploadFailed
+	return json.Marshal(struct {
+		plain
+		Error string `json:"error"`
+	}{plain(e), e.Err.Error()})
+}
+
+// ScanFinished is published when every watched folder was scanned and its
+// files uploaded.
+type ScanFinished struct {
+	Folders  int `json:"folders"`
+	Uploaded int `json:"uploaded"`
+	Updated  int `json:"updated"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+}
+
+// ConnectivityChanged is published when Drive becomes unreachable, the
+// uploads waiting until it is back, and again when it is reachable.
+type ConnectivityChanged struct {
+	Online bool `json:"online"`
+}
+
+// BatteryChanged is published when the laptop runs on a battery too low
+// to upload, the uploads waiting until it is plugged in, and again when it
+// is.
+type BatteryChanged struct {
+	Low bool `json:"low"`
+	// Percent is the charge of the battery, -1 when unknown.
+	Percent int `json:"percent,omitempty"`
+}
+
+// MeteredChanged is published when the connection becomes metered, the
+// large uploads waiting for another one, and again when it no longer is.
+type MeteredChanged struct {
+	Metered bool `json:"metered"`
+	// Network is the Wi-Fi network, when known.
+	Network string `json:"network,omitempty"`
+}
+
+// MassChangeDetected is published when a large share of the watched files
+// changed at once, like encrypted by a ransomware, the uploads being
+// paused until the user resumes them.
+type MassChangeDetected struct {
+	Changed int           `json:"changed"`
+	Total   int           `json:"total"`
+	Window  time.Duration `json:"window"`
+}
+
+// QuotaChanged is published when the Drive storage is full, the uploads
+// waiting until there is space, and again when there is.
+type QuotaChanged struct {
+	Exceeded bool `json:"exceeded"`
+	// Usage and Limit are the used and total bytes, Needed the size of the
+	// file that did not fit.
+	Usage  int64 `json:"usage,omitempty"`
+	Limit  int64 `json:"limit,omitempty"`
+	Needed int64 `json:"needed,omitempty"`
+}
+
+// CircuitChanged is published when uploads kept failing in Drive, the
+// uploads waiting until it answers again, and again when it does.
+type CircuitChanged struct {
+	Open bool `json:"open"`
+	// Failures is how many uploads failed in a row, Err the last error.
+	Failures int    `json:"failures,omitempty"`
+	Err      string `json:"error,omitempty"`
+}
+
+// SizeCapExceeded is published when a file is not uploaded as it would
+// take the backup over its size cap.
+type SizeCapExceeded struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Folder is the watched folder whose cap is reached, "" for the cap of
+	// the whole backup, Used its backed up bytes and Cap its cap.
+	Folder string `json:"folder,omitempty"`
+	Used   int64  `json:"used"`
+	Cap    int64  `json:"cap"`
+	// Exclude is an Exclude pattern leaving the file out.
+	Exclude string `json:"exclude"`
+}
+
+func (FileQueued) event()          {}
+func (UploadStarted) event()       {}
+func (UploadProgress) event()      {}
+func (UploadSucceeded) event()     {}
+func (UploadSkipped) event()       {}
+func (FileRenamed) event()         {}
+func (UploadFailed) event()        {}
+func (ScanFinished) event()        {}
+func (ConnectivityChanged) event() {}
+func (BatteryChanged) event()      {}
+func (MeteredChanged) event()      {}
+func (MassChangeDetected) event()  {}
+func (SizeCapExceeded) event()     {}
+func (QuotaChanged) event()        {}
+func (CircuitChanged) event()      {}
+
+// Type returns the name of the type of event, like "UploadFailed".
+func Type(event Event) string {
+	return reflect.TypeOf(event).Name()
+}
+
+// Bus delivers every published event to the subscribed handlers.
+type Bus struct {
+	mutex    sync.RWMutex
+	handlers map[int]func(Event)
+	nextID   int
+}
+
+// New returns a Bus without subscribers.
+func New() *Bus {
+	return &Bus{handlers: make(map[int]func(Event))}
+}
+
+// Subscribe calls handler with every event published from now on, until
+// the returned function is called. Handlers run in the goroutine of the
+// publisher and must not block.
+func (bus *Bus) Subscribe(handler func(Event)) (unsubscribe func()) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	id := bus.nextID
+	bus.nextID++
+	bus.handlers[id] = handler
+	return func() {
+		bus.mutex.Lock()
+		defer bus.mutex.Unlock()
+		delete(bus.handlers, id)
+	}
+}
+
+// Publish delivers event to every handler. Publishing on a nil Bus does
+// nothing.
+func (bus *Bus) Publish(event Event) {
+	if bus == nil {
+		return
+	}
+	bus.mutex.RLock()
+	defer bus.mutex.RUnlock()
+	for _, handler := range bus.handlers {
+		handler(event)
+	}
+}
+
+// Log writes the events worth telling the user about to the log.
+func Log(event Event) {
+	switch e := event.(type) {
+	case UploadSucceeded:
+		slog.Info("Uploaded file", "file", e.Path, "action", e.Action, "size", e.Size, "duration", e.Duration,
+			"backend", "drive", "folder", e.RemoteFolder)
+	case FileRenamed:
+		slog.Info("Renamed or moved file in Drive", "file", e.Path, "from", e.From)
+	case UploadFailed:
+		slog.Error("Upload failed", "file", e.Path, "error", e.Err)
+	case ConnectivityChanged:
+		if e.Online {
+			slog.Info("Drive reachable again, resuming uploads")
+		} else {
+			slog.Warn("Drive unreachable, changes are queued until it is back")
+		}
+	case BatteryChanged:
+		if e.Low {
+			slog.Warn("On battery, uploads wait until the laptop is plugged in", "percent", e.Percent)
+		} else {
+			slog.Info("Battery no longer low, resuming uploads")
+		}
+	case MeteredChanged:
+		if e.Metered {
+			slog.Warn("Metered connection, large uploads wait for another one", "network", e.Network)
+		} else {
+			slog.Info("Connection no longer metered, resuming large uploads")
+		}
+	case MassChangeDetected:
+		slog.Error("Many files changed at once, uploads paused to keep the backups, check the files and resume", "changed", e.Changed, "files", e.Total, "window", e.Window)
+	case SizeCapExceeded:
+		slog.Warn("File not uploaded, it would take the backup over its size cap, exclude it or raise the cap",
+			"file", e.Path, "size", e.Size, "folder", e.Folder, "used", e.Used, "cap", e.Cap, "exclude", e.Exclude)
+	case QuotaChanged:
+		if e.Exceeded {
+			slog.Error("Drive storage full, uploads wait until there is space", "usage", e.Usage, "limit", e.Limit, "needed", e.Needed)
+		} else {
+			slog.Info("Drive has free space again, resuming uploads")
+		}
+	case CircuitChanged:
+		if e.Open {
+			slog.Error("Drive keeps failing, uploads wait until it answers again", "failures", e.Failures, "error", e.Err)
+		} else {
+			slog.Info("Drive answers again, resuming uploads")
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Line is an event as a line of the JSON event streams.
+type Line struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Event Event     `json:"event"`
+}
+
+// NewLine returns the line of event, published now.
+func NewLine(event Event) Line {
+	return Line{time.Now(), Type(event), event}
+}
+
+// Writer writes every event it handles as a line of JSON, for the programs
+// showing the progress of the app. The lines are written as the events are
+// published, the reader must keep reading them.
+type Writer struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	failed  bool
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{encoder: json.NewEncoder(w)}
+}
+
+// HandleEvent writes event. It stops writing after an error, when the
+// reader went away.
+func (writer *Writer) HandleEvent(event Event) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.failed {
+		return
+	}
+	writer.failed = writer.encoder.Encode(NewLine(event)) != nil
+}
@@ -0,0 +1,162 @@
+// Package history keeps the append-only log of uploads.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/buildinfo"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+)
+
+// FileName is the default history file, in the working directory.
+const FileName = "history.jsonl"
+
+const shownEntries = 50 // entries listed by Show
+
+// history actions
+const (
+	ActionUpload = "upload"
+	ActionUpdate = "update"
+	ActionRename = "rename"
+	ActionSkip   = "skip"
+	ActionFail   = "fail"
+)
+
+// Entry is a line of the append-only upload history.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Path       string    `json:"path"`
+	From       string    `json:"from,omitempty"`
+	RemoteID   string    `json:"remoteId,omitempty"`
+	Hash       string    `json:"sha256,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	DurationMs int64     `json:"durationMs,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	// Reason is why a skipped file was not uploaded.
+	Reason string `json:"reason,omitempty"`
+	// Version is the build of the app that recorded the entry.
+	Version string `json:"version,omitempty"`
+}
+
+// Log is the history file.
+type Log struct {
+	File string
+
+	mutex   sync.Mutex
+	version string
+}
+
+// New returns the history kept in file.
+func New(file string) *Log {
+	return &Log{File: file, version: buildinfo.Get().String()}
+}
+
+// Record appends entry to the history file.
+func (log *Log) Record(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if entry.Version == "" {
+		entry.Version = log.version
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Unable to record history", "error", err)
+		return
+	}
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	f, err := os.OpenFile(log.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Error("Unable to record history", "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		slog.Error("Unable to record history", "error", err)
+	}
+}
+
+// Show prints the latest history entries whose path contains filter.
+func (log *Log) Show(filter string) {
+	f, err := os.Open(log.File)
+	if os.IsNotExist(err) {
+		fmt.Println("No uploads yet")
+		return
+	} else if err != nil {
+		slog.Error("Unable to read history", "error", err)
+		return
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if strings.Contains(entry.Path, filter) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) > shownEntries {
+		entries = entries[len(entries)-shownEntries:]
+	}
+
+	for _, entry := range entries {
+		if logging.JSONOutput() {
+			json.NewEncoder(os.Stdout).Encode(entry)
+			continue
+		}
+		detail := humanize.Bytes(entry.Size)
+		if entry.Error != "" {
+			detail = entry.Error
+		} else if entry.Reason != "" {
+			detail = entry.Reason
+		}
+		// padded before the color, the escape codes take no room
+		action := logging.Colorize(logging.Green, fmt.Sprintf("%-6s", entry.Action))
+		if entry.Error != "" {
+			action = logging.Colorize(logging.Red, fmt.Sprintf("%-6s", entry.Action))
+		}
+		fmt.Printf("%s  %s  %s  %s\n", logging.Colorize(logging.Dim, entry.Time.Format("2006-01-02 15:04:05")),
+			action, entry.Path, logging.Colorize(logging.Dim, "("+detail+")"))
+	}
+}
+
+// HandleEvent records the uploads published on the event bus.
+func (log *Log) HandleEvent(event events.Event) {
+	switch e := event.(type) {
+	case events.UploadSucceeded:
+		log.Record(Entry{
+			Action:     e.Action,
+			Path:       e.Path,
+			RemoteID:   e.RemoteID,
+			Hash:       e.Hash,
+			Size:       e.Size,
+			DurationMs: e.Duration.Milliseconds(),
+		})
+	case events.FileRenamed:
+		log.Record(Entry{Action: ActionRename, Path: e.Path, From: e.From, RemoteID: e.RemoteID})
+	case events.UploadSkipped:
+		// the unchanged files are skipped again by every scan, recording
+		// them would fill the history
+		if e.Reason != events.SkipUnchanged {
+			log.Record(Entry{Action: ActionSkip, Path: e.Path, Reason: e.Reason})
+		}
+	case events.UploadFailed:
+		log.Record(Entry{Action: ActionFail, Path: e.Path, Error: e.Err.Error()})
+	}
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"os"
+)
+
+// Color is an ANSI style of the terminal output.
+type Color string
+
+// styles of the terminal output: errors red, uploads green, warnings yellow
+// and skipped files dim
+const (
+	Red    Color = "31"
+	Green  Color = "32"
+	Yellow Color = "33"
+	Bold   Color = "1"
+	Dim    Color = "2"
+)
+
+var colorOutput bool // stdout is a terminal showing colors
+
+// Colorize returns text in color when the output is a terminal showing
+// colors, text as is otherwise.
+func Colorize(color Color, text string) string {
+	if !colorOutput {
+		return text
+	}
+	return paint(color, text)
+}
+
+// paint returns text between the escape codes of color.
+func paint(color Color, text string) string {
+	if color == "" || text == "" {
+		return text
+	}
+	return "\x1b[" + string(color) + "m" + text + "\x1b[0m"
+}
+
+// isTerminal reports whether f is a terminal, not a file, a pipe or the null
+// device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// showsColors reports whether the terminal f shows colors: not when the
+// NO_COLOR environment variable is set, https://no-color.org, or TERM is
+// dumb.
+func showsColors(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(f) && enableColors(f)
+}
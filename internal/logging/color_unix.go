@@ -0,0 +1,11 @@
+//go:build !windows
+
+package logging
+
+import "os"
+
+// enableColors prepares the terminal f for colors. Unix terminals show them
+// as they are.
+func enableColors(f *os.File) bool {
+	return true
+}
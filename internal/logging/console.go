@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consoleHandler writes the log to a terminal, one short line per record:
+// the time, the level and the message, then the fields. With colors,
+// errors are red, warnings yellow, debug messages, like the skipped files,
+// dim, and the uploads, the records with an action, green.
+type consoleHandler struct {
+	mutex  *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	colors bool
+	// attrs are the fields added with WithAttrs, already formatted
+	attrs string
+	// group prefixes the keys of the fields
+	group string
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler, colors bool) *consoleHandler {
+	return &consoleHandler{mutex: new(sync.Mutex), w: w, level: level, colors: colors}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var fields strings.Builder
+	fields.WriteString(h.attrs)
+	upload := false
+	record.Attrs(func(attr slog.Attr) bool {
+		upload = upload || attr.Key == "action"
+		writeAttr(&fields, h.group, attr)
+		return true
+	})
+
+	levelColor, messageColor := Color(""), Color("")
+	switch {
+	case record.Level >= slog.LevelError:
+		levelColor, messageColor = Red, Red
+	case record.Level >= slog.LevelWarn:
+		levelColor, messageColor = Yellow, Yellow
+	case record.Level < slog.LevelInfo:
+		levelColor, messageColor = Dim, Dim
+	case upload:
+		levelColor, messageColor = Green, Green
+	}
+	line := fmt.Sprintf("%s %s %s%s\n",
+		h.paint(Dim, record.Time.Format(time.TimeOnly)),
+		h.paint(levelColor, fmt.Sprintf("%-5s", record.Level)),
+		h.paint(messageColor, record.Message),
+		h.paint(Dim, fields.String()))
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := io.WriteString(h.w, line)
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields strings.Builder
+	fields.WriteString(h.attrs)
+	for _, attr := range attrs {
+		writeAttr(&fields, h.group, attr)
+	}
+	handler := *h
+	handler.attrs = fields.String()
+	return &handler
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	handler := *h
+	handler.group = h.group + name + "."
+	return &handler
+}
+
+// paint colors text when the terminal shows colors.
+func (h *consoleHandler) paint(color Color, text string) string {
+	if !h.colors {
+		return text
+	}
+	return paint(color, text)
+}
+
+// writeAttr writes attr as " key=value", quoting the values with spaces.
+func writeAttr(fields *strings.Builder, group string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			group += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			writeAttr(fields, group, member)
+		}
+		return
+	}
+	value := attr.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(fields, " %s%s=%s", group, attr.Key, value)
+}
+
+// fanoutHandler sends the records to every handler, the terminal and the log
+// file.
+type fanoutHandler []slog.Handler
+
+func (handlers fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (handlers fanoutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var firstErr error
+	for _, handler := range handlers {
+		if !handler.Enabled(ctx, record.Level) {
+			continue
+		}
+		if err := handler.Handle(ctx, record.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (handlers fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := make(fanoutHandler, len(handlers))
+	for i, handler := range handlers {
+		next[i] = handler.WithAttrs(attrs)
+	}
+	return next
+}
+
+func (handlers fanoutHandler) WithGroup(name string) slog.Handler {
+	next := make(fanoutHandler, len(handlers))
+	for i, handler := range handlers {
+		next[i] = handler.WithGroup(name)
+	}
+	return next
+}
@@ -0,0 +1,131 @@
+// Package logging sets up the structured logger and the output format of
+// the app.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const defaultLogMaxSizeMB = 10
+const defaultLogMaxAgeDays = 30
+const defaultLogMaxBackups = 5
+
+var logLevel = new(slog.LevelVar) // minimum level written to the log
+
+var jsonOutput bool // --output json
+
+var noConsole bool // the terminal UI owns the terminal
+
+// Options sets up the logger.
+type Options struct {
+	// Verbose enables debug messages and Quiet hides everything but
+	// warnings and errors.
+	Verbose bool
+	Quiet   bool
+	// JSON writes newline-delimited JSON events to stdout.
+	JSON bool
+	// File also writes the log to this file, rotated when it reaches
+	// MaxSizeMB or is older than MaxAgeDays, keeping MaxBackups old files.
+	File       string
+	MaxSizeMB  int
+	MaxAgeDays int
+	MaxBackups int
+	// NoConsole keeps the log and the progress off the terminal, only File
+	// receives the log.
+	NoConsole bool
+}
+
+// Setup installs the default structured logger.
+func Setup(options Options) {
+	switch {
+	case options.Verbose:
+		logLevel.Set(slog.LevelDebug)
+	case options.Quiet:
+		logLevel.Set(slog.LevelWarn)
+	default:
+		logLevel.Set(slog.LevelInfo)
+	}
+	jsonOutput = options.JSON
+	noConsole = options.NoConsole
+	colorOutput = !jsonOutput && !noConsole && showsColors(os.Stdout)
+
+	// in JSON mode the log events are the output of the app
+	var console io.Writer = os.Stderr
+	if noConsole {
+		console = io.Discard
+	} else if jsonOutput {
+		console = os.Stdout
+	}
+
+	// a terminal gets short colored lines, the rest key=value lines
+	pretty := !noConsole && !jsonOutput && isTerminal(os.Stderr)
+	var output io.Writer = console
+	if pretty {
+		output = io.Discard
+	}
+	if options.File != "" {
+		file := &lumberjack.Logger{
+			Filename:   options.File,
+			MaxSize:    config.IntOrDefault(options.MaxSizeMB, defaultLogMaxSizeMB),
+			MaxAge:     config.IntOrDefault(options.MaxAgeDays, defaultLogMaxAgeDays),
+			MaxBackups: config.IntOrDefault(options.MaxBackups, defaultLogMaxBackups),
+			LocalTime:  true,
+		}
+		if pretty {
+			output = file
+		} else {
+			output = io.MultiWriter(console, file)
+		}
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(output, handlerOptions)
+	if jsonOutput {
+		handler = slog.NewJSONHandler(output, handlerOptions)
+	}
+	if pretty {
+		terminal := newConsoleHandler(os.Stderr, logLevel, showsColors(os.Stderr))
+		if output == io.Discard {
+			handler = terminal
+		} else {
+			handler = fanoutHandler{terminal, handler}
+		}
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// JSONOutput reports whether the app writes newline-delimited JSON instead
+// of human readable text.
+func JSONOutput() bool {
+	return jsonOutput
+}
+
+// CanPrompt reports whether the standard input is a terminal, so the user
+// can answer questions, false in a container or a service, where it is a
+// pipe or the null device.
+func CanPrompt() bool {
+	return isTerminal(os.Stdin)
+}
+
+// ConsoleOutput reports whether the app writes to the terminal, false while
+// the terminal UI runs.
+func ConsoleOutput() bool {
+	return !noConsole
+}
+
+// Fatal logs msg with its key-value fields at error level and exits.
+func Fatal(msg string, args ...any) {
+	Exit(1, msg, args...)
+}
+
+// Exit logs msg with its key-value fields at error level and exits with
+// code.
+func Exit(code int, msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(code)
+}
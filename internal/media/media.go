@@ -0,0 +1,217 @@
+// Package media tells photos and videos apart and reads when they were
+// taken, from the EXIF data of photos and the movie header of MP4 and
+// QuickTime videos.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// headerSize is how much of a photo is read looking for its EXIF data,
+// which cameras write near the start.
+const headerSize = 256 << 10
+
+var photoExtensions = []string{".jpg", ".jpeg", ".heic", ".heif", ".png", ".tif", ".tiff", ".dng", ".nef", ".cr2", ".arw", ".orf", ".rw2", ".raf"}
+var videoExtensions = []string{".mp4", ".m4v", ".mov", ".3gp"}
+
+// EXIF tags holding the capture date
+const (
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// exifTimeLayout is the format of the EXIF dates, in the local time of the
+// camera.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// mp4Epoch is when the times of MP4 and QuickTime files start.
+var mp4Epoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// IsMedia reports whether the file called name is a photo or a video, by
+// its extension.
+func IsMedia(name string) bool {
+	return isPhoto(name) || isVideo(name)
+}
+
+func isPhoto(name string) bool {
+	return hasExtension(name, photoExtensions)
+}
+
+func isVideo(name string) bool {
+	return hasExtension(name, videoExtensions)
+}
+
+func hasExtension(name string, extensions []string) bool {
+	return slices.Contains(extensions, strings.ToLower(filepath.Ext(name)))
+}
+
+// CaptureTime returns when the photo or video called name, read from r,
+// was taken, false when it does not say. The date of a photo is in the
+// local time zone, like its camera clock.
+func CaptureTime(name string, r io.ReadSeeker) (time.Time, bool) {
+	if isVideo(name) {
+		return movieTime(r)
+	}
+	if !isPhoto(name) {
+		return time.Time{}, false
+	}
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return time.Time{}, false
+	}
+	header = header[:n]
+	if bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")) {
+		// TIFF and most raw formats are a TIFF file
+		return exifTime(header)
+	}
+	if exif := jpegExif(header); exif != nil {
+		return exifTime(exif)
+	}
+	return time.Time{}, false
+}
+
+// jpegExif returns the EXIF data of the JPEG file starting with header,
+// nil when it has none.
+func jpegExif(header []byte) []byte {
+	if !bytes.HasPrefix(header, []byte{0xFF, 0xD8}) {
+		return nil
+	}
+	for i := 2; i+4 <= len(header) && header[i] == 0xFF; {
+		marker := header[i+1]
+		size := int(binary.BigEndian.Uint16(header[i+2:]))
+		// the image data follows the start of scan, no EXIF after it
+		if marker == 0xDA || size < 2 || i+2+size > len(header) {
+			return nil
+		}
+		segment := header[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + size
+	}
+	return nil
+}
+
+// exifTime returns the DateTimeOriginal of the TIFF structure tiff, or its
+// DateTime when it has none.
+func exifTime(tiff []byte) (time.Time, bool) {
+	if len(tiff) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if tiff[0] == 'M' {
+		order = binary.BigEndian
+	}
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	if offset, ok := ifd0[tagExifIFD]; ok {
+		exif := readIFD(tiff, order, order.Uint32(offset))
+		if t, ok := parseExifTime(tiff, order, exif[tagDateTimeOriginal]); ok {
+			return t, true
+		}
+	}
+	return parseExifTime(tiff, order, ifd0[tagDateTime])
+}
+
+// readIFD returns the value fields of the entries of the IFD at offset
+// of tiff, by tag.
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	entries := make(map[uint16][]byte)
+	if int64(offset)+2 > int64(len(tiff)) {
+		return entries
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[start:])] = tiff[start+8 : start+12]
+	}
+	return entries
+}
+
+// parseExifTime parses the 20 bytes date pointed to by the value field
+// value of an entry.
+func parseExifTime(tiff []byte, order binary.ByteOrder, value []byte) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, false
+	}
+	offset := int(order.Uint32(value))
+	if offset < 0 || offset+len(exifTimeLayout) > len(tiff) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(exifTimeLayout, string(tiff[offset:offset+len(exifTimeLayout)]), time.Local)
+	return t, err == nil
+}
+
+// movieTime returns the creation time in the movie header, mvhd, of the
+// MP4 or QuickTime file r.
+func movieTime(r io.ReadSeeker) (time.Time, bool) {
+	moov, ok := findBox(r, "moov", -1)
+	if !ok {
+		return time.Time{}, false
+	}
+	if _, ok := findBox(r, "mvhd", moov); !ok {
+		return time.Time{}, false
+	}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return time.Time{}, false
+	}
+	var seconds uint64
+	if header[0] == 1 {
+		seconds = binary.BigEndian.Uint64(header[4:])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(header[4:]))
+	}
+	if seconds == 0 {
+		return time.Time{}, false
+	}
+	return mp4Epoch.Add(time.Duration(seconds) * time.Second).Local(), true
+}
+
+// findBox skips the boxes of r until the one of type name, among the
+// limit next bytes, -1 being until the end, and returns the size of its
+// content, -1 up to the end, r being at its start.
+func findBox(r io.ReadSeeker, name string, limit int64) (int64, bool) {
+	header := make([]byte, 8)
+	for limit < 0 || limit >= 8 {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, false
+		}
+		boxType := string(header[4:8])
+		size, headerLength := int64(binary.BigEndian.Uint32(header)), int64(8)
+		if size == 1 {
+			// a 64 bits size follows
+			if _, err := io.ReadFull(r, header); err != nil {
+				return 0, false
+			}
+			size, headerLength = int64(binary.BigEndian.Uint64(header)), 16
+		}
+		if size == 0 {
+			// the last box, up to the end of the file
+			return -1, boxType == name
+		}
+		if size < headerLength {
+			return 0, false
+		}
+		if boxType == name {
+			return size - headerLength, true
+		}
+		if _, err := r.Seek(size-headerLength, io.SeekCurrent); err != nil {
+			return 0, false
+		}
+		if limit >= 0 {
+			limit -= size
+		}
+	}
+	return 0, false
+}
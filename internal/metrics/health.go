@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+const defaultHealthFailureMinutes = 10
+
+// healthState is reported by /healthz.
+type healthState struct {
+	mutex              sync.Mutex
+	watcherAlive       bool
+	authExpired        bool
+	lastBackendSuccess time.Time
+	lastBackendFailure time.Time
+}
+
+// WatcherAlive records whether the watcher is running.
+func (m *Metrics) WatcherAlive(alive bool) {
+	m.health.mutex.Lock()
+	m.health.watcherAlive = alive
+	m.health.mutex.Unlock()
+}
+
+// AuthExpired records whether uploads wait for a new authorization.
+func (m *Metrics) AuthExpired(expired bool) {
+	m.health.mutex.Lock()
+	m.health.authExpired = expired
+	m.health.mutex.Unlock()
+}
+
+// BackendCall remembers the result of the latest Drive call.
+func (m *Metrics) BackendCall(err error) {
+	m.health.mutex.Lock()
+	if err == nil {
+		m.health.lastBackendSuccess = time.Now()
+	} else {
+		m.health.lastBackendFailure = time.Now()
+	}
+	m.health.mutex.Unlock()
+}
+
+// HealthProblems returns why the app is unhealthy, nothing when it is fine.
+func (m *Metrics) HealthProblems() []string {
+	m.health.mutex.Lock()
+	defer m.health.mutex.Unlock()
+
+	var problems []string
+	if !m.health.watcherAlive {
+		problems = append(problems, "watcher is not running")
+	}
+	if m.health.authExpired {
+		problems = append(problems, "Drive authorization expired")
+	}
+	failureWindow := time.Duration(config.IntOrDefault(m.config().HealthFailureMinutes, defaultHealthFailureMinutes)) * time.Minute
+	lastFailure := m.health.lastBackendFailure
+	if lastFailure.After(m.health.lastBackendSuccess) && time.Since(lastFailure) < failureWindow {
+		problems = append(problems, "last Drive call failed at "+lastFailure.Format(time.RFC3339))
+	}
+	return problems
+}
+
+// healthHandler answers 200 when the watcher runs, the token is valid and
+// the latest Drive call did not fail recently, 503 otherwise.
+func (m *Metrics) healthHandler(w http.ResponseWriter, r *http.Request) {
+	problems := m.HealthProblems()
+	w.Header().Set("Content-Type", "application/json")
+	status := "ok"
+	if len(problems) > 0 {
+		status = "unhealthy"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   status,
+		"problems": problems,
+	})
+}
@@ -0,0 +1,123 @@
+// Package metrics exposes the upload metrics in the Prometheus text format
+// and the health of the app.
+package metrics
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+)
+
+// Metrics counts the uploads and tracks the health of the app.
+type Metrics struct {
+	// Config is the config of the metrics when ConfigStore is nil.
+	Config *config.Config
+	// ConfigStore holds the current config, so a reloaded config applies.
+	ConfigStore *config.Store
+
+	filesUploaded int64
+	bytesUploaded int64
+	uploadErrors  int64
+	queueDepth    int64
+
+	lastSuccess      map[string]time.Time // by watched folder
+	lastSuccessMutex sync.Mutex
+
+	health healthState
+}
+
+// New returns empty metrics, cfg sets the health thresholds.
+func New(cfg *config.Config) *Metrics {
+	return &Metrics{Config: cfg, lastSuccess: make(map[string]time.Time)}
+}
+
+// config returns the current config, which is never changed.
+func (m *Metrics) config() *config.Config {
+	if m.ConfigStore != nil {
+		return m.ConfigStore.Config()
+	}
+	return m.Config
+}
+
+// UploadSucceeded counts a file of size bytes uploaded from folder.
+func (m *Metrics) UploadSucceeded(folder string, size int64) {
+	atomic.AddInt64(&m.filesUploaded, 1)
+	atomic.AddInt64(&m.bytesUploaded, size)
+	m.lastSuccessMutex.Lock()
+	m.lastSuccess[folder] = time.Now()
+	m.lastSuccessMutex.Unlock()
+}
+
+// UploadFailed counts a failed upload.
+func (m *Metrics) UploadFailed() {
+	atomic.AddInt64(&m.uploadErrors, 1)
+}
+
+// Queued adds delta to the uploads waiting in the queue.
+func (m *Metrics) Queued(delta int64) {
+	atomic.AddInt64(&m.queueDepth, delta)
+}
+
+// Serve serves /metrics and /healthz on addr in the background.
+func (m *Metrics) Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.metricsHandler)
+	mux.HandleFunc("/healthz", m.healthHandler)
+	go func() {
+		slog.Info("Serving metrics", "address", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Metrics server stopped", "address", addr, "error", err)
+		}
+	}()
+}
+
+func (m *Metrics) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeMetric(w, "encryptbckdocs_files_uploaded_total", "counter", "Files uploaded or updated.", atomic.LoadInt64(&m.filesUploaded))
+	writeMetric(w, "encryptbckdocs_bytes_uploaded_total", "counter", "Bytes uploaded.", atomic.LoadInt64(&m.bytesUploaded))
+	writeMetric(w, "encryptbckdocs_upload_errors_total", "counter", "Failed uploads.", atomic.LoadInt64(&m.uploadErrors))
+	writeMetric(w, "encryptbckdocs_queue_depth", "gauge", "Uploads waiting in the queue.", atomic.LoadInt64(&m.queueDepth))
+
+	m.lastSuccessMutex.Lock()
+	defer m.lastSuccessMutex.Unlock()
+	folders := make([]string, 0, len(m.lastSuccess))
+	for folder := range m.lastSuccess {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	fmt.Fprintln(w, "# HELP encryptbckdocs_last_success_timestamp_seconds Time of the last successful upload per watched folder.")
+	fmt.Fprintln(w, "# TYPE encryptbckdocs_last_success_timestamp_seconds gauge")
+	for _, folder := range folders {
+		fmt.Fprintf(w, "encryptbckdocs_last_success_timestamp_seconds{folder=%q} %d\n", folder, m.lastSuccess[folder].Unix())
+	}
+}
+
+func writeMetric(w http.ResponseWriter, name string, kind string, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// HandleEvent counts the uploads published on the event bus.
+func (m *Metrics) HandleEvent(event events.Event) {
+	switch e := event.(type) {
+	case events.FileQueued:
+		m.Queued(1)
+	case events.UploadStarted:
+		m.Queued(-1)
+	case events.UploadSucceeded:
+		m.UploadSucceeded(e.Folder, e.Size)
+		m.BackendCall(nil)
+	case events.UploadFailed:
+		m.UploadFailed()
+		if e.Backend {
+			m.BackendCall(e.Err)
+		}
+	}
+}
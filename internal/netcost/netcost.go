@@ -0,0 +1,10 @@
+// Package netcost tells whether the network connection is metered, as
+// flagged by NetworkManager on Linux or the connection cost on Windows, and
+// the Wi-Fi network the machine is on, to recognize phone hotspots.
+package netcost
+
+import "errors"
+
+// ErrUnsupported is returned where the connection cost or the Wi-Fi
+// network cannot be read.
+var ErrUnsupported = errors.New("network cost not readable on this system")
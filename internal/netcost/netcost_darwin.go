@@ -0,0 +1,26 @@
+package netcost
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// Metered returns ErrUnsupported, macOS keeps no metered flag: list the
+// hotspots by their Wi-Fi network instead.
+func Metered() (bool, error) {
+	return false, ErrUnsupported
+}
+
+// SSID returns the name of the Wi-Fi network of the en0 interface, empty
+// when none.
+func SSID() (string, error) {
+	output, err := exec.Command("networksetup", "-getairportnetwork", "en0").Output()
+	if err != nil {
+		return "", err
+	}
+	ssid, ok := strings.CutPrefix(strings.TrimSpace(string(output)), "Current Wi-Fi Network: ")
+	if !ok {
+		return "", nil
+	}
+	return ssid, nil
+}
@@ -0,0 +1,35 @@
+package netcost
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// Metered reports whether NetworkManager flags the connection as metered,
+// set by the user or guessed for a phone hotspot.
+func Metered() (bool, error) {
+	output, err := exec.Command("busctl", "get-property", "org.freedesktop.NetworkManager",
+		"/org/freedesktop/NetworkManager", "org.freedesktop.NetworkManager", "Metered").Output()
+	if err != nil {
+		return false, err
+	}
+	// "u 1": NM_METERED_YES, 3 being NM_METERED_GUESS_YES
+	value := strings.TrimSpace(strings.TrimPrefix(string(output), "u"))
+	return value == "1" || value == "3", nil
+}
+
+// SSID returns the name of the Wi-Fi network the machine is on, empty when
+// none.
+func SSID() (string, error) {
+	output, err := exec.Command("nmcli", "-t", "-f", "active,ssid", "device", "wifi").Output()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if ssid, ok := strings.CutPrefix(line, "yes:"); ok {
+			// nmcli escapes the colons of the terse output
+			return strings.ReplaceAll(ssid, `\:`, ":"), nil
+		}
+	}
+	return "", nil
+}
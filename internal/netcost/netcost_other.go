@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package netcost
+
+// Metered returns ErrUnsupported, only read on Linux and Windows.
+func Metered() (bool, error) {
+	return false, ErrUnsupported
+}
+
+// SSID returns ErrUnsupported, only read on Linux, macOS and Windows.
+func SSID() (string, error) {
+	return "", ErrUnsupported
+}
@@ -0,0 +1,39 @@
+package netcost
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// costScript prints the cost of the internet connection, Unrestricted,
+// Fixed, Variable or Unknown.
+const costScript = `[void][Windows.Networking.Connectivity.NetworkInformation,Windows.Networking.Connectivity,ContentType=WindowsRuntime]
+$profile = [Windows.Networking.Connectivity.NetworkInformation]::GetInternetConnectionProfile()
+if ($profile) { $profile.GetConnectionCost().NetworkCostType }`
+
+// Metered reports whether Windows sees the connection as metered, with a
+// fixed or variable cost.
+func Metered() (bool, error) {
+	output, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", costScript).Output()
+	if err != nil {
+		return false, err
+	}
+	cost := strings.TrimSpace(string(output))
+	return cost == "Fixed" || cost == "Variable", nil
+}
+
+// SSID returns the name of the Wi-Fi network the machine is on, empty when
+// none.
+func SSID() (string, error) {
+	output, err := exec.Command("netsh", "wlan", "show", "interfaces").Output()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(name) == "SSID" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return "", nil
+}
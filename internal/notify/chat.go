@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+const telegramAPI = "https://api.telegram.org/bot"
+
+// sendChatNotifications posts n to the configured chats accepting its
+// severity, in the background.
+func (notifier *Notifier) sendChatNotifications(n Notification) {
+	text := n.Title + "\n" + n.Message
+	if n.Error != "" {
+		text += "\n" + n.Error
+	}
+
+	if slack := notifier.config().Slack; slack != nil && slack.WebhookURL != "" && severityAtLeast(n.Severity, slack.MinSeverity) {
+		go notifier.postChatMessage("slack", secret(slack.WebhookURL), map[string]string{"text": text})
+	}
+	if telegram := notifier.config().Telegram; telegram != nil && telegram.BotToken != "" && severityAtLeast(n.Severity, telegram.MinSeverity) {
+		go notifier.postChatMessage("telegram", telegramAPI+secret(telegram.BotToken)+"/sendMessage",
+			map[string]string{"chat_id": telegram.ChatID, "text": text})
+	}
+}
+
+func (notifier *Notifier) postChatMessage(chat string, url string, payload map[string]string) {
+	body, err := json.Marshal(payload)
+	if err == nil {
+		err = notifier.postBody(url, "", nil, body)
+	}
+	if err != nil {
+		slog.Error("Unable to send chat notification", "chat", chat, "error", err)
+	}
+}
@@ -0,0 +1,221 @@
+// Package notify tells the user about backup events through desktop
+// notifications, webhooks, chats and summary emails.
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+)
+
+const failuresToNotify = 3 // consecutive upload failures before notifying
+
+// notification events
+const (
+	EventRunComplete  = "run-complete"
+	EventUploadFailed = "upload-failed"
+	EventAuthExpired  = "auth-expired"
+	EventQuotaFull    = "quota-exceeded"
+	EventCircuitOpen  = "backend-failing"
+	EventMassChange   = "mass-change"
+)
+
+// notification severities, from the least to the most important like
+// config.Severities
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Notification is an event worth telling the user about.
+type Notification struct {
+	Event    string    `json:"event"`
+	Severity string    `json:"severity"`
+	Time     time.Time `json:"time"`
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	Account  string    `json:"account,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Notifier delivers notifications to the notifiers enabled in Config.
+type Notifier struct {
+	// Config is the config of the notifier when ConfigStore is nil.
+	Config *config.Config
+	// ConfigStore holds the current config, so a reloaded config applies.
+	ConfigStore *config.Store
+
+	consecutiveFailures int64
+}
+
+// New returns a Notifier for the notifiers enabled in cfg.
+func New(cfg *config.Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// config returns the current config, which is never changed.
+func (notifier *Notifier) config() *config.Config {
+	if notifier.ConfigStore != nil {
+		return notifier.ConfigStore.Config()
+	}
+	return notifier.Config
+}
+
+// Send delivers n to every enabled notifier.
+func (notifier *Notifier) Send(n Notification) {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	if n.Severity == "" {
+		n.Severity = SeverityInfo
+	}
+	notifier.notifyDesktop(n.Title, n.Message)
+	notifier.sendWebhooks(n)
+	notifier.sendChatNotifications(n)
+}
+
+// UploadResult notifies once uploads have failed several times in a
+// row, and resets the count on success.
+func (notifier *Notifier) UploadResult(err error) {
+	if err == nil {
+		atomic.StoreInt64(&notifier.consecutiveFailures, 0)
+		return
+	}
+	if atomic.AddInt64(&notifier.consecutiveFailures, 1) == failuresToNotify {
+		notifier.Send(Notification{
+			Event:    EventUploadFailed,
+			Severity: SeverityError,
+			Title:    "EncryptBckDocs: uploads failing",
+			Message:  fmt.Sprintf("The last %d uploads failed", failuresToNotify),
+			Error:    err.Error(),
+		})
+	}
+}
+
+// AuthExpired notifies that uploads to account wait until it is authorized
+// again.
+func (notifier *Notifier) AuthExpired(account string) {
+	notifier.Send(Notification{
+		Event:    EventAuthExpired,
+		Severity: SeverityError,
+		Title:    "EncryptBckDocs: authorization expired",
+		Message:  "Uploads are paused until you authorize the " + account + " Drive account again",
+		Account:  account,
+	})
+}
+
+// HandleEvent notifies about the events published on the event bus: a
+// finished scan, repeated upload failures, a full Drive, Drive failing
+// until the circuit opens and many files changing at once.
+func (notifier *Notifier) HandleEvent(event events.Event) {
+	switch e := event.(type) {
+	case events.UploadSucceeded:
+		notifier.UploadResult(nil)
+	case events.UploadFailed:
+		notifier.UploadResult(e.Err)
+	case events.QuotaChanged:
+		if e.Exceeded {
+			notifier.Send(Notification{
+				Event:    EventQuotaFull,
+				Severity: SeverityError,
+				Title:    "EncryptBckDocs: Drive storage full",
+				Message:  fmt.Sprintf("Uploads are paused, %s of %s used. Free some space or get more storage, uploads resume on their own", humanize.Bytes(e.Usage), humanize.Bytes(e.Limit)),
+			})
+		}
+	case events.CircuitChanged:
+		if e.Open {
+			notifier.Send(Notification{
+				Event:    EventCircuitOpen,
+				Severity: SeverityError,
+				Title:    "EncryptBckDocs: Drive keeps failing",
+				Message:  fmt.Sprintf("Uploads are paused after %d failures in a row (%s), they resume on their own once Drive answers again", e.Failures, e.Err),
+			})
+		}
+	case events.MassChangeDetected:
+		notifier.Send(Notification{
+			Event:    EventMassChange,
+			Severity: SeverityError,
+			Title:    "EncryptBckDocs: many files changed at once",
+			Message:  fmt.Sprintf("%d of %d watched files changed or were deleted within %s, which a ransomware would do. Uploads are paused so the backups are not replaced: check the files, then resume", e.Changed, e.Total, e.Window),
+		})
+	case events.ScanFinished:
+		if e.Failed > 0 {
+			notifier.Send(Notification{
+				Event:    EventRunComplete,
+				Severity: SeverityWarning,
+				Title:    "EncryptBckDocs: backup incomplete",
+				Message:  fmt.Sprintf("%d files of the %d watched folders failed to upload, they are tried again on the next backup. See the log for the errors", e.Failed, e.Folders),
+			})
+			return
+		}
+		notifier.Send(Notification{
+			Event:   EventRunComplete,
+			Title:   "EncryptBckDocs: backup complete",
+			Message: fmt.Sprintf("Files in %d watched folders are backed up", e.Folders),
+		})
+	}
+}
+
+// severityAtLeast reports whether severity is as important as minSeverity,
+// ranked by config.Severities, an empty minSeverity accepting everything.
+func severityAtLeast(severity string, minSeverity string) bool {
+	return slices.Index(config.Severities, severity) >= slices.Index(config.Severities, minSeverity)
+}
+
+// notifyDesktop shows a native desktop notification when they are enabled
+// in config. Failing to show it is only logged.
+func (notifier *Notifier) notifyDesktop(title string, message string) {
+	if !notifier.config().DesktopNotifications {
+		return
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e",
+			fmt.Sprintf("display notification %q with title %q", message, title))
+	case "windows":
+		script := fmt.Sprintf("[void][Reflection.Assembly]::LoadWithPartialName('System.Windows.Forms');"+
+			"$n = New-Object System.Windows.Forms.NotifyIcon;"+
+			"$n.Icon = [System.Drawing.SystemIcons]::Information;"+
+			"$n.Visible = $true;"+
+			"$n.ShowBalloonTip(10000, '%s', '%s', 'Info');"+
+			"Start-Sleep -Seconds 10; $n.Dispose()",
+			powershellEscape(title), powershellEscape(message))
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=EncryptBckDocs", title, message)
+	}
+
+	if err := cmd.Start(); err != nil {
+		slog.Warn("Unable to show desktop notification", "error", err)
+		return
+	}
+	go cmd.Wait()
+}
+
+func powershellEscape(s string) string {
+	return strings.Replace(s, "'", "''", -1)
+}
+
+// secret returns a setting of the config, decrypted when it was sealed
+// with config set-secret, "" when it cannot be.
+func secret(value string) string {
+	plain, err := auth.OpenSecret(value)
+	if err != nil {
+		slog.Error("Unable to read a secret of the config, set it again with config set-secret", "error", err)
+		return ""
+	}
+	return plain
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+)
+
+// webhookNotifier returns a Notifier posting every notification to a
+// webhook of the test, received on the returned channel.
+func webhookNotifier(t *testing.T) (*Notifier, <-chan Notification) {
+	t.Helper()
+	received := make(chan Notification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		received <- n
+	}))
+	t.Cleanup(server.Close)
+	return New(&config.Config{Webhooks: []config.Webhook{{URL: server.URL}}}), received
+}
+
+// next returns the next notification posted to the webhook.
+func next(t *testing.T, received <-chan Notification) Notification {
+	t.Helper()
+	select {
+	case n := <-received:
+		return n
+	case <-time.After(10 * time.Second):
+		t.Fatal("no notification posted")
+		return Notification{}
+	}
+}
+
+func TestScanFinishedWithFailures(t *testing.T) {
+	notifier, received := webhookNotifier(t)
+
+	notifier.HandleEvent(events.ScanFinished{Folders: 2, Uploaded: 10})
+	if n := next(t, received); n.Severity != SeverityInfo {
+		t.Errorf("complete backup notified as %q, want %q", n.Severity, SeverityInfo)
+	}
+	notifier.HandleEvent(events.ScanFinished{Folders: 2, Uploaded: 8, Failed: 3})
+	if n := next(t, received); n.Severity != SeverityWarning || !strings.Contains(n.Message, "3 files") {
+		t.Errorf("backup with failures notified as %q %q, want a warning naming the 3 failed files", n.Severity, n.Message)
+	}
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	for _, test := range []struct {
+		severity, minSeverity string
+		want                  bool
+	}{
+		{SeverityInfo, "", true},
+		{SeverityError, "", true},
+		{SeverityInfo, SeverityWarning, false},
+		{SeverityWarning, SeverityWarning, true},
+		{SeverityError, SeverityWarning, true},
+		{SeverityWarning, SeverityError, false},
+		{SeverityError, SeverityError, true},
+	} {
+		if got := severityAtLeast(test.severity, test.minSeverity); got != test.want {
+			t.Errorf("severityAtLeast(%q, %q) = %v, want %v", test.severity, test.minSeverity, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+)
+
+// Report accumulates the activity since the last summary email.
+type Report struct {
+	// Config is the config of the report when ConfigStore is nil.
+	Config *config.Config
+	// ConfigStore holds the current config, so a reloaded config applies.
+	ConfigStore *config.Store
+
+	mutex         sync.Mutex
+	since         time.Time
+	files         int64
+	bytes         int64
+	failures      int64
+	lastFailures  []string
+	filesByFolder map[string]int64
+}
+
+const maxReportedFailures = 20
+
+// NewReport starts accumulating the activity reported by the emails set in
+// cfg.
+func NewReport(cfg *config.Config) *Report {
+	return &Report{Config: cfg, since: time.Now(), filesByFolder: make(map[string]int64)}
+}
+
+// config returns the current config, which is never changed.
+func (report *Report) config() *config.Config {
+	if report.ConfigStore != nil {
+		return report.ConfigStore.Config()
+	}
+	return report.Config
+}
+
+// AddUpload counts a file of size bytes uploaded from folder.
+func (report *Report) AddUpload(folder string, size int64) {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	report.files++
+	report.bytes += size
+	report.filesByFolder[folder]++
+}
+
+// AddFailure counts a failed upload, reason being shown in the report.
+func (report *Report) AddFailure(reason string) {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	report.failures++
+	if len(report.lastFailures) < maxReportedFailures {
+		report.lastFailures = append(report.lastFailures, reason)
+	}
+}
+
+// reset returns the report text for the accumulated activity and starts a
+// new period.
+func (report *Report) reset() string {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	var text bytes.Buffer
+	fmt.Fprintf(&text, "EncryptBckDocs backup summary from %s to %s\n\n",
+		report.since.Format(time.RFC1123), time.Now().Format(time.RFC1123))
+	fmt.Fprintf(&text, "Files backed up: %d\n", report.files)
+	fmt.Fprintf(&text, "Bytes transferred: %s\n", humanize.Bytes(report.bytes))
+	fmt.Fprintf(&text, "Failures: %d\n", report.failures)
+	for _, reason := range report.lastFailures {
+		fmt.Fprintf(&text, "  - %s\n", reason)
+	}
+
+	var unchanged []string
+	for _, folder := range report.config().FolderToWatch {
+		if report.filesByFolder[folder] == 0 {
+			unchanged = append(unchanged, folder)
+		}
+	}
+	sort.Strings(unchanged)
+	if len(unchanged) > 0 {
+		fmt.Fprintf(&text, "\nFolders with no changes:\n")
+		for _, folder := range unchanged {
+			fmt.Fprintf(&text, "  - %s\n", folder)
+		}
+	}
+
+	report.since = time.Now()
+	report.files = 0
+	report.bytes = 0
+	report.failures = 0
+	report.lastFailures = nil
+	report.filesByFolder = make(map[string]int64)
+	return text.String()
+}
+
+// Run sends the summary report by email every period set in config, until
+// the app exits.
+func (report *Report) Run() {
+	email := report.config().Email
+	if email == nil || email.Host == "" {
+		return
+	}
+	period := 24 * time.Hour
+	if email.Every == "weekly" {
+		period = 7 * 24 * time.Hour
+	}
+
+	for range time.Tick(period) {
+		if err := sendEmailReport(email, report.reset()); err != nil {
+			slog.Error("Unable to send summary email", "host", email.Host, "error", err)
+		} else {
+			slog.Info("Summary email sent", "to", strings.Join(email.To, ", "))
+		}
+	}
+}
+
+// sendEmailReport sends body through the SMTP server, using STARTTLS when
+// the server offers it.
+func sendEmailReport(email *config.Email, body string) error {
+	port := email.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(email.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if email.Username != "" {
+		auth = smtp.PlainAuth("", email.Username, secret(email.Password), email.Host)
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", email.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(&message, "Subject: EncryptBckDocs backup summary\r\n")
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	return smtp.SendMail(addr, auth, email.From, email.To, message.Bytes())
+}
+
+// HandleEvent accumulates the uploads published on the event bus.
+func (report *Report) HandleEvent(event events.Event) {
+	switch e := event.(type) {
+	case events.UploadSucceeded:
+		report.AddUpload(e.Folder, e.Size)
+	case events.UploadFailed:
+		report.AddFailure(e.Path + ": " + e.Err.Error())
+	}
+}
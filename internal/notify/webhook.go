@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/proxy"
+)
+
+const webhookTimeout = 30 * time.Second
+
+// sendWebhooks posts n to the configured webhooks interested in its event,
+// in the background.
+func (notifier *Notifier) sendWebhooks(n Notification) {
+	for _, webhook := range notifier.config().Webhooks {
+		if webhookWants(webhook, n.Event) {
+			go notifier.sendWebhook(webhook, n)
+		}
+	}
+}
+
+func webhookWants(webhook config.Webhook, event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, wanted := range webhook.Events {
+		if wanted == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (notifier *Notifier) sendWebhook(webhook config.Webhook, n Notification) {
+	body, err := webhookBody(webhook, n)
+	if err != nil {
+		slog.Error("Unable to build webhook body", "url", webhook.URL, "error", err)
+		return
+	}
+
+	headers := make(map[string]string, len(webhook.Headers))
+	for name, value := range webhook.Headers {
+		headers[name] = secret(value)
+	}
+	if err = notifier.postBody(secret(webhook.URL), webhook.ContentType, headers, body); err != nil {
+		slog.Error("Unable to send webhook", "url", webhook.URL, "event", n.Event, "error", err)
+	}
+}
+
+// postBody posts body to url through the configured proxy, as JSON unless
+// another contentType is given.
+func (notifier *Notifier) postBody(url string, contentType string, headers map[string]string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	request.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	client, err := proxy.NewClient(notifier.config())
+	if err != nil {
+		return err
+	}
+	client.Timeout = webhookTimeout
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("Endpoint answered %s", response.Status)
+	}
+	return nil
+}
+
+func webhookBody(webhook config.Webhook, n Notification) ([]byte, error) {
+	if webhook.Template == "" {
+		return json.Marshal(n)
+	}
+	tmpl, err := template.New("webhook").Parse(webhook.Template)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, n)
+	return body.Bytes(), err
+}
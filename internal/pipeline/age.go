@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+)
+
+// tooOld reports whether the file at path, with info, was last modified
+// longer ago than the MaxAgeDays of its folder.
+func (p *Pipeline) tooOld(path string, info os.FileInfo) bool {
+	age := p.config().FileAgeForFolder(filepath.Dir(path))
+	if age == nil || age.MaxAgeDays <= 0 {
+		return false
+	}
+	return p.clock().Now().Sub(info.ModTime()) > time.Duration(age.MaxAgeDays)*24*time.Hour
+}
+
+// youngFor returns how long the file of job has to stay unmodified before
+// reaching the MinAgeMinutes of its folder, 0 when it has.
+func (p *Pipeline) youngFor(job *queue.Job) time.Duration {
+	age := p.config().FileAgeForFolder(filepath.Dir(job.Path))
+	if age == nil || age.MinAgeMinutes <= 0 {
+		return 0
+	}
+	info, err := p.fs().Stat(job.Path)
+	if err != nil {
+		// the upload reports it
+		return 0
+	}
+	left := time.Duration(age.MinAgeMinutes)*time.Minute - p.clock().Now().Sub(info.ModTime())
+	return max(left, 0)
+}
+
+// waitAge queues job again after delay, once its file is old enough,
+// unless the worker or the job is cancelled first. A file modified
+// meanwhile waits again.
+func (p *Pipeline) waitAge(workerCtx context.Context, job *queue.Job, delay time.Duration) {
+	slog.Debug("File modified recently, waiting before uploading it", "file", job.Path, "delay", delay)
+	var cancelled <-chan struct{} // nil, never ready, without a job context
+	if job.Context != nil {
+		cancelled = job.Context.Done()
+	}
+	go func() {
+		select {
+		case <-p.clock().After(delay):
+			if p.Queue.Push(job) {
+				p.Events.Publish(events.FileQueued{Path: job.Path, Priority: int(job.Priority)})
+			}
+		case <-workerCtx.Done():
+			job.Finish(history.ActionFail, workerCtx.Err())
+		case <-cancelled:
+			job.Finish(history.ActionFail, job.Context.Err())
+		}
+	}()
+}
@@ -0,0 +1,251 @@
+package pipeline
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+	"github.com/amcereijo/EncryptBckDocs/internal/tracing"
+)
+
+// defaultArchiveFullEvery is how many archives of a folder make a cycle,
+// a full one and the incremental ones after it.
+const defaultArchiveFullEvery = 7
+
+// archives are called <folder name>.<UTC time>.L<level>.tar.gz.enc, the
+// ones made before they were encrypted end with .tar.gz
+const (
+	archiveTimeFormat     = "20060102-150405"
+	archiveExtension      = ".tar.gz" + encryptExtension
+	plainArchiveExtension = ".tar.gz"
+)
+
+// IsArchive reports whether the Drive file called name is an archive of
+// the watched folder.
+func IsArchive(folder string, name string) bool {
+	_, _, ok := ParseArchive(folder, name)
+	return ok
+}
+
+// ParseArchive returns when the archive of the watched folder called name
+// was made and its level, false when name is not one of its archives.
+func ParseArchive(folder string, name string) (time.Time, int, bool) {
+	rest, ok := strings.CutPrefix(name, filepath.Base(folder)+".")
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	if trimmed, encrypted := strings.CutSuffix(rest, archiveExtension); encrypted {
+		rest = trimmed
+	} else if rest, ok = strings.CutSuffix(rest, plainArchiveExtension); !ok {
+		return time.Time{}, 0, false
+	}
+	timestamp, level, ok := strings.Cut(rest, ".L")
+	made, err := time.Parse(archiveTimeFormat, timestamp)
+	if err != nil || !ok {
+		return time.Time{}, 0, false
+	}
+	number, err := strconv.Atoi(level)
+	return made, number, err == nil
+}
+
+// archiveFolder backs up a watched folder in archive mode: its included
+// files are written to a single tar.gz, encrypted with the master key like
+// by the encrypt processor, uploaded to the destination folder
+// of its account, all of them at level 0 and at the next levels the ones
+// modified since the previous archive. Without state.db every archive is
+// a full one.
+func (p *Pipeline) archiveFolder(ctx context.Context, folder string, result *Summary) {
+	ctx, span := tracing.Tracer.Start(ctx, "archive", trace.WithAttributes(attribute.String("folder", folder)))
+	defer span.End()
+
+	var previous *state.Archive
+	if p.State != nil {
+		var err error
+		if previous, err = p.State.Archive(folder); err != nil {
+			slog.Error("Unable to read the last archive, making a full one", "folder", folder, "error", err)
+		}
+	}
+	level := 0
+	var since time.Time
+	if previous != nil && previous.Level+1 < config.IntOrDefault(p.config().ArchiveFullEvery, defaultArchiveFullEvery) {
+		level = previous.Level + 1
+		since = previous.Time
+	}
+	start := p.clock().Now()
+	name := fmt.Sprintf("%s.%s.L%d%s", filepath.Base(folder), start.UTC().Format(archiveTimeFormat), level, archiveExtension)
+	span.SetAttributes(attribute.Int("level", level))
+
+	// written aside, the size and the hash must be known before uploading
+	archive, err := os.CreateTemp("", ".archive-*")
+	if err != nil {
+		result.AddFailure(folder, fmt.Errorf("Unable to create the archive: %v", err))
+		return
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	hasher := sha256.New()
+	count, err := p.writeArchive(ctx, archive, hasher, folder, since)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Unable to archive folder", "folder", folder, "error", err)
+		}
+		result.AddFailure(folder, err)
+		return
+	}
+	if count == 0 {
+		slog.Info("No file modified since the last archive", "folder", folder, "level", level)
+		result.Add(folder, history.ActionSkip, nil)
+		return
+	}
+	size, err := archive.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = archive.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		result.AddFailure(folder, fmt.Errorf("Unable to read the archive: %v", err))
+		return
+	}
+
+	account, err := p.Accounts.ForFolder(ctx, folder)
+	if err == nil {
+		err = p.waitOnline(ctx)
+	}
+	if err == nil {
+		err = checkQuota(ctx, account.Client(), size)
+	}
+	var remoteFile *drivev3.File
+	if err == nil {
+		remoteFile, err = account.Client().CreateFile(ctx, account.Folder(), name, &contextReader{ctx: ctx, r: archive})
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if err == nil {
+		// get decrypts and decompresses the archive and checks the hash of
+		// the tar
+		props := map[string]string{}
+		drive.SetProperty(props, drive.PropertyPath, folder)
+		drive.SetProperty(props, drive.PropertyHash, hash)
+		drive.SetProperty(props, drive.PropertyProcessors, "archive,gzip,encrypt")
+		err = account.Client().SetAppProperties(ctx, remoteFile.Id, props)
+	}
+	if err != nil {
+		err = fmt.Errorf("Unable to upload the archive %s: %v", name, err)
+		if ctx.Err() == nil {
+			p.Events.Publish(events.UploadFailed{Path: folder, Err: err, Backend: true})
+		}
+		result.Add(folder, history.ActionFail, err)
+		return
+	}
+
+	if p.State != nil {
+		err = p.State.PutArchive(folder, state.Archive{Name: name, RemoteID: remoteFile.Id, Level: level, Time: start})
+		if err != nil {
+			slog.Error("Unable to save the archive state, the next archive is a full one", "folder", folder, "error", err)
+		}
+	}
+	p.updateLastUpdate()
+	slog.Info("Archived folder", "folder", folder, "archive", name, "level", level, "files", count)
+	p.Events.Publish(events.UploadSucceeded{
+		Path:         folder,
+		Folder:       folder,
+		Action:       history.ActionUpload,
+		RemoteID:     remoteFile.Id,
+		RemoteFolder: account.Folder().Name,
+		Hash:         hash,
+		Size:         size,
+		Duration:     p.clock().Now().Sub(start),
+	})
+	result.Add(folder, history.ActionUpload, nil)
+}
+
+// writeArchive writes to w the encrypted tar.gz of the included files of
+// folder modified from since on, hashing the tar in hasher. It returns how
+// many files it holds.
+func (p *Pipeline) writeArchive(ctx context.Context, w io.Writer, hasher io.Writer, folder string, since time.Time) (int, error) {
+	encrypted, err := auth.EncryptWriter(w)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to encrypt the archive: %v", err)
+	}
+	gz := gzip.NewWriter(encrypted)
+	tw := tar.NewWriter(io.MultiWriter(gz, hasher))
+	count := 0
+	err = p.fs().WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filepath.Clean(path) == filepath.Clean(folder) {
+				return nil
+			}
+			// subfolders are not watched
+			return filepath.SkipDir
+		}
+		if !p.Included(path) || p.skipped(path) != "" {
+			return nil
+		}
+		file, err := p.fs().Open(path)
+		if os.IsNotExist(err) {
+			// deleted while archiving
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || info.ModTime().Before(since) {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.Base(path)
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		// a file growing while archived keeps its size when it started
+		if _, err = io.CopyN(tw, &contextReader{ctx: ctx, r: file}, header.Size); err == io.EOF {
+			return fmt.Errorf("%s shrank while archived", path)
+		} else if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = encrypted.Close()
+	}
+	return count, err
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+func TestParseArchive(t *testing.T) {
+	folder := filepath.Join("home", "me", "Mail")
+	made := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		level int
+		ok    bool
+	}{
+		{"Mail.20240501-093000.L0.tar.gz.enc", 0, true},
+		{"Mail.20240501-093000.L3.tar.gz.enc", 3, true},
+		// made before the archives were encrypted
+		{"Mail.20240501-093000.L2.tar.gz", 2, true},
+		{"Mail.20240501-093000.L0.tar", 0, false},
+		{"Other.20240501-093000.L0.tar.gz.enc", 0, false},
+		{"Mail.yesterday.L0.tar.gz.enc", 0, false},
+	}
+	for _, test := range tests {
+		when, level, ok := ParseArchive(folder, test.name)
+		if ok != test.ok || ok && (level != test.level || !when.Equal(made)) {
+			t.Errorf("ParseArchive(%q) = %v, %d, %v, want %v, %d, %v", test.name, when, level, ok, made, test.level, test.ok)
+		}
+	}
+}
+
+func TestWriteArchiveEncrypts(t *testing.T) {
+	fs := newFakeFS()
+	folder := filepath.Join(t.TempDir(), "Mail")
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fs.write(filepath.Join(folder, "inbox.mbox"), "secret mail", modTime)
+	fs.write(filepath.Join(folder, "sent.mbox"), "secret answer", modTime)
+	p := &Pipeline{Config: &config.Config{FolderToWatch: []string{folder}}, FS: fs}
+
+	var archive bytes.Buffer
+	hasher := sha256.New()
+	count, err := p.writeArchive(context.Background(), &archive, hasher, folder, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("archived %d files, want 2", count)
+	}
+	if bytes.Contains(archive.Bytes(), []byte("inbox")) || bytes.Contains(archive.Bytes(), []byte("secret")) {
+		t.Error("the names or contents of the files are readable in the archive, want it encrypted")
+	}
+
+	name, body, err := Unprocess("Mail.20240501-120000.L0.tar.gz.enc", []string{"archive", "gzip", "encrypt"}, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Mail.20240501-120000.L0.tar" {
+		t.Errorf("restored as %s, want the tar", name)
+	}
+	tarHash := sha256.New()
+	reader := tar.NewReader(io.TeeReader(body, tarHash))
+	contents := map[string]string{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(reader)
+		contents[header.Name] = string(content)
+	}
+	io.Copy(io.Discard, body)
+	if contents["inbox.mbox"] != "secret mail" || contents["sent.mbox"] != "secret answer" {
+		t.Errorf("archive holds %v, want both files", contents)
+	}
+	if hex.EncodeToString(tarHash.Sum(nil)) != hex.EncodeToString(hasher.Sum(nil)) {
+		t.Error("the hash kept of the archive is not the one of the tar get restores")
+	}
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"log/slog"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// scanCheckpointEvery is how many files of a folder are walked between two
+// saves of its scan checkpoint.
+const scanCheckpointEvery = 100
+
+// scanCheckpoint keeps how far the scan of a watched folder got in
+// state.db, so a scan interrupted by a crash or a shutdown, like the first
+// one of a large folder, resumes where it stopped.
+type scanCheckpoint struct {
+	p      *Pipeline
+	folder string
+	scan   state.Scan
+	// resumed is the checkpoint of the interrupted scan, nil for a new one.
+	resumed *state.Scan
+	walked  int
+}
+
+// startScan starts the scan of folder, resuming the interrupted one.
+func (p *Pipeline) startScan(folder string) *scanCheckpoint {
+	checkpoint := &scanCheckpoint{p: p, folder: folder, scan: state.Scan{Started: p.clock().Now()}}
+	if p.State == nil {
+		return checkpoint
+	}
+	resumed, err := p.State.Scan(folder)
+	if err != nil {
+		slog.Error("Unable to read the scan checkpoint", "folder", folder, "error", err)
+	} else if resumed != nil {
+		slog.Info("Resuming interrupted scan", "folder", folder, "started", resumed.Started, "after", resumed.Path)
+		checkpoint.resumed = resumed
+		checkpoint.scan = *resumed
+	}
+	checkpoint.save()
+	return checkpoint
+}
+
+// done reports whether the interrupted scan backed up the file at path:
+// reached before it stopped and uploaded since it started. Those files are
+// not even looked at again.
+func (c *scanCheckpoint) done(path string) bool {
+	if c.resumed == nil || path > c.resumed.Path {
+		return false
+	}
+	known, err := c.p.State.Get(path)
+	return err == nil && known != nil && !known.LastUpload.Before(c.resumed.Started)
+}
+
+// reached records that the scan got to the file at path, saved every
+// scanCheckpointEvery files.
+func (c *scanCheckpoint) reached(path string) {
+	if path > c.scan.Path {
+		c.scan.Path = path
+	}
+	c.walked++
+	if c.walked%scanCheckpointEvery == 0 {
+		c.save()
+	}
+}
+
+// finish forgets the checkpoint once every file of the scan is uploaded.
+func (c *scanCheckpoint) finish() {
+	if c.p.State == nil {
+		return
+	}
+	if err := c.p.State.DeleteScan(c.folder); err != nil {
+		slog.Error("Unable to delete the scan checkpoint", "folder", c.folder, "error", err)
+	}
+}
+
+func (c *scanCheckpoint) save() {
+	if c.p.State == nil {
+		return
+	}
+	if err := c.p.State.PutScan(c.folder, c.scan); err != nil {
+		slog.Error("Unable to save the scan checkpoint", "folder", c.folder, "error", err)
+	}
+}
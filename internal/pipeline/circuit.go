@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"log/slog"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+)
+
+// defaultCircuitFailures is how many uploads in a row failing in Drive
+// open the circuit.
+const defaultCircuitFailures = 5
+
+// circuitProbeInterval is how often Drive is tried while the circuit is
+// open.
+const circuitProbeInterval = time.Minute
+
+// holdCircuit is the reason the uploads are held while Drive keeps failing.
+const holdCircuit = "circuit"
+
+// CircuitOpen reports whether the uploads wait because Drive kept failing,
+// until it answers again.
+func (p *Pipeline) CircuitOpen() bool {
+	return p.heldFor() == holdCircuit
+}
+
+// backendResult counts the uploads to account failing in Drive in a row,
+// err being nil for a successful one. Once they reach CircuitFailures the
+// circuit opens: the uploads wait, changes still being queued, until Drive
+// answers a probe, instead of retrying every file. It reports whether the
+// circuit opened.
+func (p *Pipeline) backendResult(ctx context.Context, account *drive.Account, err error) bool {
+	p.offlineMutex.Lock()
+	if err == nil {
+		p.failures = 0
+		p.offlineMutex.Unlock()
+		return false
+	}
+	p.failures++
+	failures := p.failures
+	open := failures >= config.IntOrDefault(p.config().CircuitFailures, defaultCircuitFailures)
+	if open {
+		p.failures = 0
+	}
+	p.offlineMutex.Unlock()
+	if !open {
+		return false
+	}
+
+	p.hold(ctx, holdCircuit, circuitProbeInterval, func(ctx context.Context) bool {
+		_, err := account.Client().GetFile(ctx, account.Folder().Id)
+		if err != nil {
+			slog.Debug("Drive still failing", "error", err)
+			return false
+		}
+		return true
+	}, events.CircuitChanged{Open: true, Failures: failures, Err: err.Error()}, events.CircuitChanged{Open: false})
+	return true
+}
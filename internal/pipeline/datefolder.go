@@ -0,0 +1,54 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/media"
+)
+
+// dateFolderLayout names the subfolders of the photos and videos of the
+// folders organized by date.
+const dateFolderLayout = "2006/01"
+
+// uploadFolder returns the Drive folder the file at path is uploaded to
+// and the name it is uploaded with, before the processors. A file keeps
+// its name in the destination folder, unless it is a photo or video of a
+// folder organized by date, going to the YYYY/MM subfolder of the date it
+// was taken, or its folder has a remote template. The date of a file that
+// does not say when it was taken is its modification date. goFile is read
+// back from its start.
+func (p *Pipeline) uploadFolder(ctx context.Context, account *drive.Account, folder *drivev3.File, path string, goFile File) (*drivev3.File, string, error) {
+	dated := p.config().DateFolder(filepath.Dir(path)) && media.IsMedia(path)
+	template := p.config().TemplateForFolder(filepath.Dir(path))
+	if !dated && template == "" {
+		return folder, filepath.Base(path), nil
+	}
+	info, err := goFile.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	taken := info.ModTime()
+	if media.IsMedia(path) {
+		if captured, ok := media.CaptureTime(path, goFile); ok {
+			taken = captured
+		}
+		if _, err := goFile.Seek(0, io.SeekStart); err != nil {
+			return nil, "", fmt.Errorf("Unable to read file again: %v", err)
+		}
+	}
+
+	folderPath, name := taken.Format(dateFolderLayout), filepath.Base(path)
+	if !dated {
+		if folderPath, name, err = expandTemplate(template, path, taken); err != nil {
+			return nil, "", err
+		}
+	}
+	parent, err := p.Accounts.EnsureFolderPath(ctx, account, folder, folderPath)
+	return parent, name, err
+}
@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// devicePropertyPrefix starts the appProperties of a destination folder
+// telling when each machine backing up to it last started,
+// device.<hostname>.
+const devicePropertyPrefix = "device."
+
+// deviceActivity is how long a machine is taken as backing up to a folder
+// after it last started.
+const deviceActivity = 30 * 24 * time.Hour
+
+// hostname returns the name of this machine, as written in the
+// appProperties, cut to fit in a property key.
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	// room for the prefix and an RFC 3339 date
+	if limit := 124 - len(devicePropertyPrefix) - len(time.RFC3339); len(host) > limit {
+		host = host[:limit]
+	}
+	return host
+}
+
+// RegisterDevice marks the destination folder of account as backed up by
+// this machine and returns the other machines that started backing up to
+// it lately, to warn about them. Whatever the machines found, the uploads
+// check which machine last wrote the Drive files they update, as another
+// one may start backing up to the folder later: a file another machine
+// backed up with the same content is not uploaded again, and one with
+// another content is uploaded beside it, named after this machine, instead
+// of the two machines overwriting each other's versions.
+func (p *Pipeline) RegisterDevice(ctx context.Context, account *drive.Account) ([]string, error) {
+	client := account.Client()
+	folder, err := client.GetFile(ctx, account.Folder().Id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the machines of the destination folder: %v", err)
+	}
+	host, now := hostname(), p.clock().Now()
+	var others []string
+	for key, value := range folder.AppProperties {
+		name, ok := strings.CutPrefix(key, devicePropertyPrefix)
+		if !ok || name == host {
+			continue
+		}
+		if seen, err := time.Parse(time.RFC3339, value); err == nil && now.Sub(seen) < deviceActivity {
+			others = append(others, name)
+		}
+	}
+	slices.Sort(others)
+	err = client.SetAppProperties(ctx, folder.Id, map[string]string{devicePropertyPrefix + host: now.UTC().Format(time.RFC3339)})
+	if err != nil {
+		return others, fmt.Errorf("Unable to mark the destination folder as backed up by this machine: %v", err)
+	}
+	return others, nil
+}
+
+// claimRemoteFile decides what to do with the Drive file remoteFile, called
+// name, that the file at path would update, in case another machine backs
+// up to the same folder. It returns whether the upload is done already, the
+// machine that last wrote remoteFile having backed up the same content,
+// and otherwise the file to update and the name to upload with:
+// remoteFile and name when this machine wrote it last, or the file named
+// after this machine beside it. local is the state of the file at path,
+// saved with the hash and remoteFile when the upload is done already.
+func (p *Pipeline) claimRemoteFile(ctx context.Context, client drive.Client, path string, name string, parentFolder *drivev3.File, remoteFile *drivev3.File, local state.File) (bool, *drivev3.File, string, error) {
+	props := remoteFile.AppProperties
+	if props == nil {
+		// found by its cached ID alone
+		file, err := client.GetFile(ctx, remoteFile.Id)
+		if err != nil {
+			return false, nil, "", err
+		}
+		props = file.AppProperties
+	}
+	writer := drive.Property(props, drive.PropertyHost)
+	if writer == "" || writer == hostname() {
+		return false, remoteFile, name, nil
+	}
+
+	hash, err := p.hashFile(path)
+	if err != nil {
+		return false, nil, "", err
+	}
+	if hash == drive.Property(props, drive.PropertyHash) {
+		slog.Debug("File already backed up by another machine", "file", path, "machine", writer)
+		local.Hash = hash
+		local.RemoteID = remoteFile.Id
+		local.LastUpload = p.clock().Now()
+		p.saveState(path, local)
+		return true, nil, "", nil
+	}
+
+	deviceName := deviceFileName(name, hostname())
+	slog.Warn("File backed up by another machine with another content, keeping this version beside it",
+		"file", path, "machine", writer, "name", deviceName)
+	files, err := client.FindFiles(ctx, deviceName, parentFolder.Id)
+	if err != nil {
+		return false, nil, "", err
+	}
+	return false, drive.PreferFile(files, p.knownRemoteID), deviceName, nil
+}
+
+// hashFile returns the SHA-256 of the file at path.
+func (p *Pipeline) hashFile(path string) (string, error) {
+	f, err := p.fs().Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open file: %v", err)
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", fmt.Errorf("Unable to read file: %v", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// deviceFileName returns name with host before its extensions, like
+// "report (laptop).odt.gz".
+func deviceFileName(name string, host string) string {
+	base, extensions := name, ""
+	if i := strings.Index(name[1:], "."); i >= 0 {
+		// a leading dot is part of the name of hidden files
+		base, extensions = name[:i+1], name[i+1:]
+	}
+	return base + " (" + host + ")" + extensions
+}
@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// executableExtensions are programs, libraries and scripts whatever their
+// permissions, Windows running them by their extension.
+var executableExtensions = []string{".exe", ".dll", ".com", ".scr", ".msi", ".bat", ".cmd", ".ps1", ".vbs", ".so", ".dylib"}
+
+// executableMagics start the programs of Linux, ELF, and macOS, Mach-O,
+// and the scripts, with their interpreter.
+var executableMagics = [][]byte{
+	[]byte("\x7fELF"),
+	{0xFE, 0xED, 0xFA, 0xCE}, {0xFE, 0xED, 0xFA, 0xCF},
+	{0xCE, 0xFA, 0xED, 0xFE}, {0xCF, 0xFA, 0xED, 0xFE},
+	{0xCA, 0xFE, 0xBA, 0xBE},
+	[]byte("#!"),
+}
+
+// isExecutable reports whether the file at path, with info, is a program
+// or a script: it has one of the executableExtensions, or it is marked
+// executable and starts like one, so documents copied from a disk marking
+// every file executable are not taken for programs.
+func (p *Pipeline) isExecutable(path string, info os.FileInfo) bool {
+	if slices.Contains(executableExtensions, strings.ToLower(filepath.Ext(path))) {
+		return true
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return false
+	}
+	file, err := p.fs().Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	header := make([]byte, 4)
+	n, _ := io.ReadFull(file, header)
+	for _, magic := range executableMagics {
+		if bytes.HasPrefix(header[:n], magic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,364 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// errNotImplemented is returned by the calls the fakes do not support.
+var errNotImplemented = errors.New("not implemented by the fake")
+
+// fakeClient is an in-memory drive.Client.
+type fakeClient struct {
+	mutex    sync.Mutex
+	files    map[string]*drivev3.File
+	contents map[string][]byte
+	nextID   int
+	// calls are the methods called, in order.
+	calls []string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{files: map[string]*drivev3.File{}, contents: map[string][]byte{}}
+}
+
+// add stores a file called name in the folder parentID.
+func (c *fakeClient) add(name string, parentID string, content []byte, props map[string]string) *drivev3.File {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.addLocked(name, parentID, content, props)
+}
+
+func (c *fakeClient) addLocked(name string, parentID string, content []byte, props map[string]string) *drivev3.File {
+	c.nextID++
+	file := &drivev3.File{Id: fmt.Sprintf("id%d", c.nextID), Name: name, Parents: []string{parentID}, AppProperties: props}
+	c.files[file.Id] = file
+	c.contents[file.Id] = content
+	return file
+}
+
+func (c *fakeClient) call(name string) {
+	c.calls = append(c.calls, name)
+}
+
+// copyFile returns a copy of file, so callers do not share the stored one.
+func copyFile(file *drivev3.File) *drivev3.File {
+	copied := *file
+	if file.AppProperties != nil {
+		copied.AppProperties = map[string]string{}
+		for key, value := range file.AppProperties {
+			copied.AppProperties[key] = value
+		}
+	}
+	return &copied
+}
+
+func notFound(fileID string) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: "File not found: " + fileID}
+}
+
+func (c *fakeClient) FindFolder(ctx context.Context, folderName string, parentID string) (*drivev3.File, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("FindFolder")
+	for _, file := range c.files {
+		if file.Name == folderName && file.MimeType == "application/vnd.google-apps.folder" && (parentID == "" || file.Parents[0] == parentID) {
+			return copyFile(file), nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *fakeClient) CreateFolder(ctx context.Context, folderName string, parentID string) (*drivev3.File, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("CreateFolder")
+	folder := c.addLocked(folderName, parentID, nil, nil)
+	folder.MimeType = "application/vnd.google-apps.folder"
+	return copyFile(folder), nil
+}
+
+func (c *fakeClient) FindFiles(ctx context.Context, fileName string, parentID string) ([]*drivev3.File, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("FindFiles")
+	var files []*drivev3.File
+	for _, file := range c.files {
+		if file.Name == fileName && file.Parents[0] == parentID {
+			files = append(files, copyFile(file))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Id < files[j].Id })
+	return files, nil
+}
+
+func (c *fakeClient) GetFile(ctx context.Context, fileID string) (*drivev3.File, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("GetFile")
+	file, ok := c.files[fileID]
+	if !ok {
+		return nil, notFound(fileID)
+	}
+	return copyFile(file), nil
+}
+
+func (c *fakeClient) CreateFile(ctx context.Context, folderFile *drivev3.File, fileName string, r io.Reader) (*drivev3.File, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("CreateFile")
+	return copyFile(c.addLocked(fileName, folderFile.Id, content, nil)), nil
+}
+
+func (c *fakeClient) UpdateFile(ctx context.Context, file *drivev3.File, r io.Reader) (*drivev3.File, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("UpdateFile")
+	stored, ok := c.files[file.Id]
+	if !ok {
+		return nil, notFound(file.Id)
+	}
+	c.contents[file.Id] = content
+	return copyFile(stored), nil
+}
+
+func (c *fakeClient) ListFiles(ctx context.Context, parentID string) ([]*drivev3.File, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("ListFiles")
+	var files []*drivev3.File
+	for _, file := range c.files {
+		if file.Parents[0] == parentID {
+			files = append(files, copyFile(file))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Id < files[j].Id })
+	return files, nil
+}
+
+func (c *fakeClient) Download(ctx context.Context, fileID string, w io.Writer) error {
+	c.mutex.Lock()
+	content, ok := c.contents[fileID]
+	c.call("Download")
+	c.mutex.Unlock()
+	if !ok {
+		return notFound(fileID)
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+func (c *fakeClient) MoveFile(ctx context.Context, file *drivev3.File, name string, parentID string) (*drivev3.File, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("MoveFile")
+	stored, ok := c.files[file.Id]
+	if !ok {
+		return nil, notFound(file.Id)
+	}
+	stored.Name = name
+	stored.Parents = []string{parentID}
+	return copyFile(stored), nil
+}
+
+func (c *fakeClient) TrashFile(ctx context.Context, fileID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("TrashFile")
+	if _, ok := c.files[fileID]; !ok {
+		return notFound(fileID)
+	}
+	delete(c.files, fileID)
+	delete(c.contents, fileID)
+	return nil
+}
+
+func (c *fakeClient) SetAppProperties(ctx context.Context, fileID string, props map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.call("SetAppProperties")
+	file, ok := c.files[fileID]
+	if !ok {
+		return notFound(fileID)
+	}
+	if file.AppProperties == nil {
+		file.AppProperties = map[string]string{}
+	}
+	for key, value := range props {
+		if value == "" {
+			delete(file.AppProperties, key)
+		} else {
+			file.AppProperties[key] = value
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) ListRevisions(ctx context.Context, fileID string) ([]*drivev3.Revision, error) {
+	return nil, errNotImplemented
+}
+
+func (c *fakeClient) GetRevision(ctx context.Context, fileID string, revisionID string) (*drivev3.Revision, error) {
+	return nil, errNotImplemented
+}
+
+func (c *fakeClient) DownloadRevision(ctx context.Context, fileID string, revisionID string, w io.Writer) error {
+	return errNotImplemented
+}
+
+func (c *fakeClient) KeepRevision(ctx context.Context, fileID string, revisionID string) error {
+	return errNotImplemented
+}
+
+func (c *fakeClient) DeleteRevision(ctx context.Context, fileID string, revisionID string) error {
+	return errNotImplemented
+}
+
+func (c *fakeClient) Quota(ctx context.Context) (*drivev3.AboutStorageQuota, error) {
+	return &drivev3.AboutStorageQuota{}, nil
+}
+
+func (c *fakeClient) StartUpload(ctx context.Context, folderFile *drivev3.File, fileName string, file *drivev3.File) (string, error) {
+	return "", errNotImplemented
+}
+
+func (c *fakeClient) ResumeUpload(ctx context.Context, uri string, r io.Reader, checkpoint func(offset int64)) (*drivev3.File, error) {
+	return nil, errNotImplemented
+}
+
+// content returns what the file fileID holds.
+func (c *fakeClient) content(fileID string) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.contents[fileID]
+}
+
+// called returns how many times method was called.
+func (c *fakeClient) called(method string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for _, call := range c.calls {
+		if call == method {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeFS is an in-memory FileSystem.
+type fakeFS struct {
+	files map[string]*fakeInfo
+}
+
+func newFakeFS() *fakeFS {
+	return &fakeFS{files: map[string]*fakeInfo{}}
+}
+
+// write stores a file at path, modified at modTime.
+func (f *fakeFS) write(path string, content string, modTime time.Time) {
+	f.files[path] = &fakeInfo{name: filepath.Base(path), content: []byte(content), modTime: modTime}
+}
+
+func (f *fakeFS) Open(name string) (File, error) {
+	info, ok := f.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &fakeFile{Reader: bytes.NewReader(info.content), info: info}, nil
+}
+
+func (f *fakeFS) Stat(name string) (os.FileInfo, error) {
+	info, ok := f.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return info, nil
+}
+
+func (f *fakeFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	var paths []string
+	for path := range f.files {
+		if strings.HasPrefix(path, root+string(filepath.Separator)) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := fn(path, fs.FileInfoToDirEntry(f.files[path]), nil); err != nil && err != fs.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakeFile is a file open from a fakeFS.
+type fakeFile struct {
+	*bytes.Reader
+	info *fakeInfo
+}
+
+func (f *fakeFile) Close() error {
+	return nil
+}
+
+func (f *fakeFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// fakeInfo describes a file of a fakeFS.
+type fakeInfo struct {
+	name    string
+	content []byte
+	modTime time.Time
+}
+
+func (i *fakeInfo) Name() string       { return i.name }
+func (i *fakeInfo) Size() int64        { return int64(len(i.content)) }
+func (i *fakeInfo) Mode() os.FileMode  { return 0600 }
+func (i *fakeInfo) ModTime() time.Time { return i.modTime }
+func (i *fakeInfo) IsDir() bool        { return false }
+func (i *fakeInfo) Sys() any           { return nil }
+
+// fakeClock is a Clock stopped at now, moved forward by After.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	after := make(chan time.Time, 1)
+	after <- c.now
+	return after
+}
@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+)
+
+// Included reports whether the file at path is uploaded: files of the app
+// itself and files matching the Exclude patterns never are, hidden files
+// only when IncludeHidden is set. Scanning and watching the folders use it
+// alike.
+func (p *Pipeline) Included(path string) bool {
+	if p.isAppFile(path) || p.isExcluded(path) {
+		return false
+	}
+	return p.config().IncludeHidden || !p.isHidden(path)
+}
+
+// isAppFile reports whether path is one of the AppFiles, or is in one of
+// their folders.
+func (p *Pipeline) isAppFile(path string) bool {
+	path = absolutePath(path)
+	for _, appFile := range p.AppFiles {
+		if appFile == "" {
+			continue
+		}
+		if appFile = absolutePath(appFile); appFile == path || strings.HasPrefix(path, appFile+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded reports whether path matches one of the Exclude patterns, a
+// pattern with a separator matching the whole path and the others the
+// file name.
+func (p *Pipeline) isExcluded(path string) bool {
+	path = absolutePath(path)
+	for _, pattern := range p.config().Exclude {
+		name := filepath.Base(path)
+		if strings.ContainsAny(pattern, `/\`) {
+			pattern, name = filepath.Clean(pattern), path
+		}
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// absolutePath returns path made absolute, as it is when that fails.
+func absolutePath(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+	return path
+}
+
+// isHidden reports whether the file at path, or one of the folders it is
+// in below its watched folder, starts with a dot, or whether the file has
+// the hidden attribute on Windows. The folders above the watched folder do
+// not count, watching a folder in ~/.config backs it up.
+func (p *Pipeline) isHidden(path string) bool {
+	// relative to the closest watched folder, a file outside of them only
+	// by its name
+	relative := ""
+	for _, folder := range p.config().FolderToWatch {
+		rel, err := filepath.Rel(folder, path)
+		if err == nil && !strings.HasPrefix(rel, "..") && (relative == "" || len(rel) < len(relative)) {
+			relative = rel
+		}
+	}
+	if relative == "" {
+		relative = filepath.Base(path)
+	}
+	return isDotFile(relative) || p.hasHiddenAttribute(path)
+}
+
+// isDotFile reports whether a part of the relative path starts with a dot.
+// Both separators count on Windows.
+func isDotFile(relative string) bool {
+	return strings.HasPrefix(relative, ".") || strings.Contains(filepath.ToSlash(relative), "/.")
+}
+
+// skipped returns why the file at path is left out when it is about
+// to be uploaded: an empty file when SkipEmptyFiles is set, a file older
+// than the MaxAgeDays of its folder, a program or a script in a document
+// folder, or an online-only placeholder of a cloud sync client, which
+// reading would download, unless UploadPlaceholders is set.
+// It returns "" when the file is uploaded.
+func (p *Pipeline) skipped(path string) string {
+	info, err := p.fs().Stat(path)
+	if err != nil {
+		// opening the file reports it
+		return ""
+	}
+	if info.Size() == 0 && p.config().SkipEmptyFiles {
+		slog.Debug("Empty file skipped", "file", path)
+		return events.SkipEmpty
+	}
+	if p.tooOld(path, info) {
+		slog.Debug("File not modified recently enough, skipped", "file", path)
+		return events.SkipTooOld
+	}
+	if p.config().DocumentFolder(filepath.Dir(path)) && p.isExecutable(path, info) {
+		slog.Debug("Executable file skipped in a document folder", "file", path)
+		return events.SkipExecutable
+	}
+	if isPlaceholder(info) && !p.config().UploadPlaceholders {
+		slog.Warn("Online-only file skipped, make it available offline to back it up", "file", path)
+		return events.SkipPlaceholder
+	}
+	return ""
+}
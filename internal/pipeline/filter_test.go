@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+func TestIsHidden(t *testing.T) {
+	root := t.TempDir()
+	watched := filepath.Join(root, ".config", "app")
+	p := &Pipeline{Config: &config.Config{FolderToWatch: []string{watched}}}
+
+	tests := []struct {
+		path   string
+		hidden bool
+	}{
+		{filepath.Join(watched, "settings.json"), false},
+		{filepath.Join(watched, ".secret"), true},
+		{filepath.Join(watched, ".git", "config"), true},
+		{filepath.Join(watched, "sub", ".cache", "file"), true},
+		{filepath.Join(watched, "sub", "file.txt"), false},
+		// outside of the watched folders only the name counts
+		{filepath.Join(root, ".other", "file.txt"), false},
+		{filepath.Join(root, "other", ".file"), true},
+	}
+	for _, test := range tests {
+		if hidden := p.isHidden(test.path); hidden != test.hidden {
+			t.Errorf("isHidden(%q) = %v, want %v", test.path, hidden, test.hidden)
+		}
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	root := t.TempDir()
+	p := &Pipeline{Config: &config.Config{Exclude: []string{
+		"*.tmp",
+		"~$*",
+		filepath.Join(root, "docs", "private", "*"),
+	}}}
+
+	tests := []struct {
+		path     string
+		excluded bool
+	}{
+		{filepath.Join(root, "docs", "report.tmp"), true},
+		{filepath.Join(root, "docs", "deep", "cache.tmp"), true},
+		{filepath.Join(root, "docs", "~$report.docx"), true},
+		{filepath.Join(root, "docs", "report.docx"), false},
+		{filepath.Join(root, "docs", "private", "keys.txt"), true},
+		// a pattern with a separator matches the whole path
+		{filepath.Join(root, "docs", "private", "sub", "keys.txt"), false},
+		{filepath.Join(root, "other", "private", "keys.txt"), false},
+	}
+	for _, test := range tests {
+		if excluded := p.isExcluded(test.path); excluded != test.excluded {
+			t.Errorf("isExcluded(%q) = %v, want %v", test.path, excluded, test.excluded)
+		}
+	}
+}
+
+func TestIncluded(t *testing.T) {
+	root := t.TempDir()
+	watched := filepath.Join(root, "docs")
+	configFile := filepath.Join(watched, "config.json")
+	p := &Pipeline{
+		Config:   &config.Config{FolderToWatch: []string{watched}, Exclude: []string{"*.tmp"}},
+		AppFiles: []string{configFile, filepath.Join(watched, "backups"), ""},
+	}
+
+	tests := []struct {
+		path     string
+		included bool
+	}{
+		{filepath.Join(watched, "report.docx"), true},
+		{filepath.Join(watched, "sub", "photo.jpg"), true},
+		{filepath.Join(watched, "report.tmp"), false},
+		{filepath.Join(watched, ".hidden"), false},
+		{configFile, false},
+		{filepath.Join(watched, "backups", "config.json.1"), false},
+		// only the folder itself is an app file, not its prefix
+		{filepath.Join(watched, "backups-old", "file.txt"), true},
+	}
+	for _, test := range tests {
+		if included := p.Included(test.path); included != test.included {
+			t.Errorf("Included(%q) = %v, want %v", test.path, included, test.included)
+		}
+	}
+
+	p.Config.IncludeHidden = true
+	if hidden := filepath.Join(watched, ".hidden"); !p.Included(hidden) {
+		t.Errorf("Included(%q) = false with IncludeHidden, want true", hidden)
+	}
+}
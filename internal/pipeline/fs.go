@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSystem is where the watched folders are read from, so tests can
+// replace the local disk.
+type FileSystem interface {
+	Open(name string) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	// WalkDir walks the tree at root like filepath.WalkDir, following
+	// root when it is a symbolic link.
+	WalkDir(root string, fn fs.WalkDirFunc) error
+}
+
+// File is an open local file.
+type File interface {
+	io.ReadSeekCloser
+	Stat() (os.FileInfo, error)
+}
+
+// Clock tells the time, so tests can control it.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// osFileSystem is the local disk.
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	// the trailing separator makes WalkDir follow a linked root
+	return filepath.WalkDir(root+string(filepath.Separator), fn)
+}
+
+// systemClock is the wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// fs returns the FileSystem of the pipeline, the local disk by default.
+func (p *Pipeline) fs() FileSystem {
+	if p.FS == nil {
+		return osFileSystem{}
+	}
+	return p.FS
+}
+
+// clock returns the Clock of the pipeline, the wall clock by default.
+func (p *Pipeline) clock() Clock {
+	if p.Clock == nil {
+		return systemClock{}
+	}
+	return p.Clock
+}
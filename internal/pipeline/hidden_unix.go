@@ -0,0 +1,9 @@
+//go:build !windows
+
+package pipeline
+
+// hasHiddenAttribute returns false, only the name hides a file outside
+// Windows.
+func (p *Pipeline) hasHiddenAttribute(path string) bool {
+	return false
+}
@@ -0,0 +1,14 @@
+package pipeline
+
+import "syscall"
+
+// hasHiddenAttribute reports whether the file at path has the hidden
+// attribute.
+func (p *Pipeline) hasHiddenAttribute(path string) bool {
+	info, err := p.fs().Stat(path)
+	if err != nil {
+		return false
+	}
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && data.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
@@ -0,0 +1,8 @@
+package pipeline
+
+import "os"
+
+// fileInode returns 0, the file index is not in the FileInfo on Windows.
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,19 @@
+package pipeline
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// a master key of the tests for the encrypt processor, never the one
+	// of the user
+	dir, err := os.MkdirTemp("", "credentials")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("ENCRYPTBCKDOCS_CREDENTIALS_DIR", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
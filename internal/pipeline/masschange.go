@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+)
+
+// defaults of the mass change detection: 30% of the watched files, and at
+// least 50 of them, changed or deleted within 10 minutes
+const (
+	defaultMassChangePercent  = 30
+	defaultMassChangeMinFiles = 50
+	defaultMassChangeWindow   = 10
+)
+
+// FileRemoved counts a file deleted, renamed or moved out of a watched
+// folder for the mass change detection. Deletions are not propagated to
+// Drive.
+func (p *Pipeline) FileRemoved(ctx context.Context, path string) {
+	if p.Included(path) {
+		p.recordChange(ctx, path)
+	}
+}
+
+// recordChange counts the change of the file at path. When Config.MassChange
+// is set and a large share of the watched files changed within its window,
+// like a ransomware encrypting them, the uploads are paused before they
+// replace the backups, the Drive revisions from before the window are kept
+// forever and MassChangeDetected is published. The uploads resume once the
+// user resumes them.
+func (p *Pipeline) recordChange(ctx context.Context, path string) {
+	settings := p.config().MassChange
+	if settings == nil {
+		return
+	}
+	now := p.clock().Now()
+	window := time.Duration(config.IntOrDefault(settings.WindowMinutes, defaultMassChangeWindow)) * time.Minute
+	p.changesMutex.Lock()
+	if p.changes == nil {
+		p.changes = make(map[string]time.Time)
+	}
+	p.changes[path] = now
+	for changed, at := range p.changes {
+		if now.Sub(at) > window {
+			delete(p.changes, changed)
+		}
+	}
+	count := len(p.changes)
+	p.changesMutex.Unlock()
+	if count < config.IntOrDefault(settings.MinFiles, defaultMassChangeMinFiles) {
+		return
+	}
+
+	// deleted files are no longer there, the changes count among the
+	// files at least
+	total := max(p.watchedFiles(), count)
+	if count*100 < config.IntOrDefault(settings.Percent, defaultMassChangePercent)*total {
+		return
+	}
+	p.changesMutex.Lock()
+	var paths []string
+	for changed := range p.changes {
+		paths = append(paths, changed)
+	}
+	p.changes = nil
+	p.changesMutex.Unlock()
+	if len(paths) == 0 {
+		// detected by another change meanwhile
+		return
+	}
+
+	p.Queue.Pause()
+	p.Events.Publish(events.MassChangeDetected{Changed: len(paths), Total: total, Window: window})
+	go p.keepRevisionsBefore(ctx, paths, now.Add(-window))
+}
+
+// watchedFiles counts the included files of the watched folders.
+func (p *Pipeline) watchedFiles() int {
+	count := 0
+	for _, folder := range p.config().FolderToWatch {
+		p.fs().WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() {
+				if filepath.Clean(path) == filepath.Clean(folder) {
+					return nil
+				}
+				return filepath.SkipDir
+			}
+			if entry.Type().IsRegular() && p.Included(path) {
+				count++
+			}
+			return nil
+		})
+	}
+	return count
+}
+
+// keepRevisionsBefore keeps forever the last Drive revision modified before
+// since of the backups of the files at paths, so Drive does not remove the
+// good versions once newer ones replace them, and pins it so gc does not
+// delete it either.
+func (p *Pipeline) keepRevisionsBefore(ctx context.Context, paths []string, since time.Time) {
+	if p.State == nil {
+		return
+	}
+	kept := 0
+	for _, path := range paths {
+		known, err := p.State.Get(path)
+		if err != nil || known == nil || known.RemoteID == "" {
+			continue
+		}
+		account, err := p.Accounts.ForFolder(ctx, filepath.Dir(path))
+		if err != nil {
+			slog.Error("Unable to keep the revisions of the backup", "file", path, "error", err)
+			continue
+		}
+		revisions, err := account.Client().ListRevisions(ctx, known.RemoteID)
+		if err != nil {
+			slog.Error("Unable to keep the revisions of the backup", "file", path, "error", err)
+			continue
+		}
+		// oldest first, the last one before since is the good one
+		for i := len(revisions) - 1; i >= 0; i-- {
+			modified, err := time.Parse(time.RFC3339, revisions[i].ModifiedTime)
+			if err != nil || !modified.Before(since) {
+				continue
+			}
+			if !revisions[i].KeepForever {
+				if err = account.Client().KeepRevision(ctx, known.RemoteID, revisions[i].Id); err != nil {
+					slog.Error("Unable to keep the revisions of the backup", "file", path, "error", err)
+					break
+				}
+			}
+			if err = pinRevision(ctx, account.Client(), known.RemoteID, revisions[i].Id); err != nil {
+				slog.Error("Unable to keep the revisions of the backup", "file", path, "error", err)
+				break
+			}
+			kept++
+			break
+		}
+	}
+	slog.Info("Kept the Drive revisions from before the mass change", "files", kept, "since", since)
+}
+
+// pinRevision adds revisionID to the revisions pinned in the appProperties
+// of the Drive file fileID, kept whatever the retention policy of gc.
+func pinRevision(ctx context.Context, client drive.Client, fileID string, revisionID string) error {
+	file, err := client.GetFile(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	if PinnedRevisions(file)[revisionID] {
+		return nil
+	}
+	pinned := drive.Property(file.AppProperties, drive.PropertyPinned)
+	if pinned != "" {
+		pinned += ","
+	}
+	props := make(map[string]string)
+	drive.SetProperty(props, drive.PropertyPinned, pinned+revisionID)
+	return client.SetAppProperties(ctx, fileID, props)
+}
+
+// PinnedRevisions returns the revisions of the Drive file kept from before
+// a mass change.
+func PinnedRevisions(file *drivev3.File) map[string]bool {
+	pinned := make(map[string]bool)
+	for _, id := range strings.Split(drive.Property(file.AppProperties, drive.PropertyPinned), ",") {
+		if id != "" {
+			pinned[id] = true
+		}
+	}
+	return pinned
+}
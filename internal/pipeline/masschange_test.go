@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestKeepRevisionsBeforePins(t *testing.T) {
+	ctx := context.Background()
+	test := newDriveTest(t)
+	test.start(t)
+	modTime := time.Now().Add(-time.Hour)
+	path := test.write(t, "report.txt", "good version", modTime)
+	test.backup(t, path)
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	test.write(t, "report.txt", "encrypted by a ransomware", modTime.Add(time.Minute))
+	test.backup(t, path)
+
+	// twice, like two mass changes in a row
+	test.p.keepRevisionsBefore(ctx, []string{path}, since)
+	test.p.keepRevisionsBefore(ctx, []string{path}, since)
+
+	files := test.remoteFiles(t, "report.txt")
+	if len(files) != 1 {
+		t.Fatalf("%d report.txt files in Drive, want 1", len(files))
+	}
+	revisions, err := test.client.ListRevisions(ctx, files[0].Id)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("revisions = %v, %v, want 2", revisions, err)
+	}
+	file, err := test.client.GetFile(ctx, files[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := PinnedRevisions(file)
+	if len(pinned) != 1 || !pinned[revisions[0].Id] {
+		t.Errorf("pinned revisions %v, want the good version %s", pinned, revisions[0].Id)
+	}
+	if !revisions[0].KeepForever {
+		t.Error("the good version is not kept forever")
+	}
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+)
+
+// maxXattrsSize is the most bytes of encoded extended attributes kept in
+// the properties of a file, Drive allowing 30 properties per app.
+const maxXattrsSize = 1024
+
+// metadataProperties adds to props the attributes of the local file at
+// path that uploading its content loses: its permissions, owner, extended
+// attributes and, for a symbolic link, its target.
+func metadataProperties(props map[string]string, path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Readlink(path); err == nil {
+			drive.SetProperty(props, drive.PropertySymlink, target)
+		}
+		if info, err = os.Stat(path); err != nil {
+			return
+		}
+	}
+	drive.SetProperty(props, drive.PropertyMode, strconv.FormatUint(uint64(info.Mode().Perm()), 8))
+	drive.SetProperty(props, drive.PropertyOwner, fileOwner(info))
+
+	xattrs, err := fileXattrs(path)
+	if err != nil {
+		slog.Warn("Unable to read the extended attributes", "file", path, "error", err)
+	}
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, name+"="+base64.RawStdEncoding.EncodeToString(xattrs[name]))
+	}
+	encoded := strings.Join(lines, "\n")
+	if len(encoded) > maxXattrsSize {
+		slog.Warn("Extended attributes too large to keep", "file", path, "size", len(encoded))
+		encoded = ""
+	}
+	drive.SetProperty(props, drive.PropertyXattrs, encoded)
+}
+
+// RestoreMetadata gives the file at path the permissions, owner and
+// extended attributes kept in props, the appProperties of its Drive file.
+// Each attribute is restored even when another one fails.
+func RestoreMetadata(path string, props map[string]string) error {
+	var errs []error
+	if mode := drive.Property(props, drive.PropertyMode); mode != "" {
+		if perm, err := strconv.ParseUint(mode, 8, 32); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid mode %s", mode))
+		} else if err = os.Chmod(path, os.FileMode(perm).Perm()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if owner := drive.Property(props, drive.PropertyOwner); owner != "" {
+		if err := setOwner(path, owner); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if xattrs := drive.Property(props, drive.PropertyXattrs); xattrs != "" {
+		for _, line := range strings.Split(xattrs, "\n") {
+			i := strings.LastIndex(line, "=")
+			if i < 0 {
+				continue
+			}
+			value, err := base64.RawStdEncoding.DecodeString(line[i+1:])
+			if err == nil {
+				err = setXattr(path, line[:i], value)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Unable to restore the extended attribute %s: %v", line[:i], err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/netcost"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+)
+
+// networkProbeInterval is how often the connection is checked.
+const networkProbeInterval = time.Minute
+
+// defaultMeteredMaxSizeMB is the largest file uploaded on a metered
+// connection.
+const defaultMeteredMaxSizeMB = 10
+
+// Metered reports whether the connection is metered, the large uploads
+// waiting for another one.
+func (p *Pipeline) Metered() bool {
+	p.offlineMutex.Lock()
+	defer p.offlineMutex.Unlock()
+	return p.unmetered != nil
+}
+
+// WatchNetwork checks every minute, until ctx is cancelled, whether the
+// connection is metered: flagged so by the system or on one of the Wi-Fi
+// networks of Config.Metered. Nothing is checked while Config.Metered is
+// nil.
+func (p *Pipeline) WatchNetwork(ctx context.Context) {
+	for {
+		select {
+		case <-p.clock().After(networkProbeInterval):
+		case <-ctx.Done():
+			return
+		}
+		settings := p.config().Metered
+		if settings == nil {
+			p.setMetered(false, "")
+			continue
+		}
+		metered, err := netcost.Metered()
+		if err != nil && err != netcost.ErrUnsupported {
+			slog.Debug("Unable to read whether the connection is metered", "error", err)
+		}
+		ssid, err := netcost.SSID()
+		if err != nil && err != netcost.ErrUnsupported {
+			slog.Debug("Unable to read the Wi-Fi network", "error", err)
+		}
+		p.setMetered(metered || ssid != "" && slices.Contains(settings.SSIDs, ssid), ssid)
+	}
+}
+
+// setMetered records whether the connection, on the Wi-Fi network ssid,
+// is metered, queuing again the uploads that waited once it no longer is.
+func (p *Pipeline) setMetered(metered bool, ssid string) {
+	p.offlineMutex.Lock()
+	if metered == (p.unmetered != nil) {
+		p.offlineMutex.Unlock()
+		return
+	}
+	if metered {
+		p.unmetered = make(chan struct{})
+	} else {
+		close(p.unmetered)
+		p.unmetered = nil
+	}
+	p.offlineMutex.Unlock()
+	p.Events.Publish(events.MeteredChanged{Metered: metered, Network: ssid})
+}
+
+// waitsUnmetered returns the channel closed when the connection is no
+// longer metered if the file of job is too large to upload until then,
+// nil otherwise.
+func (p *Pipeline) waitsUnmetered(job *queue.Job) chan struct{} {
+	settings := p.config().Metered
+	if settings == nil {
+		return nil
+	}
+	p.offlineMutex.Lock()
+	defer p.offlineMutex.Unlock()
+	if p.unmetered == nil || job.Size <= int64(config.IntOrDefault(settings.MaxSizeMB, defaultMeteredMaxSizeMB))<<20 {
+		return nil
+	}
+	return p.unmetered
+}
+
+// waitUnmetered queues job again once unmetered is closed, unless the
+// worker or the job is cancelled first. The other files go on meanwhile.
+func (p *Pipeline) waitUnmetered(workerCtx context.Context, job *queue.Job, unmetered chan struct{}) {
+	slog.Info("Large file waits for a connection that is not metered", "file", job.Path, "size", job.Size)
+	var cancelled <-chan struct{} // nil, never ready, without a job context
+	if job.Context != nil {
+		cancelled = job.Context.Done()
+	}
+	go func() {
+		select {
+		case <-unmetered:
+			if p.Queue.Push(job) {
+				p.Events.Publish(events.FileQueued{Path: job.Path, Priority: int(job.Priority)})
+			}
+		case <-workerCtx.Done():
+			job.Finish(history.ActionFail, workerCtx.Err())
+		case <-cancelled:
+			job.Finish(history.ActionFail, job.Context.Err())
+		}
+	}()
+}
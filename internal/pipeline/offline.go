@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"golang.org/x/net/context"
+)
+
+// offlineProbeInterval is how often Drive is tried while it is unreachable.
+const offlineProbeInterval = 30 * time.Second
+
+// quotaProbeInterval is how often the free space is checked again while
+// the Drive storage is full.
+const quotaProbeInterval = 5 * time.Minute
+
+// reasons the uploads are held
+const (
+	holdOffline = "offline"
+	holdQuota   = "quota"
+)
+
+// errQuotaExceeded is returned when the pre-flight check finds a file does
+// not fit in the free Drive space.
+var errQuotaExceeded = errors.New("not enough free space in Drive")
+
+// Offline reports whether Drive is unreachable, the uploads waiting until
+// it is back.
+func (p *Pipeline) Offline() bool {
+	return p.heldFor() == holdOffline
+}
+
+// QuotaExceeded reports whether the Drive storage is full, the uploads
+// waiting until there is space again.
+func (p *Pipeline) QuotaExceeded() bool {
+	return p.heldFor() == holdQuota
+}
+
+func (p *Pipeline) heldFor() string {
+	p.offlineMutex.Lock()
+	defer p.offlineMutex.Unlock()
+	return p.holdReason
+}
+
+// goOffline makes the workers wait until Drive answers account again.
+func (p *Pipeline) goOffline(ctx context.Context, account *drive.Account) {
+	p.hold(ctx, holdOffline, offlineProbeInterval, func(ctx context.Context) bool {
+		_, err := account.Client().GetFile(ctx, account.Folder().Id)
+		if err != nil && drive.IsOffline(err) {
+			slog.Debug("Drive still unreachable", "error", err)
+			return false
+		}
+		return true
+	}, events.ConnectivityChanged{Online: false}, events.ConnectivityChanged{Online: true})
+}
+
+// quotaFull makes the workers wait until account has room again, a file
+// of needed bytes not fitting in it.
+func (p *Pipeline) quotaFull(ctx context.Context, account *drive.Account, needed int64) {
+	exceeded := events.QuotaChanged{Exceeded: true, Needed: needed}
+	if quota, err := account.Client().Quota(ctx); err == nil {
+		exceeded.Usage, exceeded.Limit = quota.Usage, quota.Limit
+	}
+	p.hold(ctx, holdQuota, quotaProbeInterval, func(ctx context.Context) bool {
+		quota, err := account.Client().Quota(ctx)
+		return err == nil && (quota.Limit == 0 || quota.Usage < quota.Limit)
+	}, exceeded, events.QuotaChanged{Exceeded: false})
+}
+
+// tooLargeForQuota reports whether a file of needed bytes does not fit in
+// the free space of account while smaller files still do: the file fails
+// instead of holding every upload until there is room for it. The uploads
+// are held when the quota is unknown.
+func tooLargeForQuota(ctx context.Context, account *drive.Account, needed int64) bool {
+	quota, err := account.Client().Quota(ctx)
+	if err != nil {
+		slog.Debug("Unable to read the Drive quota", "error", err)
+		return false
+	}
+	return quota.Limit > 0 && quota.Usage < quota.Limit && quota.Usage+needed > quota.Limit
+}
+
+// checkQuota returns errQuotaExceeded when a file of size bytes does not
+// fit in the free space of Drive, nothing when the quota is unknown.
+func checkQuota(ctx context.Context, client drive.Client, size int64) error {
+	quota, err := client.Quota(ctx)
+	if err != nil {
+		slog.Debug("Unable to read the Drive quota", "error", err)
+		return nil
+	}
+	if quota.Limit > 0 && quota.Usage+size > quota.Limit {
+		slog.Warn("File larger than the free Drive space", "size", humanize.Bytes(size),
+			"free", humanize.Bytes(quota.Limit-quota.Usage))
+		return fmt.Errorf("%w: %s needed, %s free", errQuotaExceeded,
+			humanize.Bytes(size), humanize.Bytes(quota.Limit-quota.Usage))
+	}
+	return nil
+}
+
+// isQuotaExceeded reports whether err means the file does not fit in Drive,
+// found by the pre-flight check or answered by Drive.
+func isQuotaExceeded(err error) bool {
+	return errors.Is(err, errQuotaExceeded) || drive.IsQuotaExceeded(err)
+}
+
+// hold makes the workers wait, for reason, until probe reports the uploads
+// can go on, probed every interval until ctx is cancelled. held and
+// released are published when the uploads stop and when they go on. The
+// uploads are held for one reason at a time.
+func (p *Pipeline) hold(ctx context.Context, reason string, interval time.Duration, probe func(ctx context.Context) bool, held events.Event, released events.Event) {
+	p.offlineMutex.Lock()
+	defer p.offlineMutex.Unlock()
+	if p.online != nil {
+		return
+	}
+	online := make(chan struct{})
+	p.online = online
+	p.holdReason = reason
+	p.Events.Publish(held)
+
+	go func() {
+		for {
+			select {
+			case <-p.clock().After(interval):
+			case <-ctx.Done():
+				return
+			}
+			if !probe(ctx) || ctx.Err() != nil {
+				continue
+			}
+			p.offlineMutex.Lock()
+			p.online = nil
+			p.holdReason = ""
+			p.offlineMutex.Unlock()
+			close(online)
+			p.Events.Publish(released)
+			return
+		}
+	}()
+}
+
+// waitOnline waits until the uploads are no longer held, returning early
+// with the error of ctx when it is cancelled.
+func (p *Pipeline) waitOnline(ctx context.Context) error {
+	p.offlineMutex.Lock()
+	online := p.online
+	p.offlineMutex.Unlock()
+	if online == nil {
+		return nil
+	}
+	select {
+	case <-online:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid:gid owning the file of info.
+func fileOwner(info os.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", stat.Uid, stat.Gid)
+	}
+	return ""
+}
+
+// setOwner gives the file at path the uid:gid owner, only changed when it
+// differs as it usually takes root.
+func setOwner(path string, owner string) error {
+	var uid, gid int
+	if _, err := fmt.Sscanf(owner, "%d:%d", &uid, &gid); err != nil {
+		return fmt.Errorf("Invalid owner %s", owner)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if fileOwner(info) == owner {
+		return nil
+	}
+	return os.Lchown(path, uid, gid)
+}
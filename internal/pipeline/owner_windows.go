@@ -0,0 +1,14 @@
+package pipeline
+
+import "os"
+
+// fileOwner returns an empty owner, Windows files have an ACL instead.
+func fileOwner(info os.FileInfo) string {
+	return ""
+}
+
+// setOwner does nothing, the owner of a file recorded elsewhere means
+// nothing on Windows.
+func setOwner(path string, owner string) error {
+	return nil
+}
@@ -0,0 +1,764 @@
+// Package pipeline scans the watched folders and uploads their files to the
+// backend, recording the result in the history, metrics and notifications.
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+	"github.com/amcereijo/EncryptBckDocs/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+)
+
+// errStaleRemoteID is returned when the cached Drive ID of a file no
+// longer exists, the upload is retried looking the file up by name.
+var errStaleRemoteID = errors.New("cached Drive file ID not found")
+
+// errFound stops the search of the state database.
+var errFound = errors.New("found")
+
+// defaultScanWorkers is how many files of a folder are filtered and queued
+// at the same time while scanning it.
+const defaultScanWorkers = 8
+
+// Pipeline uploads the files of the watched folders.
+type Pipeline struct {
+	// Config is the config of the pipeline when ConfigStore is nil.
+	Config *config.Config
+	// ConfigStore holds the current config, read again for every file so
+	// a reloaded config applies.
+	ConfigStore *config.Store
+	Accounts    *drive.Accounts
+	// Events receives what happens to every file, nothing is published
+	// when nil.
+	Events *events.Bus
+	// Queue holds the files waiting to be uploaded by the workers.
+	Queue *queue.Queue
+	// State remembers the files already backed up, so unchanged ones are
+	// skipped. Every file is uploaded when nil.
+	State *state.Store
+	// AppFiles are the paths of the files and folders of the app itself,
+	// like its config, its backups or its binary, never uploaded.
+	AppFiles []string
+	// FS is where files are read from, the local disk when nil.
+	FS FileSystem
+	// Clock tells the time, the wall clock when nil.
+	Clock Clock
+
+	offlineMutex sync.Mutex
+	// online is closed when the uploads are no longer held, nil while they
+	// are not, holdReason telling why they are.
+	online     chan struct{}
+	holdReason string
+	// failures counts the uploads failing in Drive in a row.
+	failures int
+	// unmetered is closed when the connection is no longer metered, nil
+	// while it is not.
+	unmetered chan struct{}
+
+	changesMutex sync.Mutex
+	// changes are when the files changed recently, by path
+	changes map[string]time.Time
+
+	sizesMutex sync.Mutex
+	// sizes are the bytes backed up by watched folder, nil until a size
+	// cap needs them
+	sizes map[string]int64
+
+	// lastUpdate is the time of the latest upload of this run
+	lastUpdate atomic.Pointer[string]
+}
+
+// config returns the current config, which is never changed.
+func (p *Pipeline) config() *config.Config {
+	if p.ConfigStore != nil {
+		return p.ConfigStore.Config()
+	}
+	return p.Config
+}
+
+// ScanAll queues the current contents of every watched folder with
+// priority and waits until they are uploaded.
+func (p *Pipeline) ScanAll(ctx context.Context, priority queue.Priority) *Summary {
+	result := &Summary{}
+	var pending sync.WaitGroup
+	for _, actualFolderToWatch := range p.config().FolderToWatch {
+		p.scanFolder(ctx, actualFolderToWatch, priority, result, &pending)
+	}
+	pending.Wait()
+	p.Events.Publish(events.ScanFinished{
+		Folders:  len(p.config().FolderToWatch),
+		Uploaded: result.Uploaded,
+		Updated:  result.Updated,
+		Skipped:  result.Skipped,
+		Failed:   result.Failed,
+	})
+	return result
+}
+
+// ScanFolder queues the current contents of a watched folder with priority
+// and waits until they are uploaded, counting what is done with every file
+// in result.
+func (p *Pipeline) ScanFolder(ctx context.Context, actualFolderToWatch string, priority queue.Priority, result *Summary) {
+	var pending sync.WaitGroup
+	p.scanFolder(ctx, actualFolderToWatch, priority, result, &pending)
+	pending.Wait()
+}
+
+func (p *Pipeline) scanFolder(ctx context.Context, actualFolderToWatch string, priority queue.Priority, result *Summary, pending *sync.WaitGroup) {
+	slog.Info("Uploading folder contents", "folder", actualFolderToWatch)
+	ctx, span := tracing.Tracer.Start(ctx, "scan", trace.WithAttributes(attribute.String("folder", actualFolderToWatch)))
+	defer span.End()
+
+	// authorize the account before queuing its files
+	if _, err := p.Accounts.ForFolder(ctx, actualFolderToWatch); err != nil {
+		slog.Error("Error getting Drive account", "error", err)
+		result.AddFailure(actualFolderToWatch, err)
+		return
+	}
+	if p.config().ArchiveFolder(actualFolderToWatch) {
+		p.archiveFolder(ctx, actualFolderToWatch, result)
+		return
+	}
+	if p.config().RepositoryFolder(actualFolderToWatch) {
+		p.snapshotFolder(ctx, actualFolderToWatch, result)
+		return
+	}
+	checkpoint := p.startScan(actualFolderToWatch)
+	// the files of this folder, the checkpoint is kept until they are
+	// uploaded
+	var folderPending sync.WaitGroup
+	paths := make(chan string)
+	var workers sync.WaitGroup
+	for i := 0; i < config.IntOrDefault(p.config().ScanWorkers, defaultScanWorkers); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for path := range paths {
+				p.scanFile(ctx, path, priority, result, &folderPending)
+			}
+		}()
+	}
+	err := p.fs().WalkDir(actualFolderToWatch, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filepath.Clean(path) == filepath.Clean(actualFolderToWatch) {
+				return nil
+			}
+			// subfolders are not watched
+			return filepath.SkipDir
+		}
+		if checkpoint.done(path) {
+			result.Add(path, history.ActionSkip, nil)
+			return nil
+		}
+		checkpoint.reached(path)
+		paths <- path
+		return nil
+	})
+	close(paths)
+	workers.Wait()
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Error reading folder", "folder", actualFolderToWatch, "error", err)
+		}
+		result.AddFailure(actualFolderToWatch, err)
+	}
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		folderPending.Wait()
+		if err == nil && ctx.Err() == nil {
+			checkpoint.finish()
+		}
+	}()
+}
+
+// scanFile queues a file found scanning a watched folder, unless it is
+// filtered out or known unchanged, counting it in pending until it is
+// uploaded.
+func (p *Pipeline) scanFile(ctx context.Context, path string, priority queue.Priority, result *Summary, pending *sync.WaitGroup) {
+	_, filterSpan := tracing.Tracer.Start(ctx, "filter", trace.WithAttributes(attribute.String("file", path)))
+	included := p.Included(path)
+	filterSpan.SetAttributes(attribute.Bool("included", included))
+	filterSpan.End()
+	if !included {
+		result.Add(path, history.ActionSkip, nil)
+		return
+	}
+	if p.knownUnchanged(path) {
+		// neither opened nor queued
+		result.Add(path, history.ActionSkip, nil)
+		p.Events.Publish(events.UploadSkipped{Path: path, Reason: events.SkipUnchanged})
+		return
+	}
+	pending.Add(1)
+	p.Enqueue(ctx, path, priority, func(action string, err error) {
+		result.Add(path, action, err)
+		pending.Done()
+	})
+}
+
+// FileChanged queues a file written in a watched folder, unless it is
+// filtered out. The files of a folder in archive mode or backed up as
+// chunks wait for its next archive or snapshot.
+func (p *Pipeline) FileChanged(ctx context.Context, path string) {
+	folder := filepath.Dir(path)
+	if p.config().ArchiveFolder(folder) || p.config().RepositoryFolder(folder) || !p.Included(path) {
+		return
+	}
+	p.recordChange(ctx, path)
+	p.Enqueue(ctx, path, queue.PriorityChange, nil)
+}
+
+// Upload uploads a local file to the destination folder of account.
+// It returns the history action done with the file. A failure is not
+// published, the worker publishing it once it gives up on the file.
+func (p *Pipeline) Upload(ctx context.Context, uploadFilePath string, account *drive.Account) (string, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "upload", trace.WithAttributes(
+		attribute.String("file", uploadFilePath), attribute.String("account", account.Name())))
+	defer span.End()
+
+	if reason := p.skipped(uploadFilePath); reason != "" {
+		span.SetAttributes(attribute.String("action", history.ActionSkip))
+		return p.skipUpload(uploadFilePath, reason)
+	}
+	goFile, err := p.fs().Open(uploadFilePath)
+	if os.IsNotExist(err) {
+		// deleted after being queued, nothing left to back up
+		slog.Debug("File no longer exists", "file", uploadFilePath)
+		span.SetAttributes(attribute.String("action", history.ActionSkip))
+		return p.skipUpload(uploadFilePath, events.SkipDeleted)
+	} else if err != nil {
+		return history.ActionFail, fmt.Errorf("Unable to open file: %v", err)
+	}
+	defer goFile.Close()
+
+	if p.unchanged(ctx, uploadFilePath, goFile) {
+		slog.Debug("File unchanged since last upload", "file", uploadFilePath)
+		span.SetAttributes(attribute.String("action", history.ActionSkip))
+		return p.skipUpload(uploadFilePath, events.SkipUnchanged)
+	}
+	if renamed, err := p.renamed(ctx, uploadFilePath, goFile, account); err != nil {
+		slog.Warn("Unable to tell whether the file was renamed, uploading it", "file", uploadFilePath, "error", err)
+		if _, err = goFile.Seek(0, io.SeekStart); err != nil {
+			return history.ActionFail, fmt.Errorf("Unable to read file again: %v", err)
+		}
+	} else if renamed {
+		span.SetAttributes(attribute.String("action", history.ActionRename))
+		return history.ActionRename, nil
+	}
+
+	refreshed := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return history.ActionFail, err
+		}
+		client := account.Client()
+		folder := account.Folder()
+		action := history.ActionFail
+		parent, name, err := p.uploadFolder(ctx, account, folder, uploadFilePath, goFile)
+		if err == nil {
+			action, err = p.uploadFile(ctx, client, goFile, uploadFilePath, name, parent)
+		}
+		if err == errStaleRemoteID || (drive.IsNotFound(err) && !refreshed) {
+			// the cached IDs are out of date, look them up and retry once
+			if err != errStaleRemoteID {
+				refreshed = true
+				if err = p.Accounts.RefreshFolder(ctx, account, folder); err != nil {
+					return history.ActionFail, err
+				}
+			}
+			if _, err = goFile.Seek(0, io.SeekStart); err != nil {
+				return history.ActionFail, fmt.Errorf("Unable to read file again: %v", err)
+			}
+			continue
+		}
+		if !auth.IsInvalidGrant(err) {
+			span.SetAttributes(attribute.String("action", action))
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return action, err
+		}
+		// wait for the user to authorize again and retry the same file
+		if err = p.Accounts.Reauthorize(ctx, account, client); err != nil {
+			return history.ActionFail, fmt.Errorf("Unable to authorize Drive again: %w", err)
+		}
+		if _, err = goFile.Seek(0, io.SeekStart); err != nil {
+			return history.ActionFail, fmt.Errorf("Unable to read file again: %v", err)
+		}
+	}
+}
+
+// skipUpload publishes that the upload of path was skipped for reason and
+// returns the skip action.
+func (p *Pipeline) skipUpload(path string, reason string) (string, error) {
+	p.Events.Publish(events.UploadSkipped{Path: path, Reason: reason})
+	return history.ActionSkip, nil
+}
+
+// failUpload publishes that the upload of path failed with err, for every
+// failure to be counted and notified once, and returns the failed action
+// and err. backend is set when Drive returned err. Offline uploads, a full
+// Drive and cancelled uploads are not failures, the uploads wait for the
+// network or for free space, or stop with the app.
+func (p *Pipeline) failUpload(ctx context.Context, path string, err error, backend bool) (string, error) {
+	if ctx.Err() == nil && !drive.IsOffline(err) && !isQuotaExceeded(err) {
+		p.Events.Publish(events.UploadFailed{Path: path, Err: err, Backend: backend})
+	}
+	return history.ActionFail, err
+}
+
+func (p *Pipeline) uploadFile(ctx context.Context, client drive.Client, goFile File, uploadFilePath string, name string, parentFolder *drivev3.File) (string, error) {
+	chain, err := p.chain(filepath.Dir(uploadFilePath))
+	if err != nil {
+		return history.ActionFail, err
+	}
+
+	var size int64
+	var modTime time.Time
+	var inode uint64
+	if info, statErr := goFile.Stat(); statErr == nil {
+		size = info.Size()
+		modTime = info.ModTime()
+		inode = fileInode(info)
+	}
+	// the hash and the progress are of the local file, before the processors
+	hasher := sha256.New()
+	var body io.Reader = io.TeeReader(&contextReader{ctx: ctx, r: goFile}, hasher)
+	if isInteractive() {
+		body = newProgressReader(body, filepath.Base(uploadFilePath), size)
+	}
+	if p.Events != nil {
+		body = &progressPublisher{reader: body, bus: p.Events, path: uploadFilePath, size: size}
+	}
+	item := &Item{Path: uploadFilePath, Name: name, Body: body}
+	err = runChain(ctx, chain, item)
+	defer func() { closeBody(item.Body) }()
+	if err == ErrSkip {
+		slog.Debug("File skipped by processor", "file", uploadFilePath)
+		return p.skipUpload(uploadFilePath, events.SkipProcessor)
+	} else if err != nil {
+		return history.ActionFail, err
+	}
+	uploadFileName := item.Name
+
+	driveFileToUpload := p.cachedRemoteFile(uploadFilePath, uploadFileName)
+	cached := driveFileToUpload != nil
+	if !cached {
+		findCtx, findSpan := tracing.Tracer.Start(ctx, "drive.find")
+		files, err := client.FindFiles(findCtx, uploadFileName, parentFolder.Id)
+		findSpan.End()
+		if err != nil {
+			return history.ActionFail, err
+		}
+		driveFileToUpload = drive.PreferFile(files, p.knownRemoteID)
+	}
+	// another machine may have started backing up to the folder since this
+	// one did, the writer of every updated file is checked
+	if driveFileToUpload != nil {
+		local := state.File{Size: size, ModTime: modTime, Inode: inode}
+		done, file, fileName, err := p.claimRemoteFile(ctx, client, uploadFilePath, uploadFileName, parentFolder, driveFileToUpload, local)
+		if cached && drive.IsNotFound(err) {
+			slog.Debug("Cached Drive file ID not found", "file", uploadFilePath, "id", driveFileToUpload.Id)
+			p.forgetState(uploadFilePath)
+			return history.ActionFail, errStaleRemoteID
+		} else if err != nil {
+			return history.ActionFail, err
+		} else if done {
+			return p.skipUpload(uploadFilePath, events.SkipOtherMachine)
+		}
+		if fileName != uploadFileName {
+			// a file of this machine beside the one of the other machine
+			driveFileToUpload, uploadFileName, item.Name = file, fileName, fileName
+			cached = false
+		}
+	}
+
+	// files of a chunk or more are large, the others are sent at once
+	large := size >= int64(drive.ChunkSize(p.config()))
+	if large {
+		if err := checkQuota(ctx, client, size); err != nil {
+			return history.ActionFail, err
+		}
+	}
+
+	p.beginState(uploadFilePath)
+	transferCtx, transferSpan := tracing.Tracer.Start(ctx, "drive.transfer", trace.WithAttributes(attribute.Int64("size", size)))
+	start := p.clock().Now()
+	action := history.ActionUpload
+	var remoteFile *drivev3.File
+	if driveFileToUpload != nil {
+		action = history.ActionUpdate
+	}
+	parts := 0
+	if split := p.splitSize(); split > 0 && size > split {
+		remoteFile, parts, err = p.uploadParts(transferCtx, client, item, parentFolder, driveFileToUpload)
+	} else if p.State != nil && large {
+		remoteFile, err = p.resumableUpload(transferCtx, client, uploadFilePath, item, parentFolder, driveFileToUpload, size, modTime)
+	} else if driveFileToUpload != nil {
+		remoteFile, err = client.UpdateFile(transferCtx, driveFileToUpload, item.Body)
+	} else {
+		remoteFile, err = client.CreateFile(transferCtx, parentFolder, uploadFileName, item.Body)
+	}
+	transferSpan.End()
+	if cached && drive.IsNotFound(err) {
+		slog.Debug("Cached Drive file ID not found", "file", uploadFilePath, "id", driveFileToUpload.Id)
+		p.abortState(uploadFilePath)
+		p.forgetState(uploadFilePath)
+		return history.ActionFail, errStaleRemoteID
+	}
+	if err == nil {
+		hash := hex.EncodeToString(hasher.Sum(nil))
+		if err = p.setProperties(ctx, client, remoteFile.Id, uploadFilePath, hash, parts); err != nil {
+			p.abortState(uploadFilePath)
+			return history.ActionFail, err
+		}
+		if parts == 0 && driveFileToUpload != nil && p.splitSize() > 0 {
+			// the file may have been uploaded in parts before
+			if err := p.trashParts(ctx, client, uploadFileName, parentFolder, 1); err != nil {
+				slog.Warn("Unable to trash the parts of the previous version", "file", uploadFilePath, "error", err)
+			}
+		}
+		p.updateLastUpdate()
+		p.Events.Publish(events.UploadSucceeded{
+			Path:         uploadFilePath,
+			Folder:       filepath.Dir(uploadFilePath),
+			Action:       action,
+			RemoteID:     remoteFile.Id,
+			RemoteFolder: parentFolder.Name,
+			Hash:         hash,
+			Size:         size,
+			Duration:     p.clock().Now().Sub(start),
+		})
+		p.commitState(uploadFilePath, state.File{
+			Hash:       hash,
+			Size:       size,
+			ModTime:    modTime,
+			Inode:      inode,
+			RemoteID:   remoteFile.Id,
+			LastUpload: p.clock().Now(),
+		})
+	} else {
+		p.abortState(uploadFilePath)
+		action = history.ActionFail
+	}
+	return action, err
+}
+
+// setProperties writes in the appProperties of the uploaded file fileID
+// what restoring it needs when state.db is lost: the local path, its hash,
+// the processors it went through, the machine it was backed up from, the
+// attributes of the local file and how many parts it was uploaded in, 0
+// when it was not split.
+func (p *Pipeline) setProperties(ctx context.Context, client drive.Client, fileID string, path string, hash string, parts int) error {
+	processors := p.config().ProcessorsForFolder(filepath.Dir(path))
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	props := map[string]string{}
+	drive.SetProperty(props, drive.PropertyPath, path)
+	drive.SetProperty(props, drive.PropertyHash, hash)
+	drive.SetProperty(props, drive.PropertyProcessors, strings.Join(processors, ","))
+	drive.SetProperty(props, drive.PropertyHost, hostname())
+	metadataProperties(props, path)
+	if parts > 0 {
+		drive.SetProperty(props, drive.PropertyParts, strconv.Itoa(parts))
+	} else {
+		drive.SetProperty(props, drive.PropertyParts, "")
+	}
+	if err := client.SetAppProperties(ctx, fileID, props); err != nil {
+		return fmt.Errorf("Unable to save the file properties: %v", err)
+	}
+	return nil
+}
+
+// resumableUpload sends item in chunks, checkpointing the session after
+// each one so an upload stopped by a shutdown goes on from the last chunk
+// Drive stored. The file and its processors must not have changed since
+// the checkpoint, and the processors must give the same bytes on every
+// run: an encrypted file starts over, its bytes differing from the ones
+// Drive has.
+func (p *Pipeline) resumableUpload(ctx context.Context, client drive.Client, path string, item *Item, parentFolder *drivev3.File, file *drivev3.File, size int64, modTime time.Time) (*drivev3.File, error) {
+	processors := p.config().ProcessorsForFolder(filepath.Dir(path))
+	resumable := true
+	for _, processor := range processors {
+		resumable = resumable && resumableProcessors[processor]
+	}
+	checkpoint, err := p.State.Checkpoint(path)
+	if err != nil {
+		slog.Error("Unable to read upload checkpoint", "file", path, "error", err)
+	}
+	if checkpoint != nil && !resumable {
+		p.deleteCheckpoint(path)
+	}
+	if !resumable || !checkpoint.Matches(size, modTime, strings.Join(processors, ",")) {
+		checkpoint = nil
+	}
+	for {
+		if checkpoint == nil {
+			uri, err := client.StartUpload(ctx, parentFolder, item.Name, file)
+			if err != nil {
+				return nil, err
+			}
+			checkpoint = &state.Checkpoint{SessionURI: uri, Size: size, ModTime: modTime, Processors: strings.Join(processors, ",")}
+			if resumable {
+				p.putCheckpoint(path, *checkpoint)
+			}
+		} else {
+			slog.Info("Resuming upload", "file", path, "offset", checkpoint.Offset)
+		}
+
+		body := &countingReader{r: item.Body}
+		remoteFile, err := client.ResumeUpload(ctx, checkpoint.SessionURI, body, func(offset int64) {
+			checkpoint.Offset = offset
+			if resumable {
+				p.putCheckpoint(path, *checkpoint)
+			}
+		})
+		if drive.IsSessionExpired(err) {
+			slog.Debug("Upload session expired", "file", path)
+			p.deleteCheckpoint(path)
+			if body.read == 0 {
+				// nothing was read from the body yet, start a new session
+				checkpoint = nil
+				continue
+			}
+		} else if err == nil {
+			p.deleteCheckpoint(path)
+		}
+		return remoteFile, err
+	}
+}
+
+func (p *Pipeline) putCheckpoint(path string, checkpoint state.Checkpoint) {
+	if err := p.State.PutCheckpoint(path, checkpoint); err != nil {
+		slog.Error("Unable to save upload checkpoint", "file", path, "error", err)
+	}
+}
+
+func (p *Pipeline) deleteCheckpoint(path string) {
+	if err := p.State.DeleteCheckpoint(path); err != nil {
+		slog.Error("Unable to delete upload checkpoint", "file", path, "error", err)
+	}
+}
+
+// updateLastUpdate saves the time of the latest upload in state.db, not in
+// config.json, whose every write would reload the config.
+func (p *Pipeline) updateLastUpdate() {
+	now := p.clock().Now().String()
+	p.lastUpdate.Store(&now)
+	if p.State == nil {
+		return
+	}
+	if err := p.State.PutLastUpdate(now); err != nil {
+		slog.Error("Unable to save the time of the last upload", "error", err)
+	}
+}
+
+// LastUpdate returns the time of the latest upload, the one config.json
+// kept before state.db did when there was none since.
+func (p *Pipeline) LastUpdate() string {
+	if lastUpdate := p.lastUpdate.Load(); lastUpdate != nil {
+		return *lastUpdate
+	}
+	if p.State != nil {
+		if lastUpdate, err := p.State.LastUpdate(); err == nil && lastUpdate != "" {
+			return lastUpdate
+		}
+	}
+	return p.config().LastUpdate
+}
+
+// knownUnchanged reports whether state.db knows the file at path unchanged
+// since its upload by its size, modification time and inode, telling it
+// without opening it.
+func (p *Pipeline) knownUnchanged(path string) bool {
+	if p.State == nil {
+		return false
+	}
+	known, err := p.State.Get(path)
+	if err != nil || known == nil {
+		return false
+	}
+	info, err := p.fs().Stat(path)
+	return err == nil && known.Unchanged(info.Size(), info.ModTime(), fileInode(info))
+}
+
+// unchanged reports whether goFile, opened from path, is the file last
+// uploaded. Its size, modification time and inode are compared first, the
+// file is only hashed when just its modification time or inode changed,
+// e.g. touched or restored from a copy, remembering them when the content
+// is the same.
+func (p *Pipeline) unchanged(ctx context.Context, path string, goFile File) bool {
+	if p.State == nil {
+		return false
+	}
+	info, err := goFile.Stat()
+	if err != nil {
+		return false
+	}
+	known, err := p.State.Get(path)
+	if err != nil {
+		slog.Error("Unable to read file state", "file", path, "error", err)
+		return false
+	}
+	inode := fileInode(info)
+	if known.Unchanged(info.Size(), info.ModTime(), inode) {
+		return true
+	}
+	if known == nil || known.Hash == "" || known.Size != info.Size() {
+		return false
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, &contextReader{ctx: ctx, r: goFile})
+	if _, seekErr := goFile.Seek(0, io.SeekStart); err != nil || seekErr != nil {
+		return false
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != known.Hash {
+		return false
+	}
+	slog.Debug("File content unchanged since last upload", "file", path)
+	known.ModTime = info.ModTime()
+	known.Inode = inode
+	p.saveState(path, *known)
+	return true
+}
+
+// cachedRemoteFile returns the Drive file, called name, the file at path
+// was last uploaded to, nil when it is not known.
+func (p *Pipeline) cachedRemoteFile(path string, name string) *drivev3.File {
+	if p.State == nil {
+		return nil
+	}
+	known, err := p.State.Get(path)
+	if err != nil || known == nil || known.RemoteID == "" {
+		return nil
+	}
+	return &drivev3.File{Id: known.RemoteID, Name: name}
+}
+
+// knownRemoteID reports whether a local file was uploaded to the Drive
+// file fileID.
+func (p *Pipeline) knownRemoteID(fileID string) bool {
+	if p.State == nil {
+		return false
+	}
+	err := p.State.ForEach(func(path string, file state.File) error {
+		if file.RemoteID == fileID {
+			return errFound
+		}
+		return nil
+	})
+	return err == errFound
+}
+
+func (p *Pipeline) forgetState(path string) {
+	if p.State == nil {
+		return
+	}
+	p.trackSize(path, 0)
+	if err := p.State.Delete(path); err != nil {
+		slog.Error("Unable to delete file state", "file", path, "error", err)
+	}
+}
+
+// saveState remembers the uploaded file at path.
+func (p *Pipeline) saveState(path string, file state.File) {
+	if p.State == nil {
+		return
+	}
+	p.trackSize(path, file.Size)
+	if err := p.State.Put(path, file); err != nil {
+		slog.Error("Unable to save file state", "file", path, "error", err)
+	}
+}
+
+// beginState journals that the remote write of the file at path starts,
+// so a crash before commitState or abortState forgets its state.
+func (p *Pipeline) beginState(path string) {
+	if p.State == nil {
+		return
+	}
+	if err := p.State.Begin(path); err != nil {
+		slog.Error("Unable to journal the upload", "file", path, "error", err)
+	}
+}
+
+// commitState remembers the file at path once its remote write completed.
+func (p *Pipeline) commitState(path string, file state.File) {
+	if p.State == nil {
+		return
+	}
+	p.trackSize(path, file.Size)
+	if err := p.State.Commit(path, file); err != nil {
+		slog.Error("Unable to save file state", "file", path, "error", err)
+	}
+}
+
+// abortState clears the journaled upload of the file at path when its
+// remote write failed.
+func (p *Pipeline) abortState(path string) {
+	if p.State == nil {
+		return
+	}
+	if err := p.State.Abort(path); err != nil {
+		slog.Error("Unable to clear the journaled upload", "file", path, "error", err)
+	}
+}
+
+// contextReader stops reading r once ctx is cancelled, so hashing and
+// uploading a large file do not go on after a shutdown.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(b []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(b)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	cr.read += int64(n)
+	return n, err
+}
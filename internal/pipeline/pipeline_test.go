@@ -0,0 +1,429 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive/drivetest"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// driveTest is a pipeline reading the local disk and uploading to a fake
+// Drive, with the watched folder docs and its destination folder.
+type driveTest struct {
+	p      *Pipeline
+	server *drivetest.Server
+	client drive.Client
+	docs   string
+	parent *drivev3.File
+}
+
+func newDriveTest(t *testing.T) *driveTest {
+	t.Helper()
+	root := t.TempDir()
+	test := &driveTest{server: drivetest.NewServer(), docs: filepath.Join(root, "docs")}
+	t.Cleanup(test.server.Close)
+	if err := os.Mkdir(test.docs, 0700); err != nil {
+		t.Fatal(err)
+	}
+	store, err := state.Open(filepath.Join(root, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	test.p = &Pipeline{Config: &config.Config{FolderToWatch: []string{test.docs}}, State: store}
+
+	ctx := context.Background()
+	if test.client, err = test.server.Connect(drive.DefaultChunkSize)(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if test.parent, err = test.client.CreateFolder(ctx, "backup", ""); err != nil {
+		t.Fatal(err)
+	}
+	return test
+}
+
+// upload uploads the file at path of docs.
+func (test *driveTest) upload(t *testing.T, path string) (string, error) {
+	t.Helper()
+	file, err := test.p.fs().Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	return test.p.uploadFile(context.Background(), test.client, file, path, filepath.Base(path), test.parent)
+}
+
+// connect sets the accounts of the pipeline, uploading to the destination
+// folder backup of the fake Drive.
+func (test *driveTest) connect() {
+	test.p.Config.FolderName = "backup"
+	test.p.Accounts = drive.NewAccounts(test.p.Config, nil)
+	test.p.Accounts.Connect = test.server.Connect(drive.DefaultChunkSize)
+}
+
+// start runs a worker uploading the queued files to the destination
+// folder backup of the fake Drive, until the test ends. The retry delays
+// pass at once.
+func (test *driveTest) start(t *testing.T) {
+	t.Helper()
+	test.connect()
+	test.p.Queue = queue.New()
+	test.p.Clock = &fakeClock{now: time.Now()}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	test.p.Start(ctx, 1)
+}
+
+// write writes content to the file called name of docs, modified at
+// modTime, and returns its path.
+func (test *driveTest) write(t *testing.T, name string, content string, modTime time.Time) string {
+	t.Helper()
+	path := filepath.Join(test.docs, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// backup queues the file at path and waits for its upload.
+func (test *driveTest) backup(t *testing.T, path string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	action, err := test.p.BackupFile(ctx, path, queue.PriorityChange)
+	if err != nil {
+		t.Fatalf("backing up %s: %v", path, err)
+	}
+	return action
+}
+
+// remoteFiles returns the files called name in Drive.
+func (test *driveTest) remoteFiles(t *testing.T, name string) []*drivev3.File {
+	t.Helper()
+	var files []*drivev3.File
+	for _, file := range test.server.Files() {
+		if file.Name == name {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+func TestBackupFileCreatesAndUpdates(t *testing.T) {
+	test := newDriveTest(t)
+	test.start(t)
+	modTime := time.Now().Add(-time.Hour)
+	path := test.write(t, "report.txt", "first version", modTime)
+
+	if action := test.backup(t, path); action != history.ActionUpload {
+		t.Errorf("first backup = %q, want %q", action, history.ActionUpload)
+	}
+	if action := test.backup(t, path); action != history.ActionSkip {
+		t.Errorf("backup of the unchanged file = %q, want %q", action, history.ActionSkip)
+	}
+	test.write(t, "report.txt", "second version", modTime.Add(time.Minute))
+	if action := test.backup(t, path); action != history.ActionUpdate {
+		t.Errorf("backup of the changed file = %q, want %q", action, history.ActionUpdate)
+	}
+
+	files := test.remoteFiles(t, "report.txt")
+	if len(files) != 1 {
+		t.Fatalf("%d report.txt files in Drive, want 1", len(files))
+	}
+	if content, _ := test.server.Content(files[0].Id); string(content) != "second version" {
+		t.Errorf("Drive holds %q, want %q", content, "second version")
+	}
+	folders := test.remoteFiles(t, "backup")
+	if len(folders) != 1 || files[0].Parents[0] != folders[0].Id {
+		t.Errorf("uploaded to %v, want the destination folder", files[0].Parents)
+	}
+}
+
+func TestBackupFileKeepsLastUpdateInState(t *testing.T) {
+	test := newDriveTest(t)
+	file := filepath.Join(t.TempDir(), "config.json")
+	test.start(t)
+	test.p.ConfigStore = config.NewStore(test.p.Config, file)
+	path := test.write(t, "report.txt", "first version", time.Now().Add(-time.Hour))
+
+	test.backup(t, path)
+	lastUpdate := test.p.LastUpdate()
+	if lastUpdate == "" {
+		t.Fatal("no time of the last upload")
+	}
+	// every write of config.json would reload the config
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("config.json written by the upload: %v", err)
+	}
+	restarted := &Pipeline{Config: &config.Config{}, State: test.p.State}
+	if got := restarted.LastUpdate(); got != lastUpdate {
+		t.Errorf("last upload after a restart = %q, want %q", got, lastUpdate)
+	}
+}
+
+func TestBackupFileRetriesRateLimit(t *testing.T) {
+	test := newDriveTest(t)
+	test.start(t)
+	modTime := time.Now().Add(-time.Hour)
+	// the first upload creates the destination folder
+	test.backup(t, test.write(t, "first.txt", "first", modTime))
+
+	path := test.write(t, "report.txt", "content", modTime)
+	test.server.Fail(http.StatusTooManyRequests, 2)
+	if action := test.backup(t, path); action != history.ActionUpload {
+		t.Errorf("backup = %q, want %q once the rate limit is over", action, history.ActionUpload)
+	}
+	if files := test.remoteFiles(t, "report.txt"); len(files) != 1 {
+		t.Errorf("%d report.txt files in Drive, want 1", len(files))
+	}
+}
+
+func TestBackupFileGivesUpOnRateLimit(t *testing.T) {
+	test := newDriveTest(t)
+	test.start(t)
+	modTime := time.Now().Add(-time.Hour)
+	test.backup(t, test.write(t, "first.txt", "first", modTime))
+
+	path := test.write(t, "report.txt", "content", modTime)
+	test.server.Fail(http.StatusTooManyRequests, maxAttempts)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	action, err := test.p.BackupFile(ctx, path, queue.PriorityChange)
+	if !drive.IsRetryable(err) || action != history.ActionFail {
+		t.Errorf("backup = %q, %v, want a failure after %d attempts", action, err, maxAttempts)
+	}
+	if files := test.remoteFiles(t, "report.txt"); len(files) != 0 {
+		t.Errorf("%d report.txt files in Drive, want none", len(files))
+	}
+}
+
+func TestBackupFilePublishesFailureOnce(t *testing.T) {
+	test := newDriveTest(t)
+	test.p.Events = events.New()
+	var mutex sync.Mutex
+	var failures []events.UploadFailed
+	test.p.Events.Subscribe(func(event events.Event) {
+		if failed, ok := event.(events.UploadFailed); ok {
+			mutex.Lock()
+			failures = append(failures, failed)
+			mutex.Unlock()
+		}
+	})
+	test.start(t)
+	modTime := time.Now().Add(-time.Hour)
+	test.backup(t, test.write(t, "first.txt", "first", modTime))
+
+	// retried until the last attempt, counted once
+	test.server.Fail(http.StatusInternalServerError, maxAttempts)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := test.p.BackupFile(ctx, test.write(t, "report.txt", "content", modTime), queue.PriorityChange); err == nil {
+		t.Fatal("backup succeeded, want a failure")
+	}
+	// a processor failing is not a failure of Drive
+	test.p.Config.FolderProcessors = map[string][]string{test.docs: {"filter", "unknown"}}
+	if _, err := test.p.BackupFile(ctx, test.write(t, "notes.txt", "notes", modTime), queue.PriorityChange); err == nil {
+		t.Fatal("backup through an unknown processor succeeded, want a failure")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(failures) != 2 {
+		t.Fatalf("%d failures published, want one per file: %+v", len(failures), failures)
+	}
+	if !failures[0].Backend || failures[1].Backend {
+		t.Errorf("backend flags %v and %v, want the Drive failure only", failures[0].Backend, failures[1].Backend)
+	}
+}
+
+func TestBackupFileTooLargeForQuota(t *testing.T) {
+	test := newDriveTest(t)
+	test.start(t)
+	modTime := time.Now().Add(-time.Hour)
+	test.backup(t, test.write(t, "first.txt", "first", modTime))
+	test.server.Limit = 1000
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	large := test.write(t, "large.txt", strings.Repeat("x", 2000), modTime)
+	if action, err := test.p.BackupFile(ctx, large, queue.PriorityChange); err == nil || action != history.ActionFail {
+		t.Errorf("backup of a file larger than the free space = %q, %v, want a failure", action, err)
+	}
+	if test.p.QuotaExceeded() {
+		t.Error("uploads held for a single file too large, want the others going on")
+	}
+	if action := test.backup(t, test.write(t, "small.txt", "small", modTime)); action != history.ActionUpload {
+		t.Errorf("backup of a small file = %q, want %q", action, history.ActionUpload)
+	}
+
+	// nothing fits in a full Drive, the uploads wait
+	test.server.Limit = 1
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := test.p.BackupFile(ctx, test.write(t, "other.txt", "other", modTime), queue.PriorityChange); err == nil {
+		t.Error("backup to a full Drive succeeded, want it waiting")
+	}
+	if !test.p.QuotaExceeded() {
+		t.Error("uploads not held with a full Drive")
+	}
+}
+
+// heapPeak samples the heap in use until stop is called, which returns
+// the largest one seen.
+func heapPeak() (stop func() uint64) {
+	var peak uint64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapInuse)
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		wg.Wait()
+		return peak
+	}
+}
+
+func TestUploadLargeFileStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads a file of several GB")
+	}
+	const size = 3 << 30
+	const budget = 64 << 20
+	test := newDriveTest(t)
+	test.p.Config.FolderProcessors = map[string][]string{test.docs: {"filter", "gzip"}}
+	// sparse, taking no space on the disk
+	path := filepath.Join(test.docs, "disk.img")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Truncate(size); err != nil {
+		file.Close()
+		t.Skipf("sparse files not supported: %v", err)
+	}
+	file.Close()
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	stop := heapPeak()
+	action, err := test.upload(t, path)
+	peak := stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != history.ActionUpload {
+		t.Errorf("action = %q, want %q", action, history.ActionUpload)
+	}
+	if grown := int64(peak) - int64(before.HeapInuse); grown > budget {
+		t.Errorf("the heap grew by %d MiB uploading a %d GiB file, want at most %d MiB", grown>>20, size>>30, budget>>20)
+	}
+
+	// the fake Drive holds the compressed file, a few MB
+	files, err := test.client.FindFiles(context.Background(), "disk.img.gz", test.parent.Id)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("found %d disk.img.gz files in Drive, error %v, want 1", len(files), err)
+	}
+	content, _ := test.server.Content(files[0].Id)
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(io.Discard, gz); err != nil || n != size {
+		t.Errorf("uploaded %d bytes once decompressed, error %v, want %d", n, err, size)
+	}
+}
+
+func TestResumableUploadRestartsEncryptedFile(t *testing.T) {
+	ctx := context.Background()
+	test := newDriveTest(t)
+	test.p.Config.UploadChunkKB = 256
+	test.p.Config.FolderProcessors = map[string][]string{test.docs: {"filter", "encrypt"}}
+	client, err := test.server.Connect(256<<10)(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 700<<10)
+	rand.Read(content)
+	modTime := time.Now().Add(-time.Hour)
+	path := test.write(t, "large.bin", string(content), modTime)
+
+	// a run stopped after the first chunk of the encrypted file
+	encrypted, err := encryptReader(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encrypted.Close()
+	uri, err := client.StartUpload(ctx, test.parent, "large.bin.enc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset int64
+	stopped := io.MultiReader(io.LimitReader(encrypted, 300<<10), iotest.ErrReader(errors.New("stopped")))
+	if _, err = client.ResumeUpload(ctx, uri, stopped, func(stored int64) { offset = stored }); err == nil || offset == 0 {
+		t.Fatalf("stopped upload = %v with %d bytes stored, want it stopped after a chunk", err, offset)
+	}
+	checkpoint := state.Checkpoint{SessionURI: uri, Offset: offset, Size: int64(len(content)), ModTime: modTime, Processors: "filter,encrypt"}
+	if err = test.p.State.PutCheckpoint(path, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = test.p.uploadFile(ctx, client, file, path, "large.bin", test.parent); err != nil {
+		t.Fatal(err)
+	}
+	files := test.remoteFiles(t, "large.bin.enc")
+	if len(files) != 1 {
+		t.Fatalf("%d large.bin.enc files in Drive, want 1", len(files))
+	}
+	target := filepath.Join(t.TempDir(), "large.bin")
+	if _, err = RestoreFile(ctx, client, files[0], nil, "", "", target); err != nil {
+		t.Fatalf("restoring the upload started again: %v", err)
+	}
+	if restored, _ := os.ReadFile(target); !bytes.Equal(restored, content) {
+		t.Errorf("restored %d bytes, want the %d of the file", len(restored), len(content))
+	}
+	if left, _ := test.p.State.Checkpoint(path); left != nil {
+		t.Errorf("checkpoint %+v left after the upload, want none", left)
+	}
+}
@@ -0,0 +1,17 @@
+package pipeline
+
+import (
+	"os"
+	"syscall"
+)
+
+// sfDataless flags the files whose content is not on the disk, like the
+// online-only files of iCloud Drive, OneDrive or Dropbox.
+const sfDataless = 0x40000000
+
+// isPlaceholder reports whether the file of info is online-only, reading
+// it downloading it from the cloud.
+func isPlaceholder(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Flags&sfDataless != 0
+}
@@ -0,0 +1,11 @@
+//go:build !windows && !darwin
+
+package pipeline
+
+import "os"
+
+// isPlaceholder returns false, the cloud sync clients with online-only
+// files run on Windows and macOS.
+func isPlaceholder(info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,21 @@
+package pipeline
+
+import (
+	"os"
+	"syscall"
+)
+
+// attributes of the files whose content is not on the disk, like the
+// online-only files of OneDrive or Dropbox
+const (
+	fileAttributeOffline            = 0x1000
+	fileAttributeRecallOnOpen       = 0x40000
+	fileAttributeRecallOnDataAccess = 0x400000
+)
+
+// isPlaceholder reports whether the file of info is online-only, reading
+// it downloading it from the cloud.
+func isPlaceholder(info os.FileInfo) bool {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && data.FileAttributes&(fileAttributeOffline|fileAttributeRecallOnOpen|fileAttributeRecallOnDataAccess) != 0
+}
@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"log/slog"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/power"
+)
+
+// powerProbeInterval is how often the power source is read.
+const powerProbeInterval = time.Minute
+
+// defaultBatteryMinPercent is the charge below which the uploads wait on
+// battery.
+const defaultBatteryMinPercent = 30
+
+// holdBattery is the reason the uploads are held on a low battery.
+const holdBattery = "battery"
+
+// BatteryLow reports whether the uploads wait for the laptop, running on a
+// low battery, to be plugged in.
+func (p *Pipeline) BatteryLow() bool {
+	return p.heldFor() == holdBattery
+}
+
+// WatchPower holds the uploads while the laptop runs on a battery charged
+// below Config.Battery, until it is plugged in or charged again, reading
+// the power source every minute until ctx is cancelled. Nothing is read
+// while Config.Battery is nil.
+func (p *Pipeline) WatchPower(ctx context.Context) {
+	for {
+		select {
+		case <-p.clock().After(powerProbeInterval):
+		case <-ctx.Done():
+			return
+		}
+		if p.config().Battery == nil {
+			continue
+		}
+		status, err := power.Read()
+		if err == power.ErrUnsupported {
+			slog.Warn("Unable to read the power source on this system, uploads do not wait for AC power")
+			return
+		} else if err != nil {
+			slog.Debug("Unable to read the power source", "error", err)
+			continue
+		}
+		if !p.batteryLow(status) {
+			continue
+		}
+		p.hold(ctx, holdBattery, powerProbeInterval, func(ctx context.Context) bool {
+			status, err := power.Read()
+			return err != nil || !p.batteryLow(status)
+		}, events.BatteryChanged{Low: true, Percent: status.Percent}, events.BatteryChanged{Low: false})
+	}
+}
+
+// batteryLow reports whether status is a battery too low to upload.
+func (p *Pipeline) batteryLow(status power.Status) bool {
+	battery := p.config().Battery
+	if battery == nil || !status.OnBattery {
+		return false
+	}
+	minPercent := config.IntOrDefault(battery.MinPercent, defaultBatteryMinPercent)
+	return minPercent >= 100 || status.Percent >= 0 && status.Percent < minPercent
+}
@@ -0,0 +1,235 @@
+package pipeline
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+)
+
+// ErrSkip is returned by a processor when the file must not be uploaded.
+var ErrSkip = errors.New("skipped by processor")
+
+// Item is a file on its way to the backend. Every processor of the chain
+// may replace its name and wrap its body.
+type Item struct {
+	// Path is the local file.
+	Path string
+	// Name is the name of the file in the backend.
+	Name string
+	Body io.Reader
+}
+
+// Processor is a step of the chain a file goes through before its upload,
+// like filtering or compressing it.
+type Processor interface {
+	Process(ctx context.Context, item *Item) error
+}
+
+// ProcessorFunc adapts a function to a Processor.
+type ProcessorFunc func(ctx context.Context, item *Item) error
+
+// Process calls f.
+func (f ProcessorFunc) Process(ctx context.Context, item *Item) error {
+	return f(ctx, item)
+}
+
+var processorsMutex sync.RWMutex
+var processors = map[string]func(p *Pipeline) Processor{
+	"filter": func(p *Pipeline) Processor {
+		return ProcessorFunc(p.filter)
+	},
+	"gzip": func(p *Pipeline) Processor {
+		return ProcessorFunc(compress)
+	},
+	"encrypt": func(p *Pipeline) Processor {
+		return ProcessorFunc(encrypt)
+	},
+}
+
+// resumableProcessors give the same bytes for the same file on every run,
+// so an upload stopped halfway goes on after a restart from the bytes Drive
+// has. encrypt picks a new salt for every stream, and what the registered
+// processors give is not known.
+var resumableProcessors = map[string]bool{"filter": true, "gzip": true}
+
+// processor extensions added to the names in Drive
+const (
+	gzipExtension    = ".gz"
+	encryptExtension = ".enc"
+)
+
+// RegisterProcessor makes a processor available to the folderProcessors
+// setting under name, newProcessor building it for a pipeline.
+func RegisterProcessor(name string, newProcessor func(p *Pipeline) Processor) {
+	processorsMutex.Lock()
+	defer processorsMutex.Unlock()
+	processors[name] = newProcessor
+}
+
+// chain returns the processors the files of folder go through.
+func (p *Pipeline) chain(folder string) ([]Processor, error) {
+	processorsMutex.RLock()
+	defer processorsMutex.RUnlock()
+
+	var chain []Processor
+	for _, name := range p.config().ProcessorsForFolder(folder) {
+		newProcessor, ok := processors[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown processor %q", name)
+		}
+		chain = append(chain, newProcessor(p))
+	}
+	return chain, nil
+}
+
+// runChain runs item through chain.
+func runChain(ctx context.Context, chain []Processor, item *Item) error {
+	for _, processor := range chain {
+		if err := processor.Process(ctx, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filter skips the files Included leaves out, in case they were queued
+// another way.
+func (p *Pipeline) filter(ctx context.Context, item *Item) error {
+	if !p.Included(item.Path) {
+		return ErrSkip
+	}
+	return nil
+}
+
+// compress gzips the body, adding .gz to the name.
+func compress(ctx context.Context, item *Item) error {
+	body := item.Body
+	reader, writer := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(writer)
+		_, err := io.Copy(gz, body)
+		if err == nil {
+			err = gz.Close()
+		}
+		writer.CloseWithError(err)
+		closeBody(body)
+	}()
+	item.Body = reader
+	item.Name += gzipExtension
+	return nil
+}
+
+// encrypt encrypts the body with the master key, adding .enc to the name.
+func encrypt(ctx context.Context, item *Item) error {
+	body, err := encryptReader(item.Body)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt %s: %v", item.Path, err)
+	}
+	item.Body = body
+	item.Name += encryptExtension
+	return nil
+}
+
+// encryptReader returns a reader of body encrypted with the master key,
+// to be closed when not read to its end.
+func encryptReader(body io.Reader) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	encrypted, err := auth.EncryptWriter(writer)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_, err := io.Copy(encrypted, body)
+		if err == nil {
+			err = encrypted.Close()
+		}
+		writer.CloseWithError(err)
+		closeBody(body)
+	}()
+	return reader, nil
+}
+
+// Decompress undoes the gzip processor on a downloaded file called name,
+// read from body, returning the name and the body of the local file.
+func Decompress(name string, body io.Reader) (string, io.Reader, error) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to decompress %s: %v", name, err)
+	}
+	return strings.TrimSuffix(name, gzipExtension), gz, nil
+}
+
+// Decrypt undoes the encrypt processor on a downloaded file called name,
+// read from body, returning the name and the body of the local file.
+// Reading the body fails when the content was modified.
+func Decrypt(name string, body io.Reader) (string, io.Reader, error) {
+	plain, err := auth.DecryptReader(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to decrypt %s: %v", name, err)
+	}
+	return strings.TrimSuffix(name, encryptExtension), plain, nil
+}
+
+// Unprocess undoes, last first, the processors a downloaded file called
+// name went through, read from body, returning the name and the body of
+// the local file. Processors without a reverse, like filter, are left out.
+func Unprocess(name string, processors []string, body io.Reader) (string, io.Reader, error) {
+	var err error
+	for i := len(processors) - 1; i >= 0; i-- {
+		switch processors[i] {
+		case "gzip":
+			name, body, err = Decompress(name, body)
+		case "encrypt":
+			name, body, err = Decrypt(name, body)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return name, body, nil
+}
+
+// Processors returns the processors a Drive file called name went through,
+// kept in its properties props. Files uploaded before they were kept are
+// told by the extensions of name, unless localPath, the file it was
+// uploaded from when known, has them already.
+func Processors(name string, props map[string]string, localPath string) []string {
+	if value := drive.Property(props, drive.PropertyProcessors); value != "" {
+		return strings.Split(value, ",")
+	}
+	var processors []string
+	for _, step := range []struct{ extension, processor string }{{encryptExtension, "encrypt"}, {gzipExtension, "gzip"}} {
+		if localPath != "" && filepath.Base(localPath) == name {
+			break
+		}
+		if trimmed, ok := strings.CutSuffix(name, step.extension); ok {
+			name = trimmed
+			processors = append([]string{step.processor}, processors...)
+		}
+	}
+	return processors
+}
+
+// UnprocessedName returns the name of the local file backed up as the
+// Drive file called name, without the extensions of the processors.
+func UnprocessedName(name string) string {
+	name = strings.TrimSuffix(name, encryptExtension)
+	return strings.TrimSuffix(name, gzipExtension)
+}
+
+// closeBody releases body when a processor made it closable, so the
+// goroutine feeding it stops.
+func closeBody(body io.Reader) {
+	if closer, ok := body.(io.Closer); ok {
+		closer.Close()
+	}
+}
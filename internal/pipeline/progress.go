@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+)
+
+const progressInterval = 200 * time.Millisecond // minimum time between redraws
+
+// progressReader reports how much of a file has been read while it is
+// uploaded, redrawing a single progress line on stderr.
+type progressReader struct {
+	reader    io.Reader
+	name      string
+	size      int64
+	read      int64
+	start     time.Time
+	lastDrawn time.Time
+}
+
+func newProgressReader(reader io.Reader, name string, size int64) *progressReader {
+	return &progressReader{reader: reader, name: name, size: size, start: time.Now()}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.read += int64(n)
+	if err == io.EOF {
+		p.draw()
+		fmt.Fprintln(os.Stderr)
+	} else if time.Since(p.lastDrawn) >= progressInterval {
+		p.draw()
+	}
+	return n, err
+}
+
+func (p *progressReader) draw() {
+	p.lastDrawn = time.Now()
+	percent := 100.0
+	if p.size > 0 {
+		percent = float64(p.read) * 100 / float64(p.size)
+	}
+	eta := "--:--"
+	elapsed := time.Since(p.start)
+	if p.read > 0 && p.size > p.read {
+		remaining := time.Duration(float64(elapsed) * float64(p.size-p.read) / float64(p.read))
+		eta = humanize.Duration(remaining)
+	} else if p.read >= p.size {
+		eta = "00:00"
+	}
+	fmt.Fprintf(os.Stderr, "\r%s %5.1f%% %s/%s ETA %s ", p.name, percent,
+		humanize.Bytes(p.read), humanize.Bytes(p.size), eta)
+}
+
+// progressPublisher publishes UploadProgress events while a file is read,
+// at most once per progressInterval.
+type progressPublisher struct {
+	reader        io.Reader
+	bus           *events.Bus
+	path          string
+	size          int64
+	read          int64
+	lastPublished time.Time
+}
+
+func (p *progressPublisher) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.read += int64(n)
+	if err == io.EOF || time.Since(p.lastPublished) >= progressInterval {
+		p.lastPublished = time.Now()
+		p.bus.Publish(events.UploadProgress{Path: p.path, Sent: p.read, Size: p.size})
+	}
+	return n, err
+}
+
+// isInteractive reports whether the app runs attached to a terminal with
+// human readable output.
+func isInteractive() bool {
+	if logging.JSONOutput() || !logging.ConsoleOutput() {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
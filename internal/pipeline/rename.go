@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// renamed reports whether goFile, at a path state.db does not know, is a
+// file uploaded before from a path that no longer exists: renamed, or moved
+// between watched folders of the same account and processors. Its Drive
+// file is then renamed or moved instead of uploading the content again.
+// The file at a path with the same size is hashed, the inode only choosing
+// between files with the same content.
+func (p *Pipeline) renamed(ctx context.Context, path string, goFile File, account *drive.Account) (bool, error) {
+	if p.State == nil {
+		return false, nil
+	}
+	if known, err := p.State.Get(path); err != nil || known != nil {
+		return false, err
+	}
+	info, err := goFile.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	candidates := make(map[string]state.File)
+	err = p.State.ForEach(func(oldPath string, file state.File) error {
+		if file.Size != info.Size() || file.RemoteID == "" || file.Hash == "" || !p.sameDestination(oldPath, path) {
+			return nil
+		}
+		if _, err := p.fs().Stat(oldPath); err == nil {
+			// still there, a copy
+			return nil
+		}
+		candidates[oldPath] = file
+		return nil
+	})
+	if err != nil || len(candidates) == 0 {
+		return false, err
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, &contextReader{ctx: ctx, r: goFile})
+	if _, seekErr := goFile.Seek(0, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	if err != nil {
+		return false, err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	inode := fileInode(info)
+	oldPath := ""
+	for candidate, file := range candidates {
+		if file.Hash == hash && (oldPath == "" || inode != 0 && file.Inode == inode) {
+			oldPath = candidate
+		}
+	}
+	if oldPath == "" {
+		return false, nil
+	}
+	known := candidates[oldPath]
+
+	client := account.Client()
+	remoteFile, err := client.GetFile(ctx, known.RemoteID)
+	if drive.IsNotFound(err) || err == nil && remoteFile.Trashed {
+		p.forgetState(oldPath)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if drive.Property(remoteFile.AppProperties, drive.PropertyParts) != "" {
+		// its parts are named after the old name
+		return false, nil
+	}
+	// the processors added a suffix like .gz to the local name
+	oldName := filepath.Base(oldPath)
+	if !strings.HasPrefix(remoteFile.Name, oldName) {
+		return false, nil
+	}
+	name := filepath.Base(path) + strings.TrimPrefix(remoteFile.Name, oldName)
+	existing, err := client.FindFiles(ctx, name, account.Folder().Id)
+	if err != nil {
+		return false, err
+	}
+	for _, file := range existing {
+		if file.Id != remoteFile.Id {
+			// uploading updates the file already called name
+			return false, nil
+		}
+	}
+	// a file moved to another watched folder keeps its name, and its
+	// Drive file its folder when both folders upload to the same one
+	if remoteFile.Name != name || !slices.Contains(remoteFile.Parents, account.Folder().Id) {
+		if _, err = client.MoveFile(ctx, remoteFile, name, account.Folder().Id); err != nil {
+			return false, err
+		}
+	}
+	if err = p.setProperties(ctx, client, remoteFile.Id, path, hash, 0); err != nil {
+		slog.Warn("Unable to update the properties of the renamed file", "file", path, "error", err)
+	}
+
+	known.ModTime = info.ModTime()
+	known.Inode = inode
+	if err = p.State.Move(oldPath, path, known); err != nil {
+		slog.Error("Unable to save file state", "file", path, "error", err)
+	}
+	p.Events.Publish(events.FileRenamed{Path: path, From: oldPath, RemoteID: remoteFile.Id})
+	return true, nil
+}
+
+// sameDestination reports whether the files at both paths are uploaded to
+// the same account through the same processors, a Drive file uploaded
+// from one serving for the other. The files of folders organized by date
+// or with a remote template are not named after the local file, never
+// renamed.
+func (p *Pipeline) sameDestination(path string, otherPath string) bool {
+	folder, otherFolder := filepath.Dir(path), filepath.Dir(otherPath)
+	if p.config().DateFolder(folder) || p.config().DateFolder(otherFolder) ||
+		p.config().TemplateForFolder(folder) != "" || p.config().TemplateForFolder(otherFolder) != "" {
+		return false
+	}
+	return p.config().AccountForFolder(folder) == p.config().AccountForFolder(otherFolder) &&
+		slices.Equal(p.config().ProcessorsForFolder(folder), p.config().ProcessorsForFolder(otherFolder))
+}
@@ -0,0 +1,588 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/chunker"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/retention"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+	"github.com/amcereijo/EncryptBckDocs/internal/tracing"
+)
+
+// folders of the repository in the destination folder: the chunks are
+// called by the ContentID of their content, the snapshots
+// <folder name>.<UTC time>.json
+const (
+	RepositoryChunks    = "repository/chunks"
+	RepositorySnapshots = "repository/snapshots"
+)
+
+// Snapshot is a backup of a watched folder backed up as chunks, uploaded
+// as JSON to the snapshots folder.
+type Snapshot struct {
+	Folder string    `json:"folder"`
+	Host   string    `json:"host,omitempty"`
+	Time   time.Time `json:"time"`
+	// Tree is the chunk holding the Tree of the files.
+	Tree  string `json:"tree"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// Tree lists the files of a snapshot, stored as a chunk.
+type Tree struct {
+	Files []TreeFile `json:"files"`
+	// Encrypted is set when the chunks of the files are encrypted, the
+	// files of a tree stored before being read again by the next snapshot.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// TreeFile is a file of a snapshot and the chunks its content is made of,
+// in order.
+type TreeFile struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	Chunks  []string    `json:"chunks"`
+}
+
+// ReadChunk downloads the chunk id from the Drive file fileID, decrypting
+// it and checking its content against its ID. Chunks stored before they
+// were encrypted, called by the SHA-256 of their content, are still read.
+func ReadChunk(ctx context.Context, client drive.Client, fileID string, id string) ([]byte, error) {
+	var stored bytes.Buffer
+	if err := client.Download(ctx, fileID, &stored); err != nil {
+		return nil, fmt.Errorf("Unable to download chunk %s: %v", id, err)
+	}
+	encrypted := auth.IsEncrypted(stored.Bytes())
+	var compressed io.Reader = &stored
+	if encrypted {
+		plain, err := auth.DecryptReader(&stored)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decrypt chunk %s: %v", id, err)
+		}
+		compressed = plain
+	}
+	gz, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read chunk %s: %v", id, err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read chunk %s: %v", id, err)
+	}
+	var contentID string
+	if encrypted {
+		contentID, err = auth.ContentID(data)
+	} else {
+		sum := sha256.Sum256(data)
+		contentID = hex.EncodeToString(sum[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if contentID != id {
+		return nil, fmt.Errorf("Chunk %s does not match its content", id)
+	}
+	return data, nil
+}
+
+// sealChunk returns data compressed then encrypted with the master key,
+// the way chunks are stored.
+func sealChunk(data []byte) (*bytes.Buffer, error) {
+	var sealed bytes.Buffer
+	encrypted, err := auth.EncryptWriter(&sealed)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encrypt the chunk: %v", err)
+	}
+	gz := gzip.NewWriter(encrypted)
+	if _, err = gz.Write(data); err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = encrypted.Close()
+	}
+	return &sealed, err
+}
+
+// repository stores the chunks of the snapshots of an account.
+type repository struct {
+	p      *Pipeline
+	client drive.Client
+	chunks *drivev3.File
+	// stored are the Drive IDs of the chunks known stored, by ID
+	stored map[string]string
+	// uploaded counts the bytes of the chunks uploaded
+	uploaded int64
+}
+
+// snapshotFolder backs up a watched folder backed up as chunks: its
+// included files are split in content-defined chunks, the chunks not
+// stored yet by any file or snapshot are uploaded, and a snapshot
+// pointing to the list of the files and their chunks is uploaded. The
+// files with the size and modification time they had in the last snapshot
+// are not read again.
+func (p *Pipeline) snapshotFolder(ctx context.Context, folder string, result *Summary) {
+	ctx, span := tracing.Tracer.Start(ctx, "snapshot", trace.WithAttributes(attribute.String("folder", folder)))
+	defer span.End()
+	start := p.clock().Now()
+
+	account, err := p.Accounts.ForFolder(ctx, folder)
+	if err == nil {
+		err = p.waitOnline(ctx)
+	}
+	repo := &repository{p: p, stored: make(map[string]string)}
+	if err == nil {
+		repo.client = account.Client()
+		repo.chunks, err = p.Accounts.EnsureFolderPath(ctx, account, account.Folder(), RepositoryChunks)
+	}
+	if err == nil {
+		err = repo.forgetCollected(ctx)
+	}
+	if err != nil {
+		result.AddFailure(folder, fmt.Errorf("Unable to open the repository: %v", err))
+		return
+	}
+
+	var previous *state.Snapshot
+	previousFiles := make(map[string]TreeFile)
+	if p.State != nil {
+		if previous, err = p.State.Snapshot(folder); err != nil {
+			slog.Error("Unable to read the last snapshot, reading every file", "folder", folder, "error", err)
+		}
+	}
+	if previous != nil {
+		if tree, err := repo.readTree(ctx, previous.Tree); err != nil {
+			slog.Warn("Unable to read the last snapshot, reading every file", "folder", folder, "error", err)
+		} else if !tree.Encrypted {
+			slog.Info("Last snapshot stored before the chunks were encrypted, reading every file", "folder", folder)
+		} else {
+			for _, file := range tree.Files {
+				previousFiles[file.Name] = file
+			}
+		}
+	}
+
+	tree, size, err := repo.storeFiles(ctx, folder, previousFiles)
+	var treeID string
+	if err == nil {
+		treeID, err = repo.storeTree(ctx, tree)
+	}
+	if err != nil {
+		err = fmt.Errorf("Unable to store the snapshot of %s: %v", folder, err)
+		if ctx.Err() == nil {
+			p.Events.Publish(events.UploadFailed{Path: folder, Err: err, Backend: true})
+		}
+		result.Add(folder, history.ActionFail, err)
+		return
+	}
+	if previous != nil && previous.Tree == treeID {
+		slog.Info("No file changed since the last snapshot", "folder", folder)
+		result.Add(folder, history.ActionSkip, nil)
+		return
+	}
+
+	snapshot := Snapshot{Folder: folder, Time: start, Tree: treeID, Files: len(tree.Files), Size: size}
+	snapshot.Host, _ = os.Hostname()
+	name := fmt.Sprintf("%s.%s.json", filepath.Base(folder), start.UTC().Format(archiveTimeFormat))
+	remoteFile, err := repo.uploadSnapshot(ctx, account, name, snapshot)
+	if err != nil {
+		err = fmt.Errorf("Unable to upload the snapshot %s: %v", name, err)
+		if ctx.Err() == nil {
+			p.Events.Publish(events.UploadFailed{Path: folder, Err: err, Backend: true})
+		}
+		result.Add(folder, history.ActionFail, err)
+		return
+	}
+	if p.State != nil {
+		if err := p.State.PutSnapshot(folder, state.Snapshot{Name: name, Tree: treeID, Time: start}); err != nil {
+			slog.Error("Unable to save the snapshot state, the next snapshot reads every file", "folder", folder, "error", err)
+		}
+	}
+	p.updateLastUpdate()
+	slog.Info("Snapshot of folder", "folder", folder, "snapshot", name, "files", len(tree.Files), "uploaded", repo.uploaded)
+	p.Events.Publish(events.UploadSucceeded{
+		Path:         folder,
+		Folder:       folder,
+		Action:       history.ActionUpload,
+		RemoteID:     remoteFile.Id,
+		RemoteFolder: account.Folder().Name,
+		Hash:         treeID,
+		Size:         repo.uploaded,
+		Duration:     p.clock().Now().Sub(start),
+	})
+	result.Add(folder, history.ActionUpload, nil)
+}
+
+// storeFiles stores the chunks of the included files of folder, the ones
+// unchanged since previous keeping their chunks, and returns their tree
+// and total size.
+func (repo *repository) storeFiles(ctx context.Context, folder string, previous map[string]TreeFile) (Tree, int64, error) {
+	p := repo.p
+	tree := Tree{Files: []TreeFile{}, Encrypted: true}
+	var size int64
+	err := p.fs().WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filepath.Clean(path) == filepath.Clean(folder) {
+				return nil
+			}
+			// subfolders are not watched
+			return filepath.SkipDir
+		}
+		if !p.Included(path) || p.skipped(path) != "" {
+			return nil
+		}
+		file, err := p.fs().Open(path)
+		if os.IsNotExist(err) {
+			// deleted while reading the folder
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		entryFile := TreeFile{Name: filepath.Base(path), Size: info.Size(), Mode: info.Mode().Perm(), ModTime: info.ModTime()}
+		if known, ok := previous[entryFile.Name]; ok && known.Size == entryFile.Size && known.ModTime.Equal(entryFile.ModTime) {
+			entryFile.Chunks = known.Chunks
+		} else if entryFile.Chunks, err = repo.storeContent(ctx, path, file, info.Size()); err != nil {
+			return err
+		}
+		tree.Files = append(tree.Files, entryFile)
+		size += entryFile.Size
+		return nil
+	})
+	return tree, size, err
+}
+
+// storeContent stores the chunks of the size bytes of file and returns
+// their IDs.
+func (repo *repository) storeContent(ctx context.Context, path string, file File, size int64) ([]string, error) {
+	// a file growing while read keeps its size when it started
+	chunks := chunker.New(io.LimitReader(&contextReader{ctx: ctx, r: file}, size))
+	ids := []string{}
+	var read int64
+	for {
+		data, err := chunks.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		read += int64(len(data))
+		id, err := auth.ContentID(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.storeChunk(ctx, id, data); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if read < size {
+		return nil, fmt.Errorf("%s shrank while read", path)
+	}
+	return ids, nil
+}
+
+// forgetCollected replaces the chunks state.db knows with the ones stored
+// in the chunks folder when gc trashed chunks since they were saved, on
+// this machine or another one sharing the repository.
+func (repo *repository) forgetCollected(ctx context.Context) error {
+	p := repo.p
+	if p.State == nil {
+		return nil
+	}
+	folder, err := repo.client.GetFile(ctx, repo.chunks.Id)
+	if err != nil {
+		return err
+	}
+	collected := drive.Property(folder.AppProperties, drive.PropertyCollected)
+	if collected == "" {
+		return nil
+	}
+	if known, err := p.State.ChunksCollected(repo.chunks.Id); err != nil || known == collected {
+		return err
+	}
+	files, err := repo.client.ListFiles(ctx, repo.chunks.Id)
+	if err != nil {
+		return fmt.Errorf("Unable to list the chunks: %v", err)
+	}
+	chunks := make(map[string]string, len(files))
+	for _, file := range files {
+		chunks[file.Name] = file.Id
+	}
+	slog.Info("Chunks collected by gc, known chunks listed again", "collected", collected, "chunks", len(chunks))
+	return p.State.ResetChunks(repo.chunks.Id, collected, chunks)
+}
+
+// storeChunk uploads the chunk id, unless it is already stored.
+func (repo *repository) storeChunk(ctx context.Context, id string, data []byte) error {
+	if _, ok := repo.stored[id]; ok {
+		return nil
+	}
+	p := repo.p
+	if p.State != nil {
+		if remoteID, err := p.State.Chunk(id); err == nil && remoteID != "" {
+			repo.stored[id] = remoteID
+			return nil
+		}
+	}
+	// stored by another machine or before losing state.db
+	files, err := repo.client.FindFiles(ctx, id, repo.chunks.Id)
+	if err != nil {
+		return err
+	}
+	var remoteID string
+	if len(files) > 0 {
+		remoteID = files[0].Id
+	} else {
+		sealed, err := sealChunk(data)
+		if err != nil {
+			return err
+		}
+		size := int64(sealed.Len())
+		remoteFile, err := repo.client.CreateFile(ctx, repo.chunks, id, sealed)
+		if err != nil {
+			return err
+		}
+		remoteID = remoteFile.Id
+		repo.uploaded += size
+	}
+	repo.stored[id] = remoteID
+	if p.State != nil {
+		if err := p.State.PutChunk(id, remoteID); err != nil {
+			slog.Error("Unable to save the chunk state", "chunk", id, "error", err)
+		}
+	}
+	return nil
+}
+
+// storeTree stores tree as a chunk and returns its ID.
+func (repo *repository) storeTree(ctx context.Context, tree Tree) (string, error) {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return "", err
+	}
+	id, err := auth.ContentID(data)
+	if err != nil {
+		return "", err
+	}
+	return id, repo.storeChunk(ctx, id, data)
+}
+
+// readTree downloads the tree stored as the chunk id.
+func (repo *repository) readTree(ctx context.Context, id string) (*Tree, error) {
+	remoteID := ""
+	if repo.p.State != nil {
+		remoteID, _ = repo.p.State.Chunk(id)
+	}
+	if remoteID == "" {
+		files, err := repo.client.FindFiles(ctx, id, repo.chunks.Id)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("No chunk %s", id)
+		}
+		remoteID = files[0].Id
+	}
+	data, err := ReadChunk(ctx, repo.client, remoteID, id)
+	if err != nil {
+		return nil, err
+	}
+	tree := &Tree{}
+	return tree, json.Unmarshal(data, tree)
+}
+
+// uploadSnapshot uploads snapshot as name to the snapshots folder of
+// account.
+func (repo *repository) uploadSnapshot(ctx context.Context, account *drive.Account, name string, snapshot Snapshot) (*drivev3.File, error) {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := repo.p.Accounts.EnsureFolderPath(ctx, account, account.Folder(), RepositorySnapshots)
+	if err != nil {
+		return nil, err
+	}
+	return repo.client.CreateFile(ctx, snapshots, name, bytes.NewReader(data))
+}
+
+// ReadSnapshot downloads the snapshot uploaded as file.
+func ReadSnapshot(ctx context.Context, client drive.Client, file *drivev3.File) (Snapshot, error) {
+	var snapshot Snapshot
+	var data bytes.Buffer
+	if err := client.Download(ctx, file.Id, &data); err != nil {
+		return snapshot, fmt.Errorf("Unable to download snapshot %s: %v", file.Name, err)
+	}
+	if err := json.Unmarshal(data.Bytes(), &snapshot); err != nil {
+		return snapshot, fmt.Errorf("Unable to read snapshot %s: %v", file.Name, err)
+	}
+	return snapshot, nil
+}
+
+// chunkGrace is how long a chunk no kept snapshot lists is left alone: a
+// snapshot under way on another machine stores its chunks before the
+// snapshot listing them.
+const chunkGrace = 24 * time.Hour
+
+// Garbage is what the retention policy collects in a repository.
+type Garbage struct {
+	// ChunksFolder is the chunks folder of the repository.
+	ChunksFolder *drivev3.File
+	// Snapshots are the snapshots the policy does not keep.
+	Snapshots []*drivev3.File
+	// Chunks are the chunks none of the kept snapshots lists.
+	Chunks []*drivev3.File
+}
+
+// CollectRepository returns the snapshots of the repository in the
+// destination folder root that policy does not keep at now, the latest one
+// of every folder and machine always being kept, and the chunks the kept
+// ones do not list, nil when there is no repository. Every kept snapshot
+// and its list of files is read, and any of them missing or unreadable is
+// an error, so a chunk is never collected for a snapshot it failed to read.
+func CollectRepository(ctx context.Context, client drive.Client, root *drivev3.File, policy *config.Retention, now time.Time) (*Garbage, error) {
+	chunksFolder, err := findFolderPath(ctx, client, root, RepositoryChunks)
+	if err != nil || chunksFolder == nil {
+		return nil, err
+	}
+	garbage := &Garbage{ChunksFolder: chunksFolder}
+	chunkFiles, err := client.ListFiles(ctx, chunksFolder.Id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list the chunks: %v", err)
+	}
+	chunks := make(map[string]*drivev3.File, len(chunkFiles))
+	for _, file := range chunkFiles {
+		chunks[file.Name] = file
+	}
+
+	snapshotsFolder, err := findFolderPath(ctx, client, root, RepositorySnapshots)
+	if err != nil {
+		return nil, err
+	}
+	var snapshotFiles []*drivev3.File
+	if snapshotsFolder != nil {
+		if snapshotFiles, err = client.ListFiles(ctx, snapshotsFolder.Id); err != nil {
+			return nil, fmt.Errorf("Unable to list the snapshots: %v", err)
+		}
+	}
+	// the snapshots of every folder and machine, oldest first
+	type snapshotFile struct {
+		file     *drivev3.File
+		snapshot Snapshot
+	}
+	groups := make(map[string][]snapshotFile)
+	for _, file := range snapshotFiles {
+		snapshot, err := ReadSnapshot(ctx, client, file)
+		if err != nil {
+			return nil, err
+		}
+		key := snapshot.Folder + "\x00" + snapshot.Host
+		groups[key] = append(groups[key], snapshotFile{file, snapshot})
+	}
+	referenced := make(map[string]bool)
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].snapshot.Time.Before(group[j].snapshot.Time) })
+		times := make([]time.Time, len(group))
+		for i, s := range group {
+			times[i] = s.snapshot.Time
+		}
+		keep := retention.Keep(times, policy, now)
+		for i, s := range group {
+			if !keep[i] && i < len(group)-1 {
+				garbage.Snapshots = append(garbage.Snapshots, s.file)
+				continue
+			}
+			tree, ok := chunks[s.snapshot.Tree]
+			if !ok {
+				return nil, fmt.Errorf("Chunk %s of snapshot %s is missing in Drive", s.snapshot.Tree, s.file.Name)
+			}
+			data, err := ReadChunk(ctx, client, tree.Id, s.snapshot.Tree)
+			if err != nil {
+				return nil, err
+			}
+			var files Tree
+			if err = json.Unmarshal(data, &files); err != nil {
+				return nil, fmt.Errorf("Unable to read the files of snapshot %s: %v", s.file.Name, err)
+			}
+			referenced[s.snapshot.Tree] = true
+			for _, file := range files.Files {
+				for _, id := range file.Chunks {
+					referenced[id] = true
+				}
+			}
+		}
+	}
+
+	for _, file := range chunkFiles {
+		if referenced[file.Name] {
+			continue
+		}
+		if modified, err := time.Parse(time.RFC3339, file.ModifiedTime); err != nil || now.Sub(modified) < chunkGrace {
+			continue
+		}
+		garbage.Chunks = append(garbage.Chunks, file)
+	}
+	return garbage, nil
+}
+
+// MarkCollected tells the machines sharing the repository that gc trashed
+// chunks of chunksFolder at now, so they stop relying on the chunks they
+// knew.
+func MarkCollected(ctx context.Context, client drive.Client, chunksFolder *drivev3.File, now time.Time) error {
+	props := map[string]string{drive.PropertyCollected: now.UTC().Format(time.RFC3339Nano)}
+	if err := client.SetAppProperties(ctx, chunksFolder.Id, props); err != nil {
+		return fmt.Errorf("Unable to mark the chunks collected: %v", err)
+	}
+	return nil
+}
+
+// findFolderPath returns the folder at path, like "repository/chunks",
+// under parent, nil when missing.
+func findFolderPath(ctx context.Context, client drive.Client, parent *drivev3.File, path string) (*drivev3.File, error) {
+	folder := parent
+	for _, name := range strings.Split(path, "/") {
+		var err error
+		if folder, err = client.FindFolder(ctx, name, folder.Id); err != nil || folder == nil {
+			return nil, err
+		}
+	}
+	return folder, nil
+}
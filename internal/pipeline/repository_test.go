@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+)
+
+func TestSnapshotFolderEncryptsChunks(t *testing.T) {
+	test := newDriveTest(t)
+	test.connect()
+	modTime := time.Now().Add(-time.Hour)
+	test.write(t, "notes.txt", strings.Repeat("secret notes\n", 1000), modTime)
+	test.write(t, "copy.txt", strings.Repeat("secret notes\n", 1000), modTime)
+
+	result := &Summary{}
+	test.p.snapshotFolder(context.Background(), test.docs, result)
+	if result.Uploaded != 1 {
+		t.Fatalf("snapshot not uploaded: %v", result.Failures)
+	}
+
+	chunks := map[string]string{}
+	for _, file := range test.server.Files() {
+		content, _ := test.server.Content(file.Id)
+		if file.MimeType == "application/vnd.google-apps.folder" || strings.HasSuffix(file.Name, ".json") {
+			continue
+		}
+		if bytes.Contains(content, []byte("secret")) || bytes.Contains(content, []byte("notes.txt")) {
+			t.Errorf("chunk %s is readable in Drive, want it encrypted", file.Name)
+		}
+		if sha256Hex(strings.Repeat("secret notes\n", 1000)) == file.Name {
+			t.Errorf("chunk %s is called by the SHA-256 of its content, want a keyed ID", file.Name)
+		}
+		chunks[file.Name] = file.Id
+	}
+	// the two files share their chunk, the tree is another one
+	if len(chunks) != 2 {
+		t.Errorf("%d chunks in Drive, want the content once and the tree", len(chunks))
+	}
+
+	known, err := test.p.State.Snapshot(test.docs)
+	if err != nil || known == nil {
+		t.Fatalf("state of the snapshot = %v, %v", known, err)
+	}
+	data, err := ReadChunk(context.Background(), test.client, chunks[known.Tree], known.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree Tree
+	if err = json.Unmarshal(data, &tree); err != nil {
+		t.Fatal(err)
+	}
+	if !tree.Encrypted || len(tree.Files) != 2 {
+		t.Fatalf("tree = %+v, want the 2 files with encrypted chunks", tree)
+	}
+	for _, file := range tree.Files {
+		var content []byte
+		for _, id := range file.Chunks {
+			data, err := ReadChunk(context.Background(), test.client, chunks[id], id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content = append(content, data...)
+		}
+		if string(content) != strings.Repeat("secret notes\n", 1000) {
+			t.Errorf("%s restored with %d bytes, want its content", file.Name, len(content))
+		}
+	}
+}
+
+func TestReadChunkBeforeEncryption(t *testing.T) {
+	test := newUploadTest(t, false)
+	data := []byte("a chunk stored before the chunks were encrypted")
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(data)
+	gz.Close()
+	id := sha256Hex(string(data))
+	file := test.client.add(id, test.parent.Id, compressed.Bytes(), nil)
+
+	read, err := ReadChunk(context.Background(), test.client, file.Id, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("read %q, want %q", read, data)
+	}
+	// a plain chunk is only accepted under the SHA-256 of its content
+	other := test.client.add("other", test.parent.Id, compressed.Bytes(), nil)
+	if _, err = ReadChunk(context.Background(), test.client, other.Id, sha256Hex("other content")); err == nil {
+		t.Error("read a chunk not matching its ID, want an error")
+	}
+}
+
+func TestCollectRepository(t *testing.T) {
+	ctx := context.Background()
+	test := newDriveTest(t)
+	test.connect()
+	clock := &fakeClock{}
+	test.p.Clock = clock
+	now := time.Now()
+	modTime := now.Add(-4 * 365 * 24 * time.Hour)
+	snapshot := func(content string, when time.Time) {
+		t.Helper()
+		modTime = modTime.Add(time.Minute)
+		test.write(t, "notes.txt", content, modTime)
+		clock.now = when
+		result := &Summary{}
+		test.p.snapshotFolder(ctx, test.docs, result)
+		if result.Uploaded != 1 {
+			t.Fatalf("snapshot not uploaded: %v", result.Failures)
+		}
+	}
+	// expired, years old, then the 3 latest ones kept
+	snapshot("first version", now.AddDate(-3, 0, 0))
+	snapshot("second version", now.Add(-3*time.Minute))
+	snapshot("third version", now.Add(-2*time.Minute))
+	snapshot("fourth version", now.Add(-time.Minute))
+	expiredChunk, _ := auth.ContentID([]byte("first version"))
+	account, err := test.p.Accounts.Get(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	garbage, err := CollectRepository(ctx, test.client, account.Folder(), nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(garbage.Snapshots) != 1 || !strings.Contains(garbage.Snapshots[0].Name, now.AddDate(-3, 0, 0).UTC().Format("20060102")) {
+		t.Errorf("collected snapshots %v, want the expired one", garbage.Snapshots)
+	}
+	// the chunks were stored a moment ago, by a snapshot maybe under way
+	if len(garbage.Chunks) != 0 {
+		t.Errorf("collected %d chunks stored less than a day ago, want none", len(garbage.Chunks))
+	}
+
+	garbage, err = CollectRepository(ctx, test.client, account.Folder(), nil, now.Add(2*chunkGrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the content and the list of files of the expired snapshot
+	if len(garbage.Chunks) != 2 {
+		t.Fatalf("collected %d chunks, want the 2 of the expired snapshot", len(garbage.Chunks))
+	}
+	for _, file := range append(garbage.Snapshots, garbage.Chunks...) {
+		if err = test.client.TrashFile(ctx, file.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = MarkCollected(ctx, test.client, garbage.ChunksFolder, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunk state.db knows is in the trash, stored again
+	snapshot("first version", now)
+	remoteID, err := test.p.State.Chunk(expiredChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := test.client.GetFile(ctx, remoteID)
+	if err != nil || stored.Trashed {
+		t.Errorf("chunk of the new snapshot = %+v, %v, want it stored again", stored, err)
+	}
+	garbage, err = CollectRepository(ctx, test.client, account.Folder(), nil, now.Add(2*chunkGrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range garbage.Chunks {
+		if file.Name == expiredChunk {
+			t.Error("collected the chunk of the latest snapshot")
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+)
+
+// Backup is a backed up file being downloaded.
+type Backup struct {
+	// Name is the name of the local file, without the extensions of the
+	// processors.
+	Name string
+	// Body is the content of the local file, the processors undone and
+	// the parts of a split file put back together.
+	Body io.Reader
+
+	reader *io.PipeReader
+	stored hash.Hash
+	split  bool
+}
+
+// OpenBackup starts downloading the Drive file remoteFile, or its revision
+// when not nil. localPath is the file it was uploaded from, "" when not
+// known. The Backup must be closed.
+func OpenBackup(ctx context.Context, client drive.Client, remoteFile *drivev3.File, revision *drivev3.Revision, localPath string) (*Backup, error) {
+	download := func(w io.Writer) error {
+		if revision != nil {
+			return client.DownloadRevision(ctx, remoteFile.Id, revision.Id, w)
+		}
+		return client.Download(ctx, remoteFile.Id, w)
+	}
+	split := drive.Property(remoteFile.AppProperties, drive.PropertyParts) != ""
+	reader, writer := io.Pipe()
+	go func() {
+		if !split {
+			writer.CloseWithError(download(writer))
+			return
+		}
+		// the Drive file is the manifest of the parts holding the content
+		var manifest bytes.Buffer
+		if err := download(&manifest); err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		writer.CloseWithError(DownloadParts(ctx, client, manifest.Bytes(), writer))
+	}()
+
+	// Drive keeps the MD5 of the stored content, still compressed and
+	// encrypted
+	backup := &Backup{reader: reader, stored: md5.New(), split: split}
+	processors := Processors(remoteFile.Name, remoteFile.AppProperties, localPath)
+	name, body, err := Unprocess(remoteFile.Name, processors, io.TeeReader(reader, backup.stored))
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	backup.Name, backup.Body = name, body
+	return backup, nil
+}
+
+// StoredMD5 reads what is left of the stored content and returns its MD5,
+// the one Drive keeps of a revision. It is "" for a file uploaded in
+// parts, whose Drive file only holds their manifest.
+func (backup *Backup) StoredMD5() (string, error) {
+	if _, err := io.Copy(io.Discard, io.TeeReader(backup.reader, backup.stored)); err != nil {
+		return "", err
+	}
+	if backup.split {
+		return "", nil
+	}
+	return hex.EncodeToString(backup.stored.Sum(nil)), nil
+}
+
+// Close stops the download.
+func (backup *Backup) Close() error {
+	return backup.reader.Close()
+}
+
+// RestoreFile downloads the Drive file remoteFile, or its revision when
+// not nil, to target, which must not exist. localPath is the file it was
+// uploaded from, "" when not known. The latest version is checked against
+// knownHash, the SHA-256 of the uploaded file, or the one kept in the
+// properties of remoteFile when empty, a revision against the MD5 Drive
+// keeps of it. The file is written aside and moved to target once complete
+// and verified, with the permissions, owner and extended attributes kept
+// in the properties. It returns the SHA-256 of the restored file.
+func RestoreFile(ctx context.Context, client drive.Client, remoteFile *drivev3.File, revision *drivev3.Revision, localPath string, knownHash string, target string) (string, error) {
+	if knownHash == "" {
+		knownHash = drive.Property(remoteFile.AppProperties, drive.PropertyHash)
+	}
+	backup, err := OpenBackup(ctx, client, remoteFile, revision, localPath)
+	if err != nil {
+		return "", err
+	}
+	defer backup.Close()
+
+	part, err := os.CreateTemp(filepath.Dir(target), ".restore-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(part.Name())
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(part, hasher), backup.Body)
+	if closeErr := part.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("Unable to download %s: %v", remoteFile.Name, err)
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if revision != nil {
+		// the known hash is the one of the latest version only
+		sum, err := backup.StoredMD5()
+		if err != nil {
+			return "", fmt.Errorf("Unable to download %s: %v", remoteFile.Name, err)
+		}
+		if sum != "" && revision.Md5Checksum != "" && sum != revision.Md5Checksum {
+			return "", fmt.Errorf("Downloaded revision %s of %s does not match Drive, md5 %s instead of %s", revision.Id, remoteFile.Name, sum, revision.Md5Checksum)
+		}
+	} else if knownHash == "" {
+		slog.Warn("No hash of the uploaded file, its content is not verified", "file", remoteFile.Name)
+	} else if hash != knownHash {
+		return "", fmt.Errorf("Downloaded %s does not match the uploaded file, sha256 %s instead of %s", remoteFile.Name, hash, knownHash)
+	}
+
+	if _, err = os.Lstat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+	if err = os.Rename(part.Name(), target); err != nil {
+		return "", err
+	}
+	if err = RestoreMetadata(target, remoteFile.AppProperties); err != nil {
+		slog.Warn("Unable to restore the file attributes", "file", target, "error", err)
+	}
+	if link := drive.Property(remoteFile.AppProperties, drive.PropertySymlink); link != "" {
+		slog.Info("The backed up file was a symbolic link, its target content is restored", "file", target, "link", link)
+	}
+	return hash, nil
+}
+
+// LocalName returns the name of the local file backed up as the Drive file
+// remoteFile, without the extensions of its processors. localPath is the
+// file it was uploaded from, "" when not known.
+func LocalName(remoteFile *drivev3.File, localPath string) string {
+	name := remoteFile.Name
+	processors := Processors(name, remoteFile.AppProperties, localPath)
+	for i := len(processors) - 1; i >= 0; i-- {
+		switch processors[i] {
+		case "gzip":
+			name = strings.TrimSuffix(name, gzipExtension)
+		case "encrypt":
+			name = strings.TrimSuffix(name, encryptExtension)
+		}
+	}
+	return name
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRestoreFile(t *testing.T) {
+	test := newUploadTest(t, false)
+	test.p.Config.FolderProcessors = map[string][]string{test.docs: {"filter", "gzip", "encrypt"}}
+	if _, _, err := test.upload(t, "report.txt", "restored content"); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := test.client.FindFiles(context.Background(), "report.txt.gz.enc", test.parent.Id)
+	if len(files) != 1 {
+		t.Fatalf("found %d report.txt.gz.enc files in Drive, want 1", len(files))
+	}
+	remoteFile := files[0]
+	if name := LocalName(remoteFile, ""); name != "report.txt" {
+		t.Errorf("LocalName = %q, want report.txt", name)
+	}
+
+	target := filepath.Join(t.TempDir(), "report.txt")
+	hash, err := RestoreFile(context.Background(), test.client, remoteFile, nil, "", "", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != sha256Hex("restored content") {
+		t.Errorf("hash = %s, want the one of the uploaded file", hash)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "restored content" {
+		t.Errorf("restored %q, want %q", content, "restored content")
+	}
+
+	// an existing file is never overwritten
+	if _, err = RestoreFile(context.Background(), test.client, remoteFile, nil, "", "", target); err == nil {
+		t.Error("restored over an existing file, want an error")
+	}
+}
+
+func TestRestoreFileMismatch(t *testing.T) {
+	test := newUploadTest(t, false)
+	if _, _, err := test.upload(t, "report.txt", "uploaded content"); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := test.client.FindFiles(context.Background(), "report.txt", test.parent.Id)
+	if len(files) != 1 {
+		t.Fatalf("found %d report.txt files in Drive, want 1", len(files))
+	}
+
+	target := filepath.Join(t.TempDir(), "report.txt")
+	if _, err := RestoreFile(context.Background(), test.client, files[0], nil, "", sha256Hex("other content"), target); err == nil {
+		t.Error("restored a file not matching its hash, want an error")
+	}
+	if _, err := os.Lstat(target); !os.IsNotExist(err) {
+		t.Errorf("a file not matching its hash was written to %s", target)
+	}
+}
@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// checkSizeCap returns an error when uploading job would take the backup
+// of its watched folder, or the whole backup, over its size cap. A file
+// not growing the backup always goes, the caps only stop it growing. The
+// sizes are the ones of the local files in state.db, so nothing is capped
+// without it.
+func (p *Pipeline) checkSizeCap(job *queue.Job) error {
+	folder := filepath.Dir(job.Path)
+	folderCap := int64(p.config().FolderMaxSizeMB[folder]) << 20
+	totalCap := int64(p.config().MaxBackupSizeMB) << 20
+	if p.State == nil || folderCap <= 0 && totalCap <= 0 {
+		return nil
+	}
+	p.sizesMutex.Lock()
+	defer p.sizesMutex.Unlock()
+	sizes := p.backedUpSizes()
+	growth := job.Size
+	if known, err := p.State.Get(job.Path); err == nil && known != nil {
+		growth -= known.Size
+	}
+	if growth <= 0 {
+		return nil
+	}
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+
+	exceeded := events.SizeCapExceeded{Path: job.Path, Size: job.Size, Exclude: suggestedExclude(job.Path)}
+	if folderCap > 0 && sizes[folder]+growth > folderCap {
+		exceeded.Folder, exceeded.Used, exceeded.Cap = folder, sizes[folder], folderCap
+	} else if totalCap > 0 && total+growth > totalCap {
+		exceeded.Used, exceeded.Cap = total, totalCap
+	} else {
+		return nil
+	}
+	p.Events.Publish(exceeded)
+	return fmt.Errorf("Backup size cap of %s reached, %s backed up, exclude the file with %q or raise the cap",
+		humanize.Bytes(exceeded.Cap), humanize.Bytes(exceeded.Used), exceeded.Exclude)
+}
+
+// backedUpSizes returns the bytes backed up of every watched folder, read
+// from state.db the first time. sizesMutex must be held.
+func (p *Pipeline) backedUpSizes() map[string]int64 {
+	if p.sizes != nil {
+		return p.sizes
+	}
+	sizes := make(map[string]int64)
+	err := p.State.ForEach(func(path string, file state.File) error {
+		sizes[filepath.Dir(path)] += file.Size
+		return nil
+	})
+	if err != nil {
+		slog.Error("Unable to read file state, the size caps count the files uploaded from now on", "error", err)
+	}
+	p.sizes = sizes
+	return sizes
+}
+
+// trackSize updates the backed up bytes once the state of the file at path
+// says it is size bytes, 0 when forgotten, before the state is saved. It
+// does nothing until the sizes are needed.
+func (p *Pipeline) trackSize(path string, size int64) {
+	p.sizesMutex.Lock()
+	defer p.sizesMutex.Unlock()
+	if p.sizes == nil {
+		return
+	}
+	if known, err := p.State.Get(path); err == nil && known != nil {
+		size -= known.Size
+	}
+	p.sizes[filepath.Dir(path)] += size
+}
+
+// suggestedExclude returns an Exclude pattern leaving out the file at
+// path and the ones like it: its extension, or its name without one.
+func suggestedExclude(path string) string {
+	if extension := filepath.Ext(path); extension != "" {
+		return "*" + extension
+	}
+	return filepath.Base(path)
+}
@@ -0,0 +1,309 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+// PartManifest is the content of the Drive file of a file uploaded in
+// parts, listing them in order.
+type PartManifest struct {
+	// Size is the bytes stored in the parts.
+	Size int64 `json:"size"`
+	// Encrypted is set when every part was encrypted on its own, the file
+	// not going through the encrypt processor.
+	Encrypted bool   `json:"encrypted,omitempty"`
+	Parts     []Part `json:"parts"`
+}
+
+// Part is a Drive file holding a piece of a file uploaded in parts, Size
+// being the bytes stored in Drive.
+type Part struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// splitSize returns the size above which files are uploaded in parts, 0
+// when they never are.
+func (p *Pipeline) splitSize() int64 {
+	return int64(p.config().SplitSizeMB) << 20
+}
+
+// partName returns the name of the part number i of the file called name.
+func partName(name string, i int) string {
+	return fmt.Sprintf("%s.part%03d", name, i)
+}
+
+// PartOf returns the name of the file the Drive file called name is a part
+// of and its number, false when it is not a part.
+func PartOf(name string) (string, int, bool) {
+	i := strings.LastIndex(name, ".part")
+	if i <= 0 {
+		return "", 0, false
+	}
+	number := name[i+len(".part"):]
+	if len(number) < 3 || strings.Trim(number, "0123456789") != "" {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(number)
+	return name[:i], n, err == nil
+}
+
+// uploadParts uploads the body of item as parts of splitSize bytes next to
+// each other in parentFolder, replacing the parts of the same name, then
+// the manifest listing them as the Drive file of item, file when it
+// already exists. It returns the manifest file and how many parts there
+// are, the parts left from a larger version being trashed. Unless the
+// folder of item has the encrypt processor, every part is encrypted with
+// the master key. With ParallelParts above 1 that many parts are written
+// aside and uploaded at the same time.
+func (p *Pipeline) uploadParts(ctx context.Context, client drive.Client, item *Item, parentFolder *drivev3.File, file *drivev3.File) (*drivev3.File, int, error) {
+	partsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		uploads   sync.WaitGroup
+		mutex     sync.Mutex
+		uploadErr error
+	)
+	fail := func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if uploadErr == nil {
+			uploadErr = err
+			cancel()
+		}
+	}
+	manifest := PartManifest{Encrypted: !slices.Contains(p.config().ProcessorsForFolder(filepath.Dir(item.Path)), "encrypt")}
+	parallel := config.IntOrDefault(p.config().ParallelParts, 1)
+	slots := make(chan struct{}, parallel)
+	body := bufio.NewReader(item.Body)
+	for i := 1; partsCtx.Err() == nil; i++ {
+		slots <- struct{}{}
+		name := partName(item.Name, i)
+		mutex.Lock()
+		manifest.Parts = append(manifest.Parts, Part{Name: name})
+		mutex.Unlock()
+		if parallel == 1 {
+			// streamed, nothing written aside
+			part, err := p.uploadPart(partsCtx, client, parentFolder, name, io.LimitReader(body, p.splitSize()), manifest.Encrypted)
+			<-slots
+			if err != nil {
+				fail(err)
+				break
+			}
+			manifest.Parts[i-1] = part
+		} else {
+			spool, err := spoolPart(body, p.splitSize())
+			if err != nil {
+				<-slots
+				fail(err)
+				break
+			}
+			uploads.Add(1)
+			go func() {
+				defer uploads.Done()
+				defer func() { <-slots }()
+				defer os.Remove(spool.Name())
+				defer spool.Close()
+				part, err := p.uploadPart(partsCtx, client, parentFolder, name, spool, manifest.Encrypted)
+				if err != nil {
+					fail(err)
+					return
+				}
+				mutex.Lock()
+				manifest.Parts[i-1] = part
+				mutex.Unlock()
+			}()
+		}
+		if _, err := body.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			fail(err)
+		}
+	}
+	uploads.Wait()
+	if uploadErr == nil {
+		uploadErr = ctx.Err()
+	}
+	if uploadErr != nil {
+		return nil, 0, uploadErr
+	}
+	for _, part := range manifest.Parts {
+		manifest.Size += part.Size
+	}
+	if err := p.trashParts(ctx, client, item.Name, parentFolder, len(manifest.Parts)+1); err != nil {
+		slog.Warn("Unable to trash the parts of the previous version", "file", item.Path, "error", err)
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, 0, err
+	}
+	var remoteFile *drivev3.File
+	if file != nil {
+		remoteFile, err = client.UpdateFile(ctx, file, bytes.NewReader(content))
+	} else {
+		remoteFile, err = client.CreateFile(ctx, parentFolder, item.Name, bytes.NewReader(content))
+	}
+	return remoteFile, len(manifest.Parts), err
+}
+
+// uploadPart uploads r as the part called name in parentFolder, replacing
+// the part of the same name, encrypted when encrypted is set.
+func (p *Pipeline) uploadPart(ctx context.Context, client drive.Client, parentFolder *drivev3.File, name string, r io.Reader, encrypted bool) (Part, error) {
+	files, err := client.FindFiles(ctx, name, parentFolder.Id)
+	if err != nil {
+		return Part{}, err
+	}
+	r = &contextReader{ctx: ctx, r: r}
+	if encrypted {
+		sealed, err := encryptReader(r)
+		if err != nil {
+			return Part{}, fmt.Errorf("Unable to encrypt part %s: %v", name, err)
+		}
+		defer sealed.Close()
+		r = sealed
+	}
+	part := &countingReader{r: r}
+	var remoteFile *drivev3.File
+	if existing := drive.PreferFile(files, p.knownRemoteID); existing != nil {
+		remoteFile, err = client.UpdateFile(ctx, existing, part)
+	} else {
+		remoteFile, err = client.CreateFile(ctx, parentFolder, name, part)
+	}
+	if err != nil {
+		return Part{}, fmt.Errorf("Unable to upload part %s: %v", name, err)
+	}
+	slog.Debug("Uploaded part", "part", name, "size", part.read)
+	return Part{Name: name, ID: remoteFile.Id, Size: part.read}, nil
+}
+
+// spoolPart writes the next size bytes of body to a temporary file, at
+// its start, removed by the caller.
+func spoolPart(body io.Reader, size int64) (*os.File, error) {
+	spool, err := os.CreateTemp("", ".part-*")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to write the part aside: %v", err)
+	}
+	_, err = io.CopyN(spool, body, size)
+	if err == io.EOF {
+		// the last part
+		err = nil
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+	return spool, nil
+}
+
+// trashParts moves to the Drive trash the parts of the file called name
+// from number from on, left by a version with more parts.
+func (p *Pipeline) trashParts(ctx context.Context, client drive.Client, name string, parentFolder *drivev3.File, from int) error {
+	for i := from; ; i++ {
+		files, err := client.FindFiles(ctx, partName(name, i), parentFolder.Id)
+		if err != nil || len(files) == 0 {
+			return err
+		}
+		for _, file := range files {
+			if err = client.TrashFile(ctx, file.Id); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// DownloadParts writes to w the content of a file uploaded in parts, read
+// from the parts listed in manifest, the content of its Drive file, each
+// of them decrypted when they were encrypted.
+func DownloadParts(ctx context.Context, client drive.Client, manifest []byte, w io.Writer) error {
+	var parts PartManifest
+	if err := json.Unmarshal(manifest, &parts); err != nil {
+		return fmt.Errorf("Invalid part manifest: %v", err)
+	}
+	for _, part := range parts.Parts {
+		var err error
+		if parts.Encrypted {
+			err = downloadEncryptedPart(ctx, client, part, w)
+		} else {
+			err = downloadPart(ctx, client, part, w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadPart writes to w the content of part, checking its size.
+func downloadPart(ctx context.Context, client drive.Client, part Part, w io.Writer) error {
+	written := &countingWriter{w: w}
+	if err := client.Download(ctx, part.ID, written); err != nil {
+		return fmt.Errorf("Unable to download part %s: %v", part.Name, err)
+	}
+	if written.written != part.Size {
+		return fmt.Errorf("Part %s has %d bytes instead of %d", part.Name, written.written, part.Size)
+	}
+	return nil
+}
+
+// downloadEncryptedPart writes to w the decrypted content of part.
+func downloadEncryptedPart(ctx context.Context, client drive.Client, part Part, w io.Writer) error {
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	// the error of the download is known before the reader sees it
+	downloaded := make(chan error, 1)
+	go func() {
+		err := downloadPart(ctx, client, part, writer)
+		downloaded <- err
+		writer.CloseWithError(err)
+	}()
+	plain, err := auth.DecryptReader(reader)
+	if err == nil {
+		_, err = io.Copy(w, plain)
+	}
+	if err != nil {
+		select {
+		case downloadErr := <-downloaded:
+			if downloadErr != nil {
+				return downloadErr
+			}
+		default:
+		}
+		return fmt.Errorf("Unable to decrypt part %s: %v", part.Name, err)
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// splitContent is a file of a bit more than 2 MiB, split in 3 parts of
+// 1 MiB.
+var splitContent = strings.Repeat("secret line of a large file\n", 80000)
+
+// uploadSplit uploads splitContent in parts with the processors of the
+// folder and returns its manifest, checking the file is restored.
+func uploadSplit(t *testing.T, processors []string, parallel int) PartManifest {
+	t.Helper()
+	test := newUploadTest(t, false)
+	test.p.Config.SplitSizeMB = 1
+	test.p.Config.ParallelParts = parallel
+	test.p.Config.FolderProcessors = map[string][]string{test.docs: processors}
+	if _, _, err := test.upload(t, "video.mkv", splitContent); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "video.mkv"
+	for _, processor := range processors {
+		if processor == "encrypt" {
+			name += encryptExtension
+		}
+	}
+	files, _ := test.client.FindFiles(context.Background(), name, test.parent.Id)
+	if len(files) != 1 {
+		t.Fatalf("found %d %s files in Drive, want 1", len(files), name)
+	}
+	var manifest PartManifest
+	if err := json.Unmarshal(test.client.content(files[0].Id), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Parts) != 3 {
+		t.Fatalf("uploaded in %d parts, want 3", len(manifest.Parts))
+	}
+	for i, part := range manifest.Parts {
+		if want := fmt.Sprintf("%s.part%03d", name, i+1); part.Name != want {
+			t.Errorf("part %d is called %s, want %s", i+1, part.Name, want)
+		}
+		if bytes.Contains(test.client.content(part.ID), []byte("secret")) {
+			t.Errorf("part %s is readable in Drive, want it encrypted", part.Name)
+		}
+	}
+
+	target := filepath.Join(t.TempDir(), "video.mkv")
+	if _, err := RestoreFile(context.Background(), test.client, files[0], nil, "", "", target); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(target); string(content) != splitContent {
+		t.Errorf("restored %d bytes, want the %d uploaded", len(content), len(splitContent))
+	}
+	return manifest
+}
+
+func TestUploadPartsEncrypts(t *testing.T) {
+	if manifest := uploadSplit(t, []string{"filter"}, 1); !manifest.Encrypted {
+		t.Error("the manifest does not tell the parts are encrypted")
+	}
+}
+
+func TestUploadPartsEncryptsInParallel(t *testing.T) {
+	if manifest := uploadSplit(t, []string{"filter"}, 2); !manifest.Encrypted {
+		t.Error("the manifest does not tell the parts are encrypted")
+	}
+}
+
+func TestUploadPartsOfEncryptedFile(t *testing.T) {
+	// encrypted once, by the processor, not again part by part
+	if manifest := uploadSplit(t, []string{"filter", "encrypt"}, 1); manifest.Encrypted {
+		t.Error("the parts of an encrypted file were encrypted again")
+	}
+}
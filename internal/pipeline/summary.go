@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+)
+
+// Summary counts what a scan of the watched folders did with every file.
+type Summary struct {
+	mutex    sync.Mutex
+	Uploaded int      `json:"uploaded"`
+	Updated  int      `json:"updated"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// Add counts the action done with file, a failure when err is set.
+func (result *Summary) Add(file string, action string, err error) {
+	if err != nil {
+		result.AddFailure(file, err)
+		return
+	}
+	result.mutex.Lock()
+	defer result.mutex.Unlock()
+	switch action {
+	case history.ActionUpload:
+		result.Uploaded++
+	case history.ActionUpdate, history.ActionRename:
+		result.Updated++
+	case history.ActionSkip:
+		result.Skipped++
+	}
+}
+
+// AddFailure counts a file or folder that could not be backed up.
+func (result *Summary) AddFailure(path string, err error) {
+	result.mutex.Lock()
+	defer result.mutex.Unlock()
+	result.Failed++
+	result.Failures = append(result.Failures, path+": "+err.Error())
+}
+
+// Print writes the consolidated summary of the scan.
+func (result *Summary) Print() {
+	result.mutex.Lock()
+	defer result.mutex.Unlock()
+	if logging.JSONOutput() {
+		json.NewEncoder(os.Stdout).Encode(struct {
+			Event string `json:"event"`
+			*Summary
+		}{"summary", result})
+		return
+	}
+	failed := fmt.Sprintf("%d failed", result.Failed)
+	if result.Failed > 0 {
+		failed = logging.Colorize(logging.Red, failed)
+	}
+	fmt.Printf("\n%s %s, %s, %s, %s\n", logging.Colorize(logging.Bold, "Backup summary:"),
+		logging.Colorize(logging.Green, fmt.Sprintf("%d uploaded", result.Uploaded)),
+		logging.Colorize(logging.Green, fmt.Sprintf("%d updated", result.Updated)),
+		logging.Colorize(logging.Dim, fmt.Sprintf("%d skipped", result.Skipped)),
+		failed)
+	for _, failure := range result.Failures {
+		fmt.Printf("  - %s\n", logging.Colorize(logging.Red, failure))
+	}
+	fmt.Println()
+}
+
+// ExitCode returns the process exit code for the scan, 1 if a file failed.
+func (result *Summary) ExitCode() int {
+	result.mutex.Lock()
+	defer result.mutex.Unlock()
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
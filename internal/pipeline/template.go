@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// templateVariable is a variable of a remote template, like {filename}.
+var templateVariable = regexp.MustCompile(`\{[a-z]*\}`)
+
+// expandTemplate expands template for the local file at path, dated when,
+// and returns the folder path the file is uploaded to, inside the
+// destination folder, and its remote name. The variables are:
+//
+//	{hostname}  the name of the machine
+//	{folder}    the name of the watched folder
+//	{filename}  the name of the file, also {relpath}
+//	{name}      the name of the file without its extension
+//	{ext}       its extension without the dot
+//	{date}      when, 2006-01-02, and {year}, {month} and {day}
+func expandTemplate(template string, path string, when time.Time) (string, string, error) {
+	filename := filepath.Base(path)
+	extension := filepath.Ext(filename)
+	var err error
+	expanded := templateVariable.ReplaceAllStringFunc(template, func(variable string) string {
+		switch variable {
+		case "{hostname}":
+			hostname, hostErr := os.Hostname()
+			if hostErr != nil {
+				err = fmt.Errorf("Unable to get the hostname: %v", hostErr)
+			}
+			return hostname
+		case "{folder}":
+			return filepath.Base(filepath.Dir(path))
+		case "{filename}", "{relpath}":
+			return filename
+		case "{name}":
+			return strings.TrimSuffix(filename, extension)
+		case "{ext}":
+			return strings.TrimPrefix(extension, ".")
+		case "{date}":
+			return when.Format("2006-01-02")
+		case "{year}":
+			return when.Format("2006")
+		case "{month}":
+			return when.Format("01")
+		case "{day}":
+			return when.Format("02")
+		}
+		err = fmt.Errorf("Unknown variable %s in remote template %q", variable, template)
+		return variable
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	var names []string
+	for _, name := range strings.Split(expanded, "/") {
+		if name == "." || name == ".." {
+			return "", "", fmt.Errorf("Remote template %q gives %s, out of the destination folder", template, expanded)
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", "", fmt.Errorf("Remote template %q gives no name for %s", template, path)
+	}
+	return strings.Join(names[:len(names)-1], "/"), names[len(names)-1], nil
+}
@@ -0,0 +1,268 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// uploadTest is a pipeline over fakes, with the watched folder docs and
+// its destination folder in the fake Drive.
+type uploadTest struct {
+	p      *Pipeline
+	client *fakeClient
+	fs     *fakeFS
+	docs   string
+	parent *drivev3.File
+}
+
+func newUploadTest(t *testing.T, withState bool) *uploadTest {
+	t.Helper()
+	docs := filepath.Join(t.TempDir(), "docs")
+	test := &uploadTest{client: newFakeClient(), fs: newFakeFS(), docs: docs}
+	test.parent, _ = test.client.CreateFolder(context.Background(), "backup", "root")
+	test.p = &Pipeline{
+		Config: &config.Config{FolderToWatch: []string{docs}},
+		FS:     test.fs,
+		Clock:  &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	if withState {
+		store, err := state.Open(filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		test.p.State = store
+	}
+	return test
+}
+
+// upload writes content to the file called name of docs and uploads it.
+func (test *uploadTest) upload(t *testing.T, name string, content string) (string, string, error) {
+	t.Helper()
+	path := filepath.Join(test.docs, name)
+	test.fs.write(path, content, test.p.clock().Now())
+	file, err := test.fs.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	action, err := test.p.uploadFile(context.Background(), test.client, file, path, name, test.parent)
+	return path, action, err
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadFileCreates(t *testing.T) {
+	test := newUploadTest(t, true)
+
+	path, action, err := test.upload(t, "report.txt", "first version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != history.ActionUpload {
+		t.Errorf("action = %q, want %q", action, history.ActionUpload)
+	}
+	files, _ := test.client.FindFiles(context.Background(), "report.txt", test.parent.Id)
+	if len(files) != 1 {
+		t.Fatalf("found %d files in Drive, want 1", len(files))
+	}
+	if content := string(test.client.content(files[0].Id)); content != "first version" {
+		t.Errorf("uploaded %q, want %q", content, "first version")
+	}
+	if hash := drive.Property(files[0].AppProperties, drive.PropertyHash); hash != sha256Hex("first version") {
+		t.Errorf("hash property = %q, want the SHA-256 of the file", hash)
+	}
+	known, err := test.p.State.Get(path)
+	if err != nil || known == nil {
+		t.Fatalf("state of the uploaded file = %v, %v", known, err)
+	}
+	if known.RemoteID != files[0].Id || known.Hash != sha256Hex("first version") {
+		t.Errorf("state = %+v, want the Drive ID %s and the hash of the file", known, files[0].Id)
+	}
+}
+
+func TestUploadFileUpdates(t *testing.T) {
+	test := newUploadTest(t, false)
+	existing := test.client.add("report.txt", test.parent.Id, []byte("old"), nil)
+
+	_, action, err := test.upload(t, "report.txt", "new version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != history.ActionUpdate {
+		t.Errorf("action = %q, want %q", action, history.ActionUpdate)
+	}
+	if n := test.client.called("CreateFile"); n != 0 {
+		t.Errorf("CreateFile called %d times, want the existing file updated", n)
+	}
+	if content := string(test.client.content(existing.Id)); content != "new version" {
+		t.Errorf("Drive file holds %q, want %q", content, "new version")
+	}
+}
+
+func TestUploadFileCompresses(t *testing.T) {
+	test := newUploadTest(t, false)
+	test.p.Config.FolderProcessors = map[string][]string{test.docs: {"filter", "gzip"}}
+
+	_, action, err := test.upload(t, "report.txt", "compressed content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != history.ActionUpload {
+		t.Errorf("action = %q, want %q", action, history.ActionUpload)
+	}
+	files, _ := test.client.FindFiles(context.Background(), "report.txt.gz", test.parent.Id)
+	if len(files) != 1 {
+		t.Fatalf("found %d report.txt.gz files in Drive, want 1", len(files))
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(test.client.content(files[0].Id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := io.ReadAll(gz); string(content) != "compressed content" {
+		t.Errorf("uploaded %q once decompressed, want %q", content, "compressed content")
+	}
+}
+
+func TestUploadFileEncrypts(t *testing.T) {
+	test := newUploadTest(t, false)
+	test.p.Config.FolderProcessors = map[string][]string{test.docs: {"filter", "gzip", "encrypt"}}
+
+	if _, _, err := test.upload(t, "report.txt", "secret content"); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := test.client.FindFiles(context.Background(), "report.txt.gz.enc", test.parent.Id)
+	if len(files) != 1 {
+		t.Fatalf("found %d report.txt.gz.enc files in Drive, want 1", len(files))
+	}
+	stored := test.client.content(files[0].Id)
+	if bytes.Contains(stored, []byte("secret")) {
+		t.Error("the content is readable in Drive, want it encrypted")
+	}
+
+	processors := Processors(files[0].Name, files[0].AppProperties, "")
+	name, body, err := Unprocess(files[0].Name, processors, bytes.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "report.txt" || string(content) != "secret content" {
+		t.Errorf("restored %s holding %q, want report.txt holding %q", name, content, "secret content")
+	}
+}
+
+func TestProcessors(t *testing.T) {
+	tests := []struct {
+		name      string
+		props     map[string]string
+		localPath string
+		want      []string
+	}{
+		{"report.txt.gz.enc", map[string]string{"processors": "filter,gzip,encrypt"}, "", []string{"filter", "gzip", "encrypt"}},
+		// uploaded before the processors were kept
+		{"report.txt.gz", nil, "", []string{"gzip"}},
+		{"report.txt.gz", nil, filepath.Join("docs", "report.txt"), []string{"gzip"}},
+		{"archive.gz", nil, filepath.Join("docs", "archive.gz"), nil},
+		{"report.txt", nil, "", nil},
+	}
+	for _, test := range tests {
+		got := Processors(test.name, test.props, test.localPath)
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("Processors(%q, %v, %q) = %q, want %q", test.name, test.props, test.localPath, got, test.want)
+		}
+	}
+}
+
+func TestUploadFileSkips(t *testing.T) {
+	test := newUploadTest(t, false)
+	test.p.Config.Exclude = []string{"*.tmp"}
+
+	_, action, err := test.upload(t, "draft.tmp", "excluded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != history.ActionSkip {
+		t.Errorf("action = %q, want %q", action, history.ActionSkip)
+	}
+	if n := test.client.called("CreateFile") + test.client.called("UpdateFile"); n != 0 {
+		t.Errorf("%d files uploaded, want the excluded file skipped", n)
+	}
+}
+
+func TestUploadFileStaleRemoteID(t *testing.T) {
+	test := newUploadTest(t, true)
+	path := filepath.Join(test.docs, "report.txt")
+	// uploaded before to a Drive file since deleted
+	if err := test.p.State.Put(path, state.File{Hash: "old", RemoteID: "deleted"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, action, err := test.upload(t, "report.txt", "content")
+	if err != errStaleRemoteID {
+		t.Fatalf("error = %v, want errStaleRemoteID", err)
+	}
+	if action != history.ActionFail {
+		t.Errorf("action = %q, want %q", action, history.ActionFail)
+	}
+	if known, _ := test.p.State.Get(path); known != nil {
+		t.Errorf("state = %+v, want the stale ID forgotten", known)
+	}
+
+	// the retry looks the file up by name
+	_, action, err = test.upload(t, "report.txt", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != history.ActionUpload {
+		t.Errorf("action of the retry = %q, want %q", action, history.ActionUpload)
+	}
+}
+
+func TestUploadFileChecksWriterWithoutOtherMachines(t *testing.T) {
+	test := newUploadTest(t, true)
+	// uploaded by a machine that started after this one registered
+	other := test.client.add("report.txt", test.parent.Id, []byte("their version"), map[string]string{
+		drive.PropertyHost: "other-machine",
+		drive.PropertyHash: sha256Hex("their version"),
+	})
+
+	_, action, err := test.upload(t, "report.txt", "their version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != history.ActionSkip {
+		t.Errorf("upload of the content the other machine backed up = %q, want %q", action, history.ActionSkip)
+	}
+
+	if _, _, err = test.upload(t, "report.txt", "my version"); err != nil {
+		t.Fatal(err)
+	}
+	if content := test.client.contents[other.Id]; string(content) != "their version" {
+		t.Errorf("file of the other machine holds %q, want it untouched", content)
+	}
+	mine, _ := test.client.FindFiles(context.Background(), deviceFileName("report.txt", hostname()), test.parent.Id)
+	if len(mine) != 1 || string(test.client.contents[mine[0].Id]) != "my version" {
+		t.Errorf("files of this machine %v, want its version beside the other one", mine)
+	}
+}
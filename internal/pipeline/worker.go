@@ -0,0 +1,197 @@
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+	"golang.org/x/net/context"
+)
+
+// retry policy for temporary Drive errors, other errors fail the file at once
+const (
+	maxAttempts = 4
+	retryDelay  = 5 * time.Second // doubled on every attempt
+)
+
+// Start runs workers uploading the queued files until the queue is
+// closed. Cancelling ctx closes the queue and stops the uploads in flight.
+func (p *Pipeline) Start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go p.work(ctx)
+	}
+	go func() {
+		<-ctx.Done()
+		p.Queue.Close()
+	}()
+}
+
+func (p *Pipeline) work(ctx context.Context) {
+	for {
+		job, ok := p.Queue.Pop()
+		if !ok {
+			return
+		}
+		p.process(ctx, job)
+	}
+}
+
+// process uploads the file of job to the account of its folder, until
+// either ctx or the context of the job is cancelled.
+func (p *Pipeline) process(workerCtx context.Context, job *queue.Job) {
+	p.Events.Publish(events.UploadStarted{Path: job.Path})
+
+	ctx := workerCtx
+	if job.Context != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(job.Context)
+		defer cancel()
+		go func() {
+			select {
+			case <-workerCtx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	if err := p.waitOnline(ctx); err != nil {
+		job.Finish(history.ActionFail, err)
+		return
+	}
+	if err := ctx.Err(); err != nil {
+		job.Finish(history.ActionFail, err)
+		return
+	}
+	if unmetered := p.waitsUnmetered(job); unmetered != nil {
+		p.waitUnmetered(workerCtx, job, unmetered)
+		return
+	}
+	if delay := p.youngFor(job); delay > 0 {
+		p.waitAge(workerCtx, job, delay)
+		return
+	}
+	if err := p.checkSizeCap(job); err != nil {
+		job.Finish(p.failUpload(ctx, job.Path, err, false))
+		return
+	}
+
+	account, err := p.Accounts.ForFolder(ctx, filepath.Dir(job.Path))
+	if err != nil {
+		slog.Error("Error getting Drive account", "error", err)
+		job.Finish(p.failUpload(ctx, job.Path, err, false))
+		return
+	}
+	uploadCtx := ctx
+	if p.config().UploadTimeoutMinutes > 0 {
+		var cancel context.CancelFunc
+		uploadCtx, cancel = context.WithTimeout(ctx, time.Duration(p.config().UploadTimeoutMinutes)*time.Minute)
+		defer cancel()
+	}
+	action, err := p.Upload(uploadCtx, job.Path, account)
+	// a stuck upload is cancelled by its deadline and retried like a
+	// temporary Drive error
+	timedOut := err != nil && ctx.Err() == nil && uploadCtx.Err() == context.DeadlineExceeded
+	if timedOut {
+		err = fmt.Errorf("Upload took longer than %d minutes", p.config().UploadTimeoutMinutes)
+	}
+	if err != nil && ctx.Err() == nil && isQuotaExceeded(err) && tooLargeForQuota(ctx, account, job.Size) {
+		// only this file does not fit, the others go on
+		slog.Warn("File larger than the free Drive space, skipping it", "file", job.Path, "error", err)
+		job.Finish(p.failUpload(ctx, job.Path, fmt.Errorf("File larger than the free Drive space: %v", err), false))
+		return
+	}
+	if err != nil && ctx.Err() == nil && (drive.IsOffline(err) || isQuotaExceeded(err)) {
+		// keep the file queued, without counting an attempt, until Drive
+		// is reachable again or has room for it
+		if drive.IsOffline(err) {
+			p.goOffline(workerCtx, account)
+		} else {
+			p.quotaFull(workerCtx, account, job.Size)
+		}
+		if p.Queue.Push(job) {
+			p.Events.Publish(events.FileQueued{Path: job.Path, Priority: int(job.Priority)})
+		}
+		return
+	}
+	// uploads failing in Drive in a row open the circuit, the file waits
+	// queued like while offline
+	backendFailed := err != nil && ctx.Err() == nil && (timedOut || drive.IsRetryable(err))
+	if (err == nil || backendFailed) && p.backendResult(workerCtx, account, err) {
+		if p.Queue.Push(job) {
+			p.Events.Publish(events.FileQueued{Path: job.Path, Priority: int(job.Priority)})
+		}
+		return
+	}
+	if backendFailed && job.Attempts < maxAttempts-1 {
+		p.retry(workerCtx, job, err)
+		return
+	}
+	if err != nil {
+		p.failUpload(ctx, job.Path, err, timedOut || drive.IsBackendError(err))
+	}
+	job.Finish(action, err)
+}
+
+// retry queues job again once its backoff is over, the Drive error being
+// temporary.
+func (p *Pipeline) retry(workerCtx context.Context, job *queue.Job, err error) {
+	job.Attempts++
+	delay := retryDelay << (job.Attempts - 1)
+	slog.Warn("Upload failed, retrying", "file", job.Path, "attempt", job.Attempts, "delay", delay, "error", err)
+	var cancelled <-chan struct{} // nil, never ready, without a job context
+	if job.Context != nil {
+		cancelled = job.Context.Done()
+	}
+	go func() {
+		select {
+		case <-p.clock().After(delay):
+			if p.Queue.Push(job) {
+				p.Events.Publish(events.FileQueued{Path: job.Path, Priority: int(job.Priority)})
+			}
+		case <-workerCtx.Done():
+			job.Finish(history.ActionFail, workerCtx.Err())
+		case <-cancelled:
+			job.Finish(history.ActionFail, job.Context.Err())
+		}
+	}()
+}
+
+// Enqueue queues the file at path with priority, done being called with
+// the result of its upload when set. Cancelling ctx cancels the upload.
+func (p *Pipeline) Enqueue(ctx context.Context, path string, priority queue.Priority, done func(action string, err error)) {
+	job := &queue.Job{Path: path, Priority: priority, Context: ctx}
+	if info, err := p.fs().Stat(path); err == nil {
+		job.Size = info.Size()
+	}
+	if done != nil {
+		job.Done = append(job.Done, done)
+	}
+	if p.Queue.Push(job) {
+		p.Events.Publish(events.FileQueued{Path: path, Priority: int(priority)})
+	}
+}
+
+// BackupFile queues the file at path with priority and waits until it is
+// uploaded or ctx is cancelled.
+// It returns the history action done with the file.
+func (p *Pipeline) BackupFile(ctx context.Context, path string, priority queue.Priority) (string, error) {
+	type result struct {
+		action string
+		err    error
+	}
+	results := make(chan result, 1)
+	p.Enqueue(ctx, path, priority, func(action string, err error) {
+		results <- result{action, err}
+	})
+	select {
+	case res := <-results:
+		return res.action, res.err
+	case <-ctx.Done():
+		return history.ActionFail, ctx.Err()
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package pipeline
+
+// fileXattrs returns no extended attributes, only read on Linux and macOS.
+func fileXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// setXattr does nothing, the attribute is dropped outside Linux and macOS.
+func setXattr(path string, name string, value []byte) error {
+	return nil
+}
@@ -0,0 +1,55 @@
+//go:build linux || darwin
+
+package pipeline
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileXattrs returns the extended attributes of the file at path, of its
+// target for a symbolic link, only the user namespace on Linux as the
+// others need privileges to restore.
+func fileXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, ignoreUnsupported(err)
+	}
+	list := make([]byte, size)
+	if size, err = unix.Listxattr(path, list); err != nil {
+		return nil, err
+	}
+	xattrs := map[string][]byte{}
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 || runtime.GOOS == "linux" && !strings.HasPrefix(string(name), "user.") {
+			continue
+		}
+		size, err := unix.Getxattr(path, string(name), nil)
+		if err != nil {
+			return xattrs, err
+		}
+		value := make([]byte, size)
+		if size, err = unix.Getxattr(path, string(name), value); err != nil {
+			return xattrs, err
+		}
+		xattrs[string(name)] = value[:size]
+	}
+	return xattrs, nil
+}
+
+// setXattr sets the extended attribute name of the file at path.
+func setXattr(path string, name string, value []byte) error {
+	return unix.Setxattr(path, name, value, 0)
+}
+
+// ignoreUnsupported returns nil for the error of a file system without
+// extended attributes.
+func ignoreUnsupported(err error) error {
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		return nil
+	}
+	return err
+}
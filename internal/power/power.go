@@ -0,0 +1,18 @@
+// Package power reads whether a laptop runs on battery and how charged it
+// is, from /sys/class/power_supply on Linux, pmset on macOS and
+// GetSystemPowerStatus on Windows.
+package power
+
+import "errors"
+
+// ErrUnsupported is returned where the power source cannot be read.
+var ErrUnsupported = errors.New("power source not readable on this system")
+
+// Status is the power source of the machine.
+type Status struct {
+	// OnBattery is set while the machine runs on battery, false on AC
+	// power or without a battery.
+	OnBattery bool
+	// Percent is the charge of the battery, -1 when unknown.
+	Percent int
+}
@@ -0,0 +1,27 @@
+package power
+
+import (
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// batteryPercent is the charge in a battery line of pmset, like
+// "-InternalBattery-0 (id=4653155)	85%; discharging; 4:12 remaining".
+var batteryPercent = regexp.MustCompile(`(\d+)%;`)
+
+// Read returns the power source of the machine, read from pmset -g batt.
+func Read() (Status, error) {
+	status := Status{Percent: -1}
+	output, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return status, err
+	}
+	text := string(output)
+	status.OnBattery = strings.Contains(text, "'Battery Power'")
+	if match := batteryPercent.FindStringSubmatch(text); match != nil {
+		status.Percent, _ = strconv.Atoi(match[1])
+	}
+	return status, nil
+}
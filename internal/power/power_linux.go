@@ -0,0 +1,53 @@
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// supplyDir lists the power supplies, AC adapters ("Mains") and batteries.
+const supplyDir = "/sys/class/power_supply"
+
+// Read returns the power source of the machine. It runs on battery when
+// no AC adapter is online and a battery is discharging, the charge being
+// the lowest of its batteries.
+func Read() (Status, error) {
+	status := Status{Percent: -1}
+	supplies, err := os.ReadDir(supplyDir)
+	if err != nil {
+		return status, err
+	}
+	onAC, discharging := false, false
+	for _, supply := range supplies {
+		dir := filepath.Join(supplyDir, supply.Name())
+		switch readValue(dir, "type") {
+		case "Mains", "USB":
+			if readValue(dir, "online") == "1" {
+				onAC = true
+			}
+		case "Battery":
+			// peripherals, like a mouse, report their own battery
+			if readValue(dir, "scope") == "Device" {
+				continue
+			}
+			if readValue(dir, "status") == "Discharging" {
+				discharging = true
+			}
+			if percent, err := strconv.Atoi(readValue(dir, "capacity")); err == nil && (status.Percent < 0 || percent < status.Percent) {
+				status.Percent = percent
+			}
+		}
+	}
+	status.OnBattery = !onAC && discharging
+	return status, nil
+}
+
+func readValue(dir string, name string) string {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
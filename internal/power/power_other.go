@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package power
+
+// Read returns ErrUnsupported, the power source is only read on Linux,
+// macOS and Windows.
+func Read() (Status, error) {
+	return Status{Percent: -1}, ErrUnsupported
+}
@@ -0,0 +1,43 @@
+package power
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var getSystemPowerStatus = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// systemPowerStatus is the SYSTEM_POWER_STATUS structure.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// values of systemPowerStatus
+const (
+	acOffline       = 0
+	noSystemBattery = 128
+	unknownPercent  = 255
+)
+
+// Read returns the power source of the machine, from GetSystemPowerStatus.
+func Read() (Status, error) {
+	status := Status{Percent: -1}
+	var system systemPowerStatus
+	if ok, _, err := getSystemPowerStatus.Call(uintptr(unsafe.Pointer(&system))); ok == 0 {
+		return status, err
+	}
+	if system.BatteryFlag&noSystemBattery != 0 {
+		return status, nil
+	}
+	status.OnBattery = system.ACLineStatus == acOffline
+	if system.BatteryLifePercent != unknownPercent {
+		status.Percent = int(system.BatteryLifePercent)
+	}
+	return status, nil
+}
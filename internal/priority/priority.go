@@ -0,0 +1,8 @@
+// Package priority lowers the priority of the app, so hashing and scanning
+// large folders leave the machine responsive: nice and the lowest level of
+// the best-effort I/O class on Linux, nice on macOS and the other Unix
+// systems, and the below normal priority class on Windows.
+package priority
+
+// niceness is the nice value of the app once lowered, like nice -n 10.
+const niceness = 10
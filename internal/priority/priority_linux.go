@@ -0,0 +1,40 @@
+package priority
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioprio_set arguments: the lowest priority of the best-effort class, the
+// idle class could starve the uploads on a busy disk
+const (
+	ioprioWhoProcess  = 1
+	ioprioClassBE     = 2
+	ioprioClassShift  = 13
+	ioprioLowestLevel = 7
+)
+
+// Lower lowers the CPU and I/O priority of every thread of the app, the
+// threads started later inheriting it: Linux keeps them per thread.
+func Lower() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err = unix.Setpriority(unix.PRIO_PROCESS, tid, niceness); err != nil {
+			return err
+		}
+		ioprio := ioprioClassBE<<ioprioClassShift | ioprioLowestLevel
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio)); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
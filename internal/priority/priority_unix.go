@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package priority
+
+import "golang.org/x/sys/unix"
+
+// Lower lowers the CPU priority of the app.
+func Lower() error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, niceness)
+}
@@ -0,0 +1,8 @@
+package priority
+
+import "golang.org/x/sys/windows"
+
+// Lower puts the app in the below normal priority class.
+func Lower() error {
+	return windows.SetPriorityClass(windows.CurrentProcess(), windows.BELOW_NORMAL_PRIORITY_CLASS)
+}
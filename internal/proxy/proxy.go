@@ -0,0 +1,73 @@
+// Package proxy builds the HTTP clients used to reach Google and the
+// notification endpoints.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// default HTTP settings, the ones of http.DefaultTransport but the response
+// timeout, so a Drive call stuck waiting for an answer fails
+const (
+	defaultConnectTimeout  = 30 * time.Second
+	defaultResponseTimeout = 2 * time.Minute
+	defaultKeepAlive       = 30 * time.Second
+	defaultMaxIdleConns    = 100
+)
+
+// NewClient returns an HTTP client with the HTTP settings of cfg, using
+// the proxy set in cfg, or the one in the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables when it is empty. http, https and socks5
+// proxy URLs are supported.
+func NewClient(cfg *config.Config) (*http.Client, error) {
+	settings := cfg.HTTP
+	if settings == nil {
+		settings = &config.HTTP{}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   seconds(settings.ConnectTimeoutSeconds, defaultConnectTimeout),
+		KeepAlive: seconds(settings.KeepAliveSeconds, defaultKeepAlive),
+	}
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = seconds(settings.ResponseTimeoutSeconds, defaultResponseTimeout)
+	transport.MaxIdleConns = config.IntOrDefault(settings.MaxIdleConns, defaultMaxIdleConns)
+	transport.MaxIdleConnsPerHost = config.IntOrDefault(settings.MaxIdleConnsPerHost, http.DefaultMaxIdleConnsPerHost)
+
+	proxy := cfg.Proxy
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("Invalid proxy \"%s\" in config", proxy)
+		}
+		proxyConfig := httpproxy.Config{
+			HTTPProxy:  proxy,
+			HTTPSProxy: proxy,
+			NoProxy:    config.GetEnvAny("NO_PROXY", "no_proxy"),
+		}
+		proxyFunc := proxyConfig.ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// seconds returns value seconds, defaultValue when value is not set.
+func seconds(value int, defaultValue time.Duration) time.Duration {
+	if value <= 0 {
+		return defaultValue
+	}
+	return time.Duration(value) * time.Second
+}
@@ -0,0 +1,202 @@
+// Package queue orders the pending uploads so interactive changes do not
+// wait behind a bulk scan.
+package queue
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrClosed finishes the jobs still waiting when the queue is closed.
+var ErrClosed = errors.New("upload queue closed")
+
+// Priority of a job, higher ones are done first.
+type Priority int
+
+// job priorities, from the lowest to the highest
+const (
+	// PriorityBulk is for files found scanning a whole folder.
+	PriorityBulk Priority = iota
+	// PriorityChange is for files just written in a watched folder.
+	PriorityChange
+	// PriorityManual is for backups the user asked for.
+	PriorityManual
+)
+
+// Job is a file waiting to be uploaded.
+type Job struct {
+	Path     string
+	Priority Priority
+	// Context is the context of whoever queued the job, cancelling it
+	// cancels the upload.
+	Context context.Context
+	// Size orders jobs of the same priority, smaller files first.
+	Size   int64
+	Queued time.Time
+	// Attempts counts the failed uploads of the job so far.
+	Attempts int
+	// Done is called with the result of the upload, when set.
+	Done []func(action string, err error)
+
+	seq   uint64
+	index int
+}
+
+// Finish calls the Done functions of the job.
+func (job *Job) Finish(action string, err error) {
+	for _, done := range job.Done {
+		done(action, err)
+	}
+}
+
+// Queue is a priority queue of jobs, holding at most one job per path.
+type Queue struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	jobs    jobHeap
+	byPath  map[string]*Job
+	nextSeq uint64
+	closed  bool
+	paused  bool
+}
+
+// New returns an empty queue.
+func New() *Queue {
+	q := &Queue{byPath: make(map[string]*Job)}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// Push queues job. When the path is already waiting the jobs are merged,
+// keeping the highest priority.
+// It returns whether job was queued as a new job.
+func (q *Queue) Push(job *Job) bool {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		job.Finish("", ErrClosed)
+		return false
+	}
+	defer q.mutex.Unlock()
+
+	if queued, ok := q.byPath[job.Path]; ok {
+		queued.Done = append(queued.Done, job.Done...)
+		queued.Size = job.Size
+		if job.Priority > queued.Priority {
+			queued.Priority = job.Priority
+		}
+		heap.Fix(&q.jobs, queued.index)
+		return false
+	}
+	if job.Queued.IsZero() {
+		job.Queued = time.Now()
+	}
+	job.seq = q.nextSeq
+	q.nextSeq++
+	heap.Push(&q.jobs, job)
+	q.byPath[job.Path] = job
+	q.cond.Signal()
+	return true
+}
+
+// Pop waits for a job and returns it, false once the queue is closed. No
+// job is returned while the queue is paused.
+func (q *Queue) Pop() (*Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for (len(q.jobs) == 0 || q.paused) && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	job := heap.Pop(&q.jobs).(*Job)
+	delete(q.byPath, job.Path)
+	return job, true
+}
+
+// Len returns the number of waiting jobs.
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.jobs)
+}
+
+// Pause keeps the jobs waiting until Resume, the ones already taken by Pop
+// go on.
+func (q *Queue) Pause() {
+	q.mutex.Lock()
+	q.paused = true
+	q.mutex.Unlock()
+}
+
+// Resume lets Pop return jobs again after Pause.
+func (q *Queue) Resume() {
+	q.mutex.Lock()
+	q.paused = false
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
+
+// Paused reports whether the queue is paused.
+func (q *Queue) Paused() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.paused
+}
+
+// Close wakes up every Pop, which then return false, and finishes the
+// waiting jobs with ErrClosed.
+func (q *Queue) Close() {
+	q.mutex.Lock()
+	q.closed = true
+	waiting := q.jobs
+	q.jobs = nil
+	q.byPath = make(map[string]*Job)
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+
+	for _, job := range waiting {
+		job.Finish("", ErrClosed)
+	}
+}
+
+// jobHeap implements heap.Interface.
+type jobHeap []*Job
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	if h[i].Size != h[j].Size {
+		return h[i].Size < h[j].Size
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x any) {
+	job := x.(*Job)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *jobHeap) Pop() any {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return job
+}
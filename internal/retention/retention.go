@@ -0,0 +1,75 @@
+// Package retention decides which versions of a backup a retention policy
+// keeps.
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+// defaults of config.Retention
+const (
+	defaultKeepLast      = 3
+	defaultDailyDays     = 7
+	defaultWeeklyWeeks   = 4
+	defaultMonthlyMonths = 12
+)
+
+// bucket keeps the latest version of every period it tells apart, for the
+// versions newer than since.
+type bucket struct {
+	since  time.Time
+	period func(t time.Time) string
+}
+
+// Keep reports, for the versions made at times, whether policy keeps them
+// at now: the KeepLast latest ones, and the latest one of every day, week
+// and month within DailyDays, WeeklyWeeks and MonthlyMonths. A nil policy
+// keeps the defaults.
+func Keep(times []time.Time, policy *config.Retention, now time.Time) []bool {
+	if policy == nil {
+		policy = &config.Retention{}
+	}
+	buckets := []bucket{
+		{now.AddDate(0, 0, -config.IntOrDefault(policy.DailyDays, defaultDailyDays)), func(t time.Time) string {
+			return t.Format("2006-01-02")
+		}},
+		{now.AddDate(0, 0, -7*config.IntOrDefault(policy.WeeklyWeeks, defaultWeeklyWeeks)), func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{now.AddDate(0, -config.IntOrDefault(policy.MonthlyMonths, defaultMonthlyMonths), 0), func(t time.Time) string {
+			return t.Format("2006-01")
+		}},
+	}
+
+	// latest first, the first version of a period is the one kept
+	order := make([]int, len(times))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return times[order[i]].After(times[order[j]])
+	})
+	keep := make([]bool, len(times))
+	kept := make([]map[string]bool, len(buckets))
+	for i := range kept {
+		kept[i] = make(map[string]bool)
+	}
+	for rank, i := range order {
+		t := times[i].Local()
+		if rank < config.IntOrDefault(policy.KeepLast, defaultKeepLast) {
+			keep[i] = true
+		}
+		for b, bucket := range buckets {
+			if period := bucket.period(t); t.After(bucket.since) && !kept[b][period] {
+				kept[b][period] = true
+				keep[i] = true
+			}
+		}
+	}
+	return keep
+}
@@ -0,0 +1,457 @@
+// Package state keeps the local index of the backed up files, so unchanged
+// files are not uploaded again after a restart.
+package state
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FileName is the default state database, in the working directory.
+const FileName = "state.db"
+
+const openTimeout = time.Second // waiting for another instance to release the database
+
+var filesBucket = []byte("files")
+var foldersBucket = []byte("folders")
+var uploadsBucket = []byte("uploads")
+var archivesBucket = []byte("archives")
+var scansBucket = []byte("scans")
+var journalBucket = []byte("journal")
+var chunksBucket = []byte("chunks")
+var snapshotsBucket = []byte("snapshots")
+var collectionsBucket = []byte("collections")
+var metaBucket = []byte("meta")
+
+// lastUpdateKey of metaBucket is the time of the latest upload.
+var lastUpdateKey = []byte("lastUpdate")
+
+// File is what is known about a backed up file.
+type File struct {
+	Hash       string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modTime"`
+	RemoteID   string    `json:"remoteId"`
+	LastUpload time.Time `json:"lastUpload"`
+	// Inode is the inode number of the file, 0 when unknown, e.g. on
+	// Windows.
+	Inode uint64 `json:"inode,omitempty"`
+}
+
+// Unchanged reports whether a local file of size bytes modified at modTime
+// with inode is still the one backed up. An unknown inode, 0, matches any.
+func (f *File) Unchanged(size int64, modTime time.Time, inode uint64) bool {
+	return f != nil && f.Size == size && f.ModTime.Equal(modTime) &&
+		(f.Inode == 0 || inode == 0 || f.Inode == inode)
+}
+
+// Checkpoint is how far a resumable upload got, so it goes on from there
+// after a restart.
+type Checkpoint struct {
+	SessionURI string    `json:"sessionUri"`
+	Offset     int64     `json:"offset"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modTime"`
+	// Processors is the chain the file went through, comma separated.
+	Processors string `json:"processors"`
+}
+
+// Matches reports whether the checkpoint is of a local file of size bytes
+// modified at modTime going through processors, a changed file or chain
+// starting a new upload.
+func (c *Checkpoint) Matches(size int64, modTime time.Time, processors string) bool {
+	return c != nil && c.Size == size && c.ModTime.Equal(modTime) && c.Processors == processors
+}
+
+// Archive is the last archive of a watched folder backed up in archive
+// mode.
+type Archive struct {
+	Name     string `json:"name"`
+	RemoteID string `json:"remoteId"`
+	Level    int    `json:"level"`
+	// Time is when its files started being read, the next level holding
+	// the files modified from then on.
+	Time time.Time `json:"time"`
+}
+
+// Scan is how far the scan of a watched folder got, kept until it
+// finishes so an interrupted one resumes from there.
+type Scan struct {
+	Started time.Time `json:"started"`
+	// Path is the last file the scan reached, in walking order.
+	Path string `json:"path"`
+}
+
+// Snapshot is the last snapshot of a watched folder backed up as chunks.
+type Snapshot struct {
+	Name string `json:"name"`
+	// Tree is the ID of the chunk listing the files of the snapshot.
+	Tree string    `json:"tree"`
+	Time time.Time `json:"time"`
+}
+
+// Intent is an upload under way, journaled before its remote write starts
+// and committed with the state of the file once the write completes.
+type Intent struct {
+	Started time.Time `json:"started"`
+}
+
+// Store is the state database, indexed by local path.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the state database in file, creating it when missing.
+func Open(file string) (*Store, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{filesBucket, foldersBucket, uploadsBucket, archivesBucket, scansBucket, journalBucket, chunksBucket, snapshotsBucket, collectionsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+// Get returns what is known about the file at path, nil when it was never
+// backed up.
+func (store *Store) Get(path string) (*File, error) {
+	var file *File
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(filesBucket).Get([]byte(path))
+		if value == nil {
+			return nil
+		}
+		file = &File{}
+		return json.Unmarshal(value, file)
+	})
+	return file, err
+}
+
+// Put saves what is known about the file at path.
+func (store *Store) Put(path string, file File) error {
+	value, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).Put([]byte(path), value)
+	})
+}
+
+// Begin journals that the remote write of the file at path starts. Until
+// Commit or Abort, its state may be of an older version at best.
+func (store *Store) Begin(path string) error {
+	value, err := json.Marshal(Intent{Started: time.Now()})
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).Put([]byte(path), value)
+	})
+}
+
+// Commit saves the file at path once its remote write completed, clearing
+// its intent in the same transaction.
+func (store *Store) Commit(path string, file File) error {
+	value, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(filesBucket).Put([]byte(path), value); err != nil {
+			return err
+		}
+		return tx.Bucket(journalBucket).Delete([]byte(path))
+	})
+}
+
+// Abort clears the intent of the file at path when its remote write
+// failed, its state still being that of the previous upload.
+func (store *Store) Abort(path string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).Delete([]byte(path))
+	})
+}
+
+// Recover forgets the files whose remote write was cut by a crash, their
+// intents never committed nor aborted, so they are checked and uploaded
+// again. It returns their paths.
+func (store *Store) Recover() ([]string, error) {
+	var paths []string
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		journal := tx.Bucket(journalBucket)
+		err := journal.ForEach(func(key []byte, value []byte) error {
+			paths = append(paths, string(key))
+			return tx.Bucket(filesBucket).Delete(key)
+		})
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err := journal.Delete([]byte(path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// Move saves the file renamed from oldPath to path, forgetting oldPath in
+// the same transaction.
+func (store *Store) Move(oldPath string, path string, file File) error {
+	value, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(filesBucket).Delete([]byte(oldPath)); err != nil {
+			return err
+		}
+		return tx.Bucket(filesBucket).Put([]byte(path), value)
+	})
+}
+
+// Delete forgets the file at path.
+func (store *Store) Delete(path string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).Delete([]byte(path))
+	})
+}
+
+// ForEach calls fn with every known file until it returns an error.
+func (store *Store) ForEach(fn func(path string, file File) error) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).ForEach(func(key []byte, value []byte) error {
+			var file File
+			if err := json.Unmarshal(value, &file); err != nil {
+				return err
+			}
+			return fn(string(key), file)
+		})
+	})
+}
+
+// FolderID returns the cached Drive ID of the folder known as key, "" when
+// it is not cached.
+func (store *Store) FolderID(key string) (string, error) {
+	var id string
+	err := store.db.View(func(tx *bolt.Tx) error {
+		id = string(tx.Bucket(foldersBucket).Get([]byte(key)))
+		return nil
+	})
+	return id, err
+}
+
+// PutFolderID caches the Drive ID of the folder known as key, "" forgetting
+// it.
+func (store *Store) PutFolderID(key string, id string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if id == "" {
+			return tx.Bucket(foldersBucket).Delete([]byte(key))
+		}
+		return tx.Bucket(foldersBucket).Put([]byte(key), []byte(id))
+	})
+}
+
+// Checkpoint returns the checkpoint of the upload of the file at path, nil
+// when there is none.
+func (store *Store) Checkpoint(path string) (*Checkpoint, error) {
+	var checkpoint *Checkpoint
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(uploadsBucket).Get([]byte(path))
+		if value == nil {
+			return nil
+		}
+		checkpoint = &Checkpoint{}
+		return json.Unmarshal(value, checkpoint)
+	})
+	return checkpoint, err
+}
+
+// PutCheckpoint saves the checkpoint of the upload of the file at path.
+func (store *Store) PutCheckpoint(path string, checkpoint Checkpoint) error {
+	value, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadsBucket).Put([]byte(path), value)
+	})
+}
+
+// DeleteCheckpoint forgets the upload of the file at path, once finished.
+func (store *Store) DeleteCheckpoint(path string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadsBucket).Delete([]byte(path))
+	})
+}
+
+// Archive returns the last archive of the watched folder, nil when it has
+// none.
+func (store *Store) Archive(folder string) (*Archive, error) {
+	var archive *Archive
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(archivesBucket).Get([]byte(folder))
+		if value == nil {
+			return nil
+		}
+		archive = &Archive{}
+		return json.Unmarshal(value, archive)
+	})
+	return archive, err
+}
+
+// PutArchive saves the last archive of the watched folder.
+func (store *Store) PutArchive(folder string, archive Archive) error {
+	value, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(archivesBucket).Put([]byte(folder), value)
+	})
+}
+
+// Scan returns the unfinished scan of the watched folder, nil when there
+// is none.
+func (store *Store) Scan(folder string) (*Scan, error) {
+	var scan *Scan
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(scansBucket).Get([]byte(folder))
+		if value == nil {
+			return nil
+		}
+		scan = &Scan{}
+		return json.Unmarshal(value, scan)
+	})
+	return scan, err
+}
+
+// PutScan saves how far the scan of the watched folder got.
+func (store *Store) PutScan(folder string, scan Scan) error {
+	value, err := json.Marshal(scan)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(scansBucket).Put([]byte(folder), value)
+	})
+}
+
+// DeleteScan forgets the scan of the watched folder, once finished.
+func (store *Store) DeleteScan(folder string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(scansBucket).Delete([]byte(folder))
+	})
+}
+
+// Chunk returns the Drive ID of the stored chunk id, "" when it is not
+// known.
+func (store *Store) Chunk(id string) (string, error) {
+	var remoteID string
+	err := store.db.View(func(tx *bolt.Tx) error {
+		remoteID = string(tx.Bucket(chunksBucket).Get([]byte(id)))
+		return nil
+	})
+	return remoteID, err
+}
+
+// PutChunk remembers the Drive ID of the stored chunk id.
+func (store *Store) PutChunk(id string, remoteID string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(chunksBucket).Put([]byte(id), []byte(remoteID))
+	})
+}
+
+// LastUpdate returns the time of the latest upload, "" when unknown.
+func (store *Store) LastUpdate() (string, error) {
+	var lastUpdate string
+	err := store.db.View(func(tx *bolt.Tx) error {
+		lastUpdate = string(tx.Bucket(metaBucket).Get(lastUpdateKey))
+		return nil
+	})
+	return lastUpdate, err
+}
+
+// PutLastUpdate saves the time of the latest upload.
+func (store *Store) PutLastUpdate(lastUpdate string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(lastUpdateKey, []byte(lastUpdate))
+	})
+}
+
+// ChunksCollected returns when gc last trashed chunks of the chunks
+// folder folderID as the known chunks were saved, "" when never.
+func (store *Store) ChunksCollected(folderID string) (string, error) {
+	var collected string
+	err := store.db.View(func(tx *bolt.Tx) error {
+		collected = string(tx.Bucket(collectionsBucket).Get([]byte(folderID)))
+		return nil
+	})
+	return collected, err
+}
+
+// ResetChunks replaces the known chunks with chunks, the Drive IDs of the
+// chunks stored in the chunks folder folderID once gc trashed chunks at
+// collected.
+func (store *Store) ResetChunks(folderID string, collected string, chunks map[string]string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(chunksBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(chunksBucket)
+		if err != nil {
+			return err
+		}
+		for id, remoteID := range chunks {
+			if err := bucket.Put([]byte(id), []byte(remoteID)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(collectionsBucket).Put([]byte(folderID), []byte(collected))
+	})
+}
+
+// Snapshot returns the last snapshot of the watched folder, nil when it
+// has none.
+func (store *Store) Snapshot(folder string) (*Snapshot, error) {
+	var snapshot *Snapshot
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(snapshotsBucket).Get([]byte(folder))
+		if value == nil {
+			return nil
+		}
+		snapshot = &Snapshot{}
+		return json.Unmarshal(value, snapshot)
+	})
+	return snapshot, err
+}
+
+// PutSnapshot saves the last snapshot of the watched folder.
+func (store *Store) PutSnapshot(folder string, snapshot Snapshot) error {
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotsBucket).Put([]byte(folder), value)
+	})
+}
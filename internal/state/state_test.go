@@ -0,0 +1,122 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// open opens the state database in file, closed at the end of the test.
+func open(t *testing.T, file string) *Store {
+	t.Helper()
+	store, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRecoverForgetsUncommittedWrites(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.db")
+	store := open(t, file)
+	previous := File{RemoteID: "old", Hash: "hash1", Size: 10}
+	for _, path := range []string{"/docs/a.txt", "/docs/b.txt"} {
+		if err := store.Put(path, previous); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a.txt and c.txt are cut by a crash, b.txt completes
+	for _, path := range []string{"/docs/a.txt", "/docs/b.txt", "/docs/c.txt"} {
+		if err := store.Begin(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	committed := File{RemoteID: "new", Hash: "hash2", Size: 20}
+	if err := store.Commit("/docs/b.txt", committed); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = open(t, file)
+	paths, err := store.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/docs/a.txt", "/docs/c.txt"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Recover = %q, want the uncommitted %q", paths, want)
+	}
+	if known, _ := store.Get("/docs/a.txt"); known != nil {
+		t.Errorf("state of a.txt = %+v, want it forgotten to upload it again", known)
+	}
+	if known, _ := store.Get("/docs/b.txt"); known == nil || *known != committed {
+		t.Errorf("state of b.txt = %+v, want the committed %+v", known, committed)
+	}
+
+	// the journal is empty once recovered
+	if paths, err = store.Recover(); err != nil || len(paths) != 0 {
+		t.Errorf("second Recover = %q, %v, want nothing", paths, err)
+	}
+}
+
+func TestAbortKeepsPreviousState(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.db")
+	store := open(t, file)
+	previous := File{RemoteID: "old", Hash: "hash1", Size: 10}
+	if err := store.Put("/docs/a.txt", previous); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Begin("/docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Abort("/docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = open(t, file)
+	if paths, err := store.Recover(); err != nil || len(paths) != 0 {
+		t.Errorf("Recover = %q, %v, want nothing after the abort", paths, err)
+	}
+	if known, _ := store.Get("/docs/a.txt"); known == nil || *known != previous {
+		t.Errorf("state of a.txt = %+v, want the previous upload %+v", known, previous)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.db")
+	store := open(t, file)
+	if checkpoint, err := store.Checkpoint("/docs/large.bin"); checkpoint != nil || err != nil {
+		t.Errorf("Checkpoint of a new upload = %+v, %v, want none", checkpoint, err)
+	}
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	saved := Checkpoint{SessionURI: "https://upload/session", Offset: 512 << 10, Size: 1 << 20, ModTime: modTime, Processors: "filter,gzip"}
+	if err := store.PutCheckpoint("/docs/large.bin", saved); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// the upload goes on after a restart
+	store = open(t, file)
+	checkpoint, err := store.Checkpoint("/docs/large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint == nil || *checkpoint != saved {
+		t.Fatalf("Checkpoint = %+v, want %+v", checkpoint, saved)
+	}
+	if !checkpoint.Matches(1<<20, modTime, "filter,gzip") {
+		t.Error("checkpoint does not match the unchanged file")
+	}
+	if checkpoint.Matches(1<<20, modTime.Add(time.Second), "filter,gzip") || checkpoint.Matches(1<<20, modTime, "filter") {
+		t.Error("checkpoint matches a changed file or chain, want a new upload")
+	}
+
+	if err = store.DeleteCheckpoint("/docs/large.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint, err = store.Checkpoint("/docs/large.bin"); checkpoint != nil || err != nil {
+		t.Errorf("Checkpoint of a finished upload = %+v, %v, want none", checkpoint, err)
+	}
+}
@@ -0,0 +1,56 @@
+// Package tracing exports OpenTelemetry traces of the upload pipeline.
+package tracing
+
+import (
+	"log/slog"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/net/context"
+)
+
+// Tracer creates the spans of the scan, filter and upload pipeline. It does
+// nothing until Setup installs an exporter.
+var Tracer = otel.Tracer("github.com/amcereijo/EncryptBckDocs")
+
+var tracerProvider *sdktrace.TracerProvider
+
+// Setup exports traces over OTLP/HTTP to endpoint, or to the one in the
+// standard OTEL_EXPORTER_OTLP_ENDPOINT variables when it is empty.
+func Setup(ctx context.Context, endpoint string) error {
+	var options []otlptracehttp.Option
+	if endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+	} else {
+		endpoint = config.GetEnvAny("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			return nil
+		}
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "EncryptBckDocs"))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	slog.Info("Exporting traces", "endpoint", endpoint)
+	return nil
+}
+
+// Shutdown flushes the pending spans, call it before exiting.
+func Shutdown() {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(context.Background()); err != nil {
+		slog.Error("Unable to flush traces", "error", err)
+	}
+}
@@ -0,0 +1,253 @@
+// Package tui is the terminal user interface of the running app: watched
+// folders, uploads in flight, recent errors and keys to drive the backup.
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/control"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"golang.org/x/net/context"
+)
+
+const (
+	shownErrors    = 8
+	shownUploads   = 5
+	statusInterval = time.Second
+	eventBuffer    = 1000 // events waiting for the UI before dropping them
+)
+
+// Options are the functions of the app the UI shows and drives.
+type Options struct {
+	Status func() control.Status
+	Pause  func()
+	Resume func()
+	// Scan backs up every watched folder in the background.
+	Scan   func()
+	Events *events.Bus
+}
+
+// Run shows the UI until the user quits or ctx is cancelled.
+func Run(ctx context.Context, options Options) error {
+	program := tea.NewProgram(&model{options: options, status: options.Status(), inFlight: make(map[string]*upload)},
+		tea.WithContext(ctx), tea.WithAltScreen())
+
+	// the bus must not wait for the UI
+	received := make(chan events.Event, eventBuffer)
+	unsubscribe := options.Events.Subscribe(func(event events.Event) {
+		select {
+		case received <- event:
+		default:
+		}
+	})
+	defer unsubscribe()
+	go func() {
+		for {
+			select {
+			case event := <-received:
+				program.Send(eventMsg{event})
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	_, err := program.Run()
+	if err == tea.ErrProgramKilled && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+type eventMsg struct {
+	event events.Event
+}
+
+type statusMsg control.Status
+
+// upload is a file being uploaded.
+type upload struct {
+	sent int64
+	size int64
+}
+
+// line is an upload or an error of the recent activity.
+type line struct {
+	time time.Time
+	text string
+}
+
+type model struct {
+	options  Options
+	status   control.Status
+	inFlight map[string]*upload
+	uploads  []line // latest first
+	errors   []line // latest first
+	lastScan *events.ScanFinished
+	width    int
+}
+
+func (m *model) Init() tea.Cmd {
+	return m.refreshStatus()
+}
+
+// refreshStatus asks for the status of the app after statusInterval.
+func (m *model) refreshStatus() tea.Cmd {
+	return tea.Tick(statusInterval, func(time.Time) tea.Msg {
+		return statusMsg(m.options.Status())
+	})
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "p":
+			if m.status.Paused {
+				m.options.Resume()
+			} else {
+				m.options.Pause()
+			}
+			m.status = m.options.Status()
+		case "s":
+			m.options.Scan()
+		}
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case statusMsg:
+		m.status = control.Status(msg)
+		return m, m.refreshStatus()
+	case eventMsg:
+		m.handleEvent(msg.event)
+	}
+	return m, nil
+}
+
+func (m *model) handleEvent(event events.Event) {
+	now := time.Now()
+	switch e := event.(type) {
+	case events.UploadStarted:
+		m.inFlight[e.Path] = &upload{}
+	case events.UploadProgress:
+		if current, ok := m.inFlight[e.Path]; ok {
+			current.sent = e.Sent
+			current.size = e.Size
+		}
+	case events.FileQueued, events.UploadSkipped:
+		// a retried or skipped file is no longer in flight
+		delete(m.inFlight, pathOf(e))
+	case events.UploadSucceeded:
+		delete(m.inFlight, e.Path)
+		m.uploads = prepend(m.uploads, line{now, fmt.Sprintf("%-6s %s (%s)", e.Action, e.Path, humanize.Bytes(e.Size))}, shownUploads)
+	case events.FileRenamed:
+		delete(m.inFlight, e.Path)
+		m.uploads = prepend(m.uploads, line{now, fmt.Sprintf("rename %s (from %s)", e.Path, e.From)}, shownUploads)
+	case events.UploadFailed:
+		delete(m.inFlight, e.Path)
+		m.errors = prepend(m.errors, line{now, e.Path + ": " + e.Err.Error()}, shownErrors)
+	case events.ScanFinished:
+		m.lastScan = &e
+	}
+}
+
+func pathOf(event events.Event) string {
+	switch e := event.(type) {
+	case events.FileQueued:
+		return e.Path
+	case events.UploadSkipped:
+		return e.Path
+	}
+	return ""
+}
+
+// prepend adds l before lines, keeping at most max lines.
+func prepend(lines []line, l line, max int) []line {
+	lines = append([]line{l}, lines...)
+	if len(lines) > max {
+		lines = lines[:max]
+	}
+	return lines
+}
+
+func (m *model) View() string {
+	var b strings.Builder
+	state := "running"
+	if m.status.Paused {
+		state = "paused"
+	} else if m.status.Offline {
+		state = "waiting for Drive"
+	} else if m.status.QuotaExceeded {
+		state = "waiting for Drive space"
+	} else if m.status.CircuitOpen {
+		state = "waiting for Drive to recover"
+	} else if m.status.BatteryLow {
+		state = "waiting for AC power"
+	}
+	fmt.Fprintf(&b, "EncryptBckDocs - uploads %s, %d queued\n", state, m.status.Queued)
+	if m.status.Metered {
+		fmt.Fprintf(&b, "Metered connection, large files wait for another one\n")
+	}
+	if m.status.LastUpdate != "" {
+		fmt.Fprintf(&b, "Last upload: %s\n", m.status.LastUpdate)
+	}
+	if m.lastScan != nil {
+		fmt.Fprintf(&b, "Last scan: %d uploaded, %d updated, %d skipped, %d failed\n",
+			m.lastScan.Uploaded, m.lastScan.Updated, m.lastScan.Skipped, m.lastScan.Failed)
+	}
+
+	b.WriteString("\nWatched folders\n")
+	for _, folder := range m.status.Folders {
+		fmt.Fprintf(&b, "  %s\n", folder)
+	}
+
+	b.WriteString("\nUploading\n")
+	paths := make([]string, 0, len(m.inFlight))
+	for path := range m.inFlight {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		current := m.inFlight[path]
+		percent := 0.0
+		if current.size > 0 {
+			percent = float64(current.sent) * 100 / float64(current.size)
+		}
+		fmt.Fprintf(&b, "  %-40s %5.1f%% %s/%s\n", m.truncate(filepath.Base(path), 40), percent,
+			humanize.Bytes(current.sent), humanize.Bytes(current.size))
+	}
+
+	b.WriteString("\nRecent uploads\n")
+	for _, l := range m.uploads {
+		fmt.Fprintf(&b, "  %s %s\n", l.time.Format("15:04:05"), m.truncate(l.text, m.width-12))
+	}
+	b.WriteString("\nRecent errors\n")
+	for _, l := range m.errors {
+		fmt.Fprintf(&b, "  %s %s\n", l.time.Format("15:04:05"), m.truncate(l.text, m.width-12))
+	}
+
+	pause := "pause"
+	if m.status.Paused {
+		pause = "resume"
+	}
+	fmt.Fprintf(&b, "\n[p] %s  [s] scan now  [q] quit\n", pause)
+	return b.String()
+}
+
+// truncate shortens text to width runes, not at all before the width of
+// the terminal is known.
+func (m *model) truncate(text string, width int) string {
+	runes := []rune(text)
+	if width <= 3 || len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-3]) + "..."
+}
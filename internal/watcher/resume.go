@@ -0,0 +1,50 @@
+package watcher
+
+import (
+	"log/slog"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// resumeCheckInterval is how often the wall clock is read to notice the
+// system was suspended.
+const resumeCheckInterval = 10 * time.Second
+
+// resumeThreshold is how late a check must be to count as a suspension.
+const resumeThreshold = time.Minute
+
+// WatchResume calls resumed with how long the system slept every time it
+// resumes from a suspension, until ctx is cancelled. The wall clock jumps
+// over the sleep while the timers, on the monotonic clock, do not.
+func WatchResume(ctx context.Context, resumed func(slept time.Duration)) {
+	ticker := time.NewTicker(resumeCheckInterval)
+	defer ticker.Stop()
+
+	// Round(0) drops the monotonic reading, comparing wall clock times
+	last := time.Now().Round(0)
+	for {
+		select {
+		case <-ticker.C:
+			if slept := time.Now().Round(0).Sub(last) - resumeCheckInterval; slept > resumeThreshold {
+				slog.Info("System resumed from sleep", "slept", slept.Round(time.Second))
+				resumed(slept)
+			}
+			last = time.Now().Round(0)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Rewatch watches folders again, their watches may be stale after the
+// system slept or the folders were unmounted.
+func (w *Watcher) Rewatch(folders []string) {
+	for _, folder := range folders {
+		// it fails when the watch is already gone, adding it is enough
+		w.fs.Remove(folder)
+		if err := w.fs.Add(folder); err != nil {
+			slog.Error("Unable to watch folder", "folder", folder, "error", err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package watcher reports the files written in the watched folders and the
+// changes of the config file.
+package watcher
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay groups the several write events produced by a single save of
+// the config file into one reload.
+const reloadDelay = 500 * time.Millisecond
+
+// Watcher watches folders for written files.
+type Watcher struct {
+	// Changed is called with the path of every written file.
+	Changed func(path string)
+	// Removed is called with the path of every file deleted, renamed or
+	// moved out of a folder, when set.
+	Removed func(path string)
+	// Alive is called with true when the watcher starts and false when it
+	// stops.
+	Alive func(alive bool)
+
+	fs *fsnotify.Watcher
+}
+
+// New returns a watcher not watching any folder yet.
+func New() (*Watcher, error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{fs: fs}, nil
+}
+
+// Add starts watching folder.
+func (w *Watcher) Add(folder string) error {
+	return w.fs.Add(folder)
+}
+
+// Remove stops watching folder.
+func (w *Watcher) Remove(folder string) error {
+	return w.fs.Remove(folder)
+}
+
+// Close stops watching every folder.
+func (w *Watcher) Close() error {
+	return w.fs.Close()
+}
+
+// Run reports the written, renamed and moved in files until the watcher
+// is closed.
+func (w *Watcher) Run() {
+	if w.Alive != nil {
+		w.Alive(true)
+		defer w.Alive(false)
+	}
+	for {
+		select {
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write {
+				slog.Debug("File changed", "file", event.Name)
+				w.Changed(event.Name)
+			} else if event.Op&fsnotify.Create == fsnotify.Create && isFile(event.Name) {
+				// renamed or moved into the folder, it is not written
+				slog.Debug("File created", "file", event.Name)
+				w.Changed(event.Name)
+			} else if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && w.Removed != nil {
+				slog.Debug("File removed", "file", event.Name)
+				w.Removed(event.Name)
+			}
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Watcher error", "error", err)
+		}
+	}
+}
+
+// isFile reports whether path is a regular file.
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// WatchConfig calls reload when configFile is written or the process
+// receives SIGHUP.
+func WatchConfig(configFile string, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	configPath, err := filepath.Abs(configFile)
+	if err != nil {
+		slog.Error("Error watching config file", "error", err)
+	}
+
+	var configEvents chan fsnotify.Event
+	var configErrors chan error
+	configWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("Error watching config file", "error", err)
+	} else {
+		defer configWatcher.Close()
+		// watch the directory, editors usually replace the file instead of writing it
+		if err = configWatcher.Add(filepath.Dir(configPath)); err != nil {
+			slog.Error("Error watching config file", "error", err)
+		}
+		configEvents = configWatcher.Events
+		configErrors = configWatcher.Errors
+	}
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-hup:
+			slog.Info("SIGHUP received, reloading config")
+			reload()
+		case event := <-configEvents:
+			if event.Name == configPath && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				timer.Reset(reloadDelay)
+			}
+		case <-timer.C:
+			reload()
+		case err := <-configErrors:
+			slog.Error("Config watcher error", "error", err)
+		}
+	}
+}
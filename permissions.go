@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"runtime"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// looseFile is a private file other users can access.
+type looseFile struct {
+	path string
+	mode fs.FileMode
+}
+
+// fix returns the command restricting the file to its owner.
+func (f looseFile) fix() string {
+	if f.mode.IsDir() {
+		return "chmod 700 " + f.path
+	}
+	return "chmod 600 " + f.path
+}
+
+// privateFiles returns the files only the user should read: the config,
+// which can hold secrets, and its backups, the state database, the history, the log file
+// and the credentials.
+func privateFiles(cfg *config.Config, authorizer *auth.Authorizer) []string {
+	files := []string{config.FileName, config.BackupDir(config.FileName), state.FileName, history.FileName}
+	if logFile := config.Resolve(logFileFlag, "ENCRYPTBCKDOCS_LOG_FILE", cfg.LogFile, ""); logFile != "" {
+		files = append(files, logFile)
+	}
+	return append(files, authorizer.CredentialFiles(append([]string{""}, accountNamesOf(cfg)...))...)
+}
+
+// loosePermissions returns the files among paths that other users can
+// access, the missing ones being left out. Windows files have no such
+// permissions.
+func loosePermissions(paths []string) []looseFile {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	var loose []looseFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().Perm()&0077 != 0 {
+			loose = append(loose, looseFile{path, info.Mode()})
+		}
+	}
+	return loose
+}
+
+// auditPermissions warns about the private files other users can access,
+// refusing to run when strict is set.
+func auditPermissions(cfg *config.Config, authorizer *auth.Authorizer, strict bool) {
+	loose := loosePermissions(privateFiles(cfg, authorizer))
+	for _, f := range loose {
+		slog.Warn("File is accessible by other users, run: "+f.fix(), "file", f.path, "mode", f.mode.Perm())
+	}
+	if strict && len(loose) > 0 {
+		logging.Exit(exitConfig, "Private files are accessible by other users, not running with --strict", "files", len(loose))
+	}
+}
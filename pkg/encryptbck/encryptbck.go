@@ -0,0 +1,328 @@
+// Package encryptbck embeds the EncryptBckDocs backup engine in other Go
+// programs: back up files and folders to Google Drive, restore them and
+// watch folders for changes.
+//
+//	client, err := encryptbck.NewClient(ctx, encryptbck.Options{ConfigFile: "config.json"})
+//	if err != nil {
+//		return err
+//	}
+//	result, err := client.Backup(ctx, []string{"/home/me/Documents"})
+package encryptbck
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/events"
+	"github.com/amcereijo/EncryptBckDocs/internal/history"
+	"github.com/amcereijo/EncryptBckDocs/internal/metrics"
+	"github.com/amcereijo/EncryptBckDocs/internal/notify"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+	"github.com/amcereijo/EncryptBckDocs/internal/watcher"
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+)
+
+const defaultFolderName = "EncryptBckDoc"
+
+// repositoryFolder holds the chunks and snapshots of the folders backed up
+// as snapshots, which Restore leaves alone.
+var repositoryFolder = strings.Split(pipeline.RepositoryChunks, "/")[0]
+
+// Options configures a Client. Empty fields take the value in ConfigFile,
+// or the same default as the EncryptBckDocs binary.
+type Options struct {
+	// ConfigFile is a config.json written by the binary, optional.
+	ConfigFile string
+	// FolderName is the Drive folder files are uploaded to, a name or a
+	// path like "Backups/Laptop".
+	FolderName string
+	// FolderID is the Drive ID of the folder files are uploaded to, taking
+	// priority over FolderName.
+	FolderID string
+	// HostFolder nests the files in a subfolder of the folder, e.g. named
+	// after the machine.
+	HostFolder string
+	// ClientSecretFile is the OAuth client secret, "client_secret.json" by
+	// default.
+	ClientSecretFile string
+	// TokenCacheFile is the cached OAuth token, the one in ~/.credentials
+	// by default.
+	TokenCacheFile string
+	// ServiceAccountFile authorizes with a service account key instead of
+	// OAuth.
+	ServiceAccountFile string
+	// HistoryFile records every upload, nothing is recorded when empty.
+	HistoryFile string
+	// StateFile remembers the files already backed up so unchanged ones
+	// are skipped, every file is uploaded when empty.
+	StateFile string
+}
+
+// Result counts what a backup did with every file.
+type Result struct {
+	Uploaded int
+	Updated  int
+	Skipped  int
+	Failed   int
+	// Failures has a "path: error" line for every failed file.
+	Failures []string
+}
+
+// Client backs up to the Drive account it was authorized for.
+type Client struct {
+	config   *config.Config
+	accounts *drive.Accounts
+	pipeline *pipeline.Pipeline
+	state    *state.Store
+	// stop cancels the uploads of the workers
+	stop context.CancelFunc
+}
+
+// NewClient authorizes the default Drive account, running the browser
+// flow when there is no cached token, and returns a Client for it.
+func NewClient(ctx context.Context, options Options) (*Client, error) {
+	cfg := &config.Config{}
+	if options.ConfigFile != "" {
+		var err error
+		if cfg, err = config.Load(options.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	if options.FolderName != "" {
+		cfg.FolderName = options.FolderName
+	}
+	if options.FolderID != "" {
+		cfg.FolderID = options.FolderID
+	}
+	if options.HostFolder != "" {
+		cfg.HostFolder = options.HostFolder
+	}
+	if cfg.FolderName == "" {
+		cfg.FolderName = defaultFolderName
+	}
+	if options.ServiceAccountFile != "" {
+		cfg.ServiceAccountFile = options.ServiceAccountFile
+	}
+
+	authorizer := &auth.Authorizer{
+		Config:           cfg,
+		ClientSecretPath: config.Resolve(options.ClientSecretFile, "ENCRYPTBCKDOCS_CLIENT_SECRET", cfg.ClientSecretFile, "client_secret.json"),
+		TokenCachePath:   config.Resolve(options.TokenCacheFile, "ENCRYPTBCKDOCS_TOKEN_CACHE", cfg.TokenCacheFile, ""),
+	}
+	c := &Client{config: cfg, accounts: drive.NewAccounts(cfg, authorizer)}
+	metricsState := metrics.New(cfg)
+	notifier := notify.New(cfg)
+	c.accounts.AuthExpired = func(account string, expired bool) {
+		metricsState.AuthExpired(expired)
+		if expired {
+			notifier.AuthExpired(account)
+		}
+	}
+	appFiles := []string{}
+	if options.ConfigFile != "" {
+		appFiles = append(appFiles, options.ConfigFile)
+	}
+	bus := events.New()
+	bus.Subscribe(events.Log)
+	bus.Subscribe(metricsState.HandleEvent)
+	bus.Subscribe(notifier.HandleEvent)
+	if options.HistoryFile != "" {
+		bus.Subscribe(history.New(options.HistoryFile).HandleEvent)
+		appFiles = append(appFiles, options.HistoryFile)
+	}
+	c.pipeline = &pipeline.Pipeline{
+		Config:   cfg,
+		Accounts: c.accounts,
+		Events:   bus,
+		Queue:    queue.New(),
+		AppFiles: appFiles,
+	}
+
+	if options.StateFile != "" {
+		store, err := state.Open(options.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		c.state = store
+		c.pipeline.State = store
+		c.accounts.State = store
+		c.pipeline.AppFiles = append(c.pipeline.AppFiles, options.StateFile)
+	}
+
+	if _, err := c.accounts.Authorize(ctx, ""); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// the workers outlive ctx, they stop on Close
+	var workersCtx context.Context
+	workersCtx, c.stop = context.WithCancel(context.Background())
+	c.pipeline.Start(workersCtx, 1)
+	return c, nil
+}
+
+// Close stops the upload workers, cancelling the uploads in flight, and
+// releases the state file. Call it once the client is no longer used.
+func (c *Client) Close() error {
+	if c.stop != nil {
+		c.stop()
+	}
+	c.pipeline.Queue.Close()
+	if c.state == nil {
+		return nil
+	}
+	return c.state.Close()
+}
+
+// Backup uploads the files in paths, which may be files or folders. Only
+// the files directly inside a folder are uploaded, not its subfolders.
+func (c *Client) Backup(ctx context.Context, paths []string) (*Result, error) {
+	summary := &pipeline.Summary{}
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			summary.AddFailure(path, err)
+			continue
+		}
+		if info.IsDir() {
+			c.pipeline.ScanFolder(ctx, path, queue.PriorityManual, summary)
+			continue
+		}
+		if !c.pipeline.Included(path) {
+			summary.Add(path, history.ActionSkip, nil)
+			continue
+		}
+		if _, err := c.accounts.ForFolder(ctx, filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+		action, err := c.pipeline.BackupFile(ctx, path, queue.PriorityManual)
+		summary.Add(path, action, err)
+	}
+	return &Result{
+		Uploaded: summary.Uploaded,
+		Updated:  summary.Updated,
+		Skipped:  summary.Skipped,
+		Failed:   summary.Failed,
+		Failures: summary.Failures,
+	}, nil
+}
+
+// Restore downloads the backed up files called names, or every file when
+// no name is given, into targetDir, decrypted, decompressed, put back
+// together from their parts and checked against the uploaded files. The
+// destination folder of every account is walked with its subfolders, like
+// the date folders, a file being restored in the same subfolder of
+// targetDir. The chunk repository of the folders backed up as snapshots is
+// not restored.
+func (c *Client) Restore(ctx context.Context, targetDir string, names ...string) error {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	if err := os.MkdirAll(targetDir, 0700); err != nil {
+		return err
+	}
+
+	var restoreErrors []error
+	// accounts sharing a destination folder restore its files once
+	walked := make(map[string]bool)
+	for _, accountName := range c.accounts.Names() {
+		account, err := c.accounts.Get(ctx, accountName)
+		if err != nil {
+			return err
+		}
+		if walked[account.Folder().Id] {
+			continue
+		}
+		walked[account.Folder().Id] = true
+		err = walkFolder(ctx, account.Client(), account.Folder().Id, "", func(file *drivev3.File, dir string) {
+			if _, _, part := pipeline.PartOf(file.Name); part {
+				return
+			}
+			if len(names) > 0 && !wanted[file.Name] {
+				return
+			}
+			delete(wanted, file.Name)
+			target := filepath.Join(targetDir, dir, filepath.Base(pipeline.LocalName(file, "")))
+			slog.Info("Restoring file", "file", file.Name, "target", target)
+			err := os.MkdirAll(filepath.Dir(target), 0700)
+			if err == nil {
+				_, err = pipeline.RestoreFile(ctx, account.Client(), file, nil, "", "", target)
+			}
+			if err != nil {
+				restoreErrors = append(restoreErrors, errors.New(file.Name+": "+err.Error()))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for name := range wanted {
+		restoreErrors = append(restoreErrors, errors.New(name+": not backed up"))
+	}
+	return errors.Join(restoreErrors...)
+}
+
+// walkFolder calls fn with every file under the Drive folder with id, and
+// the path of its folder relative to the folder walked first, dir. The
+// repository folder at the top is skipped.
+func walkFolder(ctx context.Context, client drive.Client, id string, dir string, fn func(file *drivev3.File, dir string)) error {
+	files, err := client.ListFiles(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !drive.IsFolder(file) {
+			fn(file, dir)
+			continue
+		}
+		// a name of Drive never leaves targetDir
+		name := filepath.Base(file.Name)
+		if name == "." || name == ".." || (dir == "" && name == repositoryFolder) {
+			continue
+		}
+		if err = walkFolder(ctx, client, file.Id, filepath.Join(dir, name), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch uploads every file written in the folders in paths until ctx is
+// cancelled.
+func (c *Client) Watch(ctx context.Context, paths []string) error {
+	w, err := watcher.New()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	w.Changed = func(path string) {
+		c.pipeline.FileChanged(ctx, path)
+	}
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if err = w.Add(path); err != nil {
+			return err
+		}
+	}
+	go w.Run()
+
+	<-ctx.Done()
+	return ctx.Err()
+}
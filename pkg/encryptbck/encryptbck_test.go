@@ -0,0 +1,74 @@
+package encryptbck
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive/drivetest"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+func TestRestoreWalksEveryAccount(t *testing.T) {
+	ctx := context.Background()
+	// every account has a Drive of its own
+	servers := map[string]*drivetest.Server{"": drivetest.NewServer(), "family": drivetest.NewServer()}
+	for _, server := range servers {
+		t.Cleanup(server.Close)
+	}
+	cfg := &config.Config{FolderName: "Backups", FolderAccount: map[string]string{"/photos": "family"}}
+	c := &Client{config: cfg, accounts: drive.NewAccounts(cfg, nil)}
+	c.accounts.Connect = func(ctx context.Context, name string) (drive.Client, error) {
+		return servers[name].Connect(256<<10)(ctx, name)
+	}
+
+	// the IDs of the fakes start alike, unlike the ones of two Drives
+	other, err := servers["family"].Connect(256<<10)(ctx, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.CreateFolder(ctx, "Other", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(account string, path string, content string) {
+		t.Helper()
+		a, err := c.accounts.Get(ctx, account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, name := filepath.Split(path)
+		parent, err := c.accounts.EnsureFolderPath(ctx, a, a.Folder(), strings.TrimSuffix(dir, "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = a.Client().CreateFile(ctx, parent, name, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload("", "report.txt", "report")
+	upload("", "2024/05/notes.txt", "notes")
+	upload("family", "beach.jpg", "beach")
+	upload("", "repository/chunks/0a1b2c", "chunk")
+
+	target := t.TempDir()
+	if err = c.Restore(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"report.txt":        "report",
+		"2024/05/notes.txt": "notes",
+		"beach.jpg":         "beach",
+	} {
+		if content, err := os.ReadFile(filepath.Join(target, path)); err != nil || string(content) != want {
+			t.Errorf("restored %s = %q, %v, want %q", path, content, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(target, "repository")); !os.IsNotExist(err) {
+		t.Errorf("chunk repository restored (%v), want it left alone", err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// openProgressJSON opens where --progress-json writes the events: stdout
+// for -, the file descriptor inherited from the parent process for a
+// number, like 3 with 3>progress.fifo, and the file at dest otherwise,
+// appended to.
+func openProgressJSON(dest string) (io.Writer, error) {
+	if dest == "-" {
+		return os.Stdout, nil
+	}
+	if fd, err := strconv.ParseUint(dest, 10, 32); err == nil {
+		f := os.NewFile(uintptr(fd), "progress-json")
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("Unable to use file descriptor %d: %v", fd, err)
+		}
+		return f, nil
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", dest, err)
+	}
+	return f, nil
+}
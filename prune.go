@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+	"github.com/amcereijo/EncryptBckDocs/internal/state"
+)
+
+// accountFile is a backed up file in the destination folder of account.
+type accountFile struct {
+	account *drive.Account
+	file    *drivev3.File
+}
+
+// defaults of config.DeleteProtection
+const (
+	defaultDeleteMaxFiles   = 100
+	defaultDeleteMaxPercent = 50
+)
+
+// pruneRemote trashes the files of the destination folders that no
+// longer correspond to a local file, after asking for confirmation unless
+// yes is set. A file is kept while a local file uploaded to it, or a file
+// of a watched folder with its name, exists, and so are the archives of the
+// folders in archive mode and the parts of the files kept. The subfolders
+// of the destination folders are left alone. Trashing more files than the
+// delete protection allows is refused with yes, unless force is set.
+func (a *app) pruneRemote(ctx context.Context, yes bool, force bool) error {
+	liveIDs, liveNames := a.liveFiles()
+
+	var orphans []accountFile
+	// files of the destination folder of every account, by account name
+	listed := make(map[string]int)
+	for _, name := range a.accountNames() {
+		account, err := a.accounts.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		files, err := account.Client().ListFiles(ctx, account.Folder().Id)
+		if err != nil {
+			return fmt.Errorf("Unable to list the backed up files: %v", err)
+		}
+		kept := make(map[string]bool)
+		var parts []*drivev3.File
+		for _, file := range files {
+			if drive.IsFolder(file) {
+				// the date subfolders, or folders not uploaded by the app
+				continue
+			}
+			listed[name]++
+			if _, _, ok := pipeline.PartOf(file.Name); ok {
+				parts = append(parts, file)
+			} else if liveIDs[file.Id] || liveNames[file.Name] || liveNames[pipeline.UnprocessedName(file.Name)] || a.isArchive(file.Name) || uploadedFromLive(file) {
+				kept[file.Name] = true
+			} else {
+				orphans = append(orphans, accountFile{account, file})
+			}
+		}
+		// a part goes with the file it is a part of
+		for _, file := range parts {
+			if name, _, _ := pipeline.PartOf(file.Name); !kept[name] {
+				orphans = append(orphans, accountFile{account, file})
+			}
+		}
+	}
+	if len(orphans) == 0 {
+		fmt.Println("No orphan files in Drive")
+		return nil
+	}
+
+	fmt.Printf("%d backed up files no longer correspond to a local file:\n", len(orphans))
+	for _, o := range orphans {
+		fmt.Printf("\t%s (%s)\n", o.file.Name, humanize.Bytes(o.file.Size))
+	}
+	question := "Move them to the Drive trash?"
+	if reason := a.tooManyDeletes(orphans, listed); reason != "" && !force {
+		fmt.Printf("Trashing them removes %s, is a watched folder empty or unmounted?\n", reason)
+		if yes {
+			return fmt.Errorf("Refusing to trash %d files over the delete protection, run again with --force to trash them", len(orphans))
+		}
+		question = "Move them to the Drive trash anyway?"
+	}
+	if !confirm(question, yes) {
+		fmt.Println("Nothing trashed")
+		return nil
+	}
+	trashed := a.trashFiles(ctx, orphans)
+	fmt.Printf("%d of %d orphan files moved to the Drive trash\n", len(trashed), len(orphans))
+	return nil
+}
+
+// tooManyDeletes tells how trashing orphans goes over the delete
+// protection, listed being the files of the destination folder of every
+// account, and returns "" when it does not.
+func (a *app) tooManyDeletes(orphans []accountFile, listed map[string]int) string {
+	protection := a.config().DeleteProtection
+	if protection == nil {
+		protection = &config.DeleteProtection{}
+	}
+	maxFiles := config.IntOrDefault(protection.MaxFiles, defaultDeleteMaxFiles)
+	if len(orphans) > maxFiles {
+		return fmt.Sprintf("%d files, more than the %d allowed", len(orphans), maxFiles)
+	}
+	maxPercent := config.IntOrDefault(protection.MaxPercent, defaultDeleteMaxPercent)
+	trashed := make(map[string]int)
+	for _, o := range orphans {
+		trashed[o.account.Name()]++
+	}
+	for _, name := range a.accountNames() {
+		if listed[name] > 0 && trashed[name]*100 > listed[name]*maxPercent {
+			return fmt.Sprintf("%d of the %d files of a destination folder, more than the %d%% allowed", trashed[name], listed[name], maxPercent)
+		}
+	}
+	return ""
+}
+
+// confirm asks question on the terminal, unless yes is set, and reports
+// whether the answer is yes, never without a terminal.
+func confirm(question string, yes bool) bool {
+	if yes {
+		return true
+	}
+	if !logging.CanPrompt() {
+		fmt.Println(question + " No terminal to answer, run with --yes")
+		return false
+	}
+	return strings.ToLower(ask(question+" [y/N]: ", "")) == "y"
+}
+
+// trashFiles moves files to the Drive trash, where Drive deletes them after
+// 30 days, forgets the local files uploaded to them and returns the files
+// trashed.
+func (a *app) trashFiles(ctx context.Context, files []accountFile) []accountFile {
+	var trashed []accountFile
+	for _, f := range files {
+		if err := f.account.Client().TrashFile(ctx, f.file.Id); err != nil {
+			slog.Error("Unable to trash file", "file", f.file.Name, "error", err)
+			continue
+		}
+		slog.Info("Trashed file", "file", f.file.Name, "id", f.file.Id, "account", f.account.Name())
+		trashed = append(trashed, f)
+	}
+	a.forgetTrashed(trashed)
+	return trashed
+}
+
+// liveFiles returns the Drive IDs of the uploaded files whose local file
+// still exists and the names of the files in the watched folders.
+func (a *app) liveFiles() (map[string]bool, map[string]bool) {
+	liveIDs := make(map[string]bool)
+	if a.state != nil {
+		err := a.state.ForEach(func(path string, file state.File) error {
+			if _, err := os.Stat(path); err == nil && file.RemoteID != "" {
+				liveIDs[file.RemoteID] = true
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error("Unable to read file state", "error", err)
+		}
+	}
+	liveNames := make(map[string]bool)
+	for _, folder := range a.config().FolderToWatch {
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			slog.Error("Error reading folder", "folder", folder, "error", err)
+			continue
+		}
+		for _, entry := range entries {
+			liveNames[entry.Name()] = true
+		}
+	}
+	return liveIDs, liveNames
+}
+
+// uploadedFromLive reports whether the local file file was uploaded from,
+// kept in its properties, still exists, whatever its remote name.
+func uploadedFromLive(file *drivev3.File) bool {
+	path := drive.Property(file.AppProperties, drive.PropertyPath)
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// isArchive reports whether the Drive file called name is an archive of a
+// folder in archive mode, all of them kept as restoring needs the full one
+// and the incremental ones after it.
+func (a *app) isArchive(name string) bool {
+	for _, folder := range a.config().ArchiveFolders {
+		if pipeline.IsArchive(folder, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// accountNames returns the names of the accounts files are uploaded to,
+// "" being the default account.
+func (a *app) accountNames() []string {
+	return a.accounts.Names()
+}
+
+// forgetTrashed drops the state of the local files uploaded to the
+// trashed files.
+func (a *app) forgetTrashed(trashed []accountFile) {
+	if a.state == nil {
+		return
+	}
+	trashedIDs := make(map[string]bool)
+	for _, f := range trashed {
+		trashedIDs[f.file.Id] = true
+	}
+	var paths []string
+	a.state.ForEach(func(path string, file state.File) error {
+		if trashedIDs[file.RemoteID] {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	for _, path := range paths {
+		if err := a.state.Delete(path); err != nil {
+			slog.Error("Unable to delete file state", "file", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log/slog"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+	"github.com/amcereijo/EncryptBckDocs/internal/watcher"
+)
+
+// reloadConfig reads the config file again and applies the changes without
+// restarting: removed folders stop being watched and only the files of newly
+// added folders are uploaded.
+func (a *app) reloadConfig(ctx context.Context, w *watcher.Watcher) {
+	newConfig, err := config.Load(config.FileName)
+	if err != nil {
+		slog.Error("Error reloading config", "error", err)
+		return
+	}
+	// the environment still overrides the file
+	next := *newConfig
+	next.ApplyEnvironment()
+	runtime := (*config.Config).ApplyEnvironment
+	if next.FolderName != a.config().FolderName || next.FolderID != a.config().FolderID || next.HostFolder != a.config().HostFolder {
+		slog.Warn("Destination folder changed, restart to apply it", "folder", next.FolderName, "id", next.FolderID, "host", next.HostFolder)
+		// kept until the restart, the file keeping the new one
+		folderName, folderID, hostFolder := a.config().FolderName, a.config().FolderID, a.config().HostFolder
+		runtime = func(cfg *config.Config) {
+			cfg.ApplyEnvironment()
+			cfg.FolderName, cfg.FolderID, cfg.HostFolder = folderName, folderID, hostFolder
+		}
+	}
+
+	added, removed := diffFolders(a.config().FolderToWatch, next.FolderToWatch)
+	// the components read the new config from the store
+	a.configStore.Replace(newConfig, runtime)
+	if a.config().RemoteConfig {
+		if err := a.saveRemoteConfig(ctx); err != nil {
+			slog.Warn("Unable to save the config in Drive", "error", err)
+		}
+	}
+
+	for _, folder := range removed {
+		slog.Info("Stop watching folder", "folder", folder)
+		if err := w.Remove(folder); err != nil {
+			slog.Error("Error removing watch", "folder", folder, "error", err)
+		}
+	}
+	for _, folder := range added {
+		slog.Info("Watching folder", "folder", folder)
+		if err := w.Add(folder); err != nil {
+			slog.Error("Error adding watch", "folder", folder, "error", err)
+			continue
+		}
+		result := &pipeline.Summary{}
+		a.pipeline.ScanFolder(ctx, folder, queue.PriorityBulk, result)
+		result.Print()
+	}
+}
+
+// diffFolders returns the folders only present in newFolders and the ones
+// only present in oldFolders.
+func diffFolders(oldFolders []string, newFolders []string) (added []string, removed []string) {
+	oldSet := make(map[string]bool)
+	for _, folder := range oldFolders {
+		oldSet[folder] = true
+	}
+	newSet := make(map[string]bool)
+	for _, folder := range newFolders {
+		newSet[folder] = true
+		if !oldSet[folder] {
+			added = append(added, folder)
+		}
+	}
+	for _, folder := range oldFolders {
+		if !newSet[folder] {
+			removed = append(removed, folder)
+		}
+	}
+	return added, removed
+}
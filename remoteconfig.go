@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+// the copy of the config of every machine in the app data folder is
+// config-<hostname>.json.enc
+const (
+	remoteConfigPrefix = "config-"
+	remoteConfigSuffix = ".json.enc"
+)
+
+// saveRemoteConfig saves a copy of the config, encrypted with the master
+// key, in the app data folder of the default account, replacing the
+// previous copy of this machine. Unchanged configs are not sent again.
+func (a *app) saveRemoteConfig(ctx context.Context) error {
+	a.remoteConfigMutex.Lock()
+	defer a.remoteConfigMutex.Unlock()
+	cfg := a.configStore.Copy()
+	// the time of the last upload, kept there by older versions
+	cfg.LastUpdate = ""
+	content, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(content)
+	if hash == a.remoteConfigHash {
+		return nil
+	}
+	sealed, err := auth.Seal(content)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt the config: %v", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Unable to get the hostname: %v", err)
+	}
+	name := remoteConfigPrefix + hostname + remoteConfigSuffix
+	account, err := a.accounts.Authorize(ctx, "")
+	if err != nil {
+		return err
+	}
+	client := account.Client()
+	files, err := client.FindFiles(ctx, name, drive.AppDataFolder)
+	if err != nil {
+		return fmt.Errorf("Unable to look for the config in Drive, authorize the app again if remoteConfig was enabled after: %v", err)
+	}
+	if len(files) > 0 {
+		_, err = client.UpdateFile(ctx, files[0], bytes.NewReader(sealed))
+	} else {
+		_, err = client.CreateFile(ctx, &drivev3.File{Id: drive.AppDataFolder}, name, bytes.NewReader(sealed))
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to save the config in Drive: %v", err)
+	}
+	a.remoteConfigHash = hash
+	slog.Info("Config saved in Drive", "name", name)
+	return nil
+}
+
+// initFromRemote writes config.json from the copy saved in Drive by the
+// machine called host, by the only machine that saved one when host is
+// empty, backing up the current config first.
+func (a *app) initFromRemote(ctx context.Context, host string) error {
+	account, err := a.accounts.Authorize(ctx, "")
+	if err != nil {
+		return err
+	}
+	client := account.Client()
+	files, err := client.ListFiles(ctx, drive.AppDataFolder)
+	if err != nil {
+		return fmt.Errorf("Unable to list the configs saved in Drive: %v", err)
+	}
+	saved := make(map[string]*drivev3.File)
+	var hosts []string
+	for _, file := range files {
+		name, ok := strings.CutPrefix(file.Name, remoteConfigPrefix)
+		if name, found := strings.CutSuffix(name, remoteConfigSuffix); ok && found {
+			saved[name] = file
+			hosts = append(hosts, name)
+		}
+	}
+	switch {
+	case len(saved) == 0:
+		return errors.New("No config saved in Drive, set remoteConfig in the config of the machine backed up")
+	case host == "" && len(saved) > 1:
+		return fmt.Errorf("Configs of several machines saved in Drive (%s), use init --from-remote <host>", strings.Join(hosts, ", "))
+	case host == "":
+		host = hosts[0]
+	case saved[host] == nil:
+		return fmt.Errorf("No config of %s in Drive, the saved ones are of %s", host, strings.Join(hosts, ", "))
+	}
+
+	var sealed bytes.Buffer
+	if err := client.Download(ctx, saved[host].Id, &sealed); err != nil {
+		return fmt.Errorf("Unable to download the config of %s: %v", host, err)
+	}
+	content, err := auth.Open(sealed.Bytes())
+	if err != nil {
+		keyFile, _ := auth.MasterKeyFile()
+		return fmt.Errorf("Unable to decrypt the config of %s, copy the master key of that machine to %s: %v", host, keyFile, err)
+	}
+	restored := &config.Config{}
+	if err := json.Unmarshal(content, restored); err != nil {
+		return fmt.Errorf("Invalid config of %s: %v", host, err)
+	}
+
+	backupConfig()
+	if err := restored.Save(config.FileName); err != nil {
+		return fmt.Errorf("Unable to save %s: %v", config.FileName, err)
+	}
+	fmt.Printf("%s written from the config of %s, backing up to %s\n", config.FileName, host, destinationLabel(restored))
+	for _, folder := range restored.FolderToWatch {
+		if _, err := os.Stat(folder); err != nil {
+			fmt.Printf("  watched folder %s is missing on this machine, restore it or change folderToWatch\n", folder)
+		}
+	}
+	return nil
+}
+
+// destinationLabel names the destination folder of cfg for the user.
+func destinationLabel(cfg *config.Config) string {
+	label := cfg.FolderName
+	if cfg.FolderID != "" {
+		label = "folder ID " + cfg.FolderID
+	}
+	if cfg.HostFolder != "" {
+		label += ", subfolder " + cfg.HostFolder
+	}
+	return label
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/queue"
+	"github.com/amcereijo/EncryptBckDocs/internal/watcher"
+)
+
+// resumed catches up after the system slept: the folders are watched
+// again, Drive is reached through new connections with a refreshed token
+// and the watched folders are scanned for the changes missed meanwhile.
+func (a *app) resumed(ctx context.Context, w *watcher.Watcher, slept time.Duration) {
+	w.Rewatch(a.config().FolderToWatch)
+	if err := a.accounts.Reconnect(ctx); err != nil {
+		slog.Error("Unable to reconnect to Drive", "error", err)
+	}
+	slog.Info("Scanning the watched folders after sleeping", "slept", slept.Round(time.Second))
+	a.backupWatchedFolders(ctx, queue.PriorityBulk)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/backend/drive"
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+)
+
+// listRevisions prints the revisions Drive keeps of the backed up file
+// called remoteName, oldest first, the last one being the current
+// content. A revision is downloaded with getFile.
+func (a *app) listRevisions(ctx context.Context, remoteName string) error {
+	account, err := a.accounts.Get(ctx, "")
+	if err != nil {
+		return err
+	}
+	files, err := a.findBackups(ctx, account, remoteName)
+	if err != nil {
+		return err
+	}
+	remoteFile := drive.PreferFile(files, a.isKnownFile)
+	if remoteFile == nil {
+		return fmt.Errorf("No backed up file called %s", remoteName)
+	}
+	revisions, err := account.Client().ListRevisions(ctx, remoteFile.Id)
+	if err != nil {
+		return fmt.Errorf("Unable to list the revisions of %s: %v", remoteName, err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tMODIFIED\tSIZE\tMD5\t")
+	for i, revision := range revisions {
+		modified := revision.ModifiedTime
+		if t, err := time.Parse(time.RFC3339, modified); err == nil {
+			modified = t.Local().Format("2006-01-02 15:04:05")
+		}
+		current := ""
+		if i == len(revisions)-1 {
+			current = "current"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", revision.Id, modified, humanize.Bytes(revision.Size), revision.Md5Checksum, current)
+	}
+	return w.Flush()
+}
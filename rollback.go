@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+// backupConfig backs up config.json before the user changes it from the
+// menu.
+func backupConfig() {
+	backup, err := config.Backup(config.FileName)
+	if err != nil {
+		slog.Error("Unable to back up the config, it is changed anyway", "file", config.FileName, "error", err)
+	} else if backup != "" {
+		fmt.Printf("Previous configuration saved in %s, config rollback puts it back\n", backup)
+	}
+}
+
+// listConfigBackups prints the backups of config.json, numbered from the
+// latest one, 1.
+func listConfigBackups() error {
+	backups, err := config.Backups(config.FileName)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Printf("No backups of %s yet\n", config.FileName)
+		return nil
+	}
+	for i, backup := range backups {
+		taken := "unknown date"
+		if when, err := config.BackupTime(backup); err == nil {
+			taken = when.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%2d  %s  %s\n", i+1, taken, backup)
+	}
+	return nil
+}
+
+// rollbackConfig puts back the backup number n of config.json, the latest
+// one for "".
+func rollbackConfig(n string) error {
+	backups, err := config.Backups(config.FileName)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("No backups of %s to roll back to", config.FileName)
+	}
+	number := 1
+	if n != "" {
+		if number, err = strconv.Atoi(n); err != nil || number < 1 || number > len(backups) {
+			return errors.New("Wrong backup " + n + ", use a number from config backups")
+		}
+	}
+	backup := backups[number-1]
+	if err := config.Rollback(config.FileName, backup); err != nil {
+		return err
+	}
+	fmt.Printf("%s restored from %s, the replaced config is the latest backup now\n", config.FileName, backup)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/auth"
+	"github.com/amcereijo/EncryptBckDocs/internal/config"
+)
+
+// secretKeys are the settings of config.json config set-secret seals, n
+// being the index of a webhook and NAME the name of one of its headers.
+const secretKeys = "email.password, slack.webhookUrl, telegram.botToken, webhooks.n.url or webhooks.n.headers.NAME"
+
+// setSecret reads the value of the setting key from the standard input,
+// seals it with the master key and saves it in config.json, so the file
+// no longer holds it in plain text.
+func setSecret(configStore *config.Store, loadErr error, key string) error {
+	if loadErr != nil {
+		return fmt.Errorf("Unable to read %s: %v", config.FileName, loadErr)
+	}
+	// checked before asking for the value
+	saved := configStore.Copy()
+	if _, err := secretSetter(&saved, key); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Value of %s: ", key)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	value := strings.TrimRight(line, "\r\n")
+	if value == "" {
+		if err != nil {
+			return fmt.Errorf("Unable to read the value: %v", err)
+		}
+		return errors.New("Empty value, nothing saved")
+	}
+	sealed, err := auth.SealSecret(value)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt the value: %v", err)
+	}
+	err = configStore.Update(func(cfg *config.Config) {
+		set, _ := secretSetter(cfg, key)
+		set(sealed)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to save %s: %v", config.FileName, err)
+	}
+	fmt.Fprintf(os.Stderr, "%s encrypted in %s\n", key, config.FileName)
+	return nil
+}
+
+// secretSetter returns the function setting the setting of cfg called
+// key, creating the Slack, Telegram and email settings when missing.
+func secretSetter(cfg *config.Config, key string) (func(value string), error) {
+	parts := strings.Split(key, ".")
+	switch {
+	case key == "email.password":
+		if cfg.Email == nil {
+			cfg.Email = &config.Email{}
+		}
+		return func(value string) { cfg.Email.Password = value }, nil
+	case key == "slack.webhookUrl":
+		if cfg.Slack == nil {
+			cfg.Slack = &config.Slack{}
+		}
+		return func(value string) { cfg.Slack.WebhookURL = value }, nil
+	case key == "telegram.botToken":
+		if cfg.Telegram == nil {
+			cfg.Telegram = &config.Telegram{}
+		}
+		return func(value string) { cfg.Telegram.BotToken = value }, nil
+	case parts[0] == "webhooks" && (len(parts) == 3 && parts[2] == "url" || len(parts) == 4 && parts[2] == "headers"):
+		i, err := strconv.Atoi(parts[1])
+		if err != nil || i < 0 || i >= len(cfg.Webhooks) {
+			return nil, fmt.Errorf("No webhook %s in %s, add it first", parts[1], config.FileName)
+		}
+		webhook := &cfg.Webhooks[i]
+		if parts[2] == "url" {
+			return func(value string) { webhook.URL = value }, nil
+		}
+		return func(value string) {
+			if webhook.Headers == nil {
+				webhook.Headers = make(map[string]string)
+			}
+			webhook.Headers[parts[3]] = value
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown secret %s, use %s", key, secretKeys)
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// watchSignals backs up every watched folder now on SIGUSR1, and pauses
+// the uploads on SIGUSR2 and resumes them on the next one, until ctx is
+// cancelled.
+func (a *app) watchSignals(ctx context.Context) {
+	usr := make(chan os.Signal, 1)
+	signal.Notify(usr, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(usr)
+
+	for {
+		select {
+		case received := <-usr:
+			if received == syscall.SIGUSR1 {
+				slog.Debug("SIGUSR1 received, backing up now")
+				a.syncNow(ctx)
+			} else {
+				slog.Debug("SIGUSR2 received, toggling pause")
+				a.togglePause()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import "golang.org/x/net/context"
+
+// watchSignals does nothing, Windows has no SIGUSR1 or SIGUSR2. Use the
+// control API instead.
+func (a *app) watchSignals(ctx context.Context) {}
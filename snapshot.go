@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"golang.org/x/net/context"
+	drivev3 "google.golang.org/api/drive/v3"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/pipeline"
+)
+
+// listSnapshots prints the snapshots of the folders backed up as chunks,
+// oldest first, with their number of files and size.
+func (a *app) listSnapshots(ctx context.Context) error {
+	account, err := a.accounts.Get(ctx, "")
+	if err != nil {
+		return err
+	}
+	folder, err := remoteFolder(ctx, account, pipeline.RepositorySnapshots)
+	if err != nil {
+		return err
+	}
+	var files []*drivev3.File
+	if folder != nil {
+		if files, err = account.Client().ListFiles(ctx, folder.Id); err != nil {
+			return fmt.Errorf("Unable to list the snapshots: %v", err)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Println("No snapshots in Drive")
+		return nil
+	}
+
+	snapshots := make([]pipeline.Snapshot, len(files))
+	for i, file := range files {
+		if snapshots[i], err = pipeline.ReadSnapshot(ctx, account.Client(), file); err != nil {
+			return err
+		}
+	}
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return snapshots[order[i]].Time.Before(snapshots[order[j]].Time) })
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SNAPSHOT\tFOLDER\tHOST\tFILES\tSIZE\t")
+	for _, i := range order {
+		s := snapshots[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t\n", files[i].Name, s.Folder, s.Host, s.Files, humanize.Bytes(s.Size))
+	}
+	return w.Flush()
+}
+
+// restoreSnapshot writes the files of the snapshot called name to the
+// folder target, the name of the backed up folder in the working directory
+// when empty, checking every chunk against its ID. Existing files are
+// never overwritten.
+func (a *app) restoreSnapshot(ctx context.Context, name string, target string) error {
+	account, err := a.accounts.Get(ctx, "")
+	if err != nil {
+		return err
+	}
+	files, err := a.findBackups(ctx, account, pipeline.RepositorySnapshots+"/"+name)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("No snapshot called %s", name)
+	}
+	snapshot, err := pipeline.ReadSnapshot(ctx, account.Client(), files[0])
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		target = filepath.Base(snapshot.Folder)
+	}
+
+	chunksFolder, err := remoteFolder(ctx, account, pipeline.RepositoryChunks)
+	if err != nil {
+		return err
+	}
+	if chunksFolder == nil {
+		return fmt.Errorf("No chunks in Drive")
+	}
+	chunkFiles, err := account.Client().ListFiles(ctx, chunksFolder.Id)
+	if err != nil {
+		return fmt.Errorf("Unable to list the chunks: %v", err)
+	}
+	chunkIDs := make(map[string]string, len(chunkFiles))
+	for _, file := range chunkFiles {
+		chunkIDs[file.Name] = file.Id
+	}
+	readChunk := func(id string) ([]byte, error) {
+		fileID, ok := chunkIDs[id]
+		if !ok {
+			return nil, fmt.Errorf("Chunk %s is missing in Drive", id)
+		}
+		return pipeline.ReadChunk(ctx, account.Client(), fileID, id)
+	}
+	data, err := readChunk(snapshot.Tree)
+	if err != nil {
+		return err
+	}
+	var tree pipeline.Tree
+	if err = json.Unmarshal(data, &tree); err != nil {
+		return fmt.Errorf("Unable to read the files of the snapshot: %v", err)
+	}
+
+	if err = os.MkdirAll(target, 0700); err != nil {
+		return err
+	}
+	for _, file := range tree.Files {
+		if err = restoreTreeFile(target, file, readChunk); err != nil {
+			return err
+		}
+	}
+	slog.Info("Restored snapshot", "snapshot", name, "target", target, "files", len(tree.Files), "size", snapshot.Size)
+	return nil
+}
+
+// restoreTreeFile writes file of a snapshot in the folder target, from
+// the chunks readChunk returns, with its permissions and modification
+// time.
+func restoreTreeFile(target string, file pipeline.TreeFile, readChunk func(id string) ([]byte, error)) error {
+	path := filepath.Join(target, filepath.Base(file.Name))
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("%s already exists, choose another folder with -o", path)
+	}
+	// written aside and moved in place once complete
+	part, err := os.CreateTemp(target, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(part.Name())
+	var size int64
+	for _, id := range file.Chunks {
+		data, err := readChunk(id)
+		if err != nil {
+			part.Close()
+			return err
+		}
+		if _, err = part.Write(data); err != nil {
+			part.Close()
+			return err
+		}
+		size += int64(len(data))
+	}
+	if err = part.Close(); err != nil {
+		return err
+	}
+	if size != file.Size {
+		return fmt.Errorf("%s has %d bytes instead of %d", file.Name, size, file.Size)
+	}
+	if err = os.Chmod(part.Name(), file.Mode); err != nil {
+		return err
+	}
+	if err = os.Chtimes(part.Name(), file.ModTime, file.ModTime); err != nil {
+		return err
+	}
+	return os.Rename(part.Name(), path)
+}
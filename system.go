@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// folders of the system mode: the users to back up have a config in
+// /etc/encryptbckdocs/<user>/config.json, copied the first time to
+// /var/lib/encryptbckdocs/<user>, which holds the config the user and the
+// app edit, its backups, the state and the credentials
+const (
+	systemConfigDir = "/etc/encryptbckdocs"
+	systemStateDir  = "/var/lib/encryptbckdocs"
+)
+
+// systemRestartDelay is how long the app of a user waits to be started
+// again after it stopped.
+const systemRestartDelay = time.Minute
+
+// systemStopTimeout is how long the app of a user has to finish its
+// uploads in flight once asked to stop.
+const systemStopTimeout = 30 * time.Second
+
+// runSystem backs up the folders of every user with a config in
+// systemConfigDir, each by an app running as the user, so it only reads
+// the files the user can read. An app stopping is started again, until
+// ctx is cancelled.
+func runSystem(ctx context.Context) error {
+	entries, err := os.ReadDir(systemConfigDir)
+	if err != nil {
+		return fmt.Errorf("Unable to read the users: %v", err)
+	}
+	var users []string
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(systemConfigDir, entry.Name(), "config.json")); entry.IsDir() && err == nil {
+			users = append(users, entry.Name())
+		}
+	}
+	if len(users) == 0 {
+		return errors.New("No user to back up, add /etc/encryptbckdocs/<user>/config.json")
+	}
+
+	var running sync.WaitGroup
+	for _, name := range users {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for ctx.Err() == nil {
+				cmd, err := systemCommand(ctx, name, []string{"e"})
+				if err == nil {
+					slog.Info("Backing up user", "user", name)
+					cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+					err = cmd.Run()
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Backup of user stopped, starting it again", "user", name, "delay", systemRestartDelay, "error", err)
+				select {
+				case <-time.After(systemRestartDelay):
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	running.Wait()
+	return nil
+}
+
+// runSystemOption runs the menu option args as the user called name, in
+// the terminal, like authorizing its account the first time.
+func runSystemOption(ctx context.Context, name string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing option, like system <user> doctor")
+	}
+	cmd, err := systemCommand(ctx, name, args)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// systemCommand returns the command running the app with args as the user
+// called name, in its state folder, created when missing with the config
+// of the user copied from /etc/encryptbckdocs/<user>, so the user and the
+// app can write it and its backups. The client
+// secret, /etc/encryptbckdocs/<user>/client_secret.json or else the one
+// of every user in /etc/encryptbckdocs, is read here and passed in the
+// environment, so the user does not need to read it.
+func systemCommand(ctx context.Context, name string, args []string) (*exec.Cmd, error) {
+	account, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	// every user goes through the common folder to its own
+	stateDir := filepath.Join(systemStateDir, name)
+	if err = os.MkdirAll(systemStateDir, 0755); err != nil {
+		return nil, err
+	}
+	if err = os.Mkdir(stateDir, 0700); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	configFile := filepath.Join(stateDir, "config.json")
+	if err = copyConfig(filepath.Join(systemConfigDir, name, "config.json"), configFile); err != nil {
+		return nil, fmt.Errorf("Unable to copy the config of %s: %v", name, err)
+	}
+	clientSecret, err := os.ReadFile(filepath.Join(systemConfigDir, name, clientSecretFileName))
+	if os.IsNotExist(err) {
+		clientSecret, err = os.ReadFile(filepath.Join(systemConfigDir, clientSecretFileName))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the client secret: %v", err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, executable, args...)
+	cmd.Dir = stateDir
+	cmd.Env = []string{
+		"HOME=" + account.HomeDir,
+		"USER=" + name,
+		"LOGNAME=" + name,
+		"PATH=" + os.Getenv("PATH"),
+		"ENCRYPTBCKDOCS_CONFIG=" + configFile,
+		"ENCRYPTBCKDOCS_CREDENTIALS_DIR=" + filepath.Join(stateDir, "credentials"),
+		"ENCRYPTBCKDOCS_CLIENT_SECRET_JSON=" + string(clientSecret),
+	}
+	// stopped like on Ctrl-C, finishing the uploads in flight
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = systemStopTimeout
+	return cmd, runAs(cmd, account, stateDir, configFile)
+}
+
+// copyConfig copies the config file from to the file to, unless it is
+// already there, even as a link.
+func copyConfig(from string, to string) error {
+	if _, err := os.Lstat(to); err == nil {
+		return nil
+	}
+	content, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// runAs makes cmd run as account, giving it the files at paths. Only root
+// can.
+func runAs(cmd *exec.Cmd, account *user.User, paths ...string) error {
+	if os.Geteuid() != 0 {
+		return errors.New("The system mode runs as root")
+	}
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	var groups []uint32
+	if ids, err := account.GroupIds(); err == nil {
+		for _, id := range ids {
+			if group, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(group))
+			}
+		}
+	}
+	// never following a link the user made
+	for _, path := range paths {
+		if err = os.Lchown(path, int(uid), int(gid)); err != nil {
+			return err
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}}
+	return nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"os/user"
+)
+
+// runAs fails, the system mode needs the users and permissions of Unix.
+// Run the app as every user on Windows.
+func runAs(cmd *exec.Cmd, account *user.User, paths ...string) error {
+	return errors.New("The system mode is not supported on Windows, run the app as every user")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amcereijo/EncryptBckDocs/internal/humanize"
+	"github.com/amcereijo/EncryptBckDocs/internal/logging"
+)
+
+// stdin reads the answers of the wizard, whole lines so paths may hold
+// spaces.
+var stdin = bufio.NewReader(os.Stdin)
+
+// ask prints question and returns the line answered, defaultAnswer when
+// it is empty.
+func ask(question string, defaultAnswer string) string {
+	fmt.Print(question)
+	line, _ := stdin.ReadString('\n')
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return defaultAnswer
+}
+
+// askFolderToWatch asks for a folder to watch until the user gives an
+// existing one and accepts what backing it up means, showing how many
+// files and bytes it holds. It returns its absolute path.
+func (a *app) askFolderToWatch() string {
+	for {
+		folder, err := expandHome(ask("Path to watch (default-actual folder: \".\" ): ", "."))
+		if err == nil {
+			folder, err = filepath.Abs(folder)
+		}
+		if err == nil {
+			err = checkFolder(folder)
+		}
+		if err != nil {
+			fmt.Println(logging.Colorize(logging.Red, fmt.Sprintf("%v, try again", err)))
+			continue
+		}
+		files, size := a.previewFolder(folder)
+		fmt.Printf("%s: %d files, %s to back up\n", folder, files, humanize.Bytes(size))
+		if answer := ask("Back up this folder? [Y/n]: ", "y"); strings.ToLower(answer) == "y" {
+			return folder
+		}
+	}
+}
+
+// expandHome replaces a leading ~ of path with the home folder of the
+// user.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// checkFolder returns an error telling why folder cannot be watched.
+func checkFolder(folder string) error {
+	info, err := os.Stat(folder)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s does not exist", folder)
+	} else if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(folder + " is not a folder")
+	}
+	if _, err = os.ReadDir(folder); err != nil {
+		return fmt.Errorf("%s cannot be read: %v", folder, err)
+	}
+	return nil
+}
+
+// previewFolder returns how many files of folder would be backed up and
+// their size, its subfolders not being watched.
+func (a *app) previewFolder(folder string) (int, int64) {
+	entries, _ := os.ReadDir(folder)
+	var files int
+	var size int64
+	for _, entry := range entries {
+		path := filepath.Join(folder, entry.Name())
+		if !entry.Type().IsRegular() || !a.pipeline.Included(path) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files++
+			size += info.Size()
+		}
+	}
+	return files, size
+}